@@ -37,6 +37,9 @@ func main() {
 
     // Optimization
     mux.HandleFunc("/v1/optimize", srvDeps.OptimizeHandler)
+    mux.HandleFunc("/v1/optimize/explain", srvDeps.ExplainHandler)
+    mux.HandleFunc("/v1/optimize/jobs", srvDeps.OptimizeJobsHandler)
+    mux.HandleFunc("/v1/optimize/jobs/", srvDeps.OptimizeJobsHandler) // includes /events/stream
     mux.HandleFunc("/v1/optimizer/config", srvDeps.OptimizerConfigHandler)
     mux.HandleFunc("/v1/admin/optimizer/config", srvDeps.AdminOptimizerConfigHandler)
 
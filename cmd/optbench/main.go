@@ -0,0 +1,109 @@
+// Command optbench solves Solomon/Homberger VRPTW instances with fixed seeds
+// and reports the gap to their best-known solutions, iterations per second
+// and feasibility.
+//
+//	optbench [flags] file-or-dir...
+package main
+
+import (
+    "context"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "os/signal"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "text/tabwriter"
+    "time"
+
+    "gpsnav/internal/optbench"
+)
+
+func main() {
+    seeds := flag.String("seeds", "1,2,3", "comma-separated solver seeds")
+    iterations := flag.Int("iterations", 2000, "ALNS iteration cap per seed (0 = time budget only)")
+    budget := flag.Duration("budget", time.Minute, "time budget per seed")
+    bks := flag.String("bks", "", "CSV of name,vehicles,distance best-known solutions (Solomon ones are built in)")
+    vehicleWeight := flag.Float64("vehicle-weight", 0, "vehicles objective weight, charged per route used")
+    maxGap := flag.Float64("max-gap", 0, "exit non-zero when an instance's mean gap exceeds this percentage (0 = off)")
+    flag.Parse()
+    if flag.NArg() == 0 {
+        fmt.Fprintln(os.Stderr, "usage: optbench [flags] file-or-dir...")
+        flag.PrintDefaults()
+        os.Exit(2)
+    }
+
+    cfg := optbench.Config{Iterations: *iterations, Budget: *budget}
+    for _, s := range strings.Split(*seeds, ",") {
+        n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+        if err != nil || n == 0 { log.Fatalf("bad seed %q", s) }
+        cfg.Seeds = append(cfg.Seeds, n)
+    }
+    if *vehicleWeight > 0 { cfg.Objectives = map[string]float64{"vehicles": *vehicleWeight} }
+    best := optbench.Solomon
+    if *bks != "" {
+        extra, err := optbench.LoadBest(*bks)
+        if err != nil { log.Fatal(err) }
+        best = extra
+        for k, v := range optbench.Solomon { if _, ok := best[k]; !ok { best[k] = v } }
+    }
+    files, err := instanceFiles(flag.Args())
+    if err != nil { log.Fatal(err) }
+
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+    defer stop()
+    tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+    fmt.Fprintln(tw, "instance\tseed\tvehicles\tdistance\tbest\tgap %\titer/s\tfeasible\t")
+    failed, gaps, scored := false, 0.0, 0
+    for _, path := range files {
+        in, err := optbench.Load(path)
+        if err != nil { log.Fatal(err) }
+        b, ok := best[strings.ToUpper(in.Name)]
+        var bp *optbench.Best
+        bestCol := "-"
+        if ok { bp, bestCol = &b, fmt.Sprintf("%d/%.2f", b.Vehicles, b.Distance) }
+        results := optbench.Run(ctx, in, bp, cfg)
+        mean := 0.0
+        for _, r := range results {
+            feasible := "yes"
+            if !r.Feasible {
+                feasible, failed = "NO", true
+                if r.Violation != "" { log.Printf("%s seed %d: %s", r.Instance, r.Seed, r.Violation) }
+                if r.Unassigned > 0 { log.Printf("%s seed %d: %d customers unassigned", r.Instance, r.Seed, r.Unassigned) }
+            }
+            gap := "-"
+            if bp != nil { gap = fmt.Sprintf("%.2f", r.Gap) }
+            mean += r.Gap / float64(len(results))
+            fmt.Fprintf(tw, "%s\t%d\t%d\t%.2f\t%s\t%s\t%.0f\t%s\t\n", r.Instance, r.Seed, r.Vehicles, r.Distance, bestCol, gap, r.ItersPerSec, feasible)
+        }
+        if bp != nil && *maxGap > 0 && mean > *maxGap {
+            log.Printf("%s: mean gap %.2f%% exceeds %.2f%%", in.Name, mean, *maxGap)
+            failed = true
+        }
+        if bp != nil { gaps += mean; scored++ }
+        if ctx.Err() != nil { break }
+    }
+    tw.Flush()
+    if scored > 0 { fmt.Printf("mean gap over %d instances: %.2f%%\n", scored, gaps/float64(scored)) }
+    if failed { os.Exit(1) }
+}
+
+// instanceFiles expands directories in args to the .txt/.vrp files they hold.
+func instanceFiles(args []string) ([]string, error) {
+    var out []string
+    for _, a := range args {
+        fi, err := os.Stat(a)
+        if err != nil { return nil, err }
+        if !fi.IsDir() { out = append(out, a); continue }
+        entries, err := os.ReadDir(a)
+        if err != nil { return nil, err }
+        for _, e := range entries {
+            if ext := strings.ToLower(filepath.Ext(e.Name())); !e.IsDir() && (ext == ".txt" || ext == ".vrp") {
+                out = append(out, filepath.Join(a, e.Name()))
+            }
+        }
+    }
+    return out, nil
+}
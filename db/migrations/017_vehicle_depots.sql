@@ -0,0 +1,4 @@
+-- Per-vehicle start/end depots (hub geofences) and open routes
+ALTER TABLE vehicles ADD COLUMN IF NOT EXISTS start_depot_id uuid REFERENCES geofences(id) ON DELETE SET NULL;
+ALTER TABLE vehicles ADD COLUMN IF NOT EXISTS end_depot_id uuid REFERENCES geofences(id) ON DELETE SET NULL;
+ALTER TABLE vehicles ADD COLUMN IF NOT EXISTS open_route boolean NOT NULL DEFAULT false;
@@ -0,0 +1,6 @@
+-- Vehicle availability for planning: own shift window or the assigned driver's,
+-- plus optional per-route duration and distance caps
+ALTER TABLE vehicles ADD COLUMN IF NOT EXISTS driver_id uuid REFERENCES drivers(id) ON DELETE SET NULL;
+ALTER TABLE vehicles ADD COLUMN IF NOT EXISTS shift_window tstzrange;
+ALTER TABLE vehicles ADD COLUMN IF NOT EXISTS max_route_sec int;
+ALTER TABLE vehicles ADD COLUMN IF NOT EXISTS max_distance_m double precision;
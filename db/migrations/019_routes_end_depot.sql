@@ -0,0 +1,2 @@
+-- End depot of a planned route (NULL for open routes), so replanning keeps the return leg
+ALTER TABLE routes ADD COLUMN IF NOT EXISTS end_depot_id text;
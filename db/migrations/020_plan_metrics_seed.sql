@@ -0,0 +1 @@
+ALTER TABLE plan_metrics ADD COLUMN IF NOT EXISTS seed bigint;
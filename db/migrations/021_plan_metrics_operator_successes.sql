@@ -0,0 +1,2 @@
+ALTER TABLE plan_metrics ADD COLUMN IF NOT EXISTS removal_successes jsonb;
+ALTER TABLE plan_metrics ADD COLUMN IF NOT EXISTS insert_successes jsonb;
@@ -0,0 +1,12 @@
+-- Stops may have several time windows (e.g. 09-12 and 14-17), and soft
+-- windows that charge lateness, up to an optional cap, instead of refusing
+-- late service
+DO $$
+BEGIN
+  IF (SELECT udt_name FROM information_schema.columns WHERE table_name='stops' AND column_name='time_window') = 'tstzrange' THEN
+    ALTER TABLE stops ALTER COLUMN time_window TYPE tstzmultirange
+      USING CASE WHEN time_window IS NULL THEN NULL ELSE tstzmultirange(time_window) END;
+  END IF;
+END $$;
+ALTER TABLE stops ADD COLUMN IF NOT EXISTS time_window_mode text NOT NULL DEFAULT 'hard' CHECK (time_window_mode IN ('hard','soft'));
+ALTER TABLE stops ADD COLUMN IF NOT EXISTS max_lateness_sec int;
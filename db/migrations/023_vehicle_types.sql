@@ -0,0 +1,13 @@
+-- Vehicle types price the routes their vehicles run: a fixed cost per day
+-- used, per-km and per-hour rates, and an overtime multiplier on hours past
+-- overtime_after_sec. vehicles.type names the type.
+CREATE TABLE IF NOT EXISTS vehicle_types (
+  tenant_id uuid NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+  name text NOT NULL,
+  fixed_cost double precision NOT NULL DEFAULT 0,
+  cost_per_km double precision NOT NULL DEFAULT 0,
+  cost_per_hour double precision NOT NULL DEFAULT 0,
+  overtime_after_sec int,
+  overtime_multiplier double precision NOT NULL DEFAULT 1,
+  PRIMARY KEY (tenant_id, name)
+);
@@ -0,0 +1,6 @@
+-- Geofences as planning zones: an optional polygon (JSON array of {lat,lng})
+-- instead of centre and radius. Territory geofences (type 'territory') name
+-- the vehicles and drivers that serve them in rules.vehicleIds and
+-- rules.driverIds; vehicles.restrictions.forbiddenZones lists the geofences
+-- (by id, name or type, e.g. low_emission) a vehicle may not serve stops in.
+ALTER TABLE geofences ADD COLUMN IF NOT EXISTS polygon jsonb;
@@ -0,0 +1,4 @@
+-- Optimize batch a route was planned in, so a batch can be rolled back as a
+-- whole. Planned stops (and their orders) move from 'pending' to 'assigned'.
+ALTER TABLE routes ADD COLUMN IF NOT EXISTS batch_id text;
+CREATE INDEX IF NOT EXISTS idx_routes_tenant_batch ON routes (tenant_id, batch_id);
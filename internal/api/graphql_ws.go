@@ -74,6 +74,17 @@ func (s *Server) GraphQLWSHandler(w http.ResponseWriter, r *http.Request) {
                     // require routeId variable; if missing, error
                 }
             }
+            // optimizeJobProgress(jobId): dispatcher/admin of the job's tenant
+            if jid, _ := pl.Variables["jobId"].(string); jid != "" && strings.Contains(strings.ToLower(pl.Query), "optimizejobprogress") {
+                pr := s.getPrincipal(r)
+                _, tenant := s.withTenant(r)
+                if _, ok := s.jobQueue().get(tenant, jid); !ok || !(pr.IsAdmin() || pr.Role == "dispatcher") {
+                    _ = write(wsMessage{Type: "error", ID: msg.ID, Payload: []byte(`{"message":"forbidden"}`)})
+                    _ = write(wsMessage{Type: "complete", ID: msg.ID})
+                    continue
+                }
+                rid = jobTopic(jid)
+            }
             // RBAC: admin/dispatcher or assigned driver
             pr := s.getPrincipal(r)
             if !(pr.IsAdmin() || pr.Role == "dispatcher") {
@@ -92,6 +103,7 @@ func (s *Server) GraphQLWSHandler(w http.ResponseWriter, r *http.Request) {
             if strings.Contains(ql, "policyalerts") { field = "policyAlerts" }
             if strings.Contains(ql, "podcaptured") { field = "podCaptured" }
             if strings.Contains(ql, "breakevents") { field = "breakEvents" }
+            if strings.Contains(ql, "optimizejobprogress") { field = "optimizeJobProgress" }
             ch := s.Broker.Subscribe(rid)
             subs[msg.ID] = sub{routeID: rid, ch: ch}
             // Fanout goroutine
@@ -1,16 +1,20 @@
 package api
 
 import (
+    "bytes"
     "context"
     "encoding/json"
+    "errors"
     "fmt"
     "io"
     "net/http"
+    "strconv"
     "strings"
     "time"
 
     "gpsnav/internal/model"
     "gpsnav/internal/opt"
+    "gpsnav/internal/store"
 )
 
 // OrdersHandler handles POST/GET /v1/orders
@@ -25,6 +29,10 @@ func (s *Server) OrdersHandler(w http.ResponseWriter, r *http.Request) {
             writeProblem(w, http.StatusBadRequest, "Invalid JSON", err.Error(), r.URL.Path)
             return
         }
+        if err := validateOrders(req.Orders); err != nil {
+            writeProblem(w, http.StatusBadRequest, "Invalid orders", err.Error(), r.URL.Path)
+            return
+        }
         if req.TenantID == "" { _, req.TenantID = s.withTenant(r) }
         imp, created, skipped, err := s.Store.CreateOrders(r.Context(), req.TenantID, req.Orders)
         if err != nil {
@@ -57,23 +65,53 @@ func (s *Server) OptimizeHandler(w http.ResponseWriter, r *http.Request) {
     }
     p := s.getPrincipal(r)
     if !(p.IsAdmin() || p.Role == "dispatcher") { writeProblem(w, 403, "Forbidden", "dispatcher or admin required", r.URL.Path); return }
+    req, ok := s.decodeOptimizeRequest(w, r)
+    if !ok { return }
+    res, err := s.Store.PlanRoutes(r.Context(), req)
+    if errors.Is(err, store.ErrPlanConflict) { writeProblem(w, http.StatusConflict, "Plan conflict", err.Error(), r.URL.Path); return }
+    if err != nil {
+        writeProblem(w, http.StatusInternalServerError, "Plan routes failed", err.Error(), r.URL.Path)
+        return
+    }
+    s.publishPlannedBreaks(res.Routes)
+    writeJSON(w, http.StatusOK, res)
+}
+
+// ExplainHandler handles POST /v1/optimize/explain: the cost delta and
+// feasibility of a stop at every position of existing routes. Read-only.
+func (s *Server) ExplainHandler(w http.ResponseWriter, r *http.Request) {
+    if r.URL.Path != "/v1/optimize/explain" { writeProblem(w, 404, "Not Found", "", r.URL.Path); return }
+    if r.Method != http.MethodPost { w.WriteHeader(http.StatusMethodNotAllowed); return }
+    p := s.getPrincipal(r)
+    if !(p.IsAdmin() || p.Role == "dispatcher") { writeProblem(w, 403, "Forbidden", "dispatcher or admin required", r.URL.Path); return }
+    var req model.ExplainRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil { writeProblem(w, 400, "Invalid JSON", err.Error(), r.URL.Path); return }
+    if err := validateExplainRequest(req); err != nil { writeProblem(w, 400, "Invalid explain request", err.Error(), r.URL.Path); return }
+    if req.TenantID == "" { _, req.TenantID = s.withTenant(r) }
+    res, err := s.Store.ExplainInsertion(r.Context(), req)
+    if errors.Is(err, store.ErrNotFound) { writeProblem(w, 404, "Stop not found", "no pending stop "+req.StopID, r.URL.Path); return }
+    if err != nil { writeProblem(w, 500, "Explain failed", err.Error(), r.URL.Path); return }
+    writeJSON(w, 200, res)
+}
+
+// decodeOptimizeRequest parses and validates an optimize body, writing the
+// problem response on failure. The tenant defaults to the request's tenant.
+func (s *Server) decodeOptimizeRequest(w http.ResponseWriter, r *http.Request) (model.OptimizeRequest, bool) {
     var req model.OptimizeRequest
     if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
         writeProblem(w, http.StatusBadRequest, "Invalid JSON", err.Error(), r.URL.Path)
-        return
+        return req, false
     }
     if err := validateOptimizeRequest(&req); err != nil {
         writeProblem(w, http.StatusBadRequest, "Invalid optimize request", err.Error(), r.URL.Path)
-        return
+        return req, false
     }
-
     if req.TenantID == "" { _, req.TenantID = s.withTenant(r) }
-    routes, batchID, err := s.Store.PlanRoutes(r.Context(), req)
-    if err != nil {
-        writeProblem(w, http.StatusInternalServerError, "Plan routes failed", err.Error(), r.URL.Path)
-        return
-    }
-    // Publish SSE for planned breaks (webhooks are enqueued by store)
+    return req, true
+}
+
+// publishPlannedBreaks sends SSE for planned breaks (webhooks are enqueued by store).
+func (s *Server) publishPlannedBreaks(routes []model.Route) {
     for _, rt := range routes {
         for _, lg := range rt.Legs {
             if strings.ToLower(lg.Kind) == "break" && lg.BreakSec > 0 {
@@ -82,7 +120,6 @@ func (s *Server) OptimizeHandler(w http.ResponseWriter, r *http.Request) {
             }
         }
     }
-    writeJSON(w, http.StatusOK, map[string]any{"batchId": batchID, "routes": routes})
 }
 
 // OptimizerConfigHandler returns default optimizer configuration
@@ -94,9 +131,11 @@ func (s *Server) OptimizerConfigHandler(w http.ResponseWriter, r *http.Request)
         "maxIterations": 0,
         "initTemp": 1.0,
         "cooling": 0.995,
-        "removalWeights": []float64{1.0, 1.0},
-        "insertionWeights": []float64{1.0, 1.0},
-        "objectives": map[string]float64{"driveTime": 1, "lateness": 4, "failed": 50, "distance": 0.1},
+        "removalWeights": defaultOperatorWeights(opt.RemovalOperators()),
+        "insertionWeights": defaultOperatorWeights(opt.InsertionOperators()),
+        "objectives": map[string]float64{"driveTime": 1, "lateness": 4, "earliness": 0, "failed": 50, "priority": 0, "distance": 0.1, "vehicleCost": 1, "vehicles": 0},
+        "consistency": map[string]any{"weight": 0, "lookbackDays": 28},
+        "balance": map[string]float64{"makespan": 0, "stopBalance": 0, "workBalance": 0, "minStops": 0, "maxStops": 0},
         "latencyBuckets": []int{100, 500, 1000},
     }
     // overlay tenant config if present
@@ -109,6 +148,13 @@ func (s *Server) OptimizerConfigHandler(w http.ResponseWriter, r *http.Request)
     writeJSON(w, 200, map[string]any{"defaults": defaults})
 }
 
+// defaultOperatorWeights weights every registered operator at 1.
+func defaultOperatorWeights(names []string) map[string]float64 {
+    out := make(map[string]float64, len(names))
+    for _, n := range names { out[n] = 1 }
+    return out
+}
+
 // Admin get/set optimizer tenant config
 func (s *Server) AdminOptimizerConfigHandler(w http.ResponseWriter, r *http.Request) {
     if r.URL.Path != "/v1/admin/optimizer/config" { writeProblem(w, 404, "Not Found", "", r.URL.Path); return }
@@ -123,6 +169,7 @@ func (s *Server) AdminOptimizerConfigHandler(w http.ResponseWriter, r *http.Requ
         var body struct{ Config map[string]any `json:"config"` }
         if err := json.NewDecoder(r.Body).Decode(&body); err != nil { writeProblem(w, 400, "Invalid JSON", err.Error(), r.URL.Path); return }
         if body.Config == nil { writeProblem(w, 400, "Missing config", "", r.URL.Path); return }
+        if err := validateOptimizerConfig(body.Config); err != nil { writeProblem(w, 400, "Invalid config", err.Error(), r.URL.Path); return }
         if err := s.Store.SaveOptimizerConfig(r.Context(), p.Tenant, body.Config); err != nil { writeProblem(w, 500, "Save failed", err.Error(), r.URL.Path); return }
         writeJSON(w, 200, map[string]bool{"ok": true})
     default:
@@ -264,13 +311,16 @@ func (s *Server) RouteByIDHandler(w http.ResponseWriter, r *http.Request) {
             }
             route.Legs = legs
         }
+        w.Header().Set("ETag", fmt.Sprintf(`"%d"`, route.Version))
         writeJSON(w, http.StatusOK, route)
     case http.MethodPatch:
-        // Simulate If-Match handling
-        _ = r.Header.Get("If-Match")
         body, _ := io.ReadAll(r.Body)
-        _ = body
+        var patch model.RoutePatch
+        if len(bytes.TrimSpace(body)) > 0 {
+            if err := json.Unmarshal(body, &patch); err != nil { writeProblem(w, 400, "Invalid JSON", err.Error(), r.URL.Path); return }
+        }
         _, tenant := s.withTenant(r)
+        if len(patch.Ops) > 0 { s.editRoute(w, r, tenant, id, patch); return }
         route, err := s.Store.PatchRoute(r.Context(), tenant, id, model.RoutePatch{Status: "updated"})
         if err != nil {
             writeProblem(w, http.StatusInternalServerError, "Update route failed", err.Error(), r.URL.Path)
@@ -282,6 +332,34 @@ func (s *Server) RouteByIDHandler(w http.ResponseWriter, r *http.Request) {
     }
 }
 
+// editRoute applies a PATCH carrying stop ops. The route's version must be
+// given in If-Match; the result carries the new version as ETag.
+func (s *Server) editRoute(w http.ResponseWriter, r *http.Request, tenant, id string, patch model.RoutePatch) {
+    pr := s.getPrincipal(r)
+    if !(pr.IsAdmin() || pr.Role == "dispatcher") { writeProblem(w, 403, "Forbidden", "dispatcher or admin required", r.URL.Path); return }
+    if err := validateRouteEdit(patch); err != nil { writeProblem(w, 400, "Invalid route edit", err.Error(), r.URL.Path); return }
+    im := strings.Trim(strings.TrimPrefix(strings.TrimSpace(r.Header.Get("If-Match")), "W/"), `"`)
+    if im == "" { writeProblem(w, 428, "Precondition Required", "If-Match with the route version is required to edit stops", r.URL.Path); return }
+    version, err := strconv.Atoi(im)
+    if err != nil { writeProblem(w, 400, "Invalid If-Match", "If-Match must be the route version", r.URL.Path); return }
+    res, err := s.Store.EditRoute(r.Context(), tenant, id, version, patch)
+    switch {
+    case errors.Is(err, store.ErrNotFound):
+        writeProblem(w, 404, "Route not found", err.Error(), r.URL.Path); return
+    case errors.Is(err, store.ErrVersionConflict):
+        writeProblem(w, 412, "Precondition Failed", "route was changed since version "+im, r.URL.Path); return
+    case errors.Is(err, store.ErrInvalidEdit):
+        writeProblem(w, 400, "Invalid route edit", err.Error(), r.URL.Path); return
+    case err != nil:
+        writeProblem(w, 500, "Edit route failed", err.Error(), r.URL.Path); return
+    }
+    for _, rt := range append([]model.Route{res.Route}, res.Changed...) {
+        s.Broker.Publish(rt.ID, SSEEvent{Type: "route.edited", Data: map[string]any{"routeId": rt.ID, "version": rt.Version}})
+    }
+    w.Header().Set("ETag", fmt.Sprintf(`"%d"`, res.Route.Version))
+    writeJSON(w, 200, res)
+}
+
 // RoutesIndexHandler exists for completeness (not used yet)
 func (s *Server) RoutesIndexHandler(w http.ResponseWriter, r *http.Request) {
     if r.URL.Path != "/v1/routes" { writeProblem(w, http.StatusNotFound, "Not Found", "", r.URL.Path); return }
@@ -493,6 +571,7 @@ func (s *Server) GeofencesHandler(w http.ResponseWriter, r *http.Request) {
         if !(pr.IsAdmin() || pr.Role == "dispatcher") { writeProblem(w, 403, "Forbidden", "dispatcher or admin required", r.URL.Path); return }
         var in model.GeofenceInput
         if err := json.NewDecoder(r.Body).Decode(&in); err != nil { writeProblem(w, 400, "Invalid JSON", err.Error(), r.URL.Path); return }
+        if err := validateGeofenceInput(in); err != nil { writeProblem(w, 400, "Invalid geofence", err.Error(), r.URL.Path); return }
         gf, err := s.Store.CreateGeofence(r.Context(), tenant, in)
         if err != nil { writeProblem(w, 500, "Create geofence failed", err.Error(), r.URL.Path); return }
         writeJSON(w, 201, gf)
@@ -516,6 +595,7 @@ func (s *Server) GeofenceByIDHandler(w http.ResponseWriter, r *http.Request) {
         if !(pr.IsAdmin() || pr.Role == "dispatcher") { writeProblem(w, 403, "Forbidden", "dispatcher or admin required", r.URL.Path); return }
         var in model.GeofenceInput
         if err := json.NewDecoder(r.Body).Decode(&in); err != nil { writeProblem(w, 400, "Invalid JSON", err.Error(), r.URL.Path); return }
+        if err := validateGeofenceInput(in); err != nil { writeProblem(w, 400, "Invalid geofence", err.Error(), r.URL.Path); return }
         gf, err := s.Store.PatchGeofence(r.Context(), tenant, id, in)
         if err != nil { writeProblem(w, 500, "Update geofence failed", err.Error(), r.URL.Path); return }
         writeJSON(w, 200, gf)
@@ -665,8 +745,12 @@ func (s *Server) PlanMetricsHandler(w http.ResponseWriter, r *http.Request) {
                 "acceptedWorse": m.AcceptedWorse,
                 "bestCost": m.BestCost,
                 "finalCost": m.FinalCost,
-                "removalSelects": []int{m.RemovalSelects[0], m.RemovalSelects[1]},
-                "insertSelects": []int{m.InsertSelects[0], m.InsertSelects[1]},
+                "removalSelects": m.RemovalSelects,
+                "removalSuccesses": m.RemovalSuccesses,
+                "insertSelects": m.InsertSelects,
+                "insertSuccesses": m.InsertSuccesses,
+                "seed": m.Seed,
+                "workers": m.Workers,
             })
         }
         items = i2
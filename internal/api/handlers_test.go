@@ -8,6 +8,8 @@ import (
     "net/http/httptest"
     "testing"
     "time"
+
+    "gpsnav/internal/model"
 )
 
 func newTestServer(t *testing.T) *Server {
@@ -43,6 +45,20 @@ func TestOrdersCreateList(t *testing.T) {
     if rr.Code != 200 { t.Fatalf("orders list: got %d", rr.Code) }
 }
 
+func TestOrdersTimeWindowsValidated(t *testing.T) {
+    s := newTestServer(t)
+    post := func(stop string) int {
+        rr := httptest.NewRecorder()
+        s.OrdersHandler(rr, httptest.NewRequest(http.MethodPost, "/v1/orders", bytes.NewReader([]byte(`{"tenantId":"t_test","orders":[{"stops":[`+stop+`]}]}`))))
+        return rr.Code
+    }
+    am, pm := `{"start":"2025-03-03T09:00:00Z","end":"2025-03-03T12:00:00Z"}`, `{"start":"2025-03-03T14:00:00Z","end":"2025-03-03T17:00:00Z"}`
+    if c := post(`{"type":"delivery","location":{"lat":1,"lng":2},"timeWindows":[`+am+`,`+pm+`],"timeWindowMode":"soft","maxLatenessSec":900}`); c != http.StatusAccepted { t.Fatalf("two soft windows: %d", c) }
+    if c := post(`{"type":"delivery","location":{"lat":1,"lng":2},"timeWindows":[`+pm+`,`+am+`]}`); c != 400 { t.Fatalf("windows out of order: %d", c) }
+    if c := post(`{"type":"delivery","location":{"lat":1,"lng":2},"timeWindow":`+am+`,"maxLatenessSec":900}`); c != 400 { t.Fatalf("lateness cap on a hard window: %d", c) }
+    if c := post(`{"type":"delivery","location":{"lat":1,"lng":2},"timeWindow":`+am+`,"timeWindowMode":"flexible"}`); c != 400 { t.Fatalf("unknown mode: %d", c) }
+}
+
 func TestOptimizeAndRoute(t *testing.T) {
     s := newTestServer(t)
     // Optimize
@@ -110,6 +126,11 @@ func TestAssignAdvanceEnqueuesWebhook(t *testing.T) {
     s.SubscriptionsHandler(rr, req)
     if rr.Code != http.StatusCreated { t.Fatalf("create sub: %d", rr.Code) }
 
+    // Import stops to plan
+    rr = httptest.NewRecorder()
+    s.OrdersHandler(rr, httptest.NewRequest(http.MethodPost, "/v1/orders", bytes.NewReader([]byte(`{"tenantId":"t_test","orders":[{"stops":[{"type":"delivery","location":{"lat":1,"lng":2}},{"type":"delivery","location":{"lat":1.01,"lng":2.01}}]}]}`))))
+    if rr.Code != http.StatusAccepted { t.Fatalf("orders: %d", rr.Code) }
+
     // Optimize to get a route
     oreq := map[string]any{"tenantId":"t_test","planDate":"2024-01-01","algorithm":"greedy"}
     ob,_ := json.Marshal(oreq)
@@ -227,3 +248,179 @@ func TestRouteEventsSSE(t *testing.T) {
         t.Fatal("handler did not exit after cancel")
     }
 }
+
+func TestReoptimizeKeepsRouteIDs(t *testing.T) {
+    s := newTestServer(t)
+    optimize := func(body map[string]any) map[string]any {
+        b, _ := json.Marshal(body)
+        rr := httptest.NewRecorder()
+        s.OptimizeHandler(rr, httptest.NewRequest(http.MethodPost, "/v1/optimize", bytes.NewReader(b)))
+        if rr.Code != 200 { t.Fatalf("optimize: %d %s", rr.Code, rr.Body.String()) }
+        var out map[string]any
+        _ = json.Unmarshal(rr.Body.Bytes(), &out)
+        return out
+    }
+    first := optimize(map[string]any{"tenantId": "t_reopt", "planDate": "2024-02-01"})
+    rid := first["routes"].([]any)[0].(map[string]any)["id"].(string)
+    again := optimize(map[string]any{"tenantId": "t_reopt", "planDate": "2024-02-01", "reoptimize": true})
+    routes := again["routes"].([]any)
+    if len(routes) != 1 { t.Fatalf("reoptimize should not duplicate routes: %d", len(routes)) }
+    r := routes[0].(map[string]any)
+    if r["id"] != rid || r["version"].(float64) != 2 { t.Fatalf("want %s v2, got %v v%v", rid, r["id"], r["version"]) }
+    frozen := optimize(map[string]any{"tenantId": "t_reopt", "planDate": "2024-02-01", "reoptimize": true, "freeze": map[string]any{"routes": []string{rid}}})
+    if v := frozen["routes"].([]any)[0].(map[string]any)["version"].(float64); v != 2 { t.Fatalf("frozen route version changed: %v", v) }
+}
+
+func TestOperatorWeightsValidated(t *testing.T) {
+    s := newTestServer(t)
+    post := func(body string) int {
+        rr := httptest.NewRecorder()
+        s.OptimizeHandler(rr, httptest.NewRequest(http.MethodPost, "/v1/optimize", bytes.NewReader([]byte(body))))
+        return rr.Code
+    }
+    if c := post(`{"planDate":"2024-03-02","removalWeights":{"worst":1,"cluster":2},"insertionWeights":{"regret3":1}}`); c != 200 { t.Fatalf("named weights: %d", c) }
+    if c := post(`{"planDate":"2024-03-02","removalWeights":{"bogus":1}}`); c != 400 { t.Fatalf("unknown operator: %d", c) }
+    if c := post(`{"planDate":"2024-03-02","insertionWeights":{"greedy":0}}`); c != 400 { t.Fatalf("all operators disabled: %d", c) }
+    rr := httptest.NewRecorder()
+    s.AdminOptimizerConfigHandler(rr, httptest.NewRequest(http.MethodPut, "/v1/admin/optimizer/config", bytes.NewReader([]byte(`{"config":{"removalWeights":{"nope":1}}}`))))
+    if rr.Code != 400 { t.Fatalf("config with unknown operator: %d %s", rr.Code, rr.Body.String()) }
+}
+
+func TestPriorityObjectivesValidated(t *testing.T) {
+    s := newTestServer(t)
+    post := func(body string) int {
+        rr := httptest.NewRecorder()
+        s.OptimizeHandler(rr, httptest.NewRequest(http.MethodPost, "/v1/optimize", bytes.NewReader([]byte(body))))
+        return rr.Code
+    }
+    if c := post(`{"planDate":"2024-03-02","objectives":{"failed":50,"priority":0.5,"serviceLevel.same_day":4},"constraints":{"mustServePriority":3}}`); c != 200 { t.Fatalf("priority objectives: %d", c) }
+    if c := post(`{"planDate":"2024-03-02","objectives":{"serviceLevel.":4}}`); c != 400 { t.Fatalf("empty service level: %d", c) }
+    for _, k := range []string{"Failed", "vehiclecost", "minstops", "servicelevel.same_day"} {
+        if c := post(`{"planDate":"2024-03-02","objectives":{"` + k + `":1}}`); c != 400 { t.Fatalf("miscased objective %s: %d", k, c) }
+    }
+    if c := post(`{"planDate":"2024-03-02","constraints":{"mustServePriority":0.5}}`); c != 400 { t.Fatalf("fractional must-serve priority: %d", c) }
+}
+
+func TestGeofencePolygonValidated(t *testing.T) {
+    s := newTestServer(t)
+    post := func(body string) *httptest.ResponseRecorder {
+        rr := httptest.NewRecorder()
+        s.GeofencesHandler(rr, httptest.NewRequest(http.MethodPost, "/v1/geofences", bytes.NewReader([]byte(body))))
+        return rr
+    }
+    rr := post(`{"name":"north","type":"territory","polygon":[{"lat":1,"lng":1},{"lat":1,"lng":2},{"lat":2,"lng":2}],"rules":{"vehicleIds":["v1"]}}`)
+    if rr.Code != 201 { t.Fatalf("territory polygon: %d", rr.Code) }
+    var gf struct{ Polygon []map[string]float64 `json:"polygon"` }
+    _ = json.Unmarshal(rr.Body.Bytes(), &gf)
+    if len(gf.Polygon) != 3 { t.Fatalf("polygon not kept: %s", rr.Body.String()) }
+    if c := post(`{"type":"low_emission","polygon":[{"lat":1,"lng":1},{"lat":1,"lng":2}]}`).Code; c != 400 { t.Fatalf("two-point polygon: %d", c) }
+    if c := post(`{"type":"low_emission","center":{"lat":95,"lng":1},"radiusM":500}`).Code; c != 400 { t.Fatalf("bad centre: %d", c) }
+}
+
+func TestConsistencyConfigValidated(t *testing.T) {
+    s := newTestServer(t)
+    put := func(body string) int {
+        rr := httptest.NewRecorder()
+        s.AdminOptimizerConfigHandler(rr, httptest.NewRequest(http.MethodPut, "/v1/admin/optimizer/config", bytes.NewReader([]byte(body))))
+        return rr.Code
+    }
+    if c := put(`{"config":{"consistency":{"weight":600,"lookbackDays":14}}}`); c != 200 { t.Fatalf("consistency config: %d", c) }
+    if c := put(`{"config":{"consistency":{"weight":-1}}}`); c != 400 { t.Fatalf("negative weight: %d", c) }
+    if c := put(`{"config":{"consistency":5}}`); c != 400 { t.Fatalf("scalar consistency: %d", c) }
+    rr := httptest.NewRecorder()
+    s.OptimizeHandler(rr, httptest.NewRequest(http.MethodPost, "/v1/optimize", bytes.NewReader([]byte(`{"planDate":"2024-03-02","objectives":{"consistency":300}}`))))
+    if rr.Code != 200 { t.Fatalf("consistency objective: %d", rr.Code) }
+}
+
+func TestBalanceObjectivesValidated(t *testing.T) {
+    s := newTestServer(t)
+    post := func(body string) int {
+        rr := httptest.NewRecorder()
+        s.OptimizeHandler(rr, httptest.NewRequest(http.MethodPost, "/v1/optimize", bytes.NewReader([]byte(body))))
+        return rr.Code
+    }
+    put := func(body string) int {
+        rr := httptest.NewRecorder()
+        s.AdminOptimizerConfigHandler(rr, httptest.NewRequest(http.MethodPut, "/v1/admin/optimizer/config", bytes.NewReader([]byte(body))))
+        return rr.Code
+    }
+    if c := post(`{"planDate":"2024-03-02","objectives":{"makespan":2,"stopBalance":600,"workBalance":1,"minStops":3,"maxStops":40}}`); c != 200 { t.Fatalf("balance objectives: %d", c) }
+    if c := post(`{"planDate":"2024-03-02","objectives":{"maxStops":2.5}}`); c != 400 { t.Fatalf("fractional maxStops: %d", c) }
+    if c := post(`{"planDate":"2024-03-02","objectives":{"minStops":10,"maxStops":5}}`); c != 400 { t.Fatalf("minStops above maxStops: %d", c) }
+    if c := put(`{"config":{"balance":{"stopBalance":600,"maxStops":30}}}`); c != 200 { t.Fatalf("balance config: %d", c) }
+    if c := put(`{"config":{"balance":{"spread":1}}}`); c != 400 { t.Fatalf("unknown balance key: %d", c) }
+    if c := put(`{"config":{"balance":{"makespan":-1}}}`); c != 400 { t.Fatalf("negative balance weight: %d", c) }
+}
+
+func TestExplainInsertion(t *testing.T) {
+    s := newTestServer(t)
+    rr := httptest.NewRecorder()
+    s.OrdersHandler(rr, httptest.NewRequest(http.MethodPost, "/v1/orders", bytes.NewReader([]byte(`{"tenantId":"t_ex","orders":[{"stops":[{"type":"delivery","location":{"lat":1,"lng":2}},{"type":"delivery","location":{"lat":1.01,"lng":2.01}}]},{"stops":[{"type":"delivery","location":{"lat":1.2,"lng":2.2}}]}]}`))))
+    if rr.Code != http.StatusAccepted { t.Fatalf("orders: %d", rr.Code) }
+    rr = httptest.NewRecorder()
+    s.OptimizeHandler(rr, httptest.NewRequest(http.MethodPost, "/v1/optimize", bytes.NewReader([]byte(`{"tenantId":"t_ex","planDate":"2024-05-06","vehiclePool":["v1","v2"]}`))))
+    if rr.Code != 200 { t.Fatalf("optimize: %d %s", rr.Code, rr.Body.String()) }
+    var plan model.PlanResult
+    _ = json.Unmarshal(rr.Body.Bytes(), &plan)
+    if len(plan.Routes) != 2 { t.Fatalf("routes: %d", len(plan.Routes)) }
+    stopID := plan.Routes[0].Legs[0].ToStopID
+    explain := func(body string) *httptest.ResponseRecorder {
+        rr := httptest.NewRecorder()
+        s.ExplainHandler(rr, httptest.NewRequest(http.MethodPost, "/v1/optimize/explain", bytes.NewReader([]byte(body))))
+        return rr
+    }
+    rr = explain(`{"tenantId":"t_ex","planDate":"2024-05-06","stopId":"` + stopID + `"}`)
+    if rr.Code != 200 { t.Fatalf("explain: %d %s", rr.Code, rr.Body.String()) }
+    var res model.ExplainResult
+    _ = json.Unmarshal(rr.Body.Bytes(), &res)
+    // each route offers one position more than its other stops: 4 in all
+    if res.CurrentRouteID != plan.Routes[0].ID || res.CurrentPosition != 0 || len(res.Options) != 4 || !res.Options[0].Feasible { t.Fatalf("explanation: %+v", res) }
+    for _, o := range res.Options { if o.RouteID == res.CurrentRouteID && o.Position == 0 && o.CostDelta <= 0 { t.Fatalf("current slot: %+v", o) } }
+    if got, _ := s.Store.GetRoute(context.Background(), "t_ex", plan.Routes[0].ID); len(got.Legs) != len(plan.Routes[0].Legs) || got.Version != plan.Routes[0].Version { t.Fatalf("explain changed the route: %+v", got) }
+    if c := explain(`{"tenantId":"t_ex","planDate":"2024-05-06","stopId":"nope"}`).Code; c != 404 { t.Fatalf("unknown stop: %d", c) }
+    if c := explain(`{"tenantId":"t_ex","planDate":"2024-05-06"}`).Code; c != 400 { t.Fatalf("missing stop: %d", c) }
+}
+
+func TestEditRoute(t *testing.T) {
+    s := newTestServer(t)
+    rr := httptest.NewRecorder()
+    s.OrdersHandler(rr, httptest.NewRequest(http.MethodPost, "/v1/orders", bytes.NewReader([]byte(`{"tenantId":"t_ed","orders":[{"stops":[{"type":"delivery","location":{"lat":1,"lng":2}}]},{"stops":[{"type":"delivery","location":{"lat":1.01,"lng":2.01}}]},{"stops":[{"type":"delivery","location":{"lat":1.02,"lng":2.02}}]},{"stops":[{"type":"delivery","location":{"lat":1.5,"lng":2.5}}]}]}`))))
+    if rr.Code != http.StatusAccepted { t.Fatalf("orders: %d", rr.Code) }
+    rr = httptest.NewRecorder()
+    s.OptimizeHandler(rr, httptest.NewRequest(http.MethodPost, "/v1/optimize", bytes.NewReader([]byte(`{"tenantId":"t_ed","planDate":"2024-05-06","vehiclePool":["v1","v2"]}`))))
+    if rr.Code != 200 { t.Fatalf("optimize: %d %s", rr.Code, rr.Body.String()) }
+    var plan model.PlanResult
+    _ = json.Unmarshal(rr.Body.Bytes(), &plan)
+    if len(plan.Routes) != 2 { t.Fatalf("routes: %d", len(plan.Routes)) }
+    from, to := plan.Routes[0], plan.Routes[1]
+    if len(from.Legs) < len(to.Legs) { from, to = to, from }
+    if len(from.Legs) < 3 { t.Fatalf("legs: %d and %d", len(from.Legs), len(to.Legs)) }
+    // the first leg is in progress, so only later stops can move
+    stopID, second := from.Legs[len(from.Legs)-1].ToStopID, from.Legs[len(from.Legs)-2].ToStopID
+    patch := func(id, ifMatch, body string) *httptest.ResponseRecorder {
+        rr := httptest.NewRecorder()
+        req := httptest.NewRequest(http.MethodPatch, "/v1/routes/"+id, bytes.NewReader([]byte(body)))
+        req.Header.Set("X-Tenant-Id", "t_ed")
+        if ifMatch != "" { req.Header.Set("If-Match", ifMatch) }
+        s.RouteByIDHandler(rr, req)
+        return rr
+    }
+    move := `{"ops":[{"op":"move","stopId":"` + stopID + `","toRouteId":"` + to.ID + `","position":0},{"op":"move","stopId":"` + second + `","toRouteId":"` + to.ID + `"}]}`
+    if c := patch(from.ID, "", move).Code; c != 428 { t.Fatalf("no If-Match: %d", c) }
+    if c := patch(from.ID, `"1"`, `{"ops":[{"op":"move","stopId":"` + from.Legs[0].ToStopID + `","toRouteId":"` + to.ID + `"}]}`).Code; c != 400 { t.Fatalf("in-progress stop: %d", c) }
+    if c := patch(from.ID, `"1"`, `{"ops":[{"op":"teleport","stopId":"x"}]}`).Code; c != 400 { t.Fatalf("unknown op: %d", c) }
+    _ = s.Store.SaveOptimizerConfig(context.Background(), "t_ed", map[string]any{"balance": map[string]any{"maxStops": 1}})
+    rr = patch(from.ID, `"1"`, move)
+    if rr.Code != 200 { t.Fatalf("move: %d %s", rr.Code, rr.Body.String()) }
+    if rr.Header().Get("ETag") != `"2"` { t.Fatalf("etag: %q", rr.Header().Get("ETag")) }
+    var res model.RouteEditResult
+    _ = json.Unmarshal(rr.Body.Bytes(), &res)
+    if res.Route.Version != 2 || len(res.Route.Legs) != len(from.Legs)-2 || res.Route.Legs[0].ID != from.Legs[0].ID { t.Fatalf("edited route: %+v", res.Route) }
+    if len(res.Changed) != 1 || res.Changed[0].ID != to.ID || res.Changed[0].Version != 2 { t.Fatalf("changed: %+v", res.Changed) }
+    got := res.Changed[0].Legs
+    if got[0].ID != to.Legs[0].ID || got[1].ToStopID != stopID || got[1].FromStopID != to.Legs[0].ToStopID || got[1].DistM <= 0 || got[1].ETAArrival == "" || got[2].ToStopID != second { t.Fatalf("target legs: %+v", got) }
+    // the target's tail now has a stop over its limit: kept, but reported
+    if len(res.Warnings) != 1 || res.Warnings[0].RouteID != to.ID || res.Warnings[0].Reason != "max_stops" { t.Fatalf("warnings: %+v", res.Warnings) }
+    if c := patch(from.ID, `"1"`, move).Code; c != 412 { t.Fatalf("stale version: %d", c) }
+    if c := patch("nope", `"1"`, move).Code; c != 404 { t.Fatalf("unknown route: %d", c) }
+}
@@ -0,0 +1,258 @@
+package api
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/google/uuid"
+
+    "gpsnav/internal/model"
+    "gpsnav/internal/opt"
+)
+
+// Finished jobs are kept this long for GET before being dropped.
+const jobRetention = time.Hour
+
+type optimizeJob struct {
+    model.OptimizeJob // guarded by jobQueue.mu
+    req    model.OptimizeRequest
+    ctx    context.Context
+    cancel context.CancelFunc
+    done   time.Time
+}
+
+// jobQueue runs optimize jobs on a fixed worker pool. A worker only takes a
+// job whose tenant is below tenantLimit running jobs, so one tenant cannot
+// occupy every worker.
+type jobQueue struct {
+    mu          sync.Mutex
+    cond        *sync.Cond
+    jobs        map[string]*optimizeJob
+    pending     []*optimizeJob
+    running     map[string]int // tenant -> running jobs
+    tenantLimit int
+    run         func(*optimizeJob)
+}
+
+func newJobQueue(workers, tenantLimit int, run func(*optimizeJob)) *jobQueue {
+    q := &jobQueue{jobs: map[string]*optimizeJob{}, running: map[string]int{}, tenantLimit: tenantLimit, run: run}
+    q.cond = sync.NewCond(&q.mu)
+    for i := 0; i < workers; i++ { go q.worker() }
+    return q
+}
+
+// jobQueue lazily starts the worker pool, sized by OPT_JOB_WORKERS (default 4)
+// and OPT_JOB_TENANT_LIMIT (default 2).
+func (s *Server) jobQueue() *jobQueue {
+    s.jobsOnce.Do(func() {
+        s.jobs = newJobQueue(envInt("OPT_JOB_WORKERS", 4), envInt("OPT_JOB_TENANT_LIMIT", 2), s.runOptimizeJob)
+    })
+    return s.jobs
+}
+
+func envInt(name string, def int) int {
+    if v, err := strconv.Atoi(os.Getenv(name)); err == nil && v > 0 { return v }
+    return def
+}
+
+func (q *jobQueue) worker() {
+    for {
+        q.mu.Lock()
+        j := q.next()
+        for j == nil { q.cond.Wait(); j = q.next() }
+        q.running[j.TenantID]++
+        j.Status, j.StartedAt = "running", time.Now().UTC().Format(time.RFC3339)
+        q.mu.Unlock()
+
+        q.run(j)
+
+        q.mu.Lock()
+        q.running[j.TenantID]--
+        if q.running[j.TenantID] == 0 { delete(q.running, j.TenantID) }
+        q.cond.Broadcast()
+        q.mu.Unlock()
+    }
+}
+
+// next pops the oldest pending job whose tenant has a free slot. Caller holds mu.
+func (q *jobQueue) next() *optimizeJob {
+    for i, j := range q.pending {
+        if q.running[j.TenantID] >= q.tenantLimit { continue }
+        q.pending = append(q.pending[:i], q.pending[i+1:]...)
+        return j
+    }
+    return nil
+}
+
+func (q *jobQueue) submit(req model.OptimizeRequest) model.OptimizeJob {
+    ctx, cancel := context.WithCancel(context.Background())
+    j := &optimizeJob{req: req, ctx: ctx, cancel: cancel}
+    j.ID, j.TenantID, j.Status, j.CreatedAt = "job_"+uuid.New().String(), req.TenantID, "queued", time.Now().UTC().Format(time.RFC3339)
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    for id, old := range q.jobs {
+        if !old.done.IsZero() && time.Since(old.done) > jobRetention { delete(q.jobs, id) }
+    }
+    q.jobs[j.ID] = j
+    q.pending = append(q.pending, j)
+    q.cond.Signal()
+    return j.OptimizeJob
+}
+
+// get returns a copy of the job when it belongs to tenant.
+func (q *jobQueue) get(tenant, id string) (model.OptimizeJob, bool) {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    j, ok := q.jobs[id]
+    if !ok || j.TenantID != tenant { return model.OptimizeJob{}, false }
+    return j.OptimizeJob, true
+}
+
+var errJobFinished = errors.New("job already finished")
+
+// cancel drops a queued job or signals a running one to stop; the running
+// job is marked cancelled by its worker once the planner returns.
+func (q *jobQueue) cancel(tenant, id string) (model.OptimizeJob, bool, error) {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    j, ok := q.jobs[id]
+    if !ok || j.TenantID != tenant { return model.OptimizeJob{}, false, nil }
+    switch j.Status {
+    case "queued":
+        for i, pj := range q.pending {
+            if pj == j { q.pending = append(q.pending[:i], q.pending[i+1:]...); break }
+        }
+        j.cancel()
+        j.Status, j.FinishedAt, j.done = "cancelled", time.Now().UTC().Format(time.RFC3339), time.Now()
+    case "running":
+        j.cancel()
+    default:
+        return j.OptimizeJob, true, errJobFinished
+    }
+    return j.OptimizeJob, true, nil
+}
+
+func (q *jobQueue) update(j *optimizeJob, fn func(*optimizeJob)) model.OptimizeJob {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    fn(j)
+    return j.OptimizeJob
+}
+
+func jobTopic(id string) string { return "optimize-job:" + id }
+
+func jobEvent(job model.OptimizeJob) SSEEvent {
+    data := map[string]any{"jobId": job.ID, "status": job.Status}
+    if job.BatchID != "" { data["batchId"] = job.BatchID }
+    if job.Error != "" { data["error"] = job.Error }
+    return SSEEvent{Type: "optimize.job." + job.Status, Data: data}
+}
+
+func jobFinished(status string) bool { return status == "succeeded" || status == "failed" || status == "cancelled" }
+
+// runOptimizeJob plans one job, publishing solver snapshots as optimize.progress.
+func (s *Server) runOptimizeJob(j *optimizeJob) {
+    q := s.jobs
+    ctx := opt.WithProgress(j.ctx, func(ws opt.WeightSnapshot) {
+        pg := &model.JobProgress{Iteration: ws.Iteration, BestCost: ws.BestCost, RemovalWeights: ws.Removal, InsertionWeights: ws.Insertion}
+        q.update(j, func(j *optimizeJob) { j.Progress = pg })
+        s.Broker.Publish(jobTopic(j.ID), SSEEvent{Type: "optimize.progress", Data: map[string]any{"jobId": j.ID, "iteration": pg.Iteration, "bestCost": pg.BestCost, "removalWeights": pg.RemovalWeights, "insertionWeights": pg.InsertionWeights}})
+    })
+    s.Broker.Publish(jobTopic(j.ID), jobEvent(q.update(j, func(*optimizeJob) {})))
+    res, err := s.Store.PlanRoutes(ctx, j.req)
+    job := q.update(j, func(j *optimizeJob) {
+        switch {
+        case j.ctx.Err() != nil:
+            j.Status = "cancelled"
+        case err != nil:
+            j.Status, j.Error = "failed", err.Error()
+        default:
+            j.Status, j.BatchID, j.Routes, j.Unassigned, j.ZoneViolations = "succeeded", res.BatchID, res.Routes, res.Unassigned, res.ZoneViolations
+        }
+        j.FinishedAt, j.done = time.Now().UTC().Format(time.RFC3339), time.Now()
+    })
+    j.cancel()
+    if job.Status == "succeeded" { s.publishPlannedBreaks(res.Routes) }
+    s.Broker.Publish(jobTopic(j.ID), jobEvent(job))
+}
+
+// OptimizeJobsHandler handles /v1/optimize/jobs: POST submits, GET /{id}
+// returns status and result, DELETE /{id} cancels, and GET /{id}/events/stream
+// streams progress over SSE.
+func (s *Server) OptimizeJobsHandler(w http.ResponseWriter, r *http.Request) {
+    p := s.getPrincipal(r)
+    if !(p.IsAdmin() || p.Role == "dispatcher") { writeProblem(w, 403, "Forbidden", "dispatcher or admin required", r.URL.Path); return }
+    rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/optimize/jobs"), "/")
+    if rest == "" {
+        if r.Method != http.MethodPost { w.WriteHeader(http.StatusMethodNotAllowed); return }
+        req, ok := s.decodeOptimizeRequest(w, r)
+        if !ok { return }
+        job := s.jobQueue().submit(req)
+        w.Header().Set("Location", "/v1/optimize/jobs/"+job.ID)
+        writeJSON(w, http.StatusAccepted, job)
+        return
+    }
+    parts := strings.Split(rest, "/")
+    id := parts[0]
+    _, tenant := s.withTenant(r)
+    if len(parts) == 3 && parts[1] == "events" && parts[2] == "stream" {
+        if r.Method != http.MethodGet { w.WriteHeader(http.StatusMethodNotAllowed); return }
+        s.streamOptimizeJob(w, r, tenant, id)
+        return
+    }
+    if len(parts) != 1 { writeProblem(w, 404, "Not Found", "", r.URL.Path); return }
+    switch r.Method {
+    case http.MethodGet:
+        job, ok := s.jobQueue().get(tenant, id)
+        if !ok { writeProblem(w, 404, "Job not found", id, r.URL.Path); return }
+        writeJSON(w, 200, job)
+    case http.MethodDelete:
+        job, ok, err := s.jobQueue().cancel(tenant, id)
+        if !ok { writeProblem(w, 404, "Job not found", id, r.URL.Path); return }
+        if err != nil { writeProblem(w, http.StatusConflict, "Cancel failed", err.Error(), r.URL.Path); return }
+        if job.Status == "cancelled" { s.Broker.Publish(jobTopic(id), jobEvent(job)) }
+        writeJSON(w, http.StatusAccepted, job)
+    default:
+        w.WriteHeader(http.StatusMethodNotAllowed)
+    }
+}
+
+func (s *Server) streamOptimizeJob(w http.ResponseWriter, r *http.Request, tenant, id string) {
+    flusher, ok := w.(http.Flusher)
+    if !ok { writeProblem(w, 500, "Streaming unsupported", "", r.URL.Path); return }
+    ch := s.Broker.Subscribe(jobTopic(id))
+    defer s.Broker.Unsubscribe(jobTopic(id), ch)
+    job, ok := s.jobQueue().get(tenant, id)
+    if !ok { writeProblem(w, 404, "Job not found", id, r.URL.Path); return }
+    w.Header().Set("Content-Type", "text/event-stream")
+    w.Header().Set("Cache-Control", "no-cache")
+    w.Header().Set("Connection", "keep-alive")
+    send := func(evt SSEEvent) {
+        b, _ := json.Marshal(evt.Data)
+        fmt.Fprintf(w, "event: %s\n", evt.Type)
+        fmt.Fprintf(w, "data: %s\n\n", string(b))
+        flusher.Flush()
+    }
+    // current state first; the stream ends once the job finishes
+    send(jobEvent(job))
+    if jobFinished(job.Status) { return }
+    for {
+        select {
+        case <-r.Context().Done():
+            return
+        case evt := <-ch:
+            send(evt)
+            if st, _ := evt.Data["status"].(string); evt.Type != "optimize.progress" && jobFinished(st) { return }
+        case <-time.After(15 * time.Second):
+            send(SSEEvent{Type: "heartbeat", Data: map[string]any{"jobId": id, "ts": time.Now().Format(time.RFC3339)}})
+        }
+    }
+}
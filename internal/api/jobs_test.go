@@ -0,0 +1,85 @@
+package api
+
+import (
+    "bytes"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "sync"
+    "testing"
+    "time"
+
+    "gpsnav/internal/model"
+)
+
+func TestOptimizeJobLifecycle(t *testing.T) {
+    s := newTestServer(t)
+    rr := httptest.NewRecorder()
+    s.OptimizeJobsHandler(rr, httptest.NewRequest(http.MethodPost, "/v1/optimize/jobs", bytes.NewReader([]byte(`{"planDate":"2024-03-01"}`))))
+    if rr.Code != http.StatusAccepted { t.Fatalf("submit: %d %s", rr.Code, rr.Body.String()) }
+    var job model.OptimizeJob
+    _ = json.Unmarshal(rr.Body.Bytes(), &job)
+    if job.ID == "" || job.Status != "queued" { t.Fatalf("unexpected job: %+v", job) }
+
+    deadline := time.Now().Add(2 * time.Second)
+    for !jobFinished(job.Status) && time.Now().Before(deadline) {
+        time.Sleep(10 * time.Millisecond)
+        rr = httptest.NewRecorder()
+        s.OptimizeJobsHandler(rr, httptest.NewRequest(http.MethodGet, "/v1/optimize/jobs/"+job.ID, nil))
+        if rr.Code != 200 { t.Fatalf("get: %d %s", rr.Code, rr.Body.String()) }
+        _ = json.Unmarshal(rr.Body.Bytes(), &job)
+    }
+    if job.Status != "succeeded" || job.BatchID == "" || len(job.Routes) == 0 { t.Fatalf("job did not succeed: %+v", job) }
+
+    rr = httptest.NewRecorder()
+    s.OptimizeJobsHandler(rr, httptest.NewRequest(http.MethodDelete, "/v1/optimize/jobs/"+job.ID, nil))
+    if rr.Code != http.StatusConflict { t.Fatalf("cancel finished job: got %d", rr.Code) }
+
+    rr = httptest.NewRecorder()
+    req := httptest.NewRequest(http.MethodGet, "/v1/optimize/jobs/"+job.ID, nil)
+    req.Header.Set("X-Tenant-Id", "t_other")
+    s.OptimizeJobsHandler(rr, req)
+    if rr.Code != 404 { t.Fatalf("other tenant should not see job: %d", rr.Code) }
+}
+
+func TestJobQueueTenantLimit(t *testing.T) {
+    release := make(chan struct{})
+    var mu sync.Mutex
+    running := map[string]int{}
+    peak := map[string]int{}
+    started := make(chan string, 8)
+    q := newJobQueue(3, 1, func(j *optimizeJob) {
+        mu.Lock()
+        running[j.TenantID]++
+        if running[j.TenantID] > peak[j.TenantID] { peak[j.TenantID] = running[j.TenantID] }
+        mu.Unlock()
+        started <- j.TenantID
+        <-release
+        mu.Lock()
+        running[j.TenantID]--
+        mu.Unlock()
+    })
+    a1 := q.submit(model.OptimizeRequest{TenantID: "a"})
+    a2 := q.submit(model.OptimizeRequest{TenantID: "a"})
+    q.submit(model.OptimizeRequest{TenantID: "b"})
+    for i := 0; i < 2; i++ {
+        select {
+        case <-started:
+        case <-time.After(time.Second): t.Fatal("jobs did not start")
+        }
+    }
+    select {
+    case tn := <-started: t.Fatalf("tenant %s exceeded its limit", tn)
+    case <-time.After(50 * time.Millisecond):
+    }
+    if j, _ := q.get("a", a2.ID); j.Status != "queued" { t.Fatalf("second tenant-a job should wait, got %s", j.Status) }
+    // cancelling a queued job removes it from the queue
+    if j, ok, err := q.cancel("a", a2.ID); !ok || err != nil || j.Status != "cancelled" { t.Fatalf("cancel queued: %+v %v %v", j, ok, err) }
+    if _, ok, _ := q.cancel("b", a1.ID); ok { t.Fatal("cancel across tenants should not find the job") }
+    close(release)
+    select {
+    case tn := <-started: t.Fatalf("cancelled job ran for tenant %s", tn)
+    case <-time.After(50 * time.Millisecond):
+    }
+    if peak["a"] != 1 || peak["b"] != 1 { t.Fatalf("unexpected peaks: %v", peak) }
+}
@@ -5,6 +5,7 @@ import (
     "net/http"
     "os"
     "strings"
+    "sync"
 
     "gpsnav/internal/store"
     "gpsnav/internal/webhooks"
@@ -16,6 +17,9 @@ type Server struct {
     Pub   *webhooks.Publisher
     Auth  *auth.Verifier
     Broker EventBroker
+
+    jobsOnce sync.Once
+    jobs     *jobQueue // optimize jobs; started on first use
 }
 
 // NewServer creates a Server. If DATABASE_URL is unset, uses in-memory store.
@@ -1,9 +1,15 @@
 package api
 
 import (
+    "encoding/json"
     "fmt"
+    "math"
+    "slices"
     "strings"
+    "time"
     "gpsnav/internal/model"
+    "gpsnav/internal/opt"
+    "gpsnav/internal/plan"
 )
 
 func validateOptimizeRequest(req *model.OptimizeRequest) error {
@@ -12,17 +18,168 @@ func validateOptimizeRequest(req *model.OptimizeRequest) error {
     }
     if req.TimeBudgetMs < 0 { return fmt.Errorf("timeBudgetMs must be >= 0") }
     if req.MaxIterations < 0 { return fmt.Errorf("maxIterations must be >= 0") }
+    if req.Parallel < 0 || req.Parallel > 64 { return fmt.Errorf("parallel must be in [0,64]") }
     if req.Cooling != 0 && (req.Cooling <= 0 || req.Cooling >= 1) { return fmt.Errorf("cooling must be in (0,1)") }
-    if len(req.RemovalWeights) > 0 && len(req.RemovalWeights) != 2 { return fmt.Errorf("removalWeights must have length 2") }
-    if len(req.InsertionWeights) > 0 && len(req.InsertionWeights) != 2 { return fmt.Errorf("insertionWeights must have length 2") }
+    if err := validateOperatorWeights("removalWeights", req.RemovalWeights, opt.RemovalOperators()); err != nil { return err }
+    if err := validateOperatorWeights("insertionWeights", req.InsertionWeights, opt.InsertionOperators()); err != nil { return err }
     if req.Objectives != nil {
-        allowed := map[string]struct{}{"drivetime":{}, "lateness":{}, "failed":{}, "distance":{}}
+        // keys are case-sensitive: the solver reads them verbatim
+        allowed := map[string]struct{}{"driveTime":{}, "lateness":{}, "earliness":{}, "failed":{}, "distance":{}, "priority":{}, "vehicleCost":{}, "vehicles":{}, "consistency":{}, "makespan":{}, "stopBalance":{}, "workBalance":{}, "minStops":{}, "maxStops":{}}
         for k, v := range req.Objectives {
             if v < 0 { return fmt.Errorf("objective %s must be >= 0", k) }
-            if _, ok := allowed[strings.ToLower(k)]; !ok {
-                return fmt.Errorf("unknown objective key: %s (allowed: driveTime,lateness,failed,distance)", k)
+            if level, ok := strings.CutPrefix(k, "serviceLevel."); ok && level != "" { continue }
+            if _, ok := allowed[k]; !ok {
+                return fmt.Errorf("unknown objective key: %s (allowed: driveTime,lateness,earliness,failed,distance,priority,serviceLevel.<name>,vehicleCost,vehicles,consistency,makespan,stopBalance,workBalance,minStops,maxStops)", k)
             }
         }
+        if err := validateStopLimits(req.Objectives); err != nil { return err }
+    }
+    if v, ok := req.Constraints["mustServePriority"]; ok {
+        if f, isNum := v.(float64); !isNum || f < 1 || f != float64(int(f)) { return fmt.Errorf("constraints.mustServePriority must be an integer >= 1") }
+    }
+    if req.Freeze != nil && !req.Reoptimize { return fmt.Errorf("freeze requires reoptimize") }
+    if v, ok := req.Constraints["speedProfile"]; ok && v != nil {
+        b, _ := json.Marshal(v)
+        var sp opt.SpeedProfile
+        if err := json.Unmarshal(b, &sp); err != nil { return fmt.Errorf("invalid constraints.speedProfile: %v", err) }
+        if err := sp.Validate(); err != nil { return fmt.Errorf("invalid constraints.speedProfile: %v", err) }
+    }
+    for _, k := range []string{"hosMaxDriveSec", "maxDutySec", "breakSec"} {
+        if v, ok := req.Constraints[k]; ok && v != nil {
+            if f, isNum := v.(float64); !isNum || f < 0 { return fmt.Errorf("constraints.%s must be a number >= 0", k) }
+        }
+    }
+    if _, err := plan.BreakRulesFrom(req.Constraints); err != nil { return fmt.Errorf("invalid constraints break rules: %v", err) }
+    return nil
+}
+
+// validateExplainRequest checks an explain request: a stop, a plan date or
+// routes to explain, and objectives and constraints as for optimize.
+func validateExplainRequest(req model.ExplainRequest) error {
+    if req.StopID == "" { return fmt.Errorf("stopId is required") }
+    if req.PlanDate == "" && len(req.RouteIDs) == 0 { return fmt.Errorf("planDate or routeIds is required") }
+    return validateOptimizeRequest(&model.OptimizeRequest{Objectives: req.Objectives, Constraints: req.Constraints})
+}
+
+// validateRouteEdit checks a route patch carrying ops: known ops with the
+// fields each needs, and constraints as for optimize.
+func validateRouteEdit(patch model.RoutePatch) error {
+    for i, op := range patch.Ops {
+        switch op.Op {
+        case "move", "unassign":
+            if op.StopID == "" { return fmt.Errorf("ops[%d]: stopId is required", i) }
+            if op.Position != nil && *op.Position < 0 { return fmt.Errorf("ops[%d]: position must be >= 0", i) }
+        case "swap":
+            if op.StopID == "" || op.WithStopID == "" { return fmt.Errorf("ops[%d]: stopId and withStopId are required", i) }
+            if op.StopID == op.WithStopID { return fmt.Errorf("ops[%d]: cannot swap a stop with itself", i) }
+        case "resequence":
+            if len(op.StopIDs) == 0 { return fmt.Errorf("ops[%d]: stopIds is required", i) }
+        default:
+            return fmt.Errorf("ops[%d]: op must be move, swap, resequence or unassign", i)
+        }
+    }
+    return validateOptimizeRequest(&model.OptimizeRequest{Constraints: patch.Constraints})
+}
+
+// validateOrders checks stop time windows: RFC 3339 bounds, each window
+// ending after it starts and after the previous one, a known mode and a
+// lateness cap only on soft windows.
+func validateOrders(orders []model.OrderIn) error {
+    for oi, o := range orders {
+        for si, s := range o.Stops {
+            at := fmt.Sprintf("orders[%d].stops[%d]", oi, si)
+            ws := s.TimeWindows
+            if s.TimeWindow != nil { ws = append([]model.TimeWindow{*s.TimeWindow}, ws...) }
+            var prev time.Time
+            for _, w := range ws {
+                start, err := time.Parse(time.RFC3339, w.Start)
+                if err != nil { return fmt.Errorf("%s: invalid time window start %q", at, w.Start) }
+                end, err := time.Parse(time.RFC3339, w.End)
+                if err != nil { return fmt.Errorf("%s: invalid time window end %q", at, w.End) }
+                if !end.After(start) { return fmt.Errorf("%s: time window must end after it starts", at) }
+                if start.Before(prev) { return fmt.Errorf("%s: time windows must be in order and not overlap", at) }
+                prev = end
+            }
+            switch strings.ToLower(s.TimeWindowMode) {
+            case "", "hard":
+                if s.MaxLatenessSec != 0 { return fmt.Errorf("%s: maxLatenessSec requires timeWindowMode soft", at) }
+            case "soft":
+                if s.MaxLatenessSec < 0 { return fmt.Errorf("%s: maxLatenessSec must be >= 0", at) }
+            default:
+                return fmt.Errorf("%s: invalid timeWindowMode: %s (allowed: hard,soft)", at, s.TimeWindowMode)
+            }
+        }
+    }
+    return nil
+}
+
+// validateOperatorWeights checks ALNS operator weights keyed by name: known
+// operators, no negative weight and at least one operator left enabled.
+func validateOperatorWeights(field string, weights map[string]float64, known []string) error {
+    enabled := false
+    for name, w := range weights {
+        if !slices.Contains(known, name) { return fmt.Errorf("unknown %s operator: %s (allowed: %s)", field, name, strings.Join(known, ",")) }
+        if w < 0 { return fmt.Errorf("%s.%s must be >= 0", field, name) }
+        if w > 0 { enabled = true }
+    }
+    if len(weights) > 0 && !enabled { return fmt.Errorf("%s must enable at least one operator", field) }
+    return nil
+}
+
+// validateOptimizerConfig checks the tenant optimizer config keys the
+// planner reads.
+func validateOptimizerConfig(cfg map[string]any) error {
+    if raw, ok := cfg["consistency"]; ok {
+        b, _ := json.Marshal(raw)
+        var c struct {
+            Weight       float64 `json:"weight"`
+            LookbackDays int     `json:"lookbackDays"`
+        }
+        if err := json.Unmarshal(b, &c); err != nil { return fmt.Errorf("invalid consistency: want {weight, lookbackDays}") }
+        if c.Weight < 0 { return fmt.Errorf("consistency.weight must be >= 0") }
+        if c.LookbackDays < 0 || c.LookbackDays > 365 { return fmt.Errorf("consistency.lookbackDays must be in [0,365]") }
+    }
+    if raw, ok := cfg["balance"]; ok {
+        b, _ := json.Marshal(raw)
+        var bal map[string]float64
+        if err := json.Unmarshal(b, &bal); err != nil { return fmt.Errorf("invalid balance: want {makespan, stopBalance, workBalance, minStops, maxStops}") }
+        for k, v := range bal {
+            if !slices.Contains([]string{"makespan", "stopBalance", "workBalance", "minStops", "maxStops"}, k) { return fmt.Errorf("unknown balance key: %s", k) }
+            if v < 0 { return fmt.Errorf("balance.%s must be >= 0", k) }
+        }
+        if err := validateStopLimits(bal); err != nil { return fmt.Errorf("balance: %v", err) }
+    }
+    for field, known := range map[string][]string{"removalWeights": opt.RemovalOperators(), "insertionWeights": opt.InsertionOperators()} {
+        raw, ok := cfg[field]
+        if !ok { continue }
+        b, _ := json.Marshal(raw)
+        var w map[string]float64
+        if err := json.Unmarshal(b, &w); err != nil { return fmt.Errorf("invalid %s: must map operator names to weights", field) }
+        if err := validateOperatorWeights(field, w, known); err != nil { return err }
+    }
+    return nil
+}
+
+// validateStopLimits checks the minStops and maxStops route limits: whole
+// numbers, the minimum not above the maximum.
+func validateStopLimits(obj map[string]float64) error {
+    minS, maxS := obj["minStops"], obj["maxStops"]
+    for k, v := range map[string]float64{"minStops": minS, "maxStops": maxS} {
+        if v != math.Trunc(v) { return fmt.Errorf("%s must be a whole number", k) }
+    }
+    if maxS > 0 && minS > maxS { return fmt.Errorf("minStops must not exceed maxStops") }
+    return nil
+}
+
+// validateGeofenceInput checks a geofence's geometry: a polygon needs at
+// least three vertices and every point valid coordinates.
+func validateGeofenceInput(in model.GeofenceInput) error {
+    if in.RadiusM < 0 { return fmt.Errorf("radiusM must be >= 0") }
+    if len(in.Polygon) > 0 && len(in.Polygon) < 3 { return fmt.Errorf("polygon needs at least 3 points") }
+    pts := in.Polygon
+    if in.Center != nil { pts = append([]model.GeoPoint{*in.Center}, pts...) }
+    for _, pt := range pts {
+        if pt.Lat < -90 || pt.Lat > 90 || pt.Lng < -180 || pt.Lng > 180 { return fmt.Errorf("invalid coordinates %v,%v", pt.Lat, pt.Lng) }
     }
     return nil
 }
@@ -5,6 +5,7 @@ package model
 type OrderIn struct {
     ExternalRef   string            `json:"externalRef,omitempty"`
     Priority      int               `json:"priority,omitempty"`
+    ServiceLevel  string            `json:"serviceLevel,omitempty"` // e.g. same_day; weighted by the serviceLevel.<name> objective
     Attributes    map[string]any    `json:"attributes,omitempty"`
     Stops         []StopIn          `json:"stops"`
 }
@@ -13,7 +14,10 @@ type StopIn struct {
     Type           string            `json:"type"`
     Address        string            `json:"address,omitempty"`
     Location       *GeoPoint         `json:"location"`
-    TimeWindow     *TimeWindow       `json:"timeWindow,omitempty"`
+    TimeWindow     *TimeWindow       `json:"timeWindow,omitempty"`     // single window; merged with TimeWindows
+    TimeWindows    []TimeWindow      `json:"timeWindows,omitempty"`    // alternative windows, e.g. 09-12 and 14-17
+    TimeWindowMode string            `json:"timeWindowMode,omitempty"` // hard (default) or soft: late service is penalised, not refused
+    MaxLatenessSec int               `json:"maxLatenessSec,omitempty"` // soft windows only; 0 = uncapped
     ServiceTimeSec int               `json:"serviceTimeSec,omitempty"`
     RequiredSkills []string          `json:"requiredSkills,omitempty"`
 }
@@ -36,15 +40,26 @@ type OptimizeRequest struct {
     MaxIterations int               `json:"maxIterations,omitempty"`
     InitTemp     float64            `json:"initTemp,omitempty"`
     Cooling      float64            `json:"cooling,omitempty"`
-    RemovalWeights []float64        `json:"removalWeights,omitempty"`
-    InsertionWeights []float64      `json:"insertionWeights,omitempty"`
+    RemovalWeights map[string]float64   `json:"removalWeights,omitempty"`   // by ALNS operator name; overrides the tenant config
+    InsertionWeights map[string]float64 `json:"insertionWeights,omitempty"`
     VehiclePool  []string           `json:"vehiclePool,omitempty"`
     Depots       []string           `json:"depots,omitempty"`
+    VehicleDepots map[string]VehicleDepot `json:"vehicleDepots,omitempty"` // by vehicle id; overrides the vehicle record
     IncludeOrders []string          `json:"includeOrders,omitempty"`
     Constraints  map[string]any     `json:"constraints,omitempty"`
     Objectives   map[string]float64 `json:"objectives,omitempty"`
     Reoptimize   bool               `json:"reoptimize,omitempty"`
     Freeze       *FreezeSpec        `json:"freeze,omitempty"`
+    Seed         int64              `json:"seed,omitempty"` // solver RNG seed; 0 picks one (recorded in plan metrics)
+    Parallel     int                `json:"parallel,omitempty"` // concurrent ALNS searches; best result wins
+}
+
+// VehicleDepot sets where a vehicle starts and ends its route. An empty end
+// returns to the start depot unless OpenRoute is set.
+type VehicleDepot struct {
+    StartDepotID string `json:"startDepotId,omitempty"`
+    EndDepotID   string `json:"endDepotId,omitempty"`
+    OpenRoute    bool   `json:"openRoute,omitempty"`
 }
 
 type FreezeSpec struct {
@@ -56,6 +71,7 @@ type Route struct {
     ID            string             `json:"id"`
     Version       int                `json:"version"`
     PlanDate      string             `json:"planDate,omitempty"`
+    BatchID       string             `json:"batchId,omitempty"` // optimize batch that planned the route
     Status        string             `json:"status"`
     DriverID      string             `json:"driverId,omitempty"`
     VehicleID     string             `json:"vehicleId,omitempty"`
@@ -138,6 +154,35 @@ type RoutePatch struct {
     Status      string `json:"status,omitempty"`
     LockedUntil string `json:"lockedUntil,omitempty"`
     AutoAdvance *AutoAdvancePolicy `json:"autoAdvance,omitempty"`
+    Ops         []RouteOp          `json:"ops,omitempty"`         // stop edits, applied in order (see Store.EditRoute)
+    Constraints map[string]any     `json:"constraints,omitempty"` // planner constraints the edited routes are checked against, as in OptimizeRequest
+}
+
+// RouteOp is one manual stop edit. Only pending stops after a route's
+// visited and in-progress legs can be edited, and each op must involve the
+// route being patched.
+type RouteOp struct {
+    Op         string   `json:"op"`                   // move, swap, resequence or unassign
+    StopID     string   `json:"stopId,omitempty"`     // move, swap, unassign
+    ToRouteID  string   `json:"toRouteId,omitempty"`  // move: target route; default the patched route
+    Position   *int     `json:"position,omitempty"`   // move: pending stops before it on the target; default last
+    WithStopID string   `json:"withStopId,omitempty"` // swap: the stop trading places, on any active route of the plan date
+    StopIDs    []string `json:"stopIds,omitempty"`    // resequence: the route's pending stops in their new order
+}
+
+// RouteEditResult is the outcome of route edit operations. Edits are applied
+// even when they break a planning rule; each broken rule is a warning.
+type RouteEditResult struct {
+    Route    Route          `json:"route"`
+    Changed  []Route        `json:"changedRoutes,omitempty"` // other routes the edits touched
+    Warnings []RouteWarning `json:"warnings,omitempty"`
+}
+
+// RouteWarning is a planning rule an edited route breaks.
+type RouteWarning struct {
+    RouteID string `json:"routeId"`
+    StopID  string `json:"stopId,omitempty"` // empty for the route as a whole
+    Reason  string `json:"reason"`           // time_window, capacity, max_stops, skills, zone, pair, shift or hos
 }
 
 // AutoAdvancePolicy controls automatic progression to the next stop
@@ -188,10 +233,11 @@ type HOSUpdate struct {
 // Geofences
 type GeofenceInput struct {
     Name    string            `json:"name,omitempty"`
-    Type    string            `json:"type,omitempty"`
+    Type    string            `json:"type,omitempty"` // e.g. hub, territory, low_emission
     RadiusM int               `json:"radiusM,omitempty"`
     Center  *GeoPoint         `json:"center,omitempty"`
-    Rules   map[string]any    `json:"rules,omitempty"`
+    Polygon []GeoPoint        `json:"polygon,omitempty"` // takes precedence over center and radius
+    Rules   map[string]any    `json:"rules,omitempty"`   // territories: vehicleIds, driverIds
 }
 
 type Geofence struct {
@@ -201,6 +247,7 @@ type Geofence struct {
     Type     string            `json:"type,omitempty"`
     RadiusM  int               `json:"radiusM,omitempty"`
     Center   *GeoPoint         `json:"center,omitempty"`
+    Polygon  []GeoPoint        `json:"polygon,omitempty"`
     Rules    map[string]any    `json:"rules,omitempty"`
 }
 
@@ -212,3 +259,84 @@ type PresignRequest struct {
     Bytes       int64  `json:"bytes,omitempty"`
     SHA256      string `json:"sha256,omitempty"`
 }
+
+// PlanResult is the outcome of planning one optimize request.
+type PlanResult struct {
+    BatchID        string           `json:"batchId"`
+    Routes         []Route          `json:"routes"`
+    Unassigned     []UnassignedStop `json:"unassigned,omitempty"`
+    ZoneViolations []ZoneViolation  `json:"zoneViolations,omitempty"`
+}
+
+// ExplainRequest asks what inserting a stop at each position of existing
+// routes would cost (/v1/optimize/explain). Constraints and Objectives are
+// read as in OptimizeRequest.
+type ExplainRequest struct {
+    TenantID    string             `json:"tenantId"`
+    PlanDate    string             `json:"planDate"`
+    StopID      string             `json:"stopId"`
+    RouteIDs    []string           `json:"routeIds,omitempty"` // default: the plan date's active routes
+    Constraints map[string]any     `json:"constraints,omitempty"`
+    Objectives  map[string]float64 `json:"objectives,omitempty"`
+}
+
+// ExplainResult lists every place a stop could go, feasible options first
+// and cheapest first.
+type ExplainResult struct {
+    StopID          string            `json:"stopId"`
+    CurrentRouteID  string            `json:"currentRouteId,omitempty"`
+    CurrentPosition int               `json:"currentPosition"` // with currentRouteId: stops before it on that route
+    Options         []InsertionOption `json:"options"`
+}
+
+// InsertionOption is the stop inserted at one position of one route.
+type InsertionOption struct {
+    RouteID         string   `json:"routeId"`
+    Position        int      `json:"position"`                  // pending stops before it, the explained stop left out
+    PartnerPosition *int     `json:"partnerPosition,omitempty"` // pickups and deliveries: same for the partner moved with it
+    AfterStopID     string   `json:"afterStopId,omitempty"`
+    Feasible        bool     `json:"feasible"`
+    Reasons         []string `json:"reasons,omitempty"` // time_window, capacity, max_stops, skills, zone, pair, shift or hos
+    CostDelta       float64  `json:"costDelta"`         // objective change of the route, positive = costlier
+}
+
+// UnassignedStop is a stop the planner could not route; it stays pending for
+// manual dispatch.
+type UnassignedStop struct {
+    StopID    string `json:"stopId"`
+    Reason    string `json:"reason"` // capacity, skills, zone, time_window or shift
+    MustServe bool   `json:"mustServe,omitempty"` // priority at or above constraints.mustServePriority
+}
+
+// ZoneViolation is a routed stop its route's vehicle may not serve under the
+// zone rules, e.g. one kept on a route by reoptimization.
+type ZoneViolation struct {
+    RouteID string `json:"routeId"`
+    StopID  string `json:"stopId"`
+    ZoneID  string `json:"zoneId"`
+    Rule    string `json:"rule"` // territory or forbidden
+}
+
+// OptimizeJob is an asynchronous optimize run (/v1/optimize/jobs).
+type OptimizeJob struct {
+    ID             string           `json:"id"`
+    TenantID       string           `json:"tenantId"`
+    Status         string           `json:"status"` // queued, running, succeeded, failed, cancelled
+    CreatedAt      string           `json:"createdAt"`
+    StartedAt      string           `json:"startedAt,omitempty"`
+    FinishedAt     string           `json:"finishedAt,omitempty"`
+    Progress       *JobProgress     `json:"progress,omitempty"`
+    BatchID        string           `json:"batchId,omitempty"`
+    Routes         []Route          `json:"routes,omitempty"`
+    Unassigned     []UnassignedStop `json:"unassigned,omitempty"`
+    ZoneViolations []ZoneViolation  `json:"zoneViolations,omitempty"`
+    Error          string           `json:"error,omitempty"`
+}
+
+// JobProgress is the latest solver snapshot of a running job.
+type JobProgress struct {
+    Iteration        int       `json:"iteration"`
+    BestCost         float64   `json:"bestCost"`
+    RemovalWeights   map[string]float64 `json:"removalWeights"`
+    InsertionWeights map[string]float64 `json:"insertionWeights"`
+}
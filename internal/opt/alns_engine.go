@@ -1,119 +1,183 @@
 package opt
 
 import (
+    "context"
     "math"
     "math/rand"
     "time"
 )
 
+// TW is a service window; a zero Start or End leaves that side open.
 type TW struct{ Start, End time.Time }
 
-type Demand struct{ Weight, Volume float64 }
+// Demand is the load a node puts on a vehicle. Dims holds named dimensions
+// checked against Vehicle.CapDims.
+type Demand struct {
+    Weight, Volume float64
+    Pallets        float64
+    Dims           map[string]float64
+}
 
 type Node struct {
     ID         string
     Lat, Lng   float64
     ServiceSec int
-    TW         *TW
+    Windows    []TW // service windows in time order (see windows.go); none = any time
+    SoftTW     bool // serving after the last window costs lateness instead of being infeasible
+    MaxLateSec int  // cap on soft-window lateness; 0 = uncapped
     Demand     Demand
     Skills     []string
+    Pickup     bool // collects its demand (carried to the end) instead of delivering it; paired nodes follow Problem.Pairs
+    Priority     int    // order priority; scales the failed penalty (see failCost)
+    ServiceLevel string // order service level, e.g. same_day; weighted by the serviceLevel.<name> objective
+    Affinity     map[string]float64 // by driver ID: share of the customer's past visits made by that driver (0..1)
 }
 
 type Vehicle struct {
     ID           string
     CapWeight    float64
     CapVolume    float64
+    CapPallets   float64
+    CapDims      map[string]float64 // named capacity dimensions; 0 or absent = unconstrained
     Skills       []string
     StartLatLng  *[2]float64 // optional depot
-    EndLatLng    *[2]float64 // optional depot
+    EndLatLng    *[2]float64 // optional depot; nil for an open route (no return leg)
+    StartID      string      // optional depot IDs for file-backed matrices
+    EndID        string
+    ShiftStart   time.Time   // optional availability window; zero means unbounded
+    ShiftEnd     time.Time
+    MaxRouteSec  int         // optional cap on route duration including the return leg
+    MaxDistM     float64     // optional cap on route distance including the return leg
+    Cost         VehicleCost // money cost of using the vehicle, weighted by the vehicleCost objective
+    DriverID     string      // assigned driver; matched against Node.Affinity
+    Territories  []string    // IDs of the territory zones the vehicle is assigned to
+    Forbidden    []string    // IDs of zones the vehicle may not serve stops in
 }
 
 type Problem struct {
     Nodes       []Node
     Vehicles    []Vehicle
     SpeedKph    float64
-    Objectives  map[string]float64 // weights: driveTime, distance, lateness, failed
-    HosMaxDriveSec int              // optional HoS continuous drive limit (seconds)
-    BreakSec       int              // planned break duration in seconds if HosMaxDriveSec exceeded
-    IterationsLimit int             // optional iteration cap
+    Matrix      DistanceMatrix     // travel distances/times; haversine at SpeedKph when nil
+    SpeedProfile *SpeedProfile     // optional time-of-day speed factors applied to matrix durations
+    StartAt     time.Time          // route clock origin; zero keeps the epoch-relative clock
+    Objectives  map[string]float64 // weights: driveTime, distance, lateness, earliness, failed, priority, serviceLevel.<name>, vehicleCost, vehicles, consistency, makespan, stopBalance, workBalance; limits: minStops, maxStops (see balance.go)
+    BreakRules     BreakRules       // driver rest rules; breaks are planned while scheduling (see breaks.go)
+    IterationsLimit int             // optional iteration cap (per search when Parallel > 1)
     InitialTemp    float64          // initial temperature for SA
     Cooling        float64          // cooling factor per iteration
-    InitialRemovalWeights []float64 // [random, shaw]
-    InitialInsertionWeights []float64 // [greedy, regret2]
+    InitialRemovalWeights map[string]float64   // by operator name; empty uses every registered operator at 1
+    InitialInsertionWeights map[string]float64 // by operator name; empty uses every registered operator at 1
+    Pairs          []Pair           // pickup/delivery pairs served by one vehicle, pickup first
+    InitialPlans   []RoutePlan      // optional warm start, one per vehicle; unlisted nodes are inserted
+    OnSnapshot     func(WeightSnapshot) // optional progress hook, called as snapshots are taken
+    Parallel       int              // independent searches run concurrently, best kept; <= 1 runs one
+    MustServePriority int           // nodes at or above this priority must be routed; 0 = off
+    Zones          []Zone           // territories and restricted areas referenced by vehicles (see zones.go)
+
+    tt *travelTable // precomputed by Prepare
+    pd *pairIndex   // pair lookup built by Prepare
+    zi *zoneIndex   // node zones built by Prepare
+    w  *objective   // weights resolved once by SolveContext
 }
 
 type RoutePlan struct {
     VehicleID string
-    Order     []int // indices into Nodes
+    Order     []int   // indices into Nodes
+    Breaks    []Break // planned rests; set on solutions returned by Solve
 }
 
 type Solution struct {
-    Plans []RoutePlan
-    Cost  float64
+    Plans      []RoutePlan
+    Unassigned []Unassigned // nodes no plan could take, charged the failed objective
+    Cost       float64
 }
 
 type Metrics struct {
-    RemovalSelects [2]int   // random, shaw
-    InsertSelects  [2]int   // greedy, regret2
+    RemovalSelects   map[string]int // by operator name
+    RemovalSuccesses map[string]int // selections whose result was accepted
+    InsertSelects    map[string]int
+    InsertSuccesses  map[string]int
     Iterations     int
     Improvements   int
     AcceptedWorse  int
     BestCost       float64
     FinalCost      float64
-    FinalRemovalWeights [2]float64
-    FinalInsertionWeights [2]float64
+    FinalRemovalWeights map[string]float64
+    FinalInsertionWeights map[string]float64
     Snapshots []WeightSnapshot
+    Seed      int64 // RNG seed actually used; pass it back to reproduce the run
+    Cancelled bool  // stopped early by context cancellation
+    Workers    int  // parallel searches merged into these metrics; 0 for a single search
+    BestWorker int  // search that produced the returned solution
 }
 
 type WeightSnapshot struct {
     Iteration int
-    Removal [2]float64
-    Insertion [2]float64
+    BestCost  float64
+    Removal map[string]float64
+    Insertion map[string]float64
+}
+
+// Solve runs a simple ALNS-like heuristic over the registered removal and
+// insertion operators (see operators.go).
+func Solve(p Problem, seed int64, timeBudget time.Duration) (Solution, Metrics, error) {
+    return SolveContext(context.Background(), p, seed, timeBudget)
 }
 
-// Solve runs a simple ALNS-like heuristic with regret insertion and random removal.
-func Solve(p Problem, seed int64, timeBudget time.Duration) (Solution, Metrics) {
+// SolveContext is Solve that stops when ctx is done, returning the best
+// solution found so far together with ctx.Err(). A zero seed picks one from the clock; the seed used
+// is reported in Metrics.Seed. The same seed and IterationsLimit on the same
+// problem reproduce the same plan. An unprepared p is prepared first, and
+// the Prepare error returned.
+func SolveContext(ctx context.Context, p Problem, seed int64, timeBudget time.Duration) (Solution, Metrics, error) {
     if seed == 0 { seed = time.Now().UnixNano() }
+    if p.tt == nil {
+        if err := p.Prepare(ctx); err != nil { return Solution{}, Metrics{}, err }
+    }
+    w := p.objectiveWeights()
+    p.w = &w
+    var sol Solution
+    var m Metrics
+    if p.Parallel > 1 { sol, m = solveParallel(ctx, p, seed, timeBudget) } else { sol, m = solveSeed(ctx, p, seed, timeBudget) }
+    p.planBreaks(sol)
+    if m.Cancelled { return sol, m, ctx.Err() }
+    return sol, m, nil
+}
+
+// solveSeed runs one ALNS search from seed on a prepared problem.
+func solveSeed(ctx context.Context, p Problem, seed int64, timeBudget time.Duration) (Solution, Metrics) {
     rng := rand.New(rand.NewSource(seed))
-    if p.SpeedKph <= 0 { p.SpeedKph = 50 }
-    // seed solution via greedy assignment
+    // seed solution via greedy assignment, or from the caller's plans
     curr := greedySeed(p)
+    if len(p.InitialPlans) == len(p.Vehicles) && len(p.Vehicles) > 0 { curr = warmStart(p) }
     best := curr
-    // operator weights (removal + insertion)
-    remW := []float64{1, 1}            // random, shaw
-    insW := []float64{1, 1}            // greedy, regret2
-    if len(p.InitialRemovalWeights) == 2 { remW = []float64{p.InitialRemovalWeights[0], p.InitialRemovalWeights[1]} }
-    if len(p.InitialInsertionWeights) == 2 { insW = []float64{p.InitialInsertionWeights[0], p.InitialInsertionWeights[1]} }
+    // operators taking part and their adaptive weights
+    rem := newOpSet(RemovalOperators(), p.InitialRemovalWeights)
+    ins := newOpSet(InsertionOperators(), p.InitialInsertionWeights)
+    remW, insW := rem.w, ins.w
     temp := 1.0
     if p.InitialTemp > 0 { temp = p.InitialTemp }
     cool := 0.995
     if p.Cooling > 0 && p.Cooling < 1 { cool = p.Cooling }
-    m := Metrics{BestCost: best.Cost}
+    m := Metrics{BestCost: best.Cost, Seed: seed, RemovalSelects: map[string]int{}, RemovalSuccesses: map[string]int{}, InsertSelects: map[string]int{}, InsertSuccesses: map[string]int{}}
     deadline := time.Now().Add(timeBudget)
     snapshotEvery := 50
     for time.Now().Before(deadline) {
+        if ctx.Err() != nil { m.Cancelled = true; break }
         m.Iterations++
         if p.IterationsLimit > 0 && m.Iterations >= p.IterationsLimit { break }
         k := 1 + rng.Intn(3)
         // select operators by roulette wheel
         op := selectOp(remW, rng)
-        m.RemovalSelects[op]++
         ip := selectOp(insW, rng)
-        m.InsertSelects[ip]++
-        var removedIdx []int
-        switch op {
-        case 0:
-            removedIdx = pickRandomNodes(curr, k, rng)
-        case 1:
-            removedIdx = shawRemoval(p, curr, k, rng)
-        }
+        remName, insName := rem.names[op], ins.names[ip]
+        m.RemovalSelects[remName]++
+        m.InsertSelects[insName]++
+        removedIdx := p.withPartners(removalOp(remName)(p, curr, k, rng)) // pairs leave together
+        removedIdx = append(removedIdx, curr.unassignedNodes()...)         // banked nodes get another try
         curr = removeNodes(curr, removedIdx)
-        switch ip {
-        case 0:
-            curr = greedyInsert(p, curr, removedIdx)
-        case 1:
-            curr = regretInsert(p, curr, removedIdx)
-        }
+        curr = insertionOp(insName)(p, curr, removedIdx, rng)
         // local improvements per iteration
         curr = twoOptImprove(p, curr)
         curr = crossExchangeImprove(p, curr)
@@ -123,6 +187,8 @@ func Solve(p Problem, seed int64, timeBudget time.Duration) (Solution, Metrics)
         delta := curr.Cost - best.Cost
         if delta < 0 || rng.Float64() < math.Exp(-delta/(temp+1e-9)) {
             // improvement or accepted worse
+            m.RemovalSuccesses[remName]++
+            m.InsertSuccesses[insName]++
             if curr.Cost < best.Cost { best = curr; remW[op] += 0.1; insW[ip] += 0.1; m.Improvements++; m.BestCost = best.Cost } else { remW[op] += 0.01; insW[ip] += 0.01; m.AcceptedWorse++ }
         } else {
             // slight penalty for non-acceptance
@@ -132,50 +198,121 @@ func Solve(p Problem, seed int64, timeBudget time.Duration) (Solution, Metrics)
         temp *= cool
         // snapshot weights
         if m.Iterations%snapshotEvery == 0 {
-            m.Snapshots = append(m.Snapshots, WeightSnapshot{Iteration: m.Iterations, Removal: [2]float64{remW[0], remW[1]}, Insertion: [2]float64{insW[0], insW[1]}})
+            snap := WeightSnapshot{Iteration: m.Iterations, BestCost: best.Cost, Removal: rem.weights(), Insertion: ins.weights()}
+            m.Snapshots = append(m.Snapshots, snap)
+            if p.OnSnapshot != nil { p.OnSnapshot(snap) }
         }
     }
     m.FinalCost = best.Cost
-    m.FinalRemovalWeights = [2]float64{remW[0], remW[1]}
-    m.FinalInsertionWeights = [2]float64{insW[0], insW[1]}
+    m.FinalRemovalWeights, m.FinalInsertionWeights = rem.weights(), ins.weights()
     return best, m
 }
 
 func greedySeed(p Problem) Solution {
     n := len(p.Nodes)
+    static := p.staticSchedule()
     used := make([]bool, n)
     plans := make([]RoutePlan, len(p.Vehicles))
     for vi := range plans { plans[vi] = RoutePlan{VehicleID: p.Vehicles[vi].ID, Order: []int{}} }
     for assigned := 0; assigned < n; {
         progress := false
         for vi := range p.Vehicles {
-            bestIdx, bestDelta := -1, math.MaxFloat64
+            var bestUnit []int
+            bestDelta := math.MaxFloat64
+            var rs *routeState
+            if static { rs = newRouteState(p, plans[vi], vi) }
             for i := 0; i < n; i++ {
                 if used[i] { continue }
+                u := p.unit(i)
+                if u[0] != i { continue } // pairs are appended from their pickup
                 if !feasibleAdd(p, plans[vi], p.Vehicles[vi], i) { continue }
-                d := deltaCostAppend(p, plans[vi], p.Vehicles[vi], i)
-                if d < bestDelta { bestDelta = d; bestIdx = i }
+                d := deltaCostAppend(p, plans[vi], vi, i)
+                if len(u) == 2 {
+                    withPickup := RoutePlan{VehicleID: plans[vi].VehicleID, Order: append(append([]int(nil), plans[vi].Order...), i)}
+                    if !feasibleAdd(p, withPickup, p.Vehicles[vi], u[1]) { continue }
+                    d += deltaCostAppend(p, withPickup, vi, u[1])
+                }
+                // the route must still keep its windows and shift
+                if rs != nil && len(u) == 1 {
+                    if _, ok := rs.insert(i, len(plans[vi].Order)); !ok { continue }
+                } else if _, ok := schedulePlan(p, RoutePlan{VehicleID: plans[vi].VehicleID, Order: append(append([]int(nil), plans[vi].Order...), u...)}, vi); !ok { continue }
+                if d < bestDelta { bestDelta = d; bestUnit = u }
             }
-            if bestIdx >= 0 {
-                plans[vi].Order = append(plans[vi].Order, bestIdx)
-                used[bestIdx] = true
-                assigned++
+            if bestUnit != nil {
+                plans[vi].Order = append(plans[vi].Order, bestUnit...)
+                for _, i := range bestUnit { used[i] = true }
+                assigned += len(bestUnit)
                 progress = true
                 if assigned == n { break }
             }
         }
         if !progress { break }
     }
+    // nodes that fit no route end: insert where they can, bank the rest
+    left := []int{}
+    for i := 0; i < n; i++ { if !used[i] { left = append(left, i) } }
     sol := Solution{Plans: plans}
+    if len(left) > 0 { return greedyInsert(p, sol, left) }
     sol.Cost = cost(p, sol)
     return sol
 }
 
+// warmStart copies p.InitialPlans and inserts every node they leave out.
+func warmStart(p Problem) Solution {
+    sol := Solution{Plans: make([]RoutePlan, len(p.Vehicles))}
+    seen := make([]bool, len(p.Nodes))
+    for vi, pl := range p.InitialPlans {
+        sol.Plans[vi] = RoutePlan{VehicleID: p.Vehicles[vi].ID, Order: []int{}}
+        for _, idx := range pl.Order {
+            if idx < 0 || idx >= len(p.Nodes) || seen[idx] { continue }
+            seen[idx] = true
+            sol.Plans[vi].Order = append(sol.Plans[vi].Order, idx)
+        }
+    }
+    missing := []int{}
+    for i := range p.Nodes { if !seen[i] { missing = append(missing, i) } }
+    // pairs split by the caller are re-inserted together
+    sol = removeNodes(sol, p.withPartners(missing))
+    sol = regretInsert(p, sol, p.withPartners(missing))
+    sol.Cost = cost(p, sol)
+    return sol
+}
+
+// Fit holds the plans of sol to the planning rules the way the greedy seed
+// builds routes: each plan keeps its stops in order for as long as
+// appending the next one, a pickup together with its delivery, keeps the
+// vehicle's capacity, stop limit, skills, zones, windows and shift. Dropped
+// stops are inserted where another plan can take them and banked as
+// unassigned otherwise.
+func (p Problem) Fit(sol Solution) (Solution, error) {
+    if p.tt == nil { return Solution{}, ErrUnprepared }
+    out := Solution{Plans: make([]RoutePlan, len(sol.Plans)), Unassigned: sol.Unassigned}
+    left := []int{}
+    seen := map[int]bool{}
+    for vi, pl := range sol.Plans {
+        kept := RoutePlan{VehicleID: pl.VehicleID, Order: []int{}}
+        for _, i := range pl.Order {
+            if seen[i] { continue }
+            u := p.unit(i)
+            for _, j := range u { seen[j] = true }
+            ok := true
+            cand := kept
+            for _, j := range u {
+                ok = ok && feasibleAdd(p, cand, p.Vehicles[vi], j)
+                cand = RoutePlan{VehicleID: kept.VehicleID, Order: append(append([]int(nil), cand.Order...), j)}
+            }
+            if ok { _, ok = schedulePlan(p, cand, vi) }
+            if ok { kept = cand } else { left = append(left, u...) }
+        }
+        out.Plans[vi] = kept
+    }
+    return greedyInsert(p, out, left), nil
+}
+
 func pickRandomNodes(sol Solution, k int, rng *rand.Rand) []int {
-    present := map[int]bool{}
-    for _, pl := range sol.Plans { for _, idx := range pl.Order { present[idx] = true } }
+    // plan order, not map order, so a fixed seed removes the same nodes
     all := []int{}
-    for idx := range present { all = append(all, idx) }
+    for _, pl := range sol.Plans { all = append(all, pl.Order...) }
     if len(all) == 0 { return nil }
     removed := []int{}
     for i := 0; i < k && len(all) > 0; i++ {
@@ -191,6 +328,7 @@ func removeNodes(sol Solution, removed []int) Solution {
     rm := map[int]bool{}
     for _, i := range removed { rm[i] = true }
     out := Solution{Plans: make([]RoutePlan, len(sol.Plans))}
+    for _, u := range sol.Unassigned { if !rm[u.Node] { out.Unassigned = append(out.Unassigned, u) } }
     for i := range sol.Plans {
         out.Plans[i].VehicleID = sol.Plans[i].VehicleID
         for _, idx := range sol.Plans[i].Order {
@@ -202,49 +340,32 @@ func removeNodes(sol Solution, removed []int) Solution {
 
 func regretInsert(p Problem, sol Solution, removed []int) Solution {
     if len(removed) == 0 { return sol }
-    // Greedy regret-2 insertion across all vehicles/positions with simple TW feasibility
-    nodes := removed
-    for len(nodes) > 0 {
-        bestNode := -1
-        bestPlan := -1
-        bestPos := -1
+    // Greedy regret-2 insertion across all vehicles/positions with simple TW feasibility;
+    // pickup/delivery pairs are placed together as one unit
+    units := p.units(removed)
+    for len(units) > 0 {
+        bestUnit := -1
+        var bestIns insertion
         bestCost := math.MaxFloat64
         second := math.MaxFloat64
-        for ni, idx := range nodes {
-            best1 := math.MaxFloat64
-            best2 := math.MaxFloat64
-            bp, bpos := -1, -1
-            for vi, pl := range sol.Plans {
-                for pos := 0; pos <= len(pl.Order); pos++ {
-                    if !feasibleAddAt(p, pl, p.Vehicles[vi], idx, pos) { continue }
-                    c := deltaCostInsert(p, pl, p.Vehicles[vi], idx, pos)
-                    if c < best1 { best2 = best1; best1 = c; bp = vi; bpos = pos } else if c < best2 { best2 = c }
-                }
-            }
-            regret := best2 - best1
+        for ui, u := range units {
+            b1, b2 := bestInsertions(p, sol, u)
+            regret := b2.cost - b1.cost
             if regret < 0 { regret = 0 }
-            if best1 < math.MaxFloat64 {
-                if regret > (second-bestCost) || (bestNode == -1) {
-                    bestNode = ni; bestPlan = bp; bestPos = bpos; bestCost = best1; second = best2
+            if b1.cost < math.MaxFloat64 {
+                if regret > (second-bestCost) || (bestUnit == -1) {
+                    bestUnit = ui; bestIns = b1; bestCost = b1.cost; second = b2.cost
                 }
             }
         }
-        if bestNode == -1 { // no feasible insertion; append to shortest plan
-            shortest := 0
-            for i := range sol.Plans { if len(sol.Plans[i].Order) < len(sol.Plans[shortest].Order) { shortest = i } }
-            sol.Plans[shortest].Order = append(sol.Plans[shortest].Order, nodes[0])
-            nodes = nodes[1:]
+        if bestUnit == -1 { // no feasible insertion left: bank the unit
+            p.bank(&sol, units[0])
+            units = units[1:]
             continue
         }
-        // insert chosen node
-        pl := &sol.Plans[bestPlan]
-        if bestPos == len(pl.Order) {
-            pl.Order = append(pl.Order, nodes[bestNode])
-        } else {
-            pl.Order = append(pl.Order[:bestPos+1], pl.Order[bestPos:]...)
-            pl.Order[bestPos] = nodes[bestNode]
-        }
-        nodes = append(nodes[:bestNode], nodes[bestNode+1:]...)
+        // insert chosen unit
+        applyInsertion(&sol, units[bestUnit], bestIns)
+        units = append(units[:bestUnit], units[bestUnit+1:]...)
     }
     sol.Cost = cost(p, sol)
     // local improvement pass (or-opt 1-node)
@@ -252,91 +373,122 @@ func regretInsert(p Problem, sol Solution, removed []int) Solution {
     return sol
 }
 
-// greedyInsert inserts nodes by cheapest feasible append/insertion
+// greedyInsert inserts nodes (and pickup/delivery pairs as units) by cheapest feasible insertion
 func greedyInsert(p Problem, sol Solution, removed []int) Solution {
     if len(removed) == 0 { return sol }
-    nodes := removed
-    for len(nodes) > 0 {
-        bestPlan, bestPos, bestNode := -1, -1, 0
-        bestCost := math.MaxFloat64
-        for ni, idx := range nodes {
-            for vi, pl := range sol.Plans {
-                for pos := 0; pos <= len(pl.Order); pos++ {
-                    if !feasibleAddAt(p, pl, p.Vehicles[vi], idx, pos) { continue }
-                    c := deltaCostInsert(p, pl, p.Vehicles[vi], idx, pos)
-                    if c < bestCost { bestCost = c; bestPlan = vi; bestPos = pos; bestNode = ni }
-                }
-            }
+    units := p.units(removed)
+    for len(units) > 0 {
+        bestUnit := -1
+        var bestIns insertion
+        for ui, u := range units {
+            b, _ := bestInsertions(p, sol, u)
+            if b.vi >= 0 && (bestUnit == -1 || b.cost < bestIns.cost) { bestUnit = ui; bestIns = b }
         }
-        if bestPlan == -1 {
-            // append to shortest
-            shortest := 0
-            for i := range sol.Plans { if len(sol.Plans[i].Order) < len(sol.Plans[shortest].Order) { shortest = i } }
-            sol.Plans[shortest].Order = append(sol.Plans[shortest].Order, nodes[0])
-            nodes = nodes[1:]
+        if bestUnit == -1 {
+            p.bank(&sol, units[0])
+            units = units[1:]
             continue
         }
-        pl := &sol.Plans[bestPlan]
-        if bestPos == len(pl.Order) {
-            pl.Order = append(pl.Order, nodes[bestNode])
-        } else {
-            pl.Order = append(pl.Order[:bestPos+1], pl.Order[bestPos:]...)
-            pl.Order[bestPos] = nodes[bestNode]
-        }
-        nodes = append(nodes[:bestNode], nodes[bestNode+1:]...)
+        applyInsertion(&sol, units[bestUnit], bestIns)
+        units = append(units[:bestUnit], units[bestUnit+1:]...)
     }
     sol.Cost = cost(p, sol)
     return sol
 }
 
+// objective holds the cost weights: per second of drive, lateness and
+// earliness (waiting for a window), per metre, per failed node hour, the
+// failed penalty added per priority point, per unit of vehicle money cost,
+// per vehicle used, and the discount per stop kept with a familiar driver.
+type objective struct{ drive, dist, late, early, fail, priority, money, vehicles, consistency float64 }
+
+// weights returns the objective weights of p, as resolved by SolveContext
+// when solving.
+func (p Problem) weights() objective {
+    if p.w != nil { return *p.w }
+    return p.objectiveWeights()
+}
+
+// objectiveWeights reads the objective weights from p.Objectives. failed is
+// 50 when absent, so an unassigned node costs more than any detour.
+func (p Problem) objectiveWeights() objective {
+    w := objective{drive: p.Objectives["driveTime"], dist: p.Objectives["distance"], late: p.Objectives["lateness"], early: p.Objectives["earliness"], fail: p.Objectives["failed"], priority: p.Objectives["priority"],
+        money: p.Objectives["vehicleCost"], vehicles: p.Objectives["vehicles"], consistency: p.Objectives["consistency"]}
+    if w.drive == 0 { w.drive = 1 }
+    if _, ok := p.Objectives["failed"]; !ok { w.fail = 50 }
+    return w
+}
+
 func cost(p Problem, s Solution) float64 {
-    wDrive := p.Objectives["driveTime"]
-    if wDrive == 0 { wDrive = 1 }
-    wDist := p.Objectives["distance"]
-    wLate := p.Objectives["lateness"]
-    wFail := p.Objectives["failed"]
     total := 0.0
-    for vi, pl := range s.Plans {
-        v := p.Vehicles[vi]
-        t := 0.0
-        var curLat, curLng float64
-        if v.StartLatLng != nil { curLat, curLng = v.StartLatLng[0], v.StartLatLng[1] } else if len(pl.Order) > 0 {
-            cur := p.Nodes[pl.Order[0]]; curLat, curLng = cur.Lat, cur.Lng
-        }
-        for _, idx := range pl.Order {
-            nd := p.Nodes[idx]
-            dist := haversine(curLat, curLng, nd.Lat, nd.Lng)
-            drive := dist / (p.SpeedKph/3.6)
-            t += drive
-            arr := t
-            late := 0.0
-            if nd.TW != nil && !nd.TW.End.IsZero() {
-                end := nd.TW.End.Sub(time.Unix(0,0)).Seconds() // relative zero
-                if arr > end { late = arr - end }
-            }
-            t += float64(nd.ServiceSec)
-            total += wDrive*drive + wDist*dist + wLate*late
-            curLat, curLng = nd.Lat, nd.Lng
-        }
-    }
+    for vi, pl := range s.Plans { total += routeCost(p, pl, vi) }
+    total += p.balanceCost(s)
     // failed nodes: if any node not present
-    present := map[int]bool{}
+    present := make([]bool, len(p.Nodes))
     for _, pl := range s.Plans { for _, idx := range pl.Order { present[idx] = true } }
-    failed := 0.0
-    for i := range p.Nodes { if !present[i] { failed += 1 } }
-    total += wFail * failed * 3600 // heavy penalty
+    for i := range p.Nodes { if !present[i] { total += p.failCost(i) } }
+    return total
+}
+
+// mustServePenalty is added per unrouted must-serve node so that routing one
+// outweighs any other cost.
+const mustServePenalty = 1e12
+
+// failCost is the penalty for leaving node idx unrouted: failed hours scaled
+// by 1 + priority*Priority and by the node's serviceLevel.<name> weight
+// (default 1), plus mustServePenalty for must-serve nodes.
+func (p Problem) failCost(idx int) float64 {
+    nd := p.Nodes[idx]
+    w := p.weights()
+    c := w.fail * 3600 * (1 + w.priority*float64(nd.Priority))
+    if sl, ok := p.Objectives["serviceLevel."+nd.ServiceLevel]; ok && nd.ServiceLevel != "" { c *= sl }
+    if p.MustServe(idx) { c += mustServePenalty }
+    return c
+}
+
+// MustServe reports whether node idx falls under Problem.MustServePriority.
+func (p Problem) MustServe(idx int) bool {
+    return p.MustServePriority > 0 && p.Nodes[idx].Priority >= p.MustServePriority
+}
+
+// routeCost is the drive, distance, lateness, earliness and vehicle cost of
+// one plan, less the consistency discount of its stops.
+func routeCost(p Problem, pl RoutePlan, vi int) float64 {
+    if len(pl.Order) == 0 { return 0 }
+    w := p.weights()
+    total, distTotal := 0.0, 0.0
+    t := p.routeStart(vi)
+    cur := p.startLoc(vi)
+    for _, idx := range pl.Order {
+        nd := p.Nodes[idx]
+        dist, _ := p.travel(cur, idx)
+        drive := p.driveAt(cur, idx, t)
+        start, wait, late, _ := nd.service(t + drive)
+        t = start + float64(nd.ServiceSec)
+        total += w.drive*drive + w.late*late + w.early*wait - p.familiarity(idx, vi)
+        distTotal += dist
+        cur = idx
+    }
+    // return leg to the end depot (open routes have none)
+    if e := p.endLoc(vi); e >= 0 {
+        dist, _ := p.travel(cur, e)
+        drive := p.driveAt(cur, e, t)
+        t += drive
+        total += w.drive * drive
+        distTotal += dist
+    }
+    total += w.dist*distTotal + p.vehicleCost(vi, distTotal, t-p.routeStart(vi))
     return total
 }
 
 func feasibleAdd(p Problem, pl RoutePlan, v Vehicle, idx int) bool {
-    // Capacity check (simple sum)
-    w := 0.0; vol := 0.0
-    for _, i := range pl.Order { w += p.Nodes[i].Demand.Weight; vol += p.Nodes[i].Demand.Volume }
-    w += p.Nodes[idx].Demand.Weight
-    vol += p.Nodes[idx].Demand.Volume
-    if v.CapWeight > 0 && w > v.CapWeight { return false }
-    if v.CapVolume > 0 && vol > v.CapVolume { return false }
-    // Skills (subset)
+    // Capacity along the route with idx appended
+    if !loadFeasible(p, append(append([]int(nil), pl.Order...), idx), v) { return false }
+    return p.stopsFeasible(len(pl.Order)+1) && hasSkills(p, v, idx) && inZones(p, v, idx)
+}
+
+// hasSkills reports whether v has every skill node idx requires.
+func hasSkills(p Problem, v Vehicle, idx int) bool {
     if len(p.Nodes[idx].Skills) > 0 && len(v.Skills) > 0 {
         needed := make(map[string]bool)
         for _, s := range p.Nodes[idx].Skills { needed[s] = true }
@@ -346,117 +498,184 @@ func feasibleAdd(p Problem, pl RoutePlan, v Vehicle, idx int) bool {
     return true
 }
 
-func feasibleAddAt(p Problem, pl RoutePlan, v Vehicle, idx, pos int) bool {
-    if !feasibleAdd(p, pl, v, idx) { return false }
-    // basic TW feasibility: arrival at inserted node must be before its TW end
+func feasibleAddAt(p Problem, pl RoutePlan, vi int, idx, pos int) bool {
     if pos < 0 || pos > len(pl.Order) { return false }
+    if !feasibleAdd(p, pl, p.Vehicles[vi], idx) { return false }
+    if p.staticSchedule() {
+        _, ok := newRouteState(p, pl, vi).insert(idx, pos)
+        return ok
+    }
     // full schedule propagation feasibility after insertion
-    tmp := RoutePlan{VehicleID: pl.VehicleID, Order: make([]int, 0, len(pl.Order)+1)}
-    tmp.Order = append(tmp.Order, pl.Order[:pos]...)
-    tmp.Order = append(tmp.Order, idx)
-    tmp.Order = append(tmp.Order, pl.Order[pos:]...)
-    _, feasible := schedulePlan(p, tmp, v)
+    _, feasible := schedulePlan(p, RoutePlan{VehicleID: pl.VehicleID, Order: insertAt(pl.Order, pos, idx)}, vi)
     return feasible
 }
 
-func deltaCostAppend(p Problem, pl RoutePlan, v Vehicle, idx int) float64 {
-    // cost to append node idx at end of pl
-    if len(pl.Order) == 0 {
-        return 0
-    }
-    last := p.Nodes[pl.Order[len(pl.Order)-1]]
-    nd := p.Nodes[idx]
-    dist := haversine(last.Lat, last.Lng, nd.Lat, nd.Lng)
-    return dist
+func deltaCostAppend(p Problem, pl RoutePlan, vi int, idx int) float64 {
+    // cost to append node idx at end of pl, re-routing the return leg if any
+    last := p.startLoc(vi)
+    if len(pl.Order) > 0 { last = pl.Order[len(pl.Order)-1] }
+    end := p.endLoc(vi)
+    d1, _ := p.travel(last, idx)
+    d2, _ := p.travel(idx, end)
+    rem, _ := p.travel(last, end)
+    return d1 + d2 - rem - p.familiarity(idx, vi)
 }
 
-func deltaCostInsert(p Problem, pl RoutePlan, v Vehicle, idx, pos int) float64 {
-    // approximate delta: prev->new + new->next - prev->next + service
-    var prevLat, prevLng float64
-    if pos == 0 {
-        if v.StartLatLng != nil { prevLat, prevLng = v.StartLatLng[0], v.StartLatLng[1] } else if len(pl.Order) > 0 { n := p.Nodes[pl.Order[0]]; prevLat, prevLng = n.Lat, n.Lng }
-    } else {
-        n := p.Nodes[pl.Order[pos-1]]; prevLat, prevLng = n.Lat, n.Lng
-    }
-    var nextLat, nextLng float64
-    if pos < len(pl.Order) { n := p.Nodes[pl.Order[pos]]; nextLat, nextLng = n.Lat, n.Lng } else { nextLat, nextLng = prevLat, prevLng }
-    nd := p.Nodes[idx]
-    add := haversine(prevLat, prevLng, nd.Lat, nd.Lng) + haversine(nd.Lat, nd.Lng, nextLat, nextLng)
-    rem := haversine(prevLat, prevLng, nextLat, nextLng)
-    return add - rem + float64(nd.ServiceSec)
-}
-
-// schedulePlan computes arrival times and simple feasibility for a plan with optional HoS breaks.
-// Returns total cost components (driveSec, distanceM, latenessSec) and feasibility flag.
-func schedulePlan(p Problem, pl RoutePlan, v Vehicle) (struct{drive, dist, late float64}, bool) {
-    speed := p.SpeedKph / 3.6
-    var curLat, curLng float64
-    if v.StartLatLng != nil { curLat, curLng = v.StartLatLng[0], v.StartLatLng[1] } else if len(pl.Order) > 0 {
-        cur := p.Nodes[pl.Order[0]]; curLat, curLng = cur.Lat, cur.Lng
-    }
-    t := 0.0
+func deltaCostInsert(p Problem, pl RoutePlan, vi int, idx, pos int) float64 {
+    // approximate delta: prev->new + new->next - prev->next + service,
+    // less the consistency discount; the depots stand in for prev/next at
+    // either end (none on open ends)
+    prev := p.startLoc(vi)
+    if pos > 0 { prev = pl.Order[pos-1] }
+    next := p.endLoc(vi)
+    if pos < len(pl.Order) { next = pl.Order[pos] }
+    d1, _ := p.travel(prev, idx)
+    d2, _ := p.travel(idx, next)
+    rem, _ := p.travel(prev, next)
+    return d1 + d2 - rem + float64(p.Nodes[idx].ServiceSec) - p.familiarity(idx, vi)
+}
+
+// schedulePlan computes arrival times and simple feasibility for a plan with
+// the problem's breaks (see breaks.go). Returns the route end time, distanceM
+// and latenessSec, and the feasibility flag.
+func schedulePlan(p Problem, pl RoutePlan, vi int) (struct{drive, dist, late float64}, bool) {
+    return schedule(p, pl, vi, nil)
+}
+
+// scheduleTrace collects what schedule decides beyond the route totals: the
+// breaks it plans and, for an infeasible plan, the reason code.
+type scheduleTrace struct {
+    breaks []Break
+    reason string
+    node   int  // node missing its window, with reason time_window
+    full   bool // time the whole route even after a check fails
+}
+
+// fail records why a schedule is infeasible and reports whether schedule
+// stops there.
+func (tr *scheduleTrace) fail(reason string, node int) bool {
+    if tr == nil { return true }
+    if tr.reason == "" { tr.reason, tr.node = reason, node }
+    return !tr.full
+}
+
+// timeRoute is schedulePlan that times pl through to its end even when a
+// check fails, so plans the solver would reject still get a duration and
+// distance. ok still reports feasibility.
+func timeRoute(p Problem, pl RoutePlan, vi int) (struct{drive, dist, late float64}, bool) {
+    return schedule(p, pl, vi, &scheduleTrace{full: true})
+}
+
+// schedule is schedulePlan that fills tr when not nil.
+func schedule(p Problem, pl RoutePlan, vi int, tr *scheduleTrace) (struct{drive, dist, late float64}, bool) {
+    cur := p.startLoc(vi)
+    t := p.routeStart(vi)
+    start := t
     distTotal := 0.0
     lateTotal := 0.0
-    driveSinceBreak := 0.0
-    for _, idx := range pl.Order {
+    var bc breakClock
+    if p.tt.br != nil { bc = p.tt.br.clock(t) }
+    // rest plans the breaks due before the stint from cur to loc departing
+    // at t and returns the (possibly later) departure and its drive time
+    rest := func(k, loc int, t float64, stint func(t, drive float64) float64) (float64, float64) {
+        drive := p.driveAt(cur, loc, t)
+        for i := 0; i < 3; i++ {
+            at, ok := bc.due(t, drive, stint(t, drive))
+            if !ok { break }
+            if tr != nil { tr.breaks = append(tr.breaks, Break{Pos: k, Start: epochTime(at), Sec: int(bc.cb.sec)}) }
+            t = bc.rest(at)
+            drive = p.driveAt(cur, loc, t)
+        }
+        bc.drive += drive
+        return t, drive
+    }
+    if !loadFeasible(p, pl.Order, p.Vehicles[vi]) && tr.fail(ReasonCapacity, -1) { return struct{drive, dist, late float64}{}, false }
+    if !p.stopsFeasible(len(pl.Order)) && tr.fail(ReasonMaxStops, -1) { return struct{drive, dist, late float64}{}, false }
+    // arrival/departure per position for pickup/delivery checks
+    var arrs, deps []float64
+    if p.pd != nil { arrs = make([]float64, len(pl.Order)); deps = make([]float64, len(pl.Order)) }
+    for k, idx := range pl.Order {
         nd := p.Nodes[idx]
-        d := haversine(curLat, curLng, nd.Lat, nd.Lng)
-        drive := d / speed
-        // planned break if HoS exceeded
-        if p.HosMaxDriveSec > 0 && int(driveSinceBreak+drive) > p.HosMaxDriveSec {
-            // add break
-            t += float64(p.BreakSec)
-            driveSinceBreak = 0
+        d, _ := p.travel(cur, idx)
+        var drive float64
+        if bc.cb != nil {
+            // a stint ends after the wait and service at idx
+            t, drive = rest(k, idx, t, func(t, drive float64) float64 { arr, _, _, _ := nd.service(t + drive); return arr + float64(nd.ServiceSec) })
+        } else {
+            drive = p.driveAt(cur, idx, t)
         }
         t += drive
-        driveSinceBreak += drive
-        arr := t
-        if nd.TW != nil && !nd.TW.Start.IsZero() {
-            ws := nd.TW.Start.Sub(time.Unix(0,0)).Seconds()
-            if arr < ws { arr = ws; t = arr }
-        }
-        if nd.TW != nil && !nd.TW.End.IsZero() {
-            we := nd.TW.End.Sub(time.Unix(0,0)).Seconds()
-            if arr > we { return struct{drive, dist, late float64}{t, distTotal + d, lateTotal + (arr - we)}, false }
-        }
+        arr, _, late, ok := nd.service(t)
+        lateTotal += late
+        if !ok && tr.fail(ReasonTimeWindow, idx) { return struct{drive, dist, late float64}{t, distTotal + d, lateTotal}, false }
+        t = arr
         // service
         t += float64(nd.ServiceSec)
+        if arrs != nil { arrs[k], deps[k] = arr, t }
+        distTotal += d
+        cur = idx
+    }
+    // return leg to the end depot counts towards route time and distance
+    if e := p.endLoc(vi); e >= 0 && len(pl.Order) > 0 {
+        d, _ := p.travel(cur, e)
+        var drive float64
+        if bc.cb != nil {
+            t, drive = rest(len(pl.Order), e, t, func(t, drive float64) float64 { return t + drive })
+        } else {
+            drive = p.driveAt(cur, e, t)
+        }
+        t += drive
         distTotal += d
-        curLat, curLng = nd.Lat, nd.Lng
     }
-    return struct{drive, dist, late float64}{t, distTotal, lateTotal}, true
+    if !pairsFeasible(p, pl.Order, arrs, deps) && tr.fail(ReasonPair, -1) { return struct{drive, dist, late float64}{t, distTotal, lateTotal}, false }
+    // vehicle shift end, max route duration and max distance
+    v := p.Vehicles[vi]
+    if !v.ShiftEnd.IsZero() && t > float64(v.ShiftEnd.UnixNano())/1e9 && tr.fail(ReasonShift, -1) { return struct{drive, dist, late float64}{t, distTotal, lateTotal}, false }
+    if v.MaxRouteSec > 0 && t-start > float64(v.MaxRouteSec) && tr.fail(ReasonShift, -1) { return struct{drive, dist, late float64}{t, distTotal, lateTotal}, false }
+    if v.MaxDistM > 0 && distTotal > v.MaxDistM && tr.fail(ReasonShift, -1) { return struct{drive, dist, late float64}{t, distTotal, lateTotal}, false }
+    return struct{drive, dist, late float64}{t, distTotal, lateTotal}, tr == nil || tr.reason == ""
 }
 
 // orOptLocalImprove attempts relocating single nodes within each plan if it reduces cost and remains feasible.
 func orOptLocalImprove(p Problem, sol Solution) Solution {
-    improved := true
-    for improved {
-        improved = false
-        for vi := range sol.Plans {
+    static := p.staticSchedule()
+    for vi := range sol.Plans {
+        for improved := true; improved; {
+            improved = false
             pl := sol.Plans[vi]
-            best := pl
-            bestCost := math.MaxFloat64
-            for i := 0; i < len(pl.Order); i++ {
-                for j := 0; j <= len(pl.Order); j++ {
-                    if j == i || j == i+1 { continue }
-                    cand := RoutePlan{VehicleID: pl.VehicleID, Order: append([]int(nil), pl.Order...)}
-                    // remove i, insert at j
-                    node := cand.Order[i]
-                    cand.Order = append(cand.Order[:i], cand.Order[i+1:]...)
-                    if j > len(cand.Order) { j = len(cand.Order) }
-                    cand.Order = append(cand.Order[:j], append([]int{node}, cand.Order[j:]...)...)
-                    if _, ok := schedulePlan(p, cand, p.Vehicles[vi]); !ok { continue }
-                    // approximate cost
-                    cSol := Solution{Plans: append([]RoutePlan(nil), sol.Plans...)}
-                    cSol.Plans[vi] = cand
-                    c := cost(p, cSol)
-                    if c+1e-6 < bestCost {
-                        best = cand; bestCost = c
+            n := len(pl.Order)
+            if n < 2 { break }
+            bestCost := routeCost(p, pl, vi) - 1e-6
+            bi, bat := -1, -1
+            consider := func(i, at int, c float64) { if c < bestCost { bestCost, bi, bat = c, i, at } }
+            if static {
+                rs := newRouteState(p, pl, vi)
+                for i := 0; i < n; i++ {
+                    node := rs.node(pl.Order[i])
+                    // later positions: the stops passed over move up one place
+                    var mid segment
+                    for at := i + 1; at < n; at++ {
+                        if at > i+1 { mid = rs.join(mid, rs.node(pl.Order[at])) } else { mid = rs.node(pl.Order[at]) }
+                        if s, ok := rs.route(rs.fwd[i], mid, node, rs.bwd[at+1]); ok { consider(i, at, p.segCost(vi, s)) }
+                    }
+                    // earlier positions: the stops passed over move down one place
+                    for at := i - 1; at >= 0; at-- {
+                        if at < i-1 { mid = rs.join(rs.node(pl.Order[at]), mid) } else { mid = rs.node(pl.Order[at]) }
+                        if s, ok := rs.route(rs.fwd[at], node, mid, rs.bwd[i+1]); ok { consider(i, at, p.segCost(vi, s)) }
+                    }
+                }
+            } else {
+                for i := 0; i < n; i++ {
+                    for at := 0; at < n; at++ {
+                        if at == i { continue }
+                        cand := RoutePlan{VehicleID: pl.VehicleID, Order: relocate(pl.Order, i, at)}
+                        if _, ok := schedulePlan(p, cand, vi); !ok { continue }
+                        consider(i, at, routeCost(p, cand, vi))
                     }
                 }
             }
-            if bestCost+1e-6 < cost(p, Solution{Plans: sol.Plans}) {
-                sol.Plans[vi] = best
+            if bi >= 0 {
+                sol.Plans[vi].Order = relocate(pl.Order, bi, bat)
                 improved = true
             }
         }
@@ -467,110 +686,141 @@ func orOptLocalImprove(p Problem, sol Solution) Solution {
 
 // twoOptImprove applies 2-opt within each plan when feasible
 func twoOptImprove(p Problem, sol Solution) Solution {
+    static := p.staticSchedule()
     for vi := range sol.Plans {
-        pl := sol.Plans[vi]
-        n := len(pl.Order)
-        improved := true
-        for improved {
+        for improved := true; improved; {
             improved = false
+            pl := sol.Plans[vi]
+            n := len(pl.Order)
+            cur := pathDistanceNodes(p, pl, vi)
+            var rs *routeState
+            if static { rs = newRouteState(p, pl, vi) }
+        scan:
             for i := 1; i < n-2; i++ {
+                var rev segment // order[i..k] reversed
+                if static { rev = rs.node(pl.Order[i]) }
                 for k := i + 1; k < n-1; k++ {
-                    cand := RoutePlan{VehicleID: pl.VehicleID, Order: append([]int(nil), pl.Order...)}
-                    // reverse segment [i,k]
-                    for a,b := i,k; a < b; a,b = a+1,b-1 { cand.Order[a], cand.Order[b] = cand.Order[b], cand.Order[a] }
-                    if _, ok := schedulePlan(p, cand, p.Vehicles[vi]); !ok { continue }
-                    c1 := pathDistanceNodes(p, pl)
-                    c2 := pathDistanceNodes(p, cand)
-                    if c2+1e-6 < c1 { pl = cand; improved = true }
+                    var dist float64
+                    if static {
+                        rev = rs.join(rs.node(pl.Order[k]), rev)
+                        s, ok := rs.route(rs.fwd[i], rev, rs.bwd[k+1])
+                        if !ok { continue }
+                        dist = s.dist
+                    } else {
+                        cand := RoutePlan{VehicleID: pl.VehicleID, Order: reverseSpan(pl.Order, i, k)}
+                        if _, ok := schedulePlan(p, cand, vi); !ok { continue }
+                        dist = pathDistanceNodes(p, cand, vi)
+                    }
+                    if dist+1e-6 < cur {
+                        sol.Plans[vi].Order = reverseSpan(pl.Order, i, k)
+                        improved = true
+                        break scan
+                    }
                 }
             }
         }
-        sol.Plans[vi] = pl
     }
     sol.Cost = cost(p, sol)
     return sol
 }
 
-func pathDistanceNodes(p Problem, pl RoutePlan) float64 {
-    total := 0.0
+// reverseSpan returns a copy of order with order[i..k] reversed.
+func reverseSpan(order []int, i, k int) []int {
+    out := append([]int(nil), order...)
+    for a, b := i, k; a < b; a, b = a+1, b-1 { out[a], out[b] = out[b], out[a] }
+    return out
+}
+
+// pathDistanceNodes is the route distance of pl on vehicle vi including the
+// depot approach and return legs.
+func pathDistanceNodes(p Problem, pl RoutePlan, vi int) float64 {
     if len(pl.Order) == 0 { return 0 }
-    var curLat, curLng float64
-    cur := p.Nodes[pl.Order[0]]; curLat, curLng = cur.Lat, cur.Lng
-    for _, idx := range pl.Order {
-        nd := p.Nodes[idx]
-        total += haversine(curLat, curLng, nd.Lat, nd.Lng)
-        curLat, curLng = nd.Lat, nd.Lng
+    total, _ := p.travel(p.startLoc(vi), pl.Order[0])
+    for i := 1; i < len(pl.Order); i++ {
+        d, _ := p.travel(pl.Order[i-1], pl.Order[i])
+        total += d
     }
-    return total
+    back, _ := p.travel(pl.Order[len(pl.Order)-1], p.endLoc(vi))
+    return total + back
 }
 
 // crossExchangeImprove swaps nodes between routes if cost decreases and feasible
 func crossExchangeImprove(p Problem, sol Solution) Solution {
-    m := len(sol.Plans)
-    if m < 2 { return sol }
-    improved := true
-    for improved {
-        improved = false
-        for a := 0; a < m; a++ {
-            for b := a + 1; b < m; b++ {
-                pa := sol.Plans[a]
-                pb := sol.Plans[b]
-                for i := 0; i < len(pa.Order); i++ {
-                    for j := 0; j < len(pb.Order); j++ {
-                        ca := RoutePlan{VehicleID: pa.VehicleID, Order: append([]int(nil), pa.Order...)}
-                        cb := RoutePlan{VehicleID: pb.VehicleID, Order: append([]int(nil), pb.Order...)}
-                        ca.Order[i], cb.Order[j] = cb.Order[j], ca.Order[i]
-                        if _, ok := schedulePlan(p, ca, p.Vehicles[a]); !ok { continue }
-                        if _, ok := schedulePlan(p, cb, p.Vehicles[b]); !ok { continue }
-                        before := pathDistanceNodes(p, pa) + pathDistanceNodes(p, pb)
-                        after := pathDistanceNodes(p, ca) + pathDistanceNodes(p, cb)
-                        if after+1e-6 < before {
-                            sol.Plans[a] = ca
-                            sol.Plans[b] = cb
-                            improved = true
-                        }
-                    }
-                }
-            }
-        }
-    }
-    sol.Cost = cost(p, sol)
-    return sol
+    return exchangeImprove(p, sol, 1)
 }
 
 // twoOptStarImprove performs inter-route segment exchanges (2-opt*) limited to segment length 1..2
 func twoOptStarImprove(p Problem, sol Solution) Solution {
+    return exchangeImprove(p, sol, 2)
+}
+
+// exchangeImprove swaps a run of 1..maxLen stops of one route with a run of
+// 1..maxLen stops of another while total distance, less the consistency
+// discount, drops and both routes stay feasible. Each candidate is checked in O(1) with cached segments when the
+// schedule is static, otherwise by re-scheduling both routes.
+func exchangeImprove(p Problem, sol Solution, maxLen int) Solution {
     m := len(sol.Plans)
     if m < 2 { return sol }
+    static := p.staticSchedule()
+    swap := func(order []int, i, l int, seg []int) []int {
+        out := make([]int, 0, len(order)-l+len(seg))
+        out = append(out, order[:i]...)
+        out = append(out, seg...)
+        return append(out, order[i+l:]...)
+    }
+    skilled := func(vi int, seg []int) bool {
+        for _, idx := range seg { if !hasSkills(p, p.Vehicles[vi], idx) || !inZones(p, p.Vehicles[vi], idx) { return false } }
+        return true
+    }
+    // consistency discount given up by moving segA from a to b and segB back
+    lost := func(a, b int, segA, segB []int) float64 {
+        d := 0.0
+        for _, idx := range segA { d += p.familiarity(idx, a) - p.familiarity(idx, b) }
+        for _, idx := range segB { d += p.familiarity(idx, b) - p.familiarity(idx, a) }
+        return d
+    }
     improved := true
     for improved {
         improved = false
         for a := 0; a < m; a++ {
             for b := a + 1; b < m; b++ {
-                pa := sol.Plans[a]
-                pb := sol.Plans[b]
-                for i := 0; i < len(pa.Order); i++ {
-                    for j := 0; j < len(pb.Order); j++ {
-                        for la := 1; la <= 2 && i+la <= len(pa.Order); la++ {
-                            for lb := 1; lb <= 2 && j+lb <= len(pb.Order); lb++ {
-                                ca := RoutePlan{VehicleID: pa.VehicleID, Order: append([]int(nil), pa.Order...)}
-                                cb := RoutePlan{VehicleID: pb.VehicleID, Order: append([]int(nil), pb.Order...)}
-                                segA := append([]int(nil), ca.Order[i:i+la]...)
-                                segB := append([]int(nil), cb.Order[j:j+lb]...)
-                                ca.Order = append(append(ca.Order[:i], segB...), ca.Order[i+la:]...)
-                                cb.Order = append(append(cb.Order[:j], segA...), cb.Order[j+lb:]...)
-                                if _, ok := schedulePlan(p, ca, p.Vehicles[a]); !ok { continue }
-                                if _, ok := schedulePlan(p, cb, p.Vehicles[b]); !ok { continue }
-                                before := pathDistanceNodes(p, pa) + pathDistanceNodes(p, pb)
-                                after := pathDistanceNodes(p, ca) + pathDistanceNodes(p, cb)
-                                if after+1e-6 < before {
-                                    sol.Plans[a] = ca
-                                    sol.Plans[b] = cb
-                                    improved = true
+            pair:
+                for {
+                    pa, pb := sol.Plans[a], sol.Plans[b]
+                    before := pathDistanceNodes(p, pa, a) + pathDistanceNodes(p, pb, b)
+                    var ra, rb *routeState
+                    if static { ra, rb = newRouteState(p, pa, a), newRouteState(p, pb, b) }
+                    for i := 0; i < len(pa.Order); i++ {
+                        for j := 0; j < len(pb.Order); j++ {
+                            for la := 1; la <= maxLen && i+la <= len(pa.Order); la++ {
+                                for lb := 1; lb <= maxLen && j+lb <= len(pb.Order); lb++ {
+                                    segA, segB := pa.Order[i:i+la], pb.Order[j:j+lb]
+                                    if !skilled(a, segB) || !skilled(b, segA) { continue }
+                                    var after float64
+                                    if static {
+                                        sa, ok := ra.splice(i, la, segB)
+                                        if !ok { continue }
+                                        sb, ok := rb.splice(j, lb, segA)
+                                        if !ok { continue }
+                                        after = sa.dist + sb.dist
+                                    } else {
+                                        ca := RoutePlan{VehicleID: pa.VehicleID, Order: swap(pa.Order, i, la, segB)}
+                                        cb := RoutePlan{VehicleID: pb.VehicleID, Order: swap(pb.Order, j, lb, segA)}
+                                        if _, ok := schedulePlan(p, ca, a); !ok { continue }
+                                        if _, ok := schedulePlan(p, cb, b); !ok { continue }
+                                        after = pathDistanceNodes(p, ca, a) + pathDistanceNodes(p, cb, b)
+                                    }
+                                    if after+lost(a, b, segA, segB)+1e-6 < before {
+                                        sol.Plans[a].Order = swap(pa.Order, i, la, segB)
+                                        sol.Plans[b].Order = swap(pb.Order, j, lb, segA)
+                                        improved = true
+                                        continue pair
+                                    }
                                 }
                             }
                         }
                     }
+                    break
                 }
             }
         }
@@ -593,10 +843,10 @@ func shawRemoval(p Problem, sol Solution, k int, rng *rand.Rand) []int {
     for _, idx := range assigned {
         if idx == seedIdx { continue }
         n := p.Nodes[idx]
-        geo := haversine(sN.Lat, sN.Lng, n.Lat, n.Lng)
+        geo, _ := p.travel(seedIdx, idx)
         tw := 0.0
-        if sN.TW != nil && n.TW != nil {
-            tw = twOverlap(*sN.TW, *n.TW)
+        if a, ok := sN.span(); ok {
+            if b, ok := n.span(); ok { tw = twOverlap(a, b) }
         }
         score := geo - 1000.0*tw // prefer close in geo and overlapping TW
         rel = append(rel, pair{idx: idx, score: score})
@@ -0,0 +1,72 @@
+package opt
+
+import (
+    "math"
+    "slices"
+)
+
+// Route balancing. Drive time and distance alone happily give one driver 60
+// stops and another 8; these objectives spread the work across the fleet:
+//   - makespan: per second of the longest route duration;
+//   - stopBalance: per stop of standard deviation in stop count across every
+//     vehicle, unused ones counting zero;
+//   - workBalance: per second of standard deviation in route duration across
+//     every vehicle, breaks and waiting included, unused ones counting zero.
+// Counting idle vehicles is deliberate: balancing spreads the work over the
+// whole fleet, so it opens vehicles a cheaper plan would leave idle. Weight
+// vehicles (per vehicle used) against it to balance only the routes worth
+// opening.
+// minStops and maxStops are per route limits rather than weights. A route
+// over maxStops is infeasible. A used route short of minStops pays a share of
+// the failed penalty that stays below one failed node, so the search fills
+// or merges short routes but never drops stops to do so.
+
+// balance holds the balancing objectives of p.
+type balance struct {
+    makespan, stops, work float64
+    minStops, maxStops    int
+}
+
+func (p Problem) balance() balance {
+    return balance{makespan: p.Objectives["makespan"], stops: p.Objectives["stopBalance"], work: p.Objectives["workBalance"],
+        minStops: int(p.Objectives["minStops"]), maxStops: int(p.Objectives["maxStops"])}
+}
+
+// stopsFeasible reports whether a route of n stops respects maxStops.
+func (p Problem) stopsFeasible(n int) bool {
+    limit := int(p.Objectives["maxStops"])
+    return limit <= 0 || n <= limit
+}
+
+// balanceCost is the balancing charge of solution s.
+func (p Problem) balanceCost(s Solution) float64 {
+    b := p.balance()
+    if b.makespan <= 0 && b.stops <= 0 && b.work <= 0 && b.minStops <= 0 { return 0 }
+    total := 0.0
+    stops := make([]float64, len(s.Plans))
+    work := make([]float64, len(s.Plans))
+    for vi, pl := range s.Plans {
+        n := len(pl.Order)
+        if n == 0 { continue }
+        stops[vi] = float64(n)
+        if b.minStops > n { total += p.weights().fail * 3600 * float64(b.minStops-n) / float64(b.minStops) }
+        if b.makespan > 0 || b.work > 0 {
+            // an infeasible route still takes its full duration
+            sc, _ := timeRoute(p, pl, vi)
+            work[vi] = sc.drive - p.routeStart(vi)
+        }
+    }
+    if len(work) > 0 { total += b.makespan * slices.Max(work) }
+    return total + b.stops*stddev(stops) + b.work*stddev(work)
+}
+
+// stddev is the population standard deviation of xs.
+func stddev(xs []float64) float64 {
+    if len(xs) == 0 { return 0 }
+    mean := 0.0
+    for _, x := range xs { mean += x }
+    mean /= float64(len(xs))
+    v := 0.0
+    for _, x := range xs { v += (x - mean) * (x - mean) }
+    return math.Sqrt(v / float64(len(xs)))
+}
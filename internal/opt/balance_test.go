@@ -0,0 +1,69 @@
+package opt
+
+import (
+    "context"
+    "testing"
+    "time"
+)
+
+// clusterProblem has ten stops around one depot shared by two vehicles; the
+// cheapest plan puts them all on one route.
+func clusterProblem(obj map[string]float64) Problem {
+    depot := &[2]float64{1, 1}
+    p := Problem{Vehicles: []Vehicle{{ID: "v1", StartLatLng: depot, EndLatLng: depot}, {ID: "v2", StartLatLng: depot, EndLatLng: depot}},
+        Objectives: obj, IterationsLimit: 300}
+    for i := 0; i < 10; i++ { p.Nodes = append(p.Nodes, Node{ID: string(rune('a' + i)), Lat: 1.05 + float64(i%5)*0.002, Lng: 1 + float64(i/5)*0.002, ServiceSec: 600}) }
+    return p
+}
+
+func stopCounts(sol Solution) (int, int) { return len(sol.Plans[0].Order), len(sol.Plans[1].Order) }
+
+func TestBalanceSpreadsStops(t *testing.T) {
+    sol, _ := solve(t, clusterProblem(nil), 1, time.Minute)
+    if a, b := stopCounts(sol); a != 0 && b != 0 { t.Fatalf("unbalanced baseline used both vehicles: %d/%d", a, b) }
+    for _, obj := range []map[string]float64{{"stopBalance": 3600}, {"workBalance": 10}, {"makespan": 10}} {
+        sol, _ := solve(t, clusterProblem(obj), 1, time.Minute)
+        if a, b := stopCounts(sol); a < 4 || b < 4 || len(sol.Unassigned) != 0 { t.Fatalf("%v: stops %d/%d, unassigned %v", obj, a, b, sol.Unassigned) }
+    }
+}
+
+func TestBalanceCountsIdleVehicles(t *testing.T) {
+    p := clusterProblem(map[string]float64{"stopBalance": 3600})
+    if err := p.Prepare(context.Background()); err != nil { t.Fatal(err) }
+    if c := p.balanceCost(Solution{Plans: []RoutePlan{{Order: []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}}, {}}}); c != 3600*5 { t.Fatalf("idle vehicle should count as zero stops: %v", c) }
+    // a per-vehicle charge above the imbalance keeps the second vehicle idle
+    sol, _ := solve(t, clusterProblem(map[string]float64{"stopBalance": 3600, "vehicles": 1e6}), 1, time.Minute)
+    if a, b := stopCounts(sol); a != 0 && b != 0 { t.Fatalf("vehicles weight ignored: %d/%d", a, b) }
+}
+
+func TestBalanceTimesInfeasibleRoutes(t *testing.T) {
+    plans := []RoutePlan{{Order: []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}}, {}}
+    free := clusterProblem(map[string]float64{"makespan": 1, "workBalance": 1})
+    if err := free.Prepare(context.Background()); err != nil { t.Fatal(err) }
+    want := free.balanceCost(Solution{Plans: plans})
+    // the same route over capacity takes just as long
+    full := clusterProblem(map[string]float64{"makespan": 1, "workBalance": 1})
+    full.Vehicles[0].CapWeight = 1
+    for i := range full.Nodes { full.Nodes[i].Demand = Demand{Weight: 1} }
+    if err := full.Prepare(context.Background()); err != nil { t.Fatal(err) }
+    if got := full.balanceCost(Solution{Plans: plans}); want <= 0 || got != want { t.Fatalf("over-capacity route: balance %v, want %v", got, want) }
+}
+
+func TestStopLimits(t *testing.T) {
+    sol, _ := solve(t, clusterProblem(map[string]float64{"maxStops": 6}), 1, time.Minute)
+    if a, b := stopCounts(sol); a > 6 || b > 6 || a+b != 10 { t.Fatalf("maxStops: %d/%d", a, b) }
+    // a short route costs less than dropping its stops
+    p := clusterProblem(map[string]float64{"minStops": 20})
+    sol, _ = solve(t, p, 1, time.Minute)
+    if len(sol.Unassigned) != 0 { t.Fatalf("minStops dropped %v", sol.Unassigned) }
+    if err := p.Prepare(context.Background()); err != nil { t.Fatal(err) }
+    if c := p.balanceCost(Solution{Plans: []RoutePlan{{Order: []int{0, 1, 2, 3, 4}}, {Order: []int{5, 6, 7, 8, 9}}}}); c <= p.failCost(0) { t.Fatalf("two short routes cost %v", c) }
+}
+
+func TestBalanceBypassesSegmentCache(t *testing.T) {
+    for _, obj := range []map[string]float64{nil, {"stopBalance": 1}, {"makespan": 1}, {"workBalance": 1}, {"minStops": 2}, {"maxStops": 8}} {
+        p := clusterProblem(obj)
+        if err := p.Prepare(context.Background()); err != nil { t.Fatal(err) }
+        if want := len(obj) == 0 || obj["stopBalance"] > 0; p.staticSchedule() != want { t.Fatalf("%v: staticSchedule %v, want %v", obj, !want, want) }
+    }
+}
@@ -0,0 +1,93 @@
+package opt
+
+import (
+    "context"
+    "math"
+    "math/rand"
+    "testing"
+    "time"
+)
+
+// solomonProblem generates a Solomon-style VRPTW instance: n customers on a
+// 100x100 km grid around a central depot, random ("R") or clustered ("C")
+// locations, demands of 1-50 against 200-unit vehicles, 10-minute service and
+// time windows over an 8-hour day, narrow (1h, "1" series) or wide (4h, "2").
+func solomonProblem(n int, kind string, wide bool, seed int64) Problem {
+    rng := rand.New(rand.NewSource(seed))
+    day := time.Date(2024, 1, 8, 8, 0, 0, 0, time.UTC)
+    at := func(x, y float64) (float64, float64) { return 40 + y*0.009, -88 + x*0.0118 }
+    dlat, dlng := at(50, 50)
+    var centers [][2]float64
+    for i := 0; i < 8; i++ { centers = append(centers, [2]float64{10 + rng.Float64()*80, 10 + rng.Float64()*80}) }
+    width := time.Hour
+    if wide { width = 4 * time.Hour }
+    p := Problem{StartAt: day, SpeedKph: 40}
+    for i := 0; i < n; i++ {
+        x, y := rng.Float64()*100, rng.Float64()*100
+        if kind == "C" {
+            c := centers[rng.Intn(len(centers))]
+            x, y = c[0]+rng.NormFloat64()*4, c[1]+rng.NormFloat64()*4
+        }
+        lat, lng := at(x, y)
+        // window opens after the earliest possible arrival and closes in the day
+        reach := haversine(dlat, dlng, lat, lng) / (40 / 3.6)
+        open := day.Add(time.Duration(reach+rng.Float64()*math.Max(0, 8*3600-reach-width.Seconds())) * time.Second)
+        p.Nodes = append(p.Nodes, Node{ID: string(rune('A'+i%26)) + string(rune('0'+i/26%10)), Lat: lat, Lng: lng, ServiceSec: 600,
+            Windows: []TW{{Start: open, End: open.Add(width)}}, Demand: Demand{Weight: float64(1 + rng.Intn(50))}})
+    }
+    for v := 0; v < (n+9)/10; v++ {
+        p.Vehicles = append(p.Vehicles, Vehicle{ID: "v" + string(rune('a'+v%26)), CapWeight: 200, StartLatLng: &[2]float64{dlat, dlng}, StartID: "depot",
+            EndLatLng: &[2]float64{dlat, dlng}, EndID: "depot", ShiftEnd: day.Add(10 * time.Hour)})
+    }
+    if err := p.Prepare(context.Background()); err != nil { panic(err) }
+    return p
+}
+
+// fullSchedule forces the schedulePlan path: a drive limit that is never
+// reached changes no answer but disables the segment cache.
+func fullSchedule(p Problem) Problem {
+    p.BreakRules = BreakRules{MaxDriveSec: math.MaxInt32, MinBreakSec: 1}
+    return p
+}
+
+func BenchmarkInsertionFeasibility(b *testing.B) {
+    p := solomonProblem(200, "R", true, 1)
+    sol := greedySeed(p)
+    removed := pickRandomNodes(sol, 10, rand.New(rand.NewSource(2)))
+    sol = removeNodes(sol, removed)
+    for _, c := range []struct{ name string; p Problem }{{"delta", p}, {"full", fullSchedule(p)}} {
+        b.Run(c.name, func(b *testing.B) {
+            for i := 0; i < b.N; i++ {
+                for _, idx := range removed { bestInsertions(c.p, sol, []int{idx}) }
+            }
+        })
+    }
+}
+
+func BenchmarkLocalSearch(b *testing.B) {
+    for _, kind := range []string{"R", "C"} {
+        p := solomonProblem(100, kind, true, 3)
+        seed := greedySeed(p)
+        for _, c := range []struct{ name string; p Problem }{{"delta", p}, {"full", fullSchedule(p)}} {
+            b.Run(kind+"2_100/"+c.name, func(b *testing.B) {
+                for i := 0; i < b.N; i++ {
+                    sol := Solution{Plans: append([]RoutePlan(nil), seed.Plans...)}
+                    sol = orOptLocalImprove(c.p, sol)
+                    sol = twoOptImprove(c.p, sol)
+                    sol = crossExchangeImprove(c.p, sol)
+                    twoOptStarImprove(c.p, sol)
+                }
+            })
+        }
+    }
+}
+
+func BenchmarkSolve(b *testing.B) {
+    for _, c := range []struct{ name, kind string; wide bool }{{"R1_100", "R", false}, {"C1_100", "C", false}, {"R2_100", "R", true}} {
+        p := solomonProblem(100, c.kind, c.wide, 5)
+        p.IterationsLimit = 50
+        b.Run(c.name, func(b *testing.B) {
+            for i := 0; i < b.N; i++ { SolveContext(context.Background(), p, 1, time.Minute) }
+        })
+    }
+}
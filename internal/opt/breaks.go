@@ -0,0 +1,147 @@
+package opt
+
+import (
+    "fmt"
+    "math"
+    "time"
+)
+
+// Break rules. The solver plans rests while it schedules a route, so the
+// breaks it prices are the ones callers persist (see Problem.Breaks). A break
+// is taken at the start or at a stop after its service, before the next
+// drive, and lasts MinBreakSec:
+//   - before a leg that would take continuous driving past MaxDriveSec;
+//   - before a leg whose drive, wait and service would take the time on duty
+//     since the route start or the last break past MaxDutySec;
+//   - once in each window the route is on duty across, at the last stop
+//     before the window closes, waiting for it to open if need be.
+// A break resets both clocks, and one starting inside a window also serves as
+// that window's break. A single leg longer than MaxDriveSec is driven as is:
+// the rules delay routes but never make them infeasible.
+
+// BreakWindow is a daily period ("HH:MM" local time of day) in which a break
+// must start, e.g. lunch from 11:30 to 13:30.
+type BreakWindow struct {
+    From string `json:"from"`
+    To   string `json:"to"`
+}
+
+// BreakRules are the rest requirements of every driver. Zero limits are off;
+// without MinBreakSec no break is planned.
+type BreakRules struct {
+    MaxDriveSec int           `json:"maxDriveSec,omitempty"`
+    MaxDutySec  int           `json:"maxDutySec,omitempty"`
+    MinBreakSec int           `json:"minBreakSec,omitempty"`
+    Windows     []BreakWindow `json:"windows,omitempty"`
+    Timezone    string        `json:"timezone,omitempty"` // IANA name for Windows; UTC when empty
+}
+
+// Break is a rest planned on a route, taken before the leg into Order[Pos] or,
+// when Pos == len(Order), before the return leg.
+type Break struct {
+    Pos   int
+    Start time.Time
+    Sec   int
+}
+
+// active reports whether the rules can plan any break.
+func (r BreakRules) active() bool {
+    return r.MinBreakSec > 0 && (r.MaxDriveSec > 0 || r.MaxDutySec > 0 || len(r.Windows) > 0)
+}
+
+// Validate checks the window times and the timezone name.
+func (r BreakRules) Validate() error {
+    _, err := r.compile(time.Now())
+    return err
+}
+
+// compiledBreaks are BreakRules in seconds, windows as seconds of day.
+type compiledBreaks struct {
+    maxDrive, maxDuty, sec float64
+    offset                 float64 // seconds east of UTC at the route start
+    windows                []bandSpan
+}
+
+func (r BreakRules) compile(start time.Time) (*compiledBreaks, error) {
+    loc := time.UTC
+    if r.Timezone != "" {
+        l, err := time.LoadLocation(r.Timezone)
+        if err != nil { return nil, fmt.Errorf("break rules timezone: %w", err) }
+        loc = l
+    }
+    _, off := start.In(loc).Zone()
+    cb := &compiledBreaks{maxDrive: float64(r.MaxDriveSec), maxDuty: float64(r.MaxDutySec), sec: float64(r.MinBreakSec), offset: float64(off)}
+    for _, w := range r.Windows {
+        from, err := parseClock(w.From)
+        if err != nil { return nil, fmt.Errorf("break window: %w", err) }
+        to, err := parseClock(w.To)
+        if err != nil { return nil, fmt.Errorf("break window: %w", err) }
+        if to <= from { return nil, fmt.Errorf("break window %s-%s: must end after it starts", w.From, w.To) }
+        if n := len(cb.windows); n > 0 && from < cb.windows[n-1].to { return nil, fmt.Errorf("break windows must be in order and not overlap") }
+        cb.windows = append(cb.windows, bandSpan{from: from, to: to})
+    }
+    return cb, nil
+}
+
+// breakClock is the rest state of one route as it is scheduled.
+type breakClock struct {
+    cb       *compiledBreaks
+    drive    float64 // driven since the last break
+    dutyFrom float64 // when the current duty period began
+    day0     float64 // local midnight of the route start day, epoch seconds
+    next     int     // next window occurrence to serve; the route's first two days
+}
+
+func (cb *compiledBreaks) clock(start float64) breakClock {
+    day0 := math.Floor((start+cb.offset)/86400)*86400 - cb.offset
+    return breakClock{cb: cb, dutyFrom: start, day0: day0}
+}
+
+// window returns the next window occurrence still open at t.
+func (c *breakClock) window(t float64) (from, to float64, ok bool) {
+    n := len(c.cb.windows)
+    for ; c.next < 2*n; c.next++ {
+        w := c.cb.windows[c.next%n]
+        day := c.day0 + float64(c.next/n)*86400
+        if day+w.to >= t { return day + w.from, day + w.to, true }
+    }
+    return 0, 0, false
+}
+
+// due returns when a break must start before a stint that departs at t,
+// drives drive seconds and ends at end, after any wait and service.
+func (c *breakClock) due(t, drive, end float64) (float64, bool) {
+    if c.cb == nil { return 0, false }
+    if from, to, ok := c.window(t); ok && end > to { return math.Max(t, from), true }
+    if c.cb.maxDrive > 0 && c.drive > 0 && c.drive+drive > c.cb.maxDrive { return t, true }
+    if c.cb.maxDuty > 0 && t > c.dutyFrom && end-c.dutyFrom > c.cb.maxDuty { return t, true }
+    return 0, false
+}
+
+// rest takes a break starting at start and returns when it ends.
+func (c *breakClock) rest(start float64) float64 {
+    if from, _, ok := c.window(start); ok && start >= from { c.next++ }
+    end := start + c.cb.sec
+    c.drive, c.dutyFrom = 0, end
+    return end
+}
+
+// Breaks returns the breaks the solver plans on plan pl of vehicle vi.
+func (p Problem) Breaks(vi int, pl RoutePlan) ([]Break, error) {
+    if p.tt == nil { return nil, ErrUnprepared }
+    return p.breaks(vi, pl), nil
+}
+
+func (p Problem) breaks(vi int, pl RoutePlan) []Break {
+    var tr scheduleTrace
+    schedule(p, pl, vi, &tr)
+    return tr.breaks
+}
+
+// planBreaks records the planned breaks on each route of sol.
+func (p Problem) planBreaks(sol Solution) {
+    if p.tt == nil || p.tt.br == nil { return }
+    for vi := range sol.Plans { sol.Plans[vi].Breaks = p.breaks(vi, sol.Plans[vi]) }
+}
+
+func epochTime(sec float64) time.Time { return time.Unix(0, int64(sec*1e9)).UTC() }
@@ -0,0 +1,70 @@
+package opt
+
+import (
+    "context"
+    "slices"
+    "testing"
+    "time"
+)
+
+// breakProblem is a closed route over n stops, every leg taking legSec.
+func breakProblem(n int, legSec float64, start time.Time, rules BreakRules) Problem {
+    depot := &[2]float64{1, 1}
+    p := Problem{Vehicles: []Vehicle{{ID: "v1", StartLatLng: depot, EndLatLng: depot}}, Matrix: staticMatrix{d: 1000, t: legSec}, StartAt: start, BreakRules: rules}
+    for i := 0; i < n; i++ { p.Nodes = append(p.Nodes, Node{ID: string(rune('a' + i)), Lat: 1 + float64(i+1)*0.01, Lng: 1}) }
+    return p
+}
+
+func order(n int) RoutePlan {
+    pl := RoutePlan{}
+    for i := 0; i < n; i++ { pl.Order = append(pl.Order, i) }
+    return pl
+}
+
+func at(hh, mm int) time.Time { return time.Date(2025, 3, 3, hh, mm, 0, 0, time.UTC) }
+
+func TestBreaksAfterMaxDrive(t *testing.T) {
+    p := breakProblem(4, 3600, at(8, 0), BreakRules{MaxDriveSec: 7200, MinBreakSec: 1800})
+    if err := p.Prepare(context.Background()); err != nil { t.Fatal(err) }
+    got := p.breaks(0, order(4))
+    // two hours of driving, rest, two more, rest before the return leg
+    want := []Break{{Pos: 2, Start: at(10, 0), Sec: 1800}, {Pos: 4, Start: at(12, 30), Sec: 1800}}
+    if !slices.Equal(got, want) { t.Fatalf("breaks = %+v, want %+v", got, want) }
+}
+
+func TestBreaksAfterMaxDuty(t *testing.T) {
+    p := breakProblem(4, 3600, at(8, 0), BreakRules{MaxDutySec: 3 * 3600, MinBreakSec: 1800})
+    for i := range p.Nodes { p.Nodes[i].ServiceSec = 1800 }
+    if err := p.Prepare(context.Background()); err != nil { t.Fatal(err) }
+    got := p.breaks(0, order(4))
+    // the third stop would be served until 12:30, past three hours on duty
+    if len(got) == 0 || got[0] != (Break{Pos: 2, Start: at(11, 0), Sec: 1800}) { t.Fatalf("breaks = %+v", got) }
+}
+
+func TestBreaksInLunchWindow(t *testing.T) {
+    lunch := BreakRules{MinBreakSec: 1800, Windows: []BreakWindow{{From: "11:30", To: "13:30"}}}
+    // hourly stops from 08:00: the last stop before the window closes rests
+    p := breakProblem(5, 3600, at(8, 0), lunch)
+    if err := p.Prepare(context.Background()); err != nil { t.Fatal(err) }
+    if got := p.breaks(0, order(5)); !slices.Equal(got, []Break{{Pos: 5, Start: at(13, 0), Sec: 1800}}) { t.Fatalf("hourly breaks = %+v", got) }
+    // a four hour drive from 11:00 waits for the window to open
+    p = breakProblem(1, 4*3600, at(7, 0), lunch)
+    if err := p.Prepare(context.Background()); err != nil { t.Fatal(err) }
+    if got := p.breaks(0, order(1)); !slices.Equal(got, []Break{{Pos: 1, Start: at(11, 30), Sec: 1800}}) { t.Fatalf("long drive breaks = %+v", got) }
+    // done before the window closes: no break
+    p = breakProblem(2, 3600, at(8, 0), lunch)
+    if err := p.Prepare(context.Background()); err != nil { t.Fatal(err) }
+    if got := p.breaks(0, order(2)); len(got) != 0 { t.Fatalf("short route breaks = %+v", got) }
+    if err := (BreakRules{MinBreakSec: 60, Windows: []BreakWindow{{From: "13:30", To: "11:30"}}}).Validate(); err == nil { t.Fatal("reversed window accepted") }
+    if err := (BreakRules{Timezone: "Nowhere/City"}).Validate(); err == nil { t.Fatal("unknown timezone accepted") }
+}
+
+func TestSolveRecordsPlannedBreaks(t *testing.T) {
+    p := breakProblem(6, 1800, at(8, 0), BreakRules{MaxDriveSec: 3600, MinBreakSec: 900})
+    p.IterationsLimit = 50
+    if err := p.Prepare(context.Background()); err != nil { t.Fatal(err) }
+    sol, _ := solve(t, p, 1, time.Minute)
+    rp := sol.Plans[0]
+    if len(rp.Order) != 6 || len(rp.Breaks) == 0 { t.Fatalf("plan %+v", rp) }
+    if want := p.breaks(0, rp); !slices.Equal(rp.Breaks, want) { t.Fatalf("solution breaks %+v, problem breaks %+v", rp.Breaks, want) }
+}
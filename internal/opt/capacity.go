@@ -0,0 +1,71 @@
+package opt
+
+// capDim is one constrained capacity dimension of a vehicle.
+type capDim struct {
+    name  string
+    limit float64
+}
+
+// amount returns the demand in dimension name.
+func (d Demand) amount(name string) float64 {
+    switch name {
+    case "weight": return d.Weight
+    case "volume": return d.Volume
+    case "pallets": return d.Pallets
+    }
+    return d.Dims[name]
+}
+
+// capacityDims lists the dimensions v actually limits.
+func capacityDims(v Vehicle) []capDim {
+    var dims []capDim
+    if v.CapWeight > 0 { dims = append(dims, capDim{"weight", v.CapWeight}) }
+    if v.CapVolume > 0 { dims = append(dims, capDim{"volume", v.CapVolume}) }
+    if v.CapPallets > 0 { dims = append(dims, capDim{"pallets", v.CapPallets}) }
+    for name, limit := range v.CapDims {
+        if limit > 0 { dims = append(dims, capDim{name, limit}) }
+    }
+    return dims
+}
+
+// isPickup reports whether idx adds load when served: the pickup side of a
+// pair, or an unpaired node flagged Pickup.
+func (p Problem) isPickup(idx int) bool {
+    if p.partner(idx) >= 0 { return p.pd.pickup[idx] }
+    return p.Nodes[idx].Pickup
+}
+
+// loadFeasible walks the on-board load of order against v's capacity in
+// every dimension. Deliveries not paired with a pickup are loaded at the
+// depot; pickups add their demand and deliveries drop theirs.
+func loadFeasible(p Problem, order []int, v Vehicle) bool {
+    dims := capacityDims(v)
+    if len(dims) == 0 { return true }
+    load := make([]float64, len(dims))
+    for _, i := range order {
+        if p.partner(i) >= 0 || p.Nodes[i].Pickup { continue }
+        for k, c := range dims { load[k] += p.Nodes[i].Demand.amount(c.name) }
+    }
+    over := func() bool {
+        for k, c := range dims { if load[k] > c.limit+1e-9 { return true } }
+        return false
+    }
+    if over() { return false }
+    for _, i := range order {
+        sign := -1.0
+        if p.isPickup(i) { sign = 1 }
+        for k, c := range dims { load[k] += sign * p.Nodes[i].Demand.amount(c.name) }
+        if over() { return false }
+    }
+    return true
+}
+
+// Scale returns d with every dimension multiplied by f.
+func (d Demand) Scale(f float64) Demand {
+    out := Demand{Weight: d.Weight * f, Volume: d.Volume * f, Pallets: d.Pallets * f}
+    if d.Dims != nil {
+        out.Dims = make(map[string]float64, len(d.Dims))
+        for k, v := range d.Dims { out.Dims[k] = v * f }
+    }
+    return out
+}
@@ -0,0 +1,21 @@
+package opt
+
+import "testing"
+
+func TestLoadFeasibleTracksLoadAlongRoute(t *testing.T) {
+    p := Problem{
+        Nodes: []Node{
+            {ID: "d1", Demand: Demand{Pallets: 2, Dims: map[string]float64{"chilled": 1}}},
+            {ID: "d2", Demand: Demand{Pallets: 2}},
+            {ID: "c1", Pickup: true, Demand: Demand{Pallets: 3}},
+        },
+        Vehicles: []Vehicle{{ID: "v"}},
+    }
+    v := Vehicle{ID: "v", CapPallets: 4, CapDims: map[string]float64{"chilled": 1}}
+    // 4 pallets leave the depot, both drops free room for the 3-pallet collection
+    if !loadFeasible(p, []int{0, 1, 2}, v) { t.Fatalf("collection after drops should fit") }
+    if loadFeasible(p, []int{2, 0, 1}, v) { t.Fatalf("collection before drops exceeds 4 pallets") }
+    v.CapDims["chilled"] = 0.5
+    if loadFeasible(p, []int{0, 1, 2}, v) { t.Fatalf("named dimension should be enforced") }
+    if !feasibleAdd(p, RoutePlan{Order: []int{0}}, Vehicle{ID: "v", CapWeight: 10}, 1) { t.Fatalf("unconstrained dimensions should be ignored") }
+}
@@ -0,0 +1,19 @@
+package opt
+
+// Driver consistency: a node's Affinity records how often each driver served
+// its customer on earlier plan dates. Keeping the node with a familiar
+// driver earns a discount of the consistency objective times that share, so
+// customers tend to see the same driver day after day.
+
+// affinity is the share of node idx's past visits made by vehicle vi's driver.
+func (p Problem) affinity(idx, vi int) float64 {
+    d := p.Vehicles[vi].DriverID
+    if d == "" { return 0 }
+    return p.Nodes[idx].Affinity[d]
+}
+
+// familiarity is the consistency discount for vehicle vi serving node idx.
+func (p Problem) familiarity(idx, vi int) float64 {
+    if len(p.Nodes[idx].Affinity) == 0 { return 0 }
+    return p.weights().consistency * p.affinity(idx, vi)
+}
@@ -0,0 +1,36 @@
+package opt
+
+import (
+    "testing"
+    "time"
+)
+
+func TestConsistencyKeepsFamiliarDriver(t *testing.T) {
+    west, east := &[2]float64{1, 0.99}, &[2]float64{1, 1.01}
+    p := Problem{
+        Nodes: []Node{
+            {ID: "e1", Lat: 1.0, Lng: 1.02}, {ID: "e2", Lat: 1.01, Lng: 1.02},
+            {ID: "w1", Lat: 1.0, Lng: 0.98}, {ID: "w2", Lat: 1.01, Lng: 0.98},
+        },
+        Vehicles: []Vehicle{
+            {ID: "v1", DriverID: "anna", StartLatLng: west, EndLatLng: west, CapWeight: 2},
+            {ID: "v2", DriverID: "ben", StartLatLng: east, EndLatLng: east, CapWeight: 2},
+        },
+        Objectives:      map[string]float64{"consistency": 3600},
+        IterationsLimit: 200,
+    }
+    // anna served the east side from the west depot, ben the west side
+    for i := range p.Nodes {
+        p.Nodes[i].Demand.Weight = 1
+        p.Nodes[i].Affinity = map[string]float64{"anna": 1}
+        if i >= 2 { p.Nodes[i].Affinity = map[string]float64{"ben": 0.8, "anna": 0.2} }
+    }
+    for seed := int64(1); seed <= 5; seed++ {
+        sol, _ := solve(t, p, seed, time.Minute)
+        for vi, pl := range sol.Plans {
+            for _, idx := range pl.Order {
+                if p.affinity(idx, vi) < 0.5 { t.Fatalf("seed %d: %s went to %s: %+v", seed, p.Nodes[idx].ID, p.Vehicles[vi].DriverID, sol.Plans) }
+            }
+        }
+    }
+}
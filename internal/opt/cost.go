@@ -0,0 +1,61 @@
+package opt
+
+import "math"
+
+// VehicleCost is what a vehicle type costs in money when it runs a route.
+type VehicleCost struct {
+    Fixed            float64 // per day the vehicle is used
+    PerKm            float64
+    PerHour          float64 // route duration, departure to return
+    OvertimeAfterSec int     // hours beyond this cost OvertimeFactor times PerHour; 0 = no overtime
+    OvertimeFactor   float64 // e.g. 1.5; <= 1 adds no premium
+}
+
+// CostBreakdown prices one route by vehicle cost component.
+type CostBreakdown struct {
+    Fixed, Distance, Time, Overtime float64 // money
+    DistanceM, DurationSec          float64
+}
+
+// Total is the route's money cost.
+func (b CostBreakdown) Total() float64 { return b.Fixed + b.Distance + b.Time + b.Overtime }
+
+// price splits the cost of a used vehicle driving dist metres over dur seconds.
+func (c VehicleCost) price(dist, dur float64) CostBreakdown {
+    b := CostBreakdown{Fixed: c.Fixed, Distance: c.PerKm * dist / 1000, DistanceM: dist, DurationSec: dur}
+    regular := dur
+    if c.OvertimeAfterSec > 0 { regular = math.Min(dur, float64(c.OvertimeAfterSec)) }
+    b.Time = c.PerHour * regular / 3600
+    if over := dur - regular; over > 0 {
+        // overtime hours are paid at the multiplied rate
+        b.Overtime = c.PerHour * over / 3600 * math.Max(c.OvertimeFactor, 1)
+    }
+    return b
+}
+
+// usageCost is the objective charge for using vehicle vi at all: its fixed
+// cost plus the vehicles weight, which lets the search trade fleet size
+// against drive time.
+func (p Problem) usageCost(vi int) float64 {
+    w := p.weights()
+    return w.vehicles + w.money*p.Vehicles[vi].Cost.Fixed
+}
+
+// vehicleCost is the objective charge for vehicle vi running a route of dist
+// metres over dur seconds.
+func (p Problem) vehicleCost(vi int, dist, dur float64) float64 {
+    w, c := p.weights(), p.Vehicles[vi].Cost
+    if c == (VehicleCost{}) { return w.vehicles }
+    return w.vehicles + w.money*c.price(dist, dur).Total()
+}
+
+// RouteCostBreakdown prices plan pl on vehicle vi as scheduled, HoS breaks
+// and waiting included. An empty plan costs nothing. An infeasible plan,
+// e.g. a manual edit, is priced as driven: late stops are served late and
+// the route runs past its limits.
+func (p Problem) RouteCostBreakdown(vi int, pl RoutePlan) (CostBreakdown, error) {
+    if len(pl.Order) == 0 { return CostBreakdown{}, nil }
+    if p.tt == nil { return CostBreakdown{}, ErrUnprepared }
+    sc, _ := timeRoute(p, pl, vi)
+    return p.Vehicles[vi].Cost.price(sc.dist, sc.drive-p.routeStart(vi)), nil
+}
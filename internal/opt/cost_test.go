@@ -0,0 +1,61 @@
+package opt
+
+import (
+    "context"
+    "errors"
+    "math"
+    "testing"
+    "time"
+)
+
+func TestVehicleCostBreakdown(t *testing.T) {
+    c := VehicleCost{Fixed: 120, PerKm: 0.5, PerHour: 30, OvertimeAfterSec: 8 * 3600, OvertimeFactor: 1.5}
+    b := c.price(200000, 10*3600)
+    if b.Fixed != 120 || b.Distance != 100 || b.Time != 240 || b.Overtime != 90 || b.Total() != 550 { t.Fatalf("breakdown: %+v", b) }
+    if b := (VehicleCost{PerHour: 30}).price(0, 10*3600); b.Time != 300 || b.Overtime != 0 { t.Fatalf("no overtime rule: %+v", b) }
+
+    depot := &[2]float64{1, 1}
+    shift := time.Date(2025, 3, 3, 8, 0, 0, 0, time.UTC)
+    p := Problem{
+        Nodes:           []Node{{ID: "a", Lat: 1.01, Lng: 1.01}, {ID: "b", Lat: 1.02, Lng: 1.01}, {ID: "c", Lat: 0.99, Lng: 0.98}, {ID: "d", Lat: 0.98, Lng: 0.99}},
+        StartAt:         shift,
+        IterationsLimit: 200,
+    }
+    for _, id := range []string{"v1", "v2", "v3"} {
+        p.Vehicles = append(p.Vehicles, Vehicle{ID: id, StartLatLng: depot, EndLatLng: depot, Cost: VehicleCost{Fixed: 500, PerKm: 1, PerHour: 20}})
+    }
+    if _, err := p.RouteCostBreakdown(0, RoutePlan{Order: []int{0}}); !errors.Is(err, ErrUnprepared) { t.Fatalf("unprepared problem priced: %v", err) }
+    if err := p.Prepare(context.Background()); err != nil { t.Fatal(err) }
+    sol, _ := solve(t, p, 3, time.Minute)
+    used := 0
+    for vi, pl := range sol.Plans {
+        if len(pl.Order) == 0 { continue }
+        used++
+        b, err := p.RouteCostBreakdown(vi, pl)
+        if err != nil { t.Fatal(err) }
+        if b.Fixed != 500 || b.DistanceM <= 0 || math.Abs(b.Distance-b.DistanceM/1000) > 1e-9 || b.DurationSec <= 0 { t.Fatalf("route %d breakdown: %+v", vi, b) }
+    }
+    if used != 1 { t.Fatalf("fixed costs should pack every stop into one vehicle, used %d", used) }
+}
+
+func TestRouteCostBreakdownInfeasible(t *testing.T) {
+    depot := &[2]float64{1, 1}
+    start := time.Date(2025, 3, 3, 8, 0, 0, 0, time.UTC)
+    p := Problem{
+        Nodes:    []Node{{ID: "a", Lat: 1.01, Lng: 1}, {ID: "b", Lat: 1.02, Lng: 1, Windows: []TW{{End: start.Add(5 * time.Minute)}}}},
+        Vehicles: []Vehicle{{ID: "v", StartLatLng: depot, EndLatLng: depot, Cost: VehicleCost{PerKm: 1, PerHour: 60}}},
+        Matrix:   staticMatrix{d: 1000, t: 600},
+        StartAt:  start,
+    }
+    if err := p.Prepare(context.Background()); err != nil { t.Fatal(err) }
+    // b misses its window behind a; the edit is still priced as driven
+    b, err := p.RouteCostBreakdown(0, RoutePlan{Order: []int{0, 1}})
+    if err != nil { t.Fatal(err) }
+    if b.DistanceM != 3000 || b.DurationSec != 1800 || b.Distance != 3 || b.Time != 30 { t.Fatalf("infeasible route breakdown: %+v", b) }
+}
+
+func TestVehicleCostWeightZero(t *testing.T) {
+    p := Problem{Nodes: []Node{{ID: "a", Lat: 1.01, Lng: 1}}, Vehicles: []Vehicle{{ID: "v", Cost: VehicleCost{Fixed: 500}}}, Objectives: map[string]float64{"vehicleCost": 0}}
+    if err := p.Prepare(context.Background()); err != nil { t.Fatal(err) }
+    if c := p.vehicleCost(0, 1000, 60); c != 0 { t.Fatalf("vehicleCost 0 should not charge the vehicle, got %v", c) }
+}
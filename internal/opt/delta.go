@@ -0,0 +1,151 @@
+package opt
+
+import "math"
+
+// Incremental route evaluation. A segment summarises a run of consecutive
+// stops so two segments can be joined in O(1): earliest/latest service start
+// of its first stop, minimum duration, time-window violation ("warp"), drive
+// and distance, driver affinity, and the on-board load profile per capacity
+// dimension. A
+// routeState caches the forward (depot..k) and backward (k..depot) segments
+// of one plan, so inserting, relocating or swapping stops is checked by
+// joining three or four segments instead of re-scheduling the route.
+//
+// Joining assumes drive times do not depend on departure time and that no
+// break is planned, so it is only used when staticSchedule holds; other
+// problems fall back to schedulePlan.
+
+// maxSegDims bounds the capacity dimensions a segment tracks inline;
+// vehicles limiting more fall back to schedulePlan.
+const maxSegDims = 4
+
+type segment struct {
+    first, last  int     // travel locations at either end (-1 = none)
+    dur, warp    float64 // minimum duration and time-window violation (s)
+    early, late  float64 // service start window of the first stop (epoch s)
+    drive, dist  float64
+    affinity     float64 // summed Node.Affinity of the stops for the route's driver
+    del, pick    [maxSegDims]float64 // per capacity dimension: depot-loaded deliveries, pickups
+    peak         [maxSegDims]float64 // max on-board load inside the segment
+}
+
+// staticSchedule reports whether segments give exact answers for p: no speed
+// profile, no break rules, no pickup/delivery pairs, no stop limits, no
+// balancing beyond stopBalance, few enough capacity dimensions, hard single
+// windows and no earliness cost.
+func (p Problem) staticSchedule() bool {
+    if p.tt == nil || p.tt.prof != nil || p.tt.windowed || p.BreakRules.active() || p.pd != nil || p.Objectives["earliness"] > 0 { return false }
+    if b := p.balance(); b.maxStops > 0 || b.minStops > 0 || b.makespan > 0 || b.work > 0 { return false }
+    for _, v := range p.Vehicles { if len(capacityDims(v)) > maxSegDims { return false } }
+    return true
+}
+
+func nodeSeg(nd Node, idx int, dims []capDim) segment {
+    s := segment{first: idx, last: idx, dur: float64(nd.ServiceSec), early: math.Inf(-1), late: math.Inf(1)}
+    if tw, ok := nd.span(); ok && !tw.Start.IsZero() { s.early = epochSec(tw.Start) }
+    if tw, ok := nd.span(); ok && !tw.End.IsZero() { s.late = epochSec(tw.End) }
+    for k, c := range dims {
+        q := nd.Demand.amount(c.name)
+        if nd.Pickup { s.pick[k] = q } else { s.del[k] = q }
+        s.peak[k] = q
+    }
+    return s
+}
+
+// join concatenates a then b, driving from a's last stop to b's first.
+func join(tt *travelTable, a, b segment) segment {
+    d, t := 0.0, 0.0
+    if a.last >= 0 && b.first >= 0 && a.last != b.first { d, t = tt.Dist[a.last][b.first], tt.Dur[a.last][b.first] }
+    delta := a.dur - a.warp + t
+    wait := math.Max(b.early-delta-a.late, 0)
+    warp := math.Max(a.early+delta-b.late, 0)
+    out := segment{first: a.first, last: b.last,
+        dur: a.dur + b.dur + t + wait, warp: a.warp + b.warp + warp,
+        early: math.Max(b.early-delta, a.early) - wait, late: math.Min(b.late-delta, a.late) + warp,
+        drive: a.drive + b.drive + t, dist: a.dist + b.dist + d, affinity: a.affinity + b.affinity}
+    for k := range out.peak {
+        out.del[k], out.pick[k] = a.del[k]+b.del[k], a.pick[k]+b.pick[k]
+        out.peak[k] = math.Max(a.peak[k]+b.del[k], a.pick[k]+b.peak[k])
+    }
+    return out
+}
+
+// routeState caches the prefix and suffix segments of one plan.
+type routeState struct {
+    nodes   []Node
+    tt      *travelTable
+    dims    []capDim
+    maxDist float64
+    driver  string
+    fwd     []segment // fwd[k]: start depot then order[:k]
+    bwd     []segment // bwd[k]: order[k:] then end depot
+}
+
+func newRouteState(p Problem, pl RoutePlan, vi int) *routeState {
+    v := p.Vehicles[vi]
+    rs := &routeState{nodes: p.Nodes, tt: p.tt, dims: capacityDims(v), maxDist: v.MaxDistM, driver: v.DriverID}
+    n := len(pl.Order)
+    start := p.routeStart(vi)
+    deadline := math.Inf(1)
+    if !v.ShiftEnd.IsZero() { deadline = float64(v.ShiftEnd.UnixNano()) / 1e9 }
+    if v.MaxRouteSec > 0 { deadline = math.Min(deadline, start+float64(v.MaxRouteSec)) }
+    rs.fwd, rs.bwd = make([]segment, n+1), make([]segment, n+1)
+    rs.fwd[0] = segment{first: p.startLoc(vi), last: p.startLoc(vi), early: start, late: start}
+    for k, idx := range pl.Order { rs.fwd[k+1] = rs.join(rs.fwd[k], rs.node(idx)) }
+    rs.bwd[n] = segment{first: p.endLoc(vi), last: p.endLoc(vi), early: math.Inf(-1), late: deadline}
+    for k := n - 1; k >= 0; k-- { rs.bwd[k] = rs.join(rs.node(pl.Order[k]), rs.bwd[k+1]) }
+    return rs
+}
+
+func (rs *routeState) node(idx int) segment {
+    s := nodeSeg(rs.nodes[idx], idx, rs.dims)
+    if rs.driver != "" { s.affinity = rs.nodes[idx].Affinity[rs.driver] }
+    return s
+}
+
+func (rs *routeState) join(a, b segment) segment { return join(rs.tt, a, b) }
+
+// route joins segs into a full route and reports whether it is feasible.
+func (rs *routeState) route(segs ...segment) (segment, bool) {
+    s := segs[0]
+    for _, x := range segs[1:] { s = rs.join(s, x) }
+    return s, rs.feasible(s)
+}
+
+// feasible checks a full-route segment: no time-window or deadline
+// violation, load within capacity and distance within the vehicle cap.
+func (rs *routeState) feasible(s segment) bool {
+    if s.warp > 1e-6 { return false }
+    for k, c := range rs.dims { if s.peak[k] > c.limit+1e-9 { return false } }
+    return rs.maxDist <= 0 || s.dist <= rs.maxDist
+}
+
+// splice evaluates replacing the l stops at pos with idxs.
+func (rs *routeState) splice(pos, l int, idxs []int) (segment, bool) {
+    s := rs.fwd[pos]
+    for _, idx := range idxs { s = rs.join(s, rs.node(idx)) }
+    s = rs.join(s, rs.bwd[pos+l])
+    return s, rs.feasible(s)
+}
+
+// insert evaluates placing idx at pos.
+func (rs *routeState) insert(idx, pos int) (segment, bool) {
+    return rs.route(rs.fwd[pos], rs.node(idx), rs.bwd[pos])
+}
+
+// segCost is the objective of a feasible full, non-empty route segment of
+// vehicle vi: with no lateness or earliness, drive time, distance and the
+// vehicle cost remain, less the consistency discount.
+func (p Problem) segCost(vi int, s segment) float64 {
+    w := p.weights()
+    return w.drive*s.drive + w.dist*s.dist + p.vehicleCost(vi, s.dist, s.dur) - w.consistency*s.affinity
+}
+
+// relocate returns order with the node at i moved to position at of the
+// order without it.
+func relocate(order []int, i, at int) []int {
+    out := make([]int, 0, len(order))
+    out = append(out, order[:i]...)
+    out = append(out, order[i+1:]...)
+    return insertAt(out, at, order[i])
+}
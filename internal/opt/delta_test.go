@@ -0,0 +1,72 @@
+package opt
+
+import (
+    "context"
+    "math"
+    "math/rand"
+    "slices"
+    "sort"
+    "testing"
+)
+
+// Segment joins must agree with schedulePlan and routeCost.
+func TestRouteStateMatchesSchedule(t *testing.T) {
+    for seed := int64(1); seed <= 20; seed++ {
+        rng := rand.New(rand.NewSource(seed))
+        p := solomonProblem(12, "R", seed%4 != 0, seed)
+        v := &p.Vehicles[0]
+        v.CapWeight = 60 + float64(rng.Intn(120))
+        if seed%3 == 0 { v.EndLatLng, v.EndID = nil, "" } // open route
+        if seed%4 == 0 { v.MaxDistM = 80000 }
+        if seed%5 == 0 { v.MaxRouteSec = 5 * 3600 }
+        if seed%2 == 1 { p.Nodes[3].Pickup = true }
+        if seed%3 == 1 { v.Cost = VehicleCost{Fixed: 100, PerKm: 0.4, PerHour: 30, OvertimeAfterSec: 3 * 3600, OvertimeFactor: 1.5} }
+        if seed%4 == 1 {
+            v.DriverID, p.Objectives = "d1", map[string]float64{"consistency": 300}
+            for i := range p.Nodes { if i%2 == 0 { p.Nodes[i].Affinity = map[string]float64{"d1": 0.5} } }
+        }
+        for i := range p.Nodes { if i%3 != 0 { p.Nodes[i].Windows = nil } } // mix in unconstrained stops
+        p.tt = nil
+        if err := p.Prepare(context.Background()); err != nil { t.Fatal(err) }
+        // a base route in window order, so both outcomes occur
+        order := rng.Perm(len(p.Nodes))[:4]
+        sort.Slice(order, func(i, j int) bool { return twStart(p.Nodes[order[i]]) < twStart(p.Nodes[order[j]]) })
+        pl := RoutePlan{VehicleID: v.ID, Order: order}
+        rs := newRouteState(p, pl, 0)
+        for _, idx := range rng.Perm(len(p.Nodes))[:6] {
+            if containsNode(order, idx) { continue }
+            for pos := 0; pos <= len(order); pos++ {
+                cand := RoutePlan{VehicleID: v.ID, Order: insertAt(order, pos, idx)}
+                s, ok := rs.insert(idx, pos)
+                _, want := schedulePlan(p, cand, 0)
+                if ok != want { t.Fatalf("seed %d: insert %d at %d: segments say %v, schedulePlan %v", seed, idx, pos, ok, want) }
+                if ok && math.Abs(p.segCost(0, s)-routeCost(p, cand, 0)) > 1e-6 { t.Fatalf("seed %d: cost %.3f vs %.3f", seed, p.segCost(0, s), routeCost(p, cand, 0)) }
+            }
+        }
+    }
+}
+
+func TestLocalSearchSameWithoutCache(t *testing.T) {
+    p := solomonProblem(40, "C", true, 9)
+    full := fullSchedule(p)
+    seed := greedySeed(p)
+    copyOf := func(s Solution) Solution { return Solution{Plans: append([]RoutePlan(nil), s.Plans...)} }
+    a, b := orOptLocalImprove(p, copyOf(seed)), orOptLocalImprove(full, copyOf(seed))
+    if math.Abs(a.Cost-b.Cost) > 1e-6 { t.Fatalf("or-opt: cached %.3f vs full %.3f", a.Cost, b.Cost) }
+    a, b = twoOptImprove(p, copyOf(seed)), twoOptImprove(full, copyOf(seed))
+    if math.Abs(a.Cost-b.Cost) > 1e-6 { t.Fatalf("2-opt: cached %.3f vs full %.3f", a.Cost, b.Cost) }
+    a, b = exchangeImprove(p, copyOf(seed), 2), exchangeImprove(full, copyOf(seed), 2)
+    if math.Abs(a.Cost-b.Cost) > 1e-6 { t.Fatalf("exchange: cached %.3f vs full %.3f", a.Cost, b.Cost) }
+    ra, rb := worstRemoval(p, seed, 8, rand.New(rand.NewSource(1))), worstRemoval(full, seed, 8, rand.New(rand.NewSource(1)))
+    if !slices.Equal(ra, rb) { t.Fatalf("worst removal: cached %v vs full %v", ra, rb) }
+}
+
+func twStart(nd Node) int64 {
+    if len(nd.Windows) == 0 { return 0 }
+    return nd.Windows[0].Start.Unix()
+}
+
+func containsNode(order []int, idx int) bool {
+    for _, i := range order { if i == idx { return true } }
+    return false
+}
@@ -0,0 +1,126 @@
+package opt
+
+import (
+    "math"
+    "slices"
+)
+
+// Insertion is the outcome of inserting a node at one position of a plan.
+type Insertion struct {
+    Vehicle    int // index into Vehicles and the explained plans
+    Pos        int // index in the plan's Order the node would take
+    PartnerPos int // index its pickup/delivery partner would take; -1 when unpaired
+    After      int // node it would follow; -1 at the start of the route
+    Feasible   bool
+    Reasons  []string // reason codes (see unassigned.go) when infeasible
+    Delta    float64  // objective change of the route, positive = costlier
+}
+
+// Violation is a rule a plan breaks, at node Node or, when Node is -1, on
+// the route as a whole.
+type Violation struct {
+    Node   int
+    Reason string // reason code (see unassigned.go)
+}
+
+// Explain evaluates node idx at every position of every plan, without
+// changing plans: feasibility with the reasons it fails and the route cost
+// delta. The node is first taken off the plan it is on, so its current
+// position is among those priced. A paired node is taken off together with
+// its pickup/delivery partner, which for every position of the node goes
+// back where the route is feasible at least cost, and Delta prices the pair.
+func (p Problem) Explain(plans []RoutePlan, idx int) ([]Insertion, error) {
+    if p.tt == nil { return nil, ErrUnprepared }
+    o := p.partner(idx)
+    var out []Insertion
+    for vi, pl := range plans {
+        if vi >= len(p.Vehicles) { break }
+        base := RoutePlan{VehicleID: pl.VehicleID, Order: slices.DeleteFunc(slices.Clone(pl.Order), func(i int) bool { return i == idx || i == o })}
+        baseCost := routeCost(p, base, vi)
+        for pos := 0; pos <= len(base.Order); pos++ {
+            in := Insertion{Vehicle: vi, Pos: pos, PartnerPos: -1, After: -1}
+            var cand RoutePlan
+            if o < 0 {
+                cand = RoutePlan{VehicleID: base.VehicleID, Order: insertAt(base.Order, pos, idx)}
+                in.Feasible = feasibleAddAt(p, base, vi, idx, pos)
+            } else {
+                cand, in.PartnerPos, in.Feasible = p.placePartner(base, vi, idx, pos)
+                if in.PartnerPos <= pos { in.Pos++ }
+            }
+            if in.Pos > 0 { in.After = cand.Order[in.Pos-1] }
+            in.Delta = routeCost(p, cand, vi) - baseCost
+            if !in.Feasible { in.Reasons = p.insertionReasons(cand, vi, p.unit(idx)) }
+            out = append(out, in)
+        }
+    }
+    return out, nil
+}
+
+// placePartner puts paired node idx at position pos of base and its partner
+// on the right side of it where the route is feasible at least cost, or at
+// least cost when no position is feasible. It returns the route, the
+// partner's index in it and whether the route is feasible.
+func (p Problem) placePartner(base RoutePlan, vi, idx, pos int) (RoutePlan, int, bool) {
+    o := p.partner(idx)
+    mid := insertAt(base.Order, pos, idx)
+    first, last := pos+1, len(mid) // a pickup's delivery follows it
+    if !p.pd.pickup[idx] { first, last = 0, pos }
+    v := p.Vehicles[vi]
+    fits := feasibleAdd(p, base, v, idx) && feasibleAdd(p, base, v, o)
+    var best RoutePlan
+    bestAt, bestCost, bestOK := -1, math.Inf(1), false
+    for q := first; q <= last; q++ {
+        cand := RoutePlan{VehicleID: base.VehicleID, Order: insertAt(mid, q, o)}
+        _, ok := schedulePlan(p, cand, vi)
+        ok = ok && fits
+        cost := routeCost(p, cand, vi)
+        if bestAt >= 0 && (bestOK && !ok || bestOK == ok && cost >= bestCost) { continue }
+        best, bestAt, bestCost, bestOK = cand, q, cost, ok
+    }
+    return best, bestAt, bestOK
+}
+
+// insertionReasons lists why plan cand, holding nodes, is infeasible on
+// vehicle vi: skills and zone rules of the nodes, then the first schedule
+// check the route fails.
+func (p Problem) insertionReasons(cand RoutePlan, vi int, nodes []int) []string {
+    v := p.Vehicles[vi]
+    var out []string
+    add := func(r string) { if !slices.Contains(out, r) { out = append(out, r) } }
+    for _, idx := range nodes {
+        if !hasSkills(p, v, idx) { add(ReasonSkills) }
+        if !inZones(p, v, idx) { add(ReasonZone) }
+    }
+    if sv, ok := p.scheduleViolation(cand, vi); !ok { add(sv.Reason) }
+    return out
+}
+
+// Violations checks plan pl on vehicle vi as it stands: the skills and zone
+// rules of each stop, then the first schedule check the route fails. Unlike
+// the solver it accepts any plan, so callers can report what a manual edit
+// breaks.
+func (p Problem) Violations(vi int, pl RoutePlan) ([]Violation, error) {
+    if p.tt == nil { return nil, ErrUnprepared }
+    v := p.Vehicles[vi]
+    var out []Violation
+    for _, idx := range pl.Order {
+        if !hasSkills(p, v, idx) { out = append(out, Violation{Node: idx, Reason: ReasonSkills}) }
+        if !inZones(p, v, idx) { out = append(out, Violation{Node: idx, Reason: ReasonZone}) }
+    }
+    if sv, ok := p.scheduleViolation(pl, vi); !ok { out = append(out, sv) }
+    return out, nil
+}
+
+// scheduleViolation is the first schedule check pl fails on vehicle vi. A
+// route that only fails because of its planned breaks is hos.
+func (p Problem) scheduleViolation(pl RoutePlan, vi int) (Violation, bool) {
+    var tr scheduleTrace
+    if _, ok := schedule(p, pl, vi, &tr); ok { return Violation{}, true }
+    if p.tt.br != nil && (tr.reason == ReasonTimeWindow || tr.reason == ReasonShift) {
+        noBreaks := p
+        tt := *p.tt
+        tt.br, noBreaks.tt = nil, &tt
+        if _, ok := schedulePlan(noBreaks, pl, vi); ok { return Violation{Node: -1, Reason: ReasonHoS}, false }
+    }
+    return Violation{Node: tr.node, Reason: tr.reason}, false
+}
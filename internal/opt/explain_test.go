@@ -0,0 +1,111 @@
+package opt
+
+import (
+    "context"
+    "slices"
+    "testing"
+    "time"
+)
+
+func TestExplainReasons(t *testing.T) {
+    depot := &[2]float64{1, 1}
+    start := time.Date(2025, 3, 3, 8, 0, 0, 0, time.UTC)
+    p := Problem{
+        Nodes: []Node{
+            {ID: "a", Lat: 1.01, Lng: 1, Demand: Demand{Weight: 1}}, {ID: "b", Lat: 1.02, Lng: 1, Demand: Demand{Weight: 1}}, {ID: "c", Lat: 1.03, Lng: 1},
+            {ID: "x", Lat: 1.04, Lng: 1, Demand: Demand{Weight: 1}, Skills: []string{"frozen"}, Windows: []TW{{End: start.Add(15 * time.Minute)}}},
+        },
+        Vehicles: []Vehicle{
+            {ID: "small", StartLatLng: depot, EndLatLng: depot, CapWeight: 2},
+            {ID: "chilled", StartLatLng: depot, EndLatLng: depot, Skills: []string{"cold"}},
+            {ID: "van", StartLatLng: depot, EndLatLng: depot},
+        },
+        Matrix:  staticMatrix{d: 1000, t: 600},
+        StartAt: start,
+    }
+    if err := p.Prepare(context.Background()); err != nil { t.Fatal(err) }
+    plans := []RoutePlan{{Order: []int{0, 1}}, {}, {Order: []int{2, 3}}}
+    got, err := p.Explain(plans, 3)
+    if err != nil { t.Fatal(err) }
+    if len(got) != 3+1+2 { t.Fatalf("%d options", len(got)) }
+    reasons := func(vi, pos int) []string {
+        for _, in := range got { if in.Vehicle == vi && in.Pos == pos { return in.Reasons } }
+        t.Fatalf("no option %d/%d", vi, pos)
+        return nil
+    }
+    if r := reasons(0, 0); !slices.Equal(r, []string{ReasonCapacity}) { t.Fatalf("small van: %v", r) }
+    if r := reasons(1, 0); !slices.Equal(r, []string{ReasonSkills}) { t.Fatalf("chilled van: %v", r) }
+    // x is taken off the van first: ahead of c it is served in time, after it too late
+    if got[4].Pos != 0 || !got[4].Feasible || got[4].Delta <= 0 { t.Fatalf("van first: %+v", got[4]) }
+    if r := reasons(2, 1); !slices.Equal(r, []string{ReasonTimeWindow}) { t.Fatalf("van after c: %v", r) }
+    if plans[2].Order[1] != 3 { t.Fatal("explain changed the plans") }
+}
+
+func TestExplainHoS(t *testing.T) {
+    depot := &[2]float64{1, 1}
+    start := time.Date(2025, 3, 3, 8, 0, 0, 0, time.UTC)
+    p := Problem{
+        Nodes:      []Node{{ID: "a", Lat: 1.01, Lng: 1}, {ID: "x", Lat: 1.02, Lng: 1, Windows: []TW{{End: start.Add(25 * time.Minute)}}}},
+        Vehicles:   []Vehicle{{ID: "v", StartLatLng: depot, EndLatLng: depot}},
+        Matrix:     staticMatrix{d: 1000, t: 600},
+        StartAt:    start,
+        BreakRules: BreakRules{MaxDriveSec: 900, MinBreakSec: 1800},
+    }
+    if err := p.Prepare(context.Background()); err != nil { t.Fatal(err) }
+    got, err := p.Explain([]RoutePlan{{Order: []int{0}}}, 1)
+    if err != nil { t.Fatal(err) }
+    // after a the driver must rest before reaching x, which closes meanwhile
+    if !got[0].Feasible || got[1].Feasible || !slices.Equal(got[1].Reasons, []string{ReasonHoS}) { t.Fatalf("options: %+v", got) }
+}
+
+func TestViolations(t *testing.T) {
+    depot := &[2]float64{1, 1}
+    start := time.Date(2025, 3, 3, 8, 0, 0, 0, time.UTC)
+    p := Problem{
+        Nodes: []Node{
+            {ID: "a", Lat: 1.01, Lng: 1, Demand: Demand{Weight: 1}}, {ID: "b", Lat: 1.02, Lng: 1, Demand: Demand{Weight: 1}},
+            {ID: "x", Lat: 1.04, Lng: 1, Skills: []string{"frozen"}, Windows: []TW{{End: start.Add(15 * time.Minute)}}},
+        },
+        Vehicles: []Vehicle{{ID: "small", StartLatLng: depot, EndLatLng: depot, CapWeight: 1}, {ID: "van", StartLatLng: depot, EndLatLng: depot, Skills: []string{"dry"}}},
+        Matrix:   staticMatrix{d: 1000, t: 600},
+        StartAt:  start,
+    }
+    if err := p.Prepare(context.Background()); err != nil { t.Fatal(err) }
+    if v, err := p.Violations(0, RoutePlan{Order: []int{0, 1}}); err != nil || !slices.Equal(v, []Violation{{Node: -1, Reason: ReasonCapacity}}) { t.Fatalf("over capacity: %+v %v", v, err) }
+    // the van cannot carry x anywhere, and behind a and b x is late too
+    if v, err := p.Violations(1, RoutePlan{Order: []int{0, 1, 2}}); err != nil || !slices.Equal(v, []Violation{{Node: 2, Reason: ReasonSkills}, {Node: 2, Reason: ReasonTimeWindow}}) { t.Fatalf("late x: %+v %v", v, err) }
+    if v, err := p.Violations(1, RoutePlan{Order: []int{2, 0, 1}}); err != nil || !slices.Equal(v, []Violation{{Node: 2, Reason: ReasonSkills}}) { t.Fatalf("x first: %+v %v", v, err) }
+}
+
+func TestExplainMovesPairTogether(t *testing.T) {
+    depot := &[2]float64{1, 1}
+    start := time.Date(2025, 3, 3, 8, 0, 0, 0, time.UTC)
+    p := Problem{
+        Nodes: []Node{
+            {ID: "a", Lat: 1.01, Lng: 1}, {ID: "pu", Lat: 1.02, Lng: 1, Pickup: true, Demand: Demand{Weight: 1}},
+            {ID: "de", Lat: 1.03, Lng: 1, Demand: Demand{Weight: 1}, Windows: []TW{{End: start.Add(40 * time.Minute)}}},
+        },
+        Vehicles: []Vehicle{{ID: "van", StartLatLng: depot, EndLatLng: depot}, {ID: "spare", StartLatLng: depot, EndLatLng: depot}},
+        Pairs:    []Pair{{Pickup: 1, Delivery: 2}},
+        Matrix:   staticMatrix{d: 1000, t: 600},
+        StartAt:  start,
+    }
+    if err := p.Prepare(context.Background()); err != nil { t.Fatal(err) }
+    plans := []RoutePlan{{Order: []int{0, 1, 2}}, {}}
+    got, err := p.Explain(plans, 2)
+    if err != nil { t.Fatal(err) }
+    // the pickup leaves the van with its delivery: a alone has two positions, the spare one
+    if len(got) != 2+1 { t.Fatalf("%d options: %+v", len(got), got) }
+    for _, in := range got {
+        if !in.Feasible || in.PartnerPos < 0 || in.PartnerPos >= in.Pos { t.Fatalf("delivery should follow its pickup: %+v", in) }
+    }
+    if in := got[0]; in.Pos != 1 || in.PartnerPos != 0 || in.After != 1 { t.Fatalf("van first: %+v", in) }
+    if in := got[2]; in.Vehicle != 1 || in.Pos != 1 || in.After != 1 || in.Delta <= 0 { t.Fatalf("spare: %+v", in) }
+    // a tighter window leaves no room for a ahead of the pair
+    p.Nodes[2].Windows = []TW{{End: start.Add(25 * time.Minute)}}
+    if err := p.Prepare(context.Background()); err != nil { t.Fatal(err) }
+    got, err = p.Explain(plans, 2)
+    if err != nil { t.Fatal(err) }
+    if !got[0].Feasible || got[1].Feasible || !slices.Equal(got[1].Reasons, []string{ReasonTimeWindow}) { t.Fatalf("options: %+v", got) }
+    if !slices.Equal(plans[0].Order, []int{0, 1, 2}) { t.Fatal("explain changed the plans") }
+}
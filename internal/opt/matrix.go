@@ -0,0 +1,365 @@
+package opt
+
+import (
+    "context"
+    "encoding/csv"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "os"
+    "path/filepath"
+    "slices"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Location is a point handed to a DistanceMatrix. ID is used by file-backed
+// matrices; geometric and OSRM providers only look at Lat/Lng.
+type Location struct {
+    ID       string
+    Lat, Lng float64
+}
+
+// Table holds pairwise travel distances (meters) and durations (seconds),
+// indexed in the order of the locations passed to DistanceMatrix.Table.
+type Table struct {
+    Dist [][]float64
+    Dur  [][]float64
+}
+
+// DistanceMatrix provides travel distances and drive times between locations.
+// Solve asks for one table per problem and reuses it for every evaluation.
+type DistanceMatrix interface {
+    Table(ctx context.Context, locs []Location) (Table, error)
+}
+
+// HaversineMatrix uses great-circle distance at a constant speed.
+type HaversineMatrix struct {
+    SpeedKph float64
+}
+
+func (h HaversineMatrix) Table(_ context.Context, locs []Location) (Table, error) {
+    speed := h.SpeedKph
+    if speed <= 0 { speed = 50 }
+    n := len(locs)
+    t := newTable(n)
+    for i := 0; i < n; i++ {
+        for j := 0; j < n; j++ {
+            if i == j { continue }
+            d := haversine(locs[i].Lat, locs[i].Lng, locs[j].Lat, locs[j].Lng)
+            t.Dist[i][j] = d
+            t.Dur[i][j] = d / (speed / 3.6)
+        }
+    }
+    return t, nil
+}
+
+// FileMatrix serves a precomputed matrix keyed by location ID. Pairs missing
+// from the file fall back to haversine at SpeedKph.
+type FileMatrix struct {
+    SpeedKph float64
+    dist     map[[2]string]float64
+    dur      map[[2]string]float64
+}
+
+// LoadMatrixFile reads a matrix from a .json or .csv file.
+//
+// JSON: {"ids":["a","b"],"distances":[[0,1200],[1300,0]],"durations":[[0,180],[200,0]]}
+// CSV:  from,to,dist_m,dur_sec (one row per ordered pair; header optional)
+func LoadMatrixFile(path string) (*FileMatrix, error) {
+    f, err := os.Open(path)
+    if err != nil { return nil, err }
+    defer f.Close()
+    fm := &FileMatrix{dist: map[[2]string]float64{}, dur: map[[2]string]float64{}}
+    switch strings.ToLower(filepath.Ext(path)) {
+    case ".json":
+        err = fm.readJSON(f)
+    case ".csv":
+        err = fm.readCSV(f)
+    default:
+        err = fmt.Errorf("matrix file %s: unsupported extension (want .json or .csv)", path)
+    }
+    if err != nil { return nil, err }
+    return fm, nil
+}
+
+func (fm *FileMatrix) readJSON(r io.Reader) error {
+    var doc struct {
+        IDs       []string    `json:"ids"`
+        Distances [][]float64 `json:"distances"`
+        Durations [][]float64 `json:"durations"`
+    }
+    if err := json.NewDecoder(r).Decode(&doc); err != nil { return err }
+    n := len(doc.IDs)
+    if len(doc.Distances) != n || len(doc.Durations) != n { return errors.New("matrix json: distances/durations must be n x n for n ids") }
+    for i := 0; i < n; i++ {
+        if len(doc.Distances[i]) != n || len(doc.Durations[i]) != n { return fmt.Errorf("matrix json: row %d has wrong length", i) }
+        for j := 0; j < n; j++ {
+            k := [2]string{doc.IDs[i], doc.IDs[j]}
+            fm.dist[k] = doc.Distances[i][j]
+            fm.dur[k] = doc.Durations[i][j]
+        }
+    }
+    return nil
+}
+
+func (fm *FileMatrix) readCSV(r io.Reader) error {
+    cr := csv.NewReader(r)
+    cr.FieldsPerRecord = 4
+    cr.TrimLeadingSpace = true
+    line := 0
+    for {
+        rec, err := cr.Read()
+        if err == io.EOF { return nil }
+        if err != nil { return err }
+        line++
+        d, errD := strconv.ParseFloat(rec[2], 64)
+        t, errT := strconv.ParseFloat(rec[3], 64)
+        if errD != nil || errT != nil {
+            if line == 1 { continue } // header
+            return fmt.Errorf("matrix csv line %d: invalid number", line)
+        }
+        k := [2]string{rec[0], rec[1]}
+        fm.dist[k] = d
+        fm.dur[k] = t
+    }
+}
+
+func (fm *FileMatrix) Table(ctx context.Context, locs []Location) (Table, error) {
+    t, _ := HaversineMatrix{SpeedKph: fm.SpeedKph}.Table(ctx, locs)
+    for i := range locs {
+        for j := range locs {
+            if i == j { continue }
+            k := [2]string{locs[i].ID, locs[j].ID}
+            if d, ok := fm.dist[k]; ok { t.Dist[i][j] = d; t.Dur[i][j] = fm.dur[k] }
+        }
+    }
+    return t, nil
+}
+
+// OSRMMatrix queries an OSRM-compatible /table service. Tables over
+// MaxTable locations are fetched in source/destination blocks. Entries the
+// service cannot route (null) fall back to haversine at SpeedKph.
+type OSRMMatrix struct {
+    BaseURL  string // e.g. http://localhost:5000
+    Profile  string // defaults to "driving"
+    SpeedKph float64
+    MaxTable int // most coordinates per request; defaults to 100, OSRM's default max-table-size
+    HTTP     *http.Client
+}
+
+func (o OSRMMatrix) Table(ctx context.Context, locs []Location) (Table, error) {
+    t, _ := HaversineMatrix{SpeedKph: o.SpeedKph}.Table(ctx, locs)
+    n := len(locs)
+    if n < 2 { return t, nil }
+    max := o.MaxTable
+    if max <= 0 { max = 100 }
+    // a source and a destination block share one request
+    blk := n
+    if n > max { blk = max / 2 }
+    if blk < 1 { blk = 1 }
+    for r := 0; r < n; r += blk {
+        for c := 0; c < n; c += blk {
+            if err := o.block(ctx, locs, indexRange(r, min(r+blk, n)), indexRange(c, min(c+blk, n)), t); err != nil { return Table{}, err }
+        }
+    }
+    return t, nil
+}
+
+// block fetches the entries from locs[rows] to locs[cols] into t. Distinct
+// blocks go out as one coordinate list with sources/destinations indexes.
+func (o OSRMMatrix) block(ctx context.Context, locs []Location, rows, cols []int, t Table) error {
+    profile := o.Profile
+    if profile == "" { profile = "driving" }
+    same := rows[0] == cols[0]
+    idx := rows
+    if !same { idx = append(slices.Clone(rows), cols...) }
+    coords := make([]string, len(idx))
+    for k, i := range idx { coords[k] = strconv.FormatFloat(locs[i].Lng, 'f', 6, 64) + "," + strconv.FormatFloat(locs[i].Lat, 'f', 6, 64) }
+    url := strings.TrimRight(o.BaseURL, "/") + "/table/v1/" + profile + "/" + strings.Join(coords, ";") + "?annotations=distance,duration"
+    if !same { url += "&sources=" + indexList(0, len(rows)) + "&destinations=" + indexList(len(rows), len(cols)) }
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+    if err != nil { return err }
+    client := o.HTTP
+    if client == nil { client = &http.Client{Timeout: 10 * time.Second} }
+    resp, err := client.Do(req)
+    if err != nil { return err }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+        return fmt.Errorf("osrm table: status %d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+    }
+    var body struct {
+        Code      string       `json:"code"`
+        Message   string       `json:"message"`
+        Distances [][]*float64 `json:"distances"`
+        Durations [][]*float64 `json:"durations"`
+    }
+    if err := json.NewDecoder(resp.Body).Decode(&body); err != nil { return fmt.Errorf("osrm table: %w", err) }
+    if body.Code != "Ok" { return fmt.Errorf("osrm table: code %q: %s", body.Code, body.Message) }
+    if len(body.Durations) != len(rows) { return fmt.Errorf("osrm table: got %d rows, want %d", len(body.Durations), len(rows)) }
+    for a, i := range rows {
+        for b, j := range cols {
+            if i == j { continue }
+            if b < len(body.Durations[a]) && body.Durations[a][b] != nil { t.Dur[i][j] = *body.Durations[a][b] }
+            if a < len(body.Distances) && b < len(body.Distances[a]) && body.Distances[a][b] != nil { t.Dist[i][j] = *body.Distances[a][b] }
+        }
+    }
+    return nil
+}
+
+// indexRange returns the indexes from..to-1.
+func indexRange(from, to int) []int {
+    out := make([]int, 0, to-from)
+    for i := from; i < to; i++ { out = append(out, i) }
+    return out
+}
+
+// indexList formats n indexes from first as an OSRM ";"-separated list.
+func indexList(first, n int) string {
+    parts := make([]string, n)
+    for k := range parts { parts[k] = strconv.Itoa(first + k) }
+    return strings.Join(parts, ";")
+}
+
+// MatrixFromEnv builds the configured provider:
+//   MATRIX_PROVIDER=haversine (default) | file | osrm
+//   MATRIX_FILE=/path/to/matrix.json|.csv (file)
+//   OSRM_URL=http://localhost:5000, OSRM_PROFILE=driving, OSRM_MAX_TABLE=100 (osrm)
+func MatrixFromEnv() (DistanceMatrix, error) {
+    switch strings.ToLower(strings.TrimSpace(os.Getenv("MATRIX_PROVIDER"))) {
+    case "", "haversine":
+        return nil, nil
+    case "file":
+        path := os.Getenv("MATRIX_FILE")
+        if path == "" { return nil, errors.New("MATRIX_PROVIDER=file requires MATRIX_FILE") }
+        fm, err := LoadMatrixFile(path)
+        if err != nil { return nil, err }
+        return fm, nil
+    case "osrm":
+        url := os.Getenv("OSRM_URL")
+        if url == "" { return nil, errors.New("MATRIX_PROVIDER=osrm requires OSRM_URL") }
+        o := OSRMMatrix{BaseURL: url, Profile: os.Getenv("OSRM_PROFILE")}
+        if v := os.Getenv("OSRM_MAX_TABLE"); v != "" {
+            n, err := strconv.Atoi(v)
+            if err != nil || n < 2 { return nil, fmt.Errorf("invalid OSRM_MAX_TABLE %q", v) }
+            o.MaxTable = n
+        }
+        return o, nil
+    default:
+        return nil, fmt.Errorf("unknown MATRIX_PROVIDER %q", os.Getenv("MATRIX_PROVIDER"))
+    }
+}
+
+func newTable(n int) Table {
+    t := Table{Dist: make([][]float64, n), Dur: make([][]float64, n)}
+    for i := 0; i < n; i++ { t.Dist[i] = make([]float64, n); t.Dur[i] = make([]float64, n) }
+    return t
+}
+
+// travelTable is the per-solve precomputed matrix. Nodes occupy locations
+// 0..len(Nodes)-1; vehicle depots follow. start/end hold the location index of
+// each vehicle's depots, or -1 when the vehicle has none.
+type travelTable struct {
+    Table
+    start, end []int
+    prof       *compiledProfile
+    br         *compiledBreaks // nil without active break rules
+    windowed   bool // some node has a soft window or several windows
+}
+
+// Prepare precomputes the travel table for the problem's nodes and vehicle
+// depots using p.Matrix (haversine at SpeedKph when unset) and indexes
+// p.Pairs. Solve calls it when the caller has not; the other Problem
+// methods need it to have run.
+func (p *Problem) Prepare(ctx context.Context) error {
+    if p.SpeedKph <= 0 { p.SpeedKph = 50 }
+    if err := p.indexPairs(); err != nil { return err }
+    if err := p.indexZones(); err != nil { return err }
+    locs := make([]Location, 0, len(p.Nodes)+2*len(p.Vehicles))
+    for _, nd := range p.Nodes { locs = append(locs, Location{ID: nd.ID, Lat: nd.Lat, Lng: nd.Lng}) }
+    tt := &travelTable{start: make([]int, len(p.Vehicles)), end: make([]int, len(p.Vehicles))}
+    for vi, v := range p.Vehicles {
+        tt.start[vi], tt.end[vi] = -1, -1
+        if v.StartLatLng != nil {
+            tt.start[vi] = len(locs)
+            locs = append(locs, Location{ID: v.StartID, Lat: v.StartLatLng[0], Lng: v.StartLatLng[1]})
+        }
+        if v.EndLatLng != nil {
+            tt.end[vi] = len(locs)
+            locs = append(locs, Location{ID: v.EndID, Lat: v.EndLatLng[0], Lng: v.EndLatLng[1]})
+        }
+    }
+    m := p.Matrix
+    if m == nil { m = HaversineMatrix{SpeedKph: p.SpeedKph} }
+    t, err := m.Table(ctx, locs)
+    if err != nil { return err }
+    if len(t.Dist) != len(locs) || len(t.Dur) != len(locs) { return fmt.Errorf("distance matrix returned %d rows for %d locations", len(t.Dur), len(locs)) }
+    tt.Table = t
+    if p.SpeedProfile != nil {
+        if tt.prof, err = p.SpeedProfile.compile(p.StartAt, locs); err != nil { return err }
+    }
+    if p.BreakRules.active() {
+        if tt.br, err = p.BreakRules.compile(p.StartAt); err != nil { return err }
+    }
+    for _, nd := range p.Nodes { tt.windowed = tt.windowed || nd.SoftTW || len(nd.Windows) > 1 }
+    p.tt = tt
+    return nil
+}
+
+// ErrUnprepared is returned by the Problem methods that need the travel
+// table when Prepare has not run.
+var ErrUnprepared = errors.New("opt: problem not prepared; call Prepare first")
+
+// travel returns distance (m) and drive time (s) between two location
+// indices; a negative index means "no location" and costs nothing.
+func (p Problem) travel(a, b int) (float64, float64) {
+    if a < 0 || b < 0 || a == b { return 0, 0 }
+    return p.tt.Dist[a][b], p.tt.Dur[a][b]
+}
+
+func (p Problem) startLoc(vi int) int { return p.tt.start[vi] }
+func (p Problem) endLoc(vi int) int { return p.tt.end[vi] }
+
+// PlannedLeg is one drive leg of a route. From/To are node indices; -1 stands
+// for the vehicle's start depot (From) or end depot (To). DriveSec is the
+// free-flow time; use DriveTimeAt for the time-dependent value.
+type PlannedLeg struct {
+    From, To int
+    DistM    float64
+    DriveSec float64
+
+    fromLoc, toLoc int // travel table locations
+}
+
+// Legs returns the drive legs of plan pl on vehicle vi using the same travel
+// table as Solve, including the depot approach and return when set. p must
+// be prepared (ErrUnprepared otherwise).
+func (p Problem) Legs(vi int, pl RoutePlan) ([]PlannedLeg, error) {
+    if p.tt == nil { return nil, ErrUnprepared }
+    if len(pl.Order) == 0 {
+        // an empty route still drives between distinct start and end points
+        s, e := p.startLoc(vi), p.endLoc(vi)
+        if s < 0 || e < 0 || s == e { return nil, nil }
+        d, t := p.travel(s, e)
+        return []PlannedLeg{{From: -1, To: -1, DistM: d, DriveSec: t, fromLoc: s, toLoc: e}}, nil
+    }
+    legs := []PlannedLeg{}
+    if s := p.startLoc(vi); s >= 0 {
+        d, t := p.travel(s, pl.Order[0])
+        legs = append(legs, PlannedLeg{From: -1, To: pl.Order[0], DistM: d, DriveSec: t, fromLoc: s, toLoc: pl.Order[0]})
+    }
+    for i := 0; i < len(pl.Order)-1; i++ {
+        d, t := p.travel(pl.Order[i], pl.Order[i+1])
+        legs = append(legs, PlannedLeg{From: pl.Order[i], To: pl.Order[i+1], DistM: d, DriveSec: t, fromLoc: pl.Order[i], toLoc: pl.Order[i+1]})
+    }
+    if e := p.endLoc(vi); e >= 0 {
+        last := pl.Order[len(pl.Order)-1]
+        d, t := p.travel(last, e)
+        legs = append(legs, PlannedLeg{From: last, To: -1, DistM: d, DriveSec: t, fromLoc: last, toLoc: e})
+    }
+    return legs, nil
+}
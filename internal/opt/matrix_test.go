@@ -0,0 +1,173 @@
+package opt
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "math"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestLoadMatrixFileJSONAndCSV(t *testing.T) {
+    dir := t.TempDir()
+    js := filepath.Join(dir, "m.json")
+    if err := os.WriteFile(js, []byte(`{"ids":["a","b"],"distances":[[0,1200],[1300,0]],"durations":[[0,180],[200,0]]}`), 0o600); err != nil { t.Fatal(err) }
+    cs := filepath.Join(dir, "m.csv")
+    if err := os.WriteFile(cs, []byte("from,to,dist_m,dur_sec\na,b,1200,180\nb,a,1300,200\n"), 0o600); err != nil { t.Fatal(err) }
+    locs := []Location{{ID: "a", Lat: 1, Lng: 1}, {ID: "b", Lat: 1.01, Lng: 1.01}, {ID: "x", Lat: 1.02, Lng: 1.02}}
+    for _, path := range []string{js, cs} {
+        fm, err := LoadMatrixFile(path)
+        if err != nil { t.Fatalf("%s: %v", path, err) }
+        tb, err := fm.Table(context.Background(), locs)
+        if err != nil { t.Fatalf("%s: %v", path, err) }
+        if tb.Dist[0][1] != 1200 || tb.Dur[1][0] != 200 { t.Fatalf("%s: got dist %v dur %v", path, tb.Dist[0][1], tb.Dur[1][0]) }
+        if tb.Dist[0][2] <= 0 { t.Fatalf("%s: missing pair should fall back to haversine", path) }
+    }
+}
+
+func TestOSRMMatrixTable(t *testing.T) {
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if !strings.HasPrefix(r.URL.Path, "/table/v1/driving/") { http.NotFound(w, r); return }
+        _, _ = w.Write([]byte(`{"code":"Ok","durations":[[0,60],[null,0]],"distances":[[0,900],[null,0]]}`))
+    }))
+    defer srv.Close()
+    locs := []Location{{Lat: 1, Lng: 1}, {Lat: 1.01, Lng: 1.01}}
+    tb, err := OSRMMatrix{BaseURL: srv.URL}.Table(context.Background(), locs)
+    if err != nil { t.Fatalf("table: %v", err) }
+    if tb.Dur[0][1] != 60 || tb.Dist[0][1] != 900 { t.Fatalf("got dur %v dist %v", tb.Dur[0][1], tb.Dist[0][1]) }
+    if tb.Dur[1][0] <= 0 { t.Fatalf("null entry should fall back to haversine") }
+}
+
+// A fake OSRM whose entries encode the coordinates they connect, so merged
+// blocks can be checked against the full table.
+func TestOSRMMatrixChunksLargeTables(t *testing.T) {
+    requests := 0
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        requests++
+        var lats []float64
+        for _, c := range strings.Split(strings.TrimPrefix(r.URL.Path, "/table/v1/driving/"), ";") {
+            lat, _ := strconv.ParseFloat(strings.Split(c, ",")[1], 64)
+            lats = append(lats, lat)
+        }
+        if len(lats) > 4 { http.Error(w, `{"code":"TooBig","message":"Too many table coordinates"}`, http.StatusBadRequest); return }
+        // url.Query drops ";"-separated values, so read the raw query
+        list := func(key string) []int {
+            var out []int
+            for _, kv := range strings.Split(r.URL.RawQuery, "&") {
+                if v, ok := strings.CutPrefix(kv, key+"="); ok {
+                    for _, p := range strings.Split(v, ";") { i, _ := strconv.Atoi(p); out = append(out, i) }
+                    return out
+                }
+            }
+            for i := range lats { out = append(out, i) }
+            return out
+        }
+        var rows [][]float64
+        for _, i := range list("sources") {
+            var row []float64
+            for _, j := range list("destinations") { row = append(row, math.Round(lats[i]*100)*100+math.Round(lats[j]*100)) }
+            rows = append(rows, row)
+        }
+        _ = json.NewEncoder(w).Encode(map[string]any{"code": "Ok", "durations": rows, "distances": rows})
+    }))
+    defer srv.Close()
+    var locs []Location
+    for i := 1; i <= 7; i++ { locs = append(locs, Location{Lat: float64(i) / 100, Lng: 1}) }
+    tb, err := OSRMMatrix{BaseURL: srv.URL, MaxTable: 4}.Table(context.Background(), locs)
+    if err != nil { t.Fatalf("table: %v", err) }
+    if requests != 16 { t.Fatalf("7 locations in blocks of 2 take 16 requests, got %d", requests) }
+    for i := range locs {
+        for j := range locs {
+            if i == j { continue }
+            if want := float64((i+1)*100 + j + 1); tb.Dur[i][j] != want || tb.Dist[i][j] != want { t.Fatalf("[%d][%d]: dur %v dist %v, want %v", i, j, tb.Dur[i][j], tb.Dist[i][j], want) }
+        }
+    }
+}
+
+func TestOSRMMatrixStatusError(t *testing.T) {
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        http.Error(w, "upstream unavailable", http.StatusBadGateway)
+    }))
+    defer srv.Close()
+    _, err := OSRMMatrix{BaseURL: srv.URL}.Table(context.Background(), []Location{{Lat: 1, Lng: 1}, {Lat: 1.01, Lng: 1.01}})
+    if err == nil || !strings.Contains(err.Error(), "status 502: upstream unavailable") { t.Fatalf("want status and body in error, got %v", err) }
+}
+
+// solve is Solve that fails t when the problem cannot be prepared.
+func solve(t testing.TB, p Problem, seed int64, timeBudget time.Duration) (Solution, Metrics) {
+    t.Helper()
+    sol, m, err := Solve(p, seed, timeBudget)
+    if err != nil { t.Fatal(err) }
+    return sol, m
+}
+
+func TestSolveUsesMatrix(t *testing.T) {
+    p := Problem{
+        Nodes:    []Node{{ID: "a", Lat: 1, Lng: 1}, {ID: "b", Lat: 1.01, Lng: 1.01}},
+        Vehicles: []Vehicle{{ID: "v1"}},
+        Matrix:   staticMatrix{d: 5000, t: 600},
+        IterationsLimit: 5,
+    }
+    if err := p.Prepare(context.Background()); err != nil { t.Fatal(err) }
+    sol, _ := solve(t, p, 1, 0)
+    legs, err := p.Legs(0, sol.Plans[0])
+    if err != nil { t.Fatal(err) }
+    if len(legs) != 1 || legs[0].DistM != 5000 || legs[0].DriveSec != 600 { t.Fatalf("legs: %+v", legs) }
+}
+
+type staticMatrix struct{ d, t float64 }
+
+func (s staticMatrix) Table(_ context.Context, locs []Location) (Table, error) {
+    tb := newTable(len(locs))
+    for i := range locs { for j := range locs { if i != j { tb.Dist[i][j] = s.d; tb.Dur[i][j] = s.t } } }
+    return tb, nil
+}
+
+type failingMatrix struct{}
+
+func (failingMatrix) Table(context.Context, []Location) (Table, error) { return Table{}, errors.New("osrm down") }
+
+func TestMatrixErrorIsReturned(t *testing.T) {
+    p := Problem{
+        Nodes:    []Node{{ID: "a", Lat: 1, Lng: 1}},
+        Vehicles: []Vehicle{{ID: "v1"}},
+        Matrix:   failingMatrix{},
+    }
+    if _, _, err := Solve(p, 1, 0); err == nil || err.Error() != "osrm down" { t.Fatalf("want the matrix error, got %v", err) }
+    if _, err := p.Legs(0, RoutePlan{Order: []int{0}}); !errors.Is(err, ErrUnprepared) { t.Fatalf("legs on an unprepared problem: %v", err) }
+}
+
+func TestReturnLegCountsInCost(t *testing.T) {
+    depot := &[2]float64{1, 1}
+    nodes := []Node{{ID: "a", Lat: 1.05, Lng: 1.05}}
+    open := Problem{Nodes: nodes, Vehicles: []Vehicle{{ID: "v", StartLatLng: depot}}}
+    closed := Problem{Nodes: nodes, Vehicles: []Vehicle{{ID: "v", StartLatLng: depot, EndLatLng: depot}}}
+    if err := open.Prepare(context.Background()); err != nil { t.Fatal(err) }
+    if err := closed.Prepare(context.Background()); err != nil { t.Fatal(err) }
+    sol := Solution{Plans: []RoutePlan{{VehicleID: "v", Order: []int{0}}}}
+    co, cc := cost(open, sol), cost(closed, sol)
+    if math.Abs(cc-2*co) > 1e-6 { t.Fatalf("closed route should cost twice the open one: open %v closed %v", co, cc) }
+    so, _ := schedulePlan(open, sol.Plans[0], 0)
+    sc, _ := schedulePlan(closed, sol.Plans[0], 0)
+    if sc.dist <= so.dist || sc.drive <= so.drive { t.Fatalf("return leg missing from schedule: %+v vs %+v", sc, so) }
+    if legs, err := closed.Legs(0, sol.Plans[0]); err != nil || len(legs) != 2 || legs[1].To != -1 { t.Fatalf("legs: %+v %v", legs, err) }
+}
+
+func TestWarmStartKeepsInitialPlans(t *testing.T) {
+    p := Problem{
+        Nodes:           []Node{{ID: "a", Lat: 1, Lng: 1}, {ID: "b", Lat: 1.01, Lng: 1.01}, {ID: "c", Lat: 2, Lng: 2}},
+        Vehicles:        []Vehicle{{ID: "v1"}, {ID: "v2"}},
+        InitialPlans:    []RoutePlan{{Order: []int{0, 1}}, {Order: []int{}}},
+    }
+    if err := p.Prepare(context.Background()); err != nil { t.Fatal(err) }
+    sol := warmStart(p)
+    if len(sol.Plans[0].Order) < 2 || sol.Plans[0].Order[0] != 0 || sol.Plans[0].Order[1] != 1 { t.Fatalf("initial plan not kept: %+v", sol.Plans) }
+    if n := len(sol.Plans[0].Order) + len(sol.Plans[1].Order); n != 3 { t.Fatalf("missing node not inserted: %+v", sol.Plans) }
+}
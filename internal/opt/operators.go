@@ -0,0 +1,259 @@
+package opt
+
+import (
+    "math"
+    "math/rand"
+    "sort"
+)
+
+// Operator registry. Each ALNS iteration draws one removal and one insertion
+// operator by roulette wheel over adaptive weights. Problem.InitialRemovalWeights
+// and InitialInsertionWeights pick the operators taking part, and their
+// starting weights, by name.
+
+// RemovalOperator picks about k assigned nodes of sol to take out; the caller
+// removes them (with their pickup/delivery partners).
+type RemovalOperator func(p Problem, sol Solution, k int, rng *rand.Rand) []int
+
+// InsertionOperator puts removed back into sol and returns the repaired solution.
+type InsertionOperator func(p Problem, sol Solution, removed []int, rng *rand.Rand) Solution
+
+type namedRemoval struct {
+    name string
+    op   RemovalOperator
+}
+
+type namedInsertion struct {
+    name string
+    op   InsertionOperator
+}
+
+var (
+    removalOps   []namedRemoval
+    insertionOps []namedInsertion
+)
+
+func init() {
+    RegisterRemoval("random", func(_ Problem, sol Solution, k int, rng *rand.Rand) []int { return pickRandomNodes(sol, k, rng) })
+    RegisterRemoval("shaw", shawRemoval)
+    RegisterRemoval("worst", worstRemoval)
+    RegisterRemoval("route", routeRemoval)
+    RegisterRemoval("cluster", clusterRemoval)
+    RegisterRemoval("timeWindow", timeWindowRemoval)
+    RegisterInsertion("greedy", func(p Problem, sol Solution, removed []int, _ *rand.Rand) Solution { return greedyInsert(p, sol, removed) })
+    RegisterInsertion("regret2", func(p Problem, sol Solution, removed []int, _ *rand.Rand) Solution { return regretInsert(p, sol, removed) })
+    RegisterInsertion("regret3", regretKInsert(3))
+    RegisterInsertion("regret4", regretKInsert(4))
+    RegisterInsertion("noiseGreedy", noiseGreedyInsert)
+}
+
+// RegisterRemoval adds a removal operator, or replaces the one with the same
+// name. Call it from init: the registry is read without locking while solving.
+func RegisterRemoval(name string, op RemovalOperator) {
+    for i := range removalOps {
+        if removalOps[i].name == name { removalOps[i].op = op; return }
+    }
+    removalOps = append(removalOps, namedRemoval{name: name, op: op})
+}
+
+// RegisterInsertion is RegisterRemoval for insertion operators.
+func RegisterInsertion(name string, op InsertionOperator) {
+    for i := range insertionOps {
+        if insertionOps[i].name == name { insertionOps[i].op = op; return }
+    }
+    insertionOps = append(insertionOps, namedInsertion{name: name, op: op})
+}
+
+// RemovalOperators lists the registered removal operators in registration order.
+func RemovalOperators() []string {
+    out := make([]string, len(removalOps))
+    for i, o := range removalOps { out[i] = o.name }
+    return out
+}
+
+// InsertionOperators lists the registered insertion operators in registration order.
+func InsertionOperators() []string {
+    out := make([]string, len(insertionOps))
+    for i, o := range insertionOps { out[i] = o.name }
+    return out
+}
+
+func removalOp(name string) RemovalOperator {
+    for _, o := range removalOps { if o.name == name { return o.op } }
+    return nil
+}
+
+func insertionOp(name string) InsertionOperator {
+    for _, o := range insertionOps { if o.name == name { return o.op } }
+    return nil
+}
+
+// opSet is the operators one search draws from, in registry order, with their
+// adaptive weights.
+type opSet struct {
+    names []string
+    w     []float64
+}
+
+// newOpSet takes every registered operator at weight 1 when init is empty,
+// otherwise the operators init gives a positive weight; unknown names are
+// ignored.
+func newOpSet(registered []string, init map[string]float64) opSet {
+    var s opSet
+    for _, name := range registered {
+        w := 1.0
+        if len(init) > 0 {
+            if w = init[name]; w <= 0 { continue }
+        }
+        s.names, s.w = append(s.names, name), append(s.w, w)
+    }
+    if len(s.names) == 0 && len(init) > 0 { return newOpSet(registered, nil) }
+    return s
+}
+
+func (s opSet) weights() map[string]float64 {
+    out := make(map[string]float64, len(s.names))
+    for i, name := range s.names { out[name] = s.w[i] }
+    return out
+}
+
+// worstRandomness skews worstRemoval towards the costliest nodes: index
+// floor(u^worstRandomness * n) of the nodes sorted by saving.
+const worstRandomness = 3
+
+// worstRemoval removes nodes whose removal saves the most route cost,
+// randomised so the same nodes are not picked every time.
+func worstRemoval(p Problem, sol Solution, k int, rng *rand.Rand) []int {
+    type saving struct{ idx int; gain float64 }
+    cand := []saving{}
+    static := p.staticSchedule()
+    for vi, pl := range sol.Plans {
+        base := routeCost(p, pl, vi)
+        var rs *routeState // one O(n) build, then O(1) per stop
+        if static && len(pl.Order) > 1 { rs = newRouteState(p, pl, vi) }
+        for i, idx := range pl.Order {
+            if rs != nil {
+                if s, ok := rs.route(rs.fwd[i], rs.bwd[i+1]); ok { cand = append(cand, saving{idx: idx, gain: base - p.segCost(vi, s)}); continue }
+            }
+            without := RoutePlan{VehicleID: pl.VehicleID, Order: append(append([]int(nil), pl.Order[:i]...), pl.Order[i+1:]...)}
+            cand = append(cand, saving{idx: idx, gain: base - routeCost(p, without, vi)})
+        }
+    }
+    sort.SliceStable(cand, func(a, b int) bool { return cand[a].gain > cand[b].gain })
+    removed := []int{}
+    for len(removed) < k && len(cand) > 0 {
+        j := int(math.Pow(rng.Float64(), worstRandomness) * float64(len(cand)))
+        removed = append(removed, cand[j].idx)
+        cand = append(cand[:j], cand[j+1:]...)
+    }
+    return removed
+}
+
+// routeRemoval empties one random route. Repair then has to fold its stops
+// into the other routes, which is how the search gets rid of a vehicle.
+func routeRemoval(_ Problem, sol Solution, _ int, rng *rand.Rand) []int {
+    used := []int{}
+    for vi, pl := range sol.Plans { if len(pl.Order) > 0 { used = append(used, vi) } }
+    if len(used) == 0 { return nil }
+    return append([]int(nil), sol.Plans[used[rng.Intn(len(used))]].Order...)
+}
+
+// clusterRemoval removes a random stop and the stops of the same route
+// nearest to it, opening a gap another route may fill.
+func clusterRemoval(p Problem, sol Solution, k int, rng *rand.Rand) []int {
+    used := []int{}
+    for vi, pl := range sol.Plans { if len(pl.Order) > 0 { used = append(used, vi) } }
+    if len(used) == 0 { return nil }
+    order := append([]int(nil), sol.Plans[used[rng.Intn(len(used))]].Order...)
+    seed := order[rng.Intn(len(order))]
+    dist := func(idx int) float64 { d, _ := p.travel(seed, idx); return d }
+    sort.SliceStable(order, func(a, b int) bool { return dist(order[a]) < dist(order[b]) })
+    if len(order) > k { order = order[:k] }
+    return order
+}
+
+// timeWindowRemoval removes a random windowed stop and the stops whose windows
+// are closest to it, wherever they are routed; a stop with several windows
+// counts by their span. Falls back to random removal when no assigned stop
+// has a window.
+func timeWindowRemoval(p Problem, sol Solution, k int, rng *rand.Rand) []int {
+    windowed := []int{}
+    for _, pl := range sol.Plans {
+        for _, idx := range pl.Order {
+            if tw, ok := p.Nodes[idx].span(); ok && !tw.Start.IsZero() && !tw.End.IsZero() { windowed = append(windowed, idx) }
+        }
+    }
+    if len(windowed) == 0 { return pickRandomNodes(sol, k, rng) }
+    seed, _ := p.Nodes[windowed[rng.Intn(len(windowed))]].span()
+    gap := func(idx int) float64 {
+        tw, _ := p.Nodes[idx].span()
+        return math.Abs(tw.Start.Sub(seed.Start).Seconds()) + math.Abs(tw.End.Sub(seed.End).Seconds())
+    }
+    sort.SliceStable(windowed, func(a, b int) bool { return gap(windowed[a]) < gap(windowed[b]) })
+    if len(windowed) > k { windowed = windowed[:k] }
+    return windowed
+}
+
+// regretKInsert returns regret-k insertion: each step places the unit whose
+// best route beats its next k-1 best routes by the most. Units with fewer than
+// k feasible routes go first, the fewest options earliest.
+func regretKInsert(k int) InsertionOperator {
+    return func(p Problem, sol Solution, removed []int, _ *rand.Rand) Solution {
+        if len(removed) == 0 { return sol }
+        units := p.units(removed)
+        for len(units) > 0 {
+            bestUnit, bestMissing, bestRegret := -1, 0, 0.0
+            var bestIns insertion
+            for ui, u := range units {
+                byRoute := routeInsertions(p, sol, u)
+                if len(byRoute) == 0 { continue }
+                missing, regret := 0, 0.0
+                for j := 1; j < k; j++ {
+                    if j < len(byRoute) { regret += byRoute[j].cost - byRoute[0].cost } else { missing++ }
+                }
+                better := bestUnit == -1 || missing > bestMissing ||
+                    (missing == bestMissing && (regret > bestRegret || (regret == bestRegret && byRoute[0].cost < bestIns.cost)))
+                if better { bestUnit, bestMissing, bestRegret, bestIns = ui, missing, regret, byRoute[0] }
+            }
+            if bestUnit == -1 {
+                p.bank(&sol, units[0])
+                units = units[1:]
+                continue
+            }
+            applyInsertion(&sol, units[bestUnit], bestIns)
+            units = append(units[:bestUnit], units[bestUnit+1:]...)
+        }
+        sol.Cost = cost(p, sol)
+        return orOptLocalImprove(p, sol)
+    }
+}
+
+// insertionNoise scales each candidate cost in noiseGreedyInsert by a factor
+// drawn from [1-insertionNoise, 1+insertionNoise].
+const insertionNoise = 0.2
+
+// noiseGreedyInsert is cheapest insertion over randomly perturbed costs, so
+// repeated repairs of the same gap explore different placements.
+func noiseGreedyInsert(p Problem, sol Solution, removed []int, rng *rand.Rand) Solution {
+    if len(removed) == 0 { return sol }
+    units := p.units(removed)
+    for len(units) > 0 {
+        bestUnit := -1
+        var bestIns insertion
+        bestCost := math.MaxFloat64
+        for ui, u := range units {
+            eachInsertion(p, sol, u, func(c insertion) {
+                if noisy := c.cost * (1 + insertionNoise*(2*rng.Float64()-1)); noisy < bestCost { bestUnit, bestIns, bestCost = ui, c, noisy }
+            })
+        }
+        if bestUnit == -1 {
+            p.bank(&sol, units[0])
+            units = units[1:]
+            continue
+        }
+        applyInsertion(&sol, units[bestUnit], bestIns)
+        units = append(units[:bestUnit], units[bestUnit+1:]...)
+    }
+    sol.Cost = cost(p, sol)
+    return sol
+}
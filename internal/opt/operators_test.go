@@ -0,0 +1,48 @@
+package opt
+
+import (
+    "math/rand"
+    "sort"
+    "testing"
+    "time"
+)
+
+func TestOperatorsRepairEveryNode(t *testing.T) {
+    p := solomonProblem(30, "R", true, 4)
+    base := greedySeed(p)
+    for _, rn := range RemovalOperators() {
+        removed := removalOp(rn)(p, base, 4, rand.New(rand.NewSource(1)))
+        if len(removed) == 0 { t.Fatalf("%s removed nothing", rn) }
+        seen := map[int]bool{}
+        for _, idx := range removed {
+            if seen[idx] { t.Fatalf("%s removed %d twice", rn, idx) }
+            seen[idx] = true
+        }
+        if rn != "route" && len(removed) > 4 { t.Fatalf("%s removed %d nodes, want <= 4", rn, len(removed)) }
+        for _, in := range InsertionOperators() {
+            sol := insertionOp(in)(p, removeNodes(base, removed), removed, rand.New(rand.NewSource(2)))
+            // every node is routed or banked exactly once
+            seen := make([]int, len(p.Nodes))
+            for _, pl := range sol.Plans { for _, idx := range pl.Order { seen[idx]++ } }
+            for _, u := range sol.Unassigned { seen[u.Node]++ }
+            for idx, c := range seen { if c != 1 { t.Fatalf("%s+%s: node %d placed %d times", rn, in, idx, c) } }
+        }
+    }
+}
+
+func TestSolveUsesNamedOperators(t *testing.T) {
+    p := seedProblem()
+    p.InitialRemovalWeights = map[string]float64{"worst": 2, "cluster": 1, "shaw": 0}
+    p.InitialInsertionWeights = map[string]float64{"regret3": 1}
+    _, m := solve(t, p, 5, time.Minute)
+    names := func(c map[string]int) []string {
+        out := []string{}
+        for k := range c { out = append(out, k) }
+        sort.Strings(out)
+        return out
+    }
+    if got := names(m.RemovalSelects); len(got) != 2 || got[0] != "cluster" || got[1] != "worst" { t.Fatalf("removal selects: %v", m.RemovalSelects) }
+    if got := names(m.InsertSelects); len(got) != 1 || got[0] != "regret3" { t.Fatalf("insert selects: %v", m.InsertSelects) }
+    if _, ok := m.FinalRemovalWeights["shaw"]; ok { t.Fatal("zero-weight operator should not take part") }
+    for k, n := range m.RemovalSuccesses { if n > m.RemovalSelects[k] { t.Fatalf("%s: %d successes > %d selects", k, n, m.RemovalSelects[k]) } }
+}
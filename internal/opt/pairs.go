@@ -0,0 +1,185 @@
+package opt
+
+import (
+    "fmt"
+    "math"
+    "sort"
+)
+
+// Pair links a pickup node to its delivery node (PDPTW). Both must be served
+// by the same vehicle with the pickup first; MaxRideSec optionally caps the
+// time between leaving the pickup and starting service at the delivery.
+type Pair struct {
+    Pickup     int // index into Nodes
+    Delivery   int // index into Nodes
+    MaxRideSec int
+}
+
+// pairIndex is the per-solve lookup built from Problem.Pairs.
+type pairIndex struct {
+    partner []int  // node -> partner node, -1 when unpaired
+    pickup  []bool // node is the pickup side of its pair
+    maxRide []int  // node -> pair max ride seconds (0 = none)
+}
+
+func (p *Problem) indexPairs() error {
+    p.pd = nil
+    if len(p.Pairs) == 0 { return nil }
+    n := len(p.Nodes)
+    pd := &pairIndex{partner: make([]int, n), pickup: make([]bool, n), maxRide: make([]int, n)}
+    for i := range pd.partner { pd.partner[i] = -1 }
+    for _, pr := range p.Pairs {
+        if pr.Pickup < 0 || pr.Pickup >= n || pr.Delivery < 0 || pr.Delivery >= n || pr.Pickup == pr.Delivery {
+            return fmt.Errorf("invalid pickup/delivery pair %d/%d", pr.Pickup, pr.Delivery)
+        }
+        if pd.partner[pr.Pickup] >= 0 || pd.partner[pr.Delivery] >= 0 {
+            return fmt.Errorf("node in more than one pickup/delivery pair")
+        }
+        pd.partner[pr.Pickup], pd.partner[pr.Delivery] = pr.Delivery, pr.Pickup
+        pd.pickup[pr.Pickup] = true
+        pd.maxRide[pr.Pickup], pd.maxRide[pr.Delivery] = pr.MaxRideSec, pr.MaxRideSec
+    }
+    p.pd = pd
+    return nil
+}
+
+// partner returns the paired node of idx, or -1.
+func (p Problem) partner(idx int) int {
+    if p.pd == nil { return -1 }
+    return p.pd.partner[idx]
+}
+
+// unit returns the nodes that must move together with idx, pickup first.
+func (p Problem) unit(idx int) []int {
+    o := p.partner(idx)
+    if o < 0 { return []int{idx} }
+    if p.pd.pickup[idx] { return []int{idx, o} }
+    return []int{o, idx}
+}
+
+// withPartners extends a removal set so pairs always leave together.
+func (p Problem) withPartners(removed []int) []int {
+    if p.pd == nil { return removed }
+    in := map[int]bool{}
+    for _, i := range removed { in[i] = true }
+    out := append([]int(nil), removed...)
+    for _, i := range removed {
+        if o := p.partner(i); o >= 0 && !in[o] { in[o] = true; out = append(out, o) }
+    }
+    return out
+}
+
+// units groups removed nodes into insertion units (singles or pickup/delivery pairs).
+func (p Problem) units(removed []int) [][]int {
+    seen := map[int]bool{}
+    out := [][]int{}
+    for _, i := range removed {
+        if seen[i] { continue }
+        u := p.unit(i)
+        for _, j := range u { seen[j] = true }
+        out = append(out, u)
+    }
+    // costliest to leave out first, so must-serve and high-priority units
+    // get the best slots from sequential repairs
+    sort.SliceStable(out, func(a, b int) bool { return p.failCost(out[a][0]) > p.failCost(out[b][0]) })
+    return out
+}
+
+// insertion is a candidate placement of a unit: pos[k] is the index of
+// unit[k] in the resulting order.
+type insertion struct {
+    vi   int
+    pos  []int
+    cost float64
+}
+
+func insertAt(order []int, pos, idx int) []int {
+    out := make([]int, 0, len(order)+1)
+    out = append(out, order[:pos]...)
+    out = append(out, idx)
+    return append(out, order[pos:]...)
+}
+
+// bestInsertions returns the cheapest and second-cheapest feasible placements
+// of unit across all plans (cost math.MaxFloat64 when none).
+func bestInsertions(p Problem, sol Solution, unit []int) (insertion, insertion) {
+    best := insertion{vi: -1, cost: math.MaxFloat64}
+    second := insertion{vi: -1, cost: math.MaxFloat64}
+    eachInsertion(p, sol, unit, func(c insertion) {
+        if c.cost < best.cost { second = best; best = c } else if c.cost < second.cost { second = c }
+    })
+    return best, second
+}
+
+// routeInsertions returns the cheapest feasible placement of unit in each
+// plan that has one, cheapest first.
+func routeInsertions(p Problem, sol Solution, unit []int) []insertion {
+    best := make([]insertion, len(sol.Plans))
+    for vi := range best { best[vi] = insertion{vi: -1, cost: math.MaxFloat64} }
+    eachInsertion(p, sol, unit, func(c insertion) { if c.cost < best[c.vi].cost { best[c.vi] = c } })
+    out := []insertion{}
+    for _, c := range best { if c.vi >= 0 { out = append(out, c) } }
+    sort.SliceStable(out, func(i, j int) bool { return out[i].cost < out[j].cost })
+    return out
+}
+
+// eachInsertion calls consider with every feasible placement of unit, plan by
+// plan. Opening an empty plan also costs the vehicle's usage.
+func eachInsertion(p Problem, sol Solution, unit []int, consider func(insertion)) {
+    static := p.staticSchedule()
+    for vi, pl := range sol.Plans {
+        open := 0.0
+        if len(pl.Order) == 0 { open = p.usageCost(vi) }
+        if len(unit) == 1 {
+            idx := unit[0]
+            var rs *routeState // one O(n) build, then O(1) per position
+            if static {
+                if !feasibleAdd(p, pl, p.Vehicles[vi], idx) { continue }
+                rs = newRouteState(p, pl, vi)
+            }
+            for pos := 0; pos <= len(pl.Order); pos++ {
+                if rs != nil {
+                    if _, ok := rs.insert(idx, pos); !ok { continue }
+                } else if !feasibleAddAt(p, pl, vi, idx, pos) { continue }
+                consider(insertion{vi: vi, pos: []int{pos}, cost: open + deltaCostInsert(p, pl, vi, idx, pos)})
+            }
+            continue
+        }
+        pu, de := unit[0], unit[1]
+        v := p.Vehicles[vi]
+        if !feasibleAdd(p, pl, v, pu) || !feasibleAdd(p, pl, v, de) { continue }
+        for i := 0; i <= len(pl.Order); i++ {
+            mid := RoutePlan{VehicleID: pl.VehicleID, Order: insertAt(pl.Order, i, pu)}
+            c1 := deltaCostInsert(p, pl, vi, pu, i)
+            for j := i + 1; j <= len(mid.Order); j++ {
+                cand := RoutePlan{VehicleID: pl.VehicleID, Order: insertAt(mid.Order, j, de)}
+                if _, ok := schedulePlan(p, cand, vi); !ok { continue }
+                consider(insertion{vi: vi, pos: []int{i, j}, cost: open + c1 + deltaCostInsert(p, mid, vi, de, j)})
+            }
+        }
+    }
+}
+
+// applyInsertion places unit into sol according to ins.
+func applyInsertion(sol *Solution, unit []int, ins insertion) {
+    pl := &sol.Plans[ins.vi]
+    for k, idx := range unit { pl.Order = insertAt(pl.Order, ins.pos[k], idx) }
+}
+
+// pairsFeasible checks pickup-before-delivery on the same route and max ride
+// time for one plan given each node's arrival and departure times.
+func pairsFeasible(p Problem, order []int, arr, dep []float64) bool {
+    if p.pd == nil { return true }
+    at := map[int]int{}
+    for k, idx := range order { if p.partner(idx) >= 0 { at[idx] = k } }
+    for idx, k := range at {
+        if !p.pd.pickup[idx] { continue }
+        dk, ok := at[p.partner(idx)]
+        if !ok || dk < k { return false }
+        if mr := p.pd.maxRide[idx]; mr > 0 && arr[dk]-dep[k] > float64(mr) { return false }
+    }
+    for idx := range at {
+        if _, ok := at[p.partner(idx)]; !ok { return false }
+    }
+    return true
+}
@@ -0,0 +1,47 @@
+package opt
+
+import (
+    "context"
+    "testing"
+)
+
+func TestSolveKeepsPickupBeforeDelivery(t *testing.T) {
+    // deliveries sit next to the depot side so a naive order would visit them first
+    p := Problem{
+        Nodes: []Node{
+            {ID: "p1", Lat: 1.05, Lng: 1.05, Demand: Demand{Weight: 10}},
+            {ID: "d1", Lat: 1.00, Lng: 1.00, Demand: Demand{Weight: 10}},
+            {ID: "p2", Lat: 1.06, Lng: 1.06, Demand: Demand{Weight: 10}},
+            {ID: "d2", Lat: 1.01, Lng: 1.01, Demand: Demand{Weight: 10}},
+            {ID: "x", Lat: 1.02, Lng: 1.02},
+        },
+        Vehicles:        []Vehicle{{ID: "v1", CapWeight: 15, StartLatLng: &[2]float64{0.99, 0.99}}, {ID: "v2", CapWeight: 15, StartLatLng: &[2]float64{0.99, 0.99}}},
+        Pairs:           []Pair{{Pickup: 0, Delivery: 1}, {Pickup: 2, Delivery: 3}},
+        IterationsLimit: 200,
+    }
+    if err := p.Prepare(context.Background()); err != nil { t.Fatal(err) }
+    sol, _ := solve(t, p, 7, 0)
+    where := map[int][2]int{}
+    for vi, pl := range sol.Plans { for k, idx := range pl.Order { where[idx] = [2]int{vi, k} } }
+    if len(where) != len(p.Nodes) { t.Fatalf("unassigned nodes: %+v", sol.Plans) }
+    for _, pr := range p.Pairs {
+        pu, de := where[pr.Pickup], where[pr.Delivery]
+        if pu[0] != de[0] || pu[1] > de[1] { t.Fatalf("pair %+v broken: %+v", pr, sol.Plans) }
+    }
+    // capacity 15 cannot carry both 10-unit loads at once
+    for vi, pl := range sol.Plans {
+        if !loadFeasible(p, pl.Order, p.Vehicles[vi]) { t.Fatalf("plan %d over capacity: %v", vi, pl.Order) }
+    }
+}
+
+func TestMaxRideTime(t *testing.T) {
+    p := Problem{
+        Nodes:    []Node{{ID: "p", Lat: 1, Lng: 1}, {ID: "x", Lat: 1.2, Lng: 1.2}, {ID: "d", Lat: 1.001, Lng: 1.001}},
+        Vehicles: []Vehicle{{ID: "v1"}},
+        Pairs:    []Pair{{Pickup: 0, Delivery: 2, MaxRideSec: 600}},
+    }
+    if err := p.Prepare(context.Background()); err != nil { t.Fatal(err) }
+    if _, ok := schedulePlan(p, RoutePlan{Order: []int{0, 1, 2}}, 0); ok { t.Fatalf("detour should exceed max ride time") }
+    if _, ok := schedulePlan(p, RoutePlan{Order: []int{0, 2, 1}}, 0); !ok { t.Fatalf("direct ride should be feasible") }
+    if _, ok := schedulePlan(p, RoutePlan{Order: []int{2, 0, 1}}, 0); ok { t.Fatalf("delivery before pickup should be infeasible") }
+}
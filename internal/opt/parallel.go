@@ -0,0 +1,68 @@
+package opt
+
+import (
+    "context"
+    "math"
+    "sync"
+    "time"
+)
+
+// workerSeed derives the seed of parallel search i; search 0 uses seed itself
+// so Parallel=1 and a single search agree.
+func workerSeed(seed int64, i int) int64 { return seed + int64(i)*0x9E3779B9 }
+
+// solveParallel runs p.Parallel independent searches with derived seeds and
+// returns the cheapest solution (lowest search index on ties, so a fixed seed
+// stays reproducible). Counters are summed across searches; costs, final
+// weights and snapshots come from the winning search.
+func solveParallel(ctx context.Context, p Problem, seed int64, timeBudget time.Duration) (Solution, Metrics) {
+    n := p.Parallel
+    sols := make([]Solution, n)
+    ms := make([]Metrics, n)
+    // progress reports the total iterations and the best cost over all searches
+    var mu sync.Mutex
+    iters := make([]int, n)
+    bests := make([]float64, n)
+    for i := range bests { bests[i] = math.MaxFloat64 }
+    hook := p.OnSnapshot
+    var wg sync.WaitGroup
+    for i := 0; i < n; i++ {
+        q := p
+        if hook != nil {
+            i := i
+            q.OnSnapshot = func(s WeightSnapshot) {
+                mu.Lock()
+                defer mu.Unlock()
+                iters[i], bests[i] = s.Iteration, s.BestCost
+                s.Iteration, s.BestCost = 0, math.MaxFloat64
+                for k := range iters { s.Iteration += iters[k]; s.BestCost = math.Min(s.BestCost, bests[k]) }
+                hook(s)
+            }
+        }
+        wg.Add(1)
+        go func(i int, q Problem) {
+            defer wg.Done()
+            sols[i], ms[i] = solveSeed(ctx, q, workerSeed(seed, i), timeBudget)
+        }(i, q)
+    }
+    wg.Wait()
+    b := 0
+    for i := 1; i < n; i++ { if sols[i].Cost < sols[b].Cost { b = i } }
+    m := ms[b]
+    m.Seed, m.Workers, m.BestWorker = seed, n, b
+    m.RemovalSelects, m.RemovalSuccesses, m.InsertSelects, m.InsertSuccesses = map[string]int{}, map[string]int{}, map[string]int{}, map[string]int{}
+    m.Iterations, m.Improvements, m.AcceptedWorse = 0, 0, 0
+    for _, wm := range ms {
+        addCounts(m.RemovalSelects, wm.RemovalSelects)
+        addCounts(m.RemovalSuccesses, wm.RemovalSuccesses)
+        addCounts(m.InsertSelects, wm.InsertSelects)
+        addCounts(m.InsertSuccesses, wm.InsertSuccesses)
+        m.Iterations += wm.Iterations
+        m.Improvements += wm.Improvements
+        m.AcceptedWorse += wm.AcceptedWorse
+        m.Cancelled = m.Cancelled || wm.Cancelled
+    }
+    return sols[b], m
+}
+
+func addCounts(dst, src map[string]int) { for k, v := range src { dst[k] += v } }
@@ -0,0 +1,17 @@
+package opt
+
+import "context"
+
+type progressKey struct{}
+
+// WithProgress returns a context carrying a snapshot hook. Callers that plan
+// through the store use it to observe Solve without changing store signatures.
+func WithProgress(ctx context.Context, fn func(WeightSnapshot)) context.Context {
+    return context.WithValue(ctx, progressKey{}, fn)
+}
+
+// ProgressFrom returns the snapshot hook attached by WithProgress, or nil.
+func ProgressFrom(ctx context.Context) func(WeightSnapshot) {
+    fn, _ := ctx.Value(progressKey{}).(func(WeightSnapshot))
+    return fn
+}
@@ -0,0 +1,32 @@
+package opt
+
+import (
+    "context"
+    "testing"
+    "time"
+)
+
+func TestShiftWindowAndRouteCaps(t *testing.T) {
+    shift := time.Date(2025, 3, 3, 8, 0, 0, 0, time.UTC)
+    depot := &[2]float64{1, 1}
+    p := Problem{
+        Nodes:    []Node{{ID: "a", Lat: 1.1, Lng: 1.1, ServiceSec: 600}, {ID: "b", Lat: 1.2, Lng: 1.2, ServiceSec: 600}},
+        Vehicles: []Vehicle{{ID: "v", StartLatLng: depot, EndLatLng: depot, ShiftStart: shift, ShiftEnd: shift.Add(2 * time.Hour)}},
+        StartAt:  shift.Add(-12 * time.Hour),
+    }
+    if err := p.Prepare(context.Background()); err != nil { t.Fatal(err) }
+    if got := p.RouteStart(0); !got.Equal(shift) { t.Fatalf("route start = %v, want shift start %v", got, shift) }
+    full := RoutePlan{VehicleID: "v", Order: []int{0, 1}}
+    sc, ok := schedulePlan(p, full, 0)
+    if !ok { t.Fatalf("route should fit the shift: ends %v", time.Unix(int64(sc.drive), 0).UTC()) }
+    // the return leg pushes a later start past the shift end
+    p.Vehicles[0].ShiftEnd = time.Unix(int64(sc.drive)-60, 0)
+    if _, ok := schedulePlan(p, full, 0); ok { t.Fatalf("return after shift end should be infeasible") }
+    if feasibleAddAt(p, RoutePlan{VehicleID: "v", Order: []int{0}}, 0, 1, 1) { t.Fatalf("insertion must respect the shift end") }
+    p.Vehicles[0].ShiftEnd = time.Time{}
+    p.Vehicles[0].MaxRouteSec = int(sc.drive-p.routeStart(0)) - 1
+    if _, ok := schedulePlan(p, full, 0); ok { t.Fatalf("max route duration should be enforced") }
+    p.Vehicles[0].MaxRouteSec = 0
+    p.Vehicles[0].MaxDistM = sc.dist - 1
+    if _, ok := schedulePlan(p, full, 0); ok { t.Fatalf("max distance should be enforced") }
+}
@@ -0,0 +1,58 @@
+package opt
+
+import (
+    "context"
+    "errors"
+    "math/rand"
+    "reflect"
+    "testing"
+    "time"
+)
+
+func seedProblem() Problem {
+    rng := rand.New(rand.NewSource(3))
+    p := Problem{Vehicles: []Vehicle{{ID: "v1"}, {ID: "v2"}}, IterationsLimit: 60}
+    for i := 0; i < 12; i++ {
+        p.Nodes = append(p.Nodes, Node{ID: string(rune('a' + i)), Lat: 40 + rng.Float64()*0.2, Lng: -88 + rng.Float64()*0.2, ServiceSec: 60})
+    }
+    return p
+}
+
+func TestSolveContextSeedReproducible(t *testing.T) {
+    s1, m1 := solve(t, seedProblem(), 42, time.Minute)
+    s2, m2 := solve(t, seedProblem(), 42, time.Minute)
+    if m1.Seed != 42 || m2.Seed != 42 { t.Fatalf("seed not recorded: %d %d", m1.Seed, m2.Seed) }
+    if !reflect.DeepEqual(s1.Plans, s2.Plans) || s1.Cost != s2.Cost { t.Fatalf("same seed gave different plans:\n%+v\n%+v", s1.Plans, s2.Plans) }
+    if _, m := solve(t, seedProblem(), 0, 0); m.Seed == 0 { t.Fatal("generated seed should be reported") }
+}
+
+func TestSolveContextCancelled(t *testing.T) {
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+    sol, m, err := SolveContext(ctx, seedProblem(), 1, time.Minute)
+    if !errors.Is(err, context.Canceled) { t.Fatalf("want context.Canceled, got %v", err) }
+    if !m.Cancelled || m.Iterations != 0 { t.Fatalf("want immediate stop, got cancelled=%v iterations=%d", m.Cancelled, m.Iterations) }
+    n := 0
+    for _, pl := range sol.Plans { n += len(pl.Order) }
+    if n != 12 { t.Fatalf("cancelled solve should return the seed solution, got %d nodes", n) }
+    p := seedProblem()
+    p.Parallel = 3
+    if sol, m, err := SolveContext(ctx, p, 1, time.Minute); !errors.Is(err, context.Canceled) || !m.Cancelled || len(sol.Plans) == 0 {
+        t.Fatalf("parallel cancel: err %v, metrics %+v", err, m)
+    }
+}
+
+func TestSolveParallelKeepsBestSearch(t *testing.T) {
+    single, sm := solve(t, seedProblem(), 42, time.Minute)
+    p := seedProblem()
+    p.Parallel = 4
+    var snaps int
+    p.OnSnapshot = func(WeightSnapshot) { snaps++ } // calls are serialised by solveParallel
+    sol, m := solve(t, p, 42, time.Minute)
+    if m.Workers != 4 || m.Seed != 42 { t.Fatalf("workers=%d seed=%d", m.Workers, m.Seed) }
+    if m.Iterations != 4*sm.Iterations { t.Fatalf("iterations not merged: %d vs 4x%d", m.Iterations, sm.Iterations) }
+    if sol.Cost > single.Cost { t.Fatalf("parallel cost %.2f worse than its first search %.2f", sol.Cost, single.Cost) }
+    if snaps == 0 { t.Fatal("progress hook not called") }
+    again, m2 := solve(t, p, 42, time.Minute)
+    if !reflect.DeepEqual(sol.Plans, again.Plans) || m2.BestWorker != m.BestWorker { t.Fatal("parallel solve with a fixed seed is not reproducible") }
+}
@@ -0,0 +1,181 @@
+package opt
+
+import (
+    "fmt"
+    "math"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// SpeedBand scales matrix drive times between From and To ("HH:MM", local
+// time of day). Factor is a speed multiplier: 0.5 doubles the travel time.
+// Bands may wrap past midnight; hours outside every band run at factor 1.
+type SpeedBand struct {
+    From   string  `json:"from"`
+    To     string  `json:"to"`
+    Factor float64 `json:"factor"`
+}
+
+// SpeedZone overrides the default bands for legs departing inside a circle.
+type SpeedZone struct {
+    Name    string      `json:"name,omitempty"`
+    Lat     float64     `json:"lat"`
+    Lng     float64     `json:"lng"`
+    RadiusM float64     `json:"radiusM"`
+    Bands   []SpeedBand `json:"bands"`
+}
+
+// SpeedProfile is a tenant's time-of-day traffic model.
+type SpeedProfile struct {
+    Timezone string      `json:"timezone,omitempty"` // IANA name; UTC when empty
+    Bands    []SpeedBand `json:"bands,omitempty"`
+    Zones    []SpeedZone `json:"zones,omitempty"`
+}
+
+// bandSpan is a compiled band in seconds of day, [from, to).
+type bandSpan struct {
+    from, to float64
+    factor   float64
+}
+
+type compiledProfile struct {
+    offset float64      // seconds east of UTC at the route start
+    def    []bandSpan
+    zones  [][]bandSpan
+    zoneOf []int        // location index -> zone, -1 for default bands
+}
+
+func parseClock(s string) (float64, error) {
+    parts := strings.Split(strings.TrimSpace(s), ":")
+    if len(parts) != 2 { return 0, fmt.Errorf("invalid time of day %q (want HH:MM)", s) }
+    h, err1 := strconv.Atoi(parts[0])
+    m, err2 := strconv.Atoi(parts[1])
+    if err1 != nil || err2 != nil || h < 0 || h > 24 || m < 0 || m > 59 || (h == 24 && m != 0) {
+        return 0, fmt.Errorf("invalid time of day %q (want HH:MM)", s)
+    }
+    return float64(h*3600 + m*60), nil
+}
+
+func compileBands(bands []SpeedBand) ([]bandSpan, error) {
+    out := []bandSpan{}
+    for _, b := range bands {
+        if b.Factor <= 0 { return nil, fmt.Errorf("speed band %s-%s: factor must be > 0", b.From, b.To) }
+        from, err := parseClock(b.From)
+        if err != nil { return nil, err }
+        to, err := parseClock(b.To)
+        if err != nil { return nil, err }
+        if from == to { continue }
+        if to < from {
+            out = append(out, bandSpan{from: from, to: 86400, factor: b.Factor}, bandSpan{from: 0, to: to, factor: b.Factor})
+        } else {
+            out = append(out, bandSpan{from: from, to: to, factor: b.Factor})
+        }
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].from < out[j].from })
+    for i := 1; i < len(out); i++ {
+        if out[i].from < out[i-1].to { return nil, fmt.Errorf("speed bands overlap") }
+    }
+    return out, nil
+}
+
+// Validate checks band times, factors, overlaps and the timezone name.
+func (sp *SpeedProfile) Validate() error {
+    _, err := sp.compile(time.Now(), nil)
+    return err
+}
+
+// compile validates the profile and resolves zone membership for locs.
+func (sp *SpeedProfile) compile(start time.Time, locs []Location) (*compiledProfile, error) {
+    loc := time.UTC
+    if sp.Timezone != "" {
+        l, err := time.LoadLocation(sp.Timezone)
+        if err != nil { return nil, fmt.Errorf("speed profile timezone: %w", err) }
+        loc = l
+    }
+    _, off := start.In(loc).Zone()
+    cp := &compiledProfile{offset: float64(off), zoneOf: make([]int, len(locs))}
+    var err error
+    if cp.def, err = compileBands(sp.Bands); err != nil { return nil, err }
+    for _, z := range sp.Zones {
+        bs, err := compileBands(z.Bands)
+        if err != nil { return nil, fmt.Errorf("speed zone %s: %w", z.Name, err) }
+        cp.zones = append(cp.zones, bs)
+    }
+    for i, l := range locs {
+        cp.zoneOf[i] = -1
+        for zi, z := range sp.Zones {
+            if haversine(l.Lat, l.Lng, z.Lat, z.Lng) <= z.RadiusM { cp.zoneOf[i] = zi; break }
+        }
+    }
+    return cp, nil
+}
+
+// factorAt returns the speed factor at second-of-day sod and how long it
+// holds before the next band boundary.
+func factorAt(bands []bandSpan, sod float64) (float64, float64) {
+    next := 86400.0
+    for _, b := range bands {
+        if sod < b.from { next = b.from; break }
+        if sod < b.to { return b.factor, b.to - sod }
+    }
+    return 1, next - sod
+}
+
+// travelFrom integrates freeFlow seconds of driving departing at epoch second
+// t0 across band boundaries, returning the elapsed wall-clock seconds.
+func (cp *compiledProfile) travelFrom(origin int, t0, freeFlow float64) float64 {
+    bands := cp.def
+    if origin >= 0 && cp.zoneOf[origin] >= 0 { bands = cp.zones[cp.zoneOf[origin]] }
+    if len(bands) == 0 { return freeFlow }
+    t, rem := t0, freeFlow
+    for guard := 0; rem > 1e-9 && guard < 64; guard++ {
+        sod := math.Mod(t+cp.offset, 86400)
+        if sod < 0 { sod += 86400 }
+        f, hold := factorAt(bands, sod)
+        if rem <= f*hold { return t + rem/f - t0 }
+        rem -= f * hold
+        t += hold
+    }
+    return t + rem - t0
+}
+
+// driveAt returns the drive time between locations a and b departing at
+// epoch second t, honouring the speed profile when one is set.
+func (p Problem) driveAt(a, b int, t float64) float64 {
+    _, ff := p.travel(a, b)
+    if ff == 0 || p.tt.prof == nil { return ff }
+    return p.tt.prof.travelFrom(a, t, ff)
+}
+
+// startSec is the route clock origin in epoch seconds.
+func (p Problem) startSec() float64 {
+    if p.StartAt.IsZero() { return 0 }
+    return float64(p.StartAt.UnixNano()) / 1e9
+}
+
+// routeStart is when vehicle vi leaves its start depot: the later of
+// StartAt and the vehicle's shift start, in epoch seconds.
+func (p Problem) routeStart(vi int) float64 {
+    t := p.startSec()
+    if ss := p.Vehicles[vi].ShiftStart; !ss.IsZero() {
+        if s := float64(ss.UnixNano()) / 1e9; s > t { t = s }
+    }
+    return t
+}
+
+// RouteStart is routeStart as a time; zero when neither StartAt nor a shift
+// start is set.
+func (p Problem) RouteStart(vi int) time.Time {
+    t := p.routeStart(vi)
+    if t == 0 { return time.Time{} }
+    return time.Unix(0, int64(t*1e9)).UTC()
+}
+
+// DriveTimeAt returns the drive seconds of lg when departing at depart,
+// using the same time-dependent model as Solve.
+func (p Problem) DriveTimeAt(lg PlannedLeg, depart time.Time) (float64, error) {
+    if p.tt == nil { return 0, ErrUnprepared }
+    return p.driveAt(lg.fromLoc, lg.toLoc, float64(depart.UnixNano())/1e9), nil
+}
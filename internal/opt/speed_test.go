@@ -0,0 +1,53 @@
+package opt
+
+import (
+    "context"
+    "math"
+    "testing"
+    "time"
+)
+
+func TestSpeedProfileIntegratesAcrossBands(t *testing.T) {
+    p := Problem{
+        Nodes:        []Node{{ID: "a", Lat: 1, Lng: 1}, {ID: "b", Lat: 1.01, Lng: 1.01}},
+        Vehicles:     []Vehicle{{ID: "v1"}},
+        Matrix:       staticMatrix{d: 5000, t: 3600},
+        SpeedProfile: &SpeedProfile{Bands: []SpeedBand{{From: "08:00", To: "09:00", Factor: 0.5}}},
+        StartAt:      time.Date(2025, 1, 6, 7, 30, 0, 0, time.UTC),
+    }
+    if err := p.Prepare(context.Background()); err != nil { t.Fatal(err) }
+    legs, err := p.Legs(0, RoutePlan{Order: []int{0, 1}})
+    if err != nil { t.Fatal(err) }
+    // 07:30-08:00 covers 30 free-flow minutes, 08:00-09:00 the remaining 30 at half speed
+    if got, _ := p.DriveTimeAt(legs[0], p.StartAt); math.Abs(got-5400) > 1e-6 { t.Fatalf("rush hour drive = %v, want 5400", got) }
+    // outside the band the matrix duration is unchanged
+    if got, _ := p.DriveTimeAt(legs[0], p.StartAt.Add(3*time.Hour)); math.Abs(got-3600) > 1e-6 { t.Fatalf("off-peak drive = %v, want 3600", got) }
+}
+
+func TestSpeedProfileZonesAndValidation(t *testing.T) {
+    sp := &SpeedProfile{
+        Timezone: "UTC",
+        Bands:    []SpeedBand{{From: "22:00", To: "02:00", Factor: 2}},
+        Zones:    []SpeedZone{{Name: "core", Lat: 1, Lng: 1, RadiusM: 500, Bands: []SpeedBand{{From: "00:00", To: "24:00", Factor: 0.25}}}},
+    }
+    if err := sp.Validate(); err != nil { t.Fatal(err) }
+    p := Problem{
+        Nodes:        []Node{{ID: "a", Lat: 1, Lng: 1}, {ID: "b", Lat: 1.1, Lng: 1.1}},
+        Vehicles:     []Vehicle{{ID: "v1"}},
+        Matrix:       staticMatrix{d: 5000, t: 600},
+        SpeedProfile: sp,
+        StartAt:      time.Date(2025, 1, 6, 23, 0, 0, 0, time.UTC),
+    }
+    if err := p.Prepare(context.Background()); err != nil { t.Fatal(err) }
+    legs, err := p.Legs(0, RoutePlan{Order: []int{0, 1, 0}})
+    if err != nil { t.Fatal(err) }
+    if got, _ := p.DriveTimeAt(legs[0], p.StartAt); math.Abs(got-2400) > 1e-6 { t.Fatalf("zone drive = %v, want 2400", got) }
+    if got, _ := p.DriveTimeAt(legs[1], p.StartAt); math.Abs(got-300) > 1e-6 { t.Fatalf("night drive = %v, want 300", got) }
+    bad := []SpeedProfile{
+        {Bands: []SpeedBand{{From: "7am", To: "09:00", Factor: 1}}},
+        {Bands: []SpeedBand{{From: "07:00", To: "09:00", Factor: 0}}},
+        {Bands: []SpeedBand{{From: "07:00", To: "09:00", Factor: 1}, {From: "08:00", To: "10:00", Factor: 1}}},
+        {Timezone: "Mars/Olympus"},
+    }
+    for i := range bad { if bad[i].Validate() == nil { t.Fatalf("profile %d should be rejected", i) } }
+}
@@ -0,0 +1,86 @@
+package opt
+
+// Reason codes for nodes no plan can take; Explain also reports max_stops,
+// pair and hos (driver breaks push the route out of a window or shift).
+const (
+    ReasonCapacity   = "capacity"
+    ReasonSkills     = "skills"
+    ReasonTimeWindow = "time_window"
+    ReasonShift      = "shift"
+    ReasonZone       = "zone"
+    ReasonMaxStops   = "max_stops"
+    ReasonPair       = "pair"
+    ReasonHoS        = "hos"
+)
+
+// Unassigned is a node left out of every plan, with the reason.
+type Unassigned struct {
+    Node   int // index into Nodes
+    Reason string
+}
+
+// bank leaves unit out of sol, recording why no plan could take it.
+func (p Problem) bank(sol *Solution, unit []int) {
+    reason := p.unassignedReason(*sol, unit)
+    for _, idx := range unit { sol.Unassigned = append(sol.Unassigned, Unassigned{Node: idx, Reason: reason}) }
+}
+
+// unassignedNodes returns the banked nodes of sol.
+func (sol Solution) unassignedNodes() []int {
+    out := make([]int, len(sol.Unassigned))
+    for i, u := range sol.Unassigned { out[i] = u.Node }
+    return out
+}
+
+// unassignedReason explains why unit fits no plan of sol by the first check
+// every vehicle fails: skills, zone rules, then capacity on top of the
+// current load. A unit that fails even alone on an empty route is
+// time_window when no vehicle can reach its window in time and shift
+// otherwise (shift end, route duration or distance caps). A unit that would
+// fit alone is blocked by the current routes: time_window for a windowed
+// stop, shift when it has no window.
+func (p Problem) unassignedReason(sol Solution, unit []int) string {
+    if len(sol.Plans) == 0 { return ReasonShift }
+    skilled, zoned, loaded, alone, late := false, false, false, false, true
+    for vi, pl := range sol.Plans {
+        v := p.Vehicles[vi]
+        ok := true
+        for _, idx := range unit { ok = ok && hasSkills(p, v, idx) }
+        if !ok { continue }
+        skilled = true
+        for _, idx := range unit { ok = ok && inZones(p, v, idx) }
+        if !ok { continue }
+        zoned = true
+        if !loadFeasible(p, append(append([]int(nil), pl.Order...), unit...), v) { continue }
+        loaded = true
+        if _, ok := schedulePlan(p, RoutePlan{VehicleID: v.ID, Order: unit}, vi); ok { alone = true; continue }
+        if !p.missesWindow(vi, unit) { late = false }
+    }
+    switch {
+    case !skilled:
+        return ReasonSkills
+    case !zoned:
+        return ReasonZone
+    case !loaded:
+        return ReasonCapacity
+    case alone:
+        for _, idx := range unit {
+            if len(p.Nodes[idx].Windows) > 0 { return ReasonTimeWindow }
+        }
+        return ReasonShift
+    case late:
+        return ReasonTimeWindow
+    }
+    return ReasonShift
+}
+
+// missesWindow reports whether vehicle vi, driving straight from its start,
+// arrives at some node of unit too late to serve it.
+func (p Problem) missesWindow(vi int, unit []int) bool {
+    start := p.routeStart(vi)
+    for _, idx := range unit {
+        _, t := p.travel(p.startLoc(vi), idx)
+        if _, _, _, ok := p.Nodes[idx].service(start + t); !ok { return true }
+    }
+    return false
+}
@@ -0,0 +1,63 @@
+package opt
+
+import (
+    "context"
+    "testing"
+    "time"
+)
+
+func TestUnassignedReasons(t *testing.T) {
+    shift := time.Date(2025, 3, 3, 8, 0, 0, 0, time.UTC)
+    depot := &[2]float64{1, 1}
+    p := Problem{
+        Nodes: []Node{
+            {ID: "ok", Lat: 1.01, Lng: 1.01, Demand: Demand{Weight: 5}},
+            {ID: "skills", Lat: 1.01, Lng: 1.02, Skills: []string{"crane"}},
+            {ID: "capacity", Lat: 1.02, Lng: 1.01, Demand: Demand{Weight: 50}},
+            {ID: "tw", Lat: 1.3, Lng: 1.3, Windows: []TW{{Start: shift.Add(-time.Hour), End: shift.Add(10 * time.Minute)}}},
+            {ID: "shift", Lat: 3, Lng: 3},
+        },
+        Vehicles:        []Vehicle{{ID: "v", Skills: []string{"lift"}, CapWeight: 10, StartLatLng: depot, EndLatLng: depot, ShiftStart: shift, ShiftEnd: shift.Add(4 * time.Hour)}},
+        StartAt:         shift,
+        IterationsLimit: 20,
+    }
+    if err := p.Prepare(context.Background()); err != nil { t.Fatal(err) }
+    sol, _ := solve(t, p, 1, time.Minute)
+    if len(sol.Plans[0].Order) != 1 || sol.Plans[0].Order[0] != 0 { t.Fatalf("only the feasible stop should be routed: %v", sol.Plans[0].Order) }
+    got := map[string]string{}
+    for _, u := range sol.Unassigned { got[p.Nodes[u.Node].ID] = u.Reason }
+    want := map[string]string{"skills": ReasonSkills, "capacity": ReasonCapacity, "tw": ReasonTimeWindow, "shift": ReasonShift}
+    for id, r := range want { if got[id] != r { t.Errorf("%s: reason %q, want %q (all: %v)", id, got[id], r, got) } }
+    base := cost(p, Solution{Plans: sol.Plans})
+    if sol.Cost != base || sol.Cost < 4*50*3600 { t.Fatalf("unassigned stops should be charged the failed objective: %.0f", sol.Cost) }
+}
+
+func TestPriorityAndServiceLevelFailures(t *testing.T) {
+    depot := &[2]float64{1, 1}
+    p := Problem{
+        Nodes: []Node{
+            {ID: "parcel", Lat: 1.01, Lng: 1.01, Demand: Demand{Weight: 10}},
+            {ID: "medical", Lat: 1.2, Lng: 1.2, Demand: Demand{Weight: 10}, Priority: 3, ServiceLevel: "same_day"},
+        },
+        Vehicles:        []Vehicle{{ID: "v", CapWeight: 10, StartLatLng: depot, EndLatLng: depot}},
+        IterationsLimit: 20,
+    }
+    routed := func() string {
+        sol, _ := solve(t, p, 1, time.Minute)
+        if len(sol.Plans[0].Order) != 1 { t.Fatalf("one stop fits: %v", sol.Plans[0].Order) }
+        return p.Nodes[sol.Plans[0].Order[0]].ID
+    }
+    if got := routed(); got != "parcel" { t.Fatalf("equal penalties should keep the nearer stop, got %s", got) }
+    p.Objectives = map[string]float64{"priority": 0.5}
+    if got := routed(); got != "medical" { t.Fatalf("priority should outweigh the detour, got %s", got) }
+    p.Objectives = map[string]float64{"serviceLevel.same_day": 3}
+    if got := routed(); got != "medical" { t.Fatalf("service level should outweigh the detour, got %s", got) }
+    p.Objectives, p.MustServePriority = nil, 3
+    if got := routed(); got != "medical" { t.Fatalf("must-serve stop left out, got %s", got) }
+    if p.failCost(1) < mustServePenalty || p.MustServe(0) { t.Fatalf("must-serve applies from priority 3 only") }
+}
+
+func TestFailedWeightDefaultsOnlyWhenAbsent(t *testing.T) {
+    if w := (Problem{}).weights(); w.fail != 50 { t.Fatalf("default failed weight %v, want 50", w.fail) }
+    if w := (Problem{Objectives: map[string]float64{"failed": 0}}).weights(); w.fail != 0 { t.Fatalf("explicit failed 0 became %v", w.fail) }
+}
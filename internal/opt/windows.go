@@ -0,0 +1,44 @@
+package opt
+
+import "time"
+
+// Time windows. A node may have several windows, e.g. 9–12 and 14–17.
+// Arriving inside a window starts service at once; arriving between windows
+// waits for the next one to open, and the wait is charged to the earliness
+// objective. Arriving after the last window closes is infeasible for a hard
+// node; a soft node is served on arrival and charged lateness, up to its
+// MaxLateSec.
+
+func epochSec(t time.Time) float64 { return float64(t.UnixNano()) / 1e9 }
+
+// service returns when service at nd starts for a vehicle arriving at arr
+// (epoch seconds), the wait before a window opens, the lateness past the
+// last window, and whether nd can be served at all.
+func (nd Node) service(arr float64) (start, wait, late float64, ok bool) {
+    for _, w := range nd.Windows {
+        if !w.End.IsZero() && arr > epochSec(w.End) { continue }
+        if !w.Start.IsZero() && arr < epochSec(w.Start) { return epochSec(w.Start), epochSec(w.Start) - arr, 0, true }
+        return arr, 0, 0, true
+    }
+    if len(nd.Windows) == 0 { return arr, 0, 0, true }
+    late = arr - epochSec(nd.Windows[len(nd.Windows)-1].End)
+    ok = nd.SoftTW && (nd.MaxLateSec <= 0 || late <= float64(nd.MaxLateSec))
+    return arr, 0, late, ok
+}
+
+// span covers nd's windows from the first opening to the last closing.
+func (nd Node) span() (TW, bool) {
+    if len(nd.Windows) == 0 { return TW{}, false }
+    return TW{Start: nd.Windows[0].Start, End: nd.Windows[len(nd.Windows)-1].End}, true
+}
+
+// ServiceStart is when service at nd starts for a vehicle arriving at arr:
+// arr itself, or the opening of the next window.
+func (nd Node) ServiceStart(arr time.Time) time.Time {
+    for _, w := range nd.Windows {
+        if !w.End.IsZero() && arr.After(w.End) { continue }
+        if arr.Before(w.Start) { return w.Start }
+        return arr
+    }
+    return arr
+}
@@ -0,0 +1,53 @@
+package opt
+
+import (
+    "context"
+    "math"
+    "testing"
+    "time"
+)
+
+func TestMultipleAndSoftWindows(t *testing.T) {
+    day := time.Date(2025, 3, 3, 0, 0, 0, 0, time.UTC)
+    at := func(h float64) time.Time { return day.Add(time.Duration(h * float64(time.Hour))) }
+    nd := Node{Windows: []TW{{Start: at(9), End: at(12)}, {Start: at(14), End: at(17)}}}
+    for _, c := range []struct{ arr, start, wait float64; ok bool }{
+        {8, 9, 1, true}, {10, 10, 0, true}, {13, 14, 1, true}, {18, 18, 0, false},
+    } {
+        start, wait, _, ok := nd.service(epochSec(at(c.arr)))
+        if ok != c.ok || (ok && (start != epochSec(at(c.start)) || wait != c.wait*3600)) { t.Fatalf("arrive %v: start %v wait %v ok %v", c.arr, start, wait, ok) }
+    }
+    nd.SoftTW = true
+    if _, _, late, ok := nd.service(epochSec(at(18))); !ok || late != 3600 { t.Fatalf("soft window: late %v ok %v", late, ok) }
+    nd.MaxLateSec = 1800
+    if _, _, _, ok := nd.service(epochSec(at(18))); ok { t.Fatalf("lateness past the cap should be infeasible") }
+
+    // a stop the vehicle cannot reach before its window closes
+    depot := &[2]float64{1, 1}
+    p := Problem{
+        Nodes:           []Node{{ID: "a", Lat: 1.1, Lng: 1.1, Windows: []TW{{Start: at(7), End: at(8)}}}},
+        Vehicles:        []Vehicle{{ID: "v", StartLatLng: depot, EndLatLng: depot, ShiftStart: at(8)}},
+        StartAt:         at(8),
+        Objectives:      map[string]float64{"lateness": 2},
+        IterationsLimit: 5,
+    }
+    sol, _ := solve(t, p, 1, time.Minute)
+    if len(sol.Unassigned) != 1 || sol.Unassigned[0].Reason != ReasonTimeWindow { t.Fatalf("hard window should bank the stop: %+v", sol.Unassigned) }
+    p.Nodes[0].SoftTW = true
+    sol, _ = solve(t, p, 1, time.Minute)
+    if len(sol.Unassigned) != 0 { t.Fatalf("soft window should route the stop: %+v", sol.Unassigned) }
+    if err := p.Prepare(context.Background()); err != nil { t.Fatal(err) }
+    _, drive := p.travel(p.startLoc(0), 0)
+    onTime := routeCost(p, RoutePlan{VehicleID: "v", Order: []int{0}}, 0)
+    p.Objectives["lateness"] = 0
+    if got := onTime - routeCost(p, RoutePlan{VehicleID: "v", Order: []int{0}}, 0); math.Abs(got-2*drive) > 1e-6 { t.Fatalf("lateness cost %.1f, want %.1f", got, 2*drive) }
+
+    // waiting for the afternoon window is charged to earliness
+    p.Nodes[0] = Node{ID: "a", Lat: 1.1, Lng: 1.1, Windows: []TW{{Start: at(6), End: at(7)}, {Start: at(9), End: at(10)}}}
+    p.tt = nil
+    if err := p.Prepare(context.Background()); err != nil { t.Fatal(err) }
+    if p.staticSchedule() { t.Fatalf("several windows must bypass the segment cache") }
+    base := routeCost(p, RoutePlan{VehicleID: "v", Order: []int{0}}, 0)
+    p.Objectives["earliness"] = 1
+    if got := routeCost(p, RoutePlan{VehicleID: "v", Order: []int{0}}, 0) - base; math.Abs(got-(3600-drive)) > 1e-6 { t.Fatalf("earliness cost %.1f, want %.1f", got, 3600-drive) }
+}
@@ -0,0 +1,107 @@
+package opt
+
+import (
+    "fmt"
+    "slices"
+)
+
+// Zone rules a routed node can break (see ZoneViolations).
+const (
+    ZoneTerritory = "territory" // served by a vehicle not assigned to the territory
+    ZoneForbidden = "forbidden" // inside a zone its vehicle may not enter
+)
+
+// Zone is a circular or polygonal area. Nodes inside a territory may only be
+// served by vehicles listing it in Vehicle.Territories; any zone can be
+// closed to a vehicle through Vehicle.Forbidden.
+type Zone struct {
+    ID        string
+    Center    *[2]float64  // circle centre (lat, lng), used with RadiusM
+    RadiusM   float64
+    Polygon   [][2]float64 // vertices (lat, lng); takes precedence over the circle
+    Territory bool
+}
+
+// Contains reports whether the point lies inside z.
+func (z Zone) Contains(lat, lng float64) bool {
+    if len(z.Polygon) >= 3 {
+        // even-odd ray cast along the latitude
+        in := false
+        for i, j := 0, len(z.Polygon)-1; i < len(z.Polygon); j, i = i, i+1 {
+            a, b := z.Polygon[i], z.Polygon[j]
+            if (a[0] > lat) != (b[0] > lat) && lng < (b[1]-a[1])*(lat-a[0])/(b[0]-a[0])+a[1] { in = !in }
+        }
+        return in
+    }
+    return z.Center != nil && haversine(z.Center[0], z.Center[1], lat, lng) <= z.RadiusM
+}
+
+// zoneIndex is the per-solve lookup built from Problem.Zones.
+type zoneIndex struct {
+    in [][]int // node -> indices of the zones containing it
+}
+
+func (p *Problem) indexZones() error {
+    p.zi = nil
+    if len(p.Zones) == 0 { return nil }
+    for _, z := range p.Zones {
+        if len(z.Polygon) < 3 && (z.Center == nil || z.RadiusM <= 0) {
+            return fmt.Errorf("zone %s needs a polygon or a centre and radius", z.ID)
+        }
+    }
+    zi := &zoneIndex{in: make([][]int, len(p.Nodes))}
+    for i, nd := range p.Nodes {
+        for k, z := range p.Zones {
+            if z.Contains(nd.Lat, nd.Lng) { zi.in[i] = append(zi.in[i], k) }
+        }
+    }
+    p.zi = zi
+    return nil
+}
+
+// zoneRule returns the first zone rule v breaks by serving node idx, with
+// the zone's ID, or two empty strings when v may serve it.
+func (p Problem) zoneRule(v Vehicle, idx int) (rule, zone string) {
+    if p.zi == nil { return "", "" }
+    territory, assigned := "", false
+    for _, k := range p.zi.in[idx] {
+        z := p.Zones[k]
+        if slices.Contains(v.Forbidden, z.ID) { return ZoneForbidden, z.ID }
+        if !z.Territory { continue }
+        if territory == "" { territory = z.ID }
+        assigned = assigned || slices.Contains(v.Territories, z.ID)
+    }
+    // overlapping territories: any one assigned to v will do
+    if territory != "" && !assigned { return ZoneTerritory, territory }
+    return "", ""
+}
+
+// inZones reports whether v may serve node idx under the zone rules.
+func inZones(p Problem, v Vehicle, idx int) bool {
+    rule, _ := p.zoneRule(v, idx)
+    return rule == ""
+}
+
+// ZoneViolation is a routed node that breaks a zone rule of its vehicle,
+// e.g. one kept on a route by a warm start.
+type ZoneViolation struct {
+    Node    int // index into Nodes
+    Vehicle int // index into Vehicles
+    Zone    string
+    Rule    string // ZoneTerritory or ZoneForbidden
+}
+
+// ZoneViolations lists the routed nodes of sol that break a zone rule.
+func (p Problem) ZoneViolations(sol Solution) ([]ZoneViolation, error) {
+    if len(p.Zones) == 0 { return nil, nil }
+    if p.tt == nil { return nil, ErrUnprepared }
+    var out []ZoneViolation
+    for vi, pl := range sol.Plans {
+        for _, idx := range pl.Order {
+            if rule, zone := p.zoneRule(p.Vehicles[vi], idx); rule != "" {
+                out = append(out, ZoneViolation{Node: idx, Vehicle: vi, Zone: zone, Rule: rule})
+            }
+        }
+    }
+    return out, nil
+}
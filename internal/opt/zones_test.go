@@ -0,0 +1,53 @@
+package opt
+
+import (
+    "context"
+    "testing"
+    "time"
+)
+
+func TestZoneContains(t *testing.T) {
+    square := Zone{ID: "sq", Polygon: [][2]float64{{0, 0}, {0, 1}, {1, 1}, {1, 0}}}
+    if !square.Contains(0.5, 0.5) || square.Contains(1.5, 0.5) || square.Contains(0.5, -0.1) { t.Fatalf("polygon containment") }
+    circle := Zone{ID: "c", Center: &[2]float64{1, 1}, RadiusM: 2000}
+    if !circle.Contains(1.01, 1) || circle.Contains(1.05, 1) { t.Fatalf("circle containment") }
+}
+
+func TestZoneRulesRestrictVehicles(t *testing.T) {
+    depot := &[2]float64{1, 1}
+    north := Zone{ID: "north", Polygon: [][2]float64{{1.005, 0.9}, {1.005, 1.1}, {1.1, 1.1}, {1.1, 0.9}}, Territory: true}
+    lez := Zone{ID: "lez", Center: &[2]float64{0.98, 1}, RadiusM: 1500}
+    p := Problem{
+        Nodes: []Node{
+            {ID: "n1", Lat: 1.01, Lng: 1.0}, {ID: "n2", Lat: 1.02, Lng: 1.01}, // north territory
+            {ID: "lez", Lat: 0.98, Lng: 1.0},
+            {ID: "free", Lat: 0.99, Lng: 1.02},
+        },
+        Vehicles: []Vehicle{
+            {ID: "team", StartLatLng: depot, EndLatLng: depot, Territories: []string{"north"}},
+            {ID: "diesel", StartLatLng: depot, EndLatLng: depot, Forbidden: []string{"lez"}},
+        },
+        Zones:           []Zone{north, lez},
+        IterationsLimit: 100,
+    }
+    if err := p.Prepare(context.Background()); err != nil { t.Fatal(err) }
+    sol, _ := solve(t, p, 7, time.Minute)
+    if v, err := p.ZoneViolations(sol); err != nil || len(v) != 0 { t.Fatalf("solver broke zone rules: %+v %v", v, err) }
+    for _, idx := range sol.Plans[1].Order {
+        if id := p.Nodes[idx].ID; id == "n1" || id == "n2" || id == "lez" { t.Fatalf("diesel served %s: %v", id, sol.Plans[1].Order) }
+    }
+    if len(sol.Unassigned) != 0 { t.Fatalf("every stop has a permitted vehicle: %+v", sol.Unassigned) }
+
+    // without the team vehicle only the free stop is left to route
+    p.Vehicles = p.Vehicles[1:]
+    p.tt = nil
+    sol, _ = solve(t, p, 7, time.Minute)
+    if len(sol.Unassigned) != 3 { t.Fatalf("territory and low-emission stops should be banked: %+v", sol.Unassigned) }
+    for _, u := range sol.Unassigned { if u.Reason != ReasonZone { t.Fatalf("reason %q, want zone", u.Reason) } }
+
+    // a warm start keeping a forbidden stop is reported
+    if err := p.Prepare(context.Background()); err != nil { t.Fatal(err) }
+    v, err := p.ZoneViolations(Solution{Plans: []RoutePlan{{VehicleID: "diesel", Order: []int{2, 0}}}})
+    if err != nil { t.Fatal(err) }
+    if len(v) != 2 || v[0].Rule != ZoneForbidden || v[0].Zone != "lez" || v[1].Rule != ZoneTerritory || v[1].Zone != "north" { t.Fatalf("violations: %+v", v) }
+}
@@ -0,0 +1,98 @@
+package optbench
+
+import (
+    "context"
+    "fmt"
+    "time"
+
+    "gpsnav/internal/opt"
+)
+
+// Config is how each instance is solved. Runs are reproducible for a fixed
+// seed and Iterations; Budget only bounds the wall time.
+type Config struct {
+    Seeds      []int64
+    Iterations int                // ALNS iteration cap per seed
+    Budget     time.Duration      // time limit per seed
+    Objectives map[string]float64 // opt.Problem objectives, e.g. vehicles to favour fewer routes
+}
+
+// Result is one seeded solve of an instance.
+type Result struct {
+    Instance    string
+    Seed        int64
+    Vehicles    int
+    Distance    float64
+    Unassigned  int
+    Feasible    bool
+    Violation   string  // first broken rule when not feasible
+    Gap         float64 // distance above the best known, in percent; 0 without one
+    Iterations  int
+    ItersPerSec float64
+    Elapsed     time.Duration
+}
+
+// Run solves in once per seed and scores each solution against best, which
+// may be nil.
+func Run(ctx context.Context, in Instance, best *Best, cfg Config) []Result {
+    seeds := cfg.Seeds
+    if len(seeds) == 0 { seeds = []int64{1} }
+    budget := cfg.Budget
+    if budget <= 0 { budget = time.Minute }
+    p := in.Problem()
+    p.IterationsLimit = cfg.Iterations
+    p.Objectives = cfg.Objectives
+    if err := p.Prepare(ctx); err != nil {
+        return []Result{{Instance: in.Name, Violation: err.Error()}}
+    }
+    out := make([]Result, 0, len(seeds))
+    for _, seed := range seeds {
+        began := time.Now()
+        sol, m, err := opt.SolveContext(ctx, p, seed, budget)
+        if err != nil && !m.Cancelled { return append(out, Result{Instance: in.Name, Seed: seed, Violation: err.Error()}) }
+        r := Result{Instance: in.Name, Seed: m.Seed, Iterations: m.Iterations, Elapsed: time.Since(began)}
+        if s := r.Elapsed.Seconds(); s > 0 { r.ItersPerSec = float64(m.Iterations) / s }
+        routes := make([][]int, 0, len(sol.Plans))
+        for _, pl := range sol.Plans { routes = append(routes, pl.Order) }
+        r.Vehicles, r.Distance, r.Unassigned, r.Violation = in.Check(routes)
+        r.Feasible = r.Violation == "" && r.Unassigned == 0
+        if best != nil && best.Distance > 0 { r.Gap = 100 * (r.Distance - best.Distance) / best.Distance }
+        out = append(out, r)
+    }
+    return out
+}
+
+// Check validates routes, given as indices into in.Customers, against the
+// instance independently of the solver: every customer served at most once,
+// capacity, service starting by each due time, the depot reached by its due
+// time and no more routes than the fleet. It returns the routes used, their
+// Euclidean distance, the customers left out and the first violation found.
+func (in Instance) Check(routes [][]int) (vehicles int, dist float64, unassigned int, violation string) {
+    seen := make([]bool, len(in.Customers))
+    fail := func(format string, a ...any) { if violation == "" { violation = fmt.Sprintf(format, a...) } }
+    for r, route := range routes {
+        if len(route) == 0 { continue }
+        vehicles++
+        load, t, prev := 0.0, in.Depot.Ready, in.Depot
+        for _, idx := range route {
+            if idx < 0 || idx >= len(in.Customers) { fail("route %d: unknown customer index %d", r, idx); continue }
+            c := in.Customers[idx]
+            if seen[idx] { fail("customer %d served twice", c.ID) }
+            seen[idx] = true
+            load += c.Demand
+            d := distance(prev, c)
+            dist += d
+            t = max(t+d, c.Ready)
+            if t > c.Due+1e-6 { fail("route %d: customer %d served at %.2f after due %.0f", r, c.ID, t, c.Due) }
+            t += c.Service
+            prev = c
+        }
+        d := distance(prev, in.Depot)
+        dist += d
+        if t+d > in.Depot.Due+1e-6 { fail("route %d: back at the depot at %.2f after %.0f", r, t+d, in.Depot.Due) }
+        if load > in.Capacity+1e-6 { fail("route %d: load %.0f over capacity %.0f", r, load, in.Capacity) }
+    }
+    if vehicles > in.Vehicles { fail("%d routes for a fleet of %d", vehicles, in.Vehicles) }
+    for _, s := range seen { if !s { unassigned++ } }
+    return vehicles, dist, unassigned, violation
+}
@@ -0,0 +1,57 @@
+package optbench
+
+import (
+    "context"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestParseSolomon(t *testing.T) {
+    in, err := Load("testdata/r1_40.txt")
+    if err != nil { t.Fatal(err) }
+    if in.Name != "R1_40" || in.Vehicles != 12 || in.Capacity != 200 || len(in.Customers) != 40 { t.Fatalf("header: %+v", in) }
+    if in.Depot != (Customer{X: 40, Y: 50, Due: 230}) { t.Fatalf("depot %+v", in.Depot) }
+    if c := in.Customers[0]; c != (Customer{ID: 1, X: 18, Y: 56, Demand: 20, Ready: 71, Due: 101, Service: 10}) { t.Fatalf("customer %+v", c) }
+    if _, err := Parse(strings.NewReader("X\n\nCUSTOMER\n 0 1 2 0 0 10 0\n")); err == nil { t.Fatal("missing VEHICLE section accepted") }
+}
+
+func TestCheckIndependentOfSolver(t *testing.T) {
+    in := Instance{Vehicles: 2, Capacity: 10, Depot: Customer{Due: 100},
+        Customers: []Customer{{ID: 1, X: 3, Y: 4, Demand: 6, Due: 50}, {ID: 2, X: 6, Y: 8, Demand: 6, Ready: 20, Due: 30, Service: 5}}}
+    v, d, u, bad := in.Check([][]int{{0}, {1}})
+    if v != 2 || d != 30 || u != 0 || bad != "" { t.Fatalf("got %d %.2f %d %q", v, d, u, bad) }
+    if _, _, _, bad := in.Check([][]int{{0, 1}}); !strings.Contains(bad, "capacity") { t.Fatalf("overload: %q", bad) }
+    if _, _, u, bad := in.Check([][]int{{0, 0}}); u != 1 || !strings.Contains(bad, "twice") { t.Fatalf("duplicate: %d %q", u, bad) }
+    in.Customers[1].Due = 9
+    if _, _, _, bad := in.Check([][]int{{1}}); !strings.Contains(bad, "after due") { t.Fatalf("late: %q", bad) }
+}
+
+// maxGap is the mean gap, in percent, to the fixtures' reference solutions
+// (testdata/best.csv) a short fixed-seed run may not exceed.
+const maxGap = 5.0
+
+func TestSolverRegression(t *testing.T) {
+    if testing.Short() { t.Skip("solves every fixture") }
+    best, err := LoadBest("testdata/best.csv")
+    if err != nil { t.Fatal(err) }
+    files, _ := filepath.Glob("testdata/*.txt")
+    if len(files) == 0 { t.Fatal("no fixtures") }
+    cfg := Config{Seeds: []int64{1, 2}, Iterations: 300, Budget: time.Minute}
+    for _, path := range files {
+        in, err := Load(path)
+        if err != nil { t.Fatal(err) }
+        b, ok := best[in.Name]
+        if !ok { t.Fatalf("%s has no reference solution", in.Name) }
+        t.Run(in.Name, func(t *testing.T) {
+            mean := 0.0
+            for _, r := range Run(context.Background(), in, &b, cfg) {
+                if !r.Feasible { t.Errorf("seed %d infeasible: %q, %d unassigned", r.Seed, r.Violation, r.Unassigned) }
+                t.Logf("seed %d: %d vehicles, %.2f (gap %.2f%%), %.0f iter/s", r.Seed, r.Vehicles, r.Distance, r.Gap, r.ItersPerSec)
+                mean += r.Gap / float64(len(cfg.Seeds))
+            }
+            if mean > maxGap { t.Errorf("mean gap %.2f%% over %.1f%%: solution quality regressed", mean, maxGap) }
+        })
+    }
+}
@@ -0,0 +1,152 @@
+// Package optbench loads standard VRPTW benchmark instances into opt.Problem
+// and measures the solver against their best-known solutions.
+package optbench
+
+import (
+    "bufio"
+    "context"
+    "fmt"
+    "io"
+    "math"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "time"
+
+    "gpsnav/internal/opt"
+)
+
+// Customer is one row of an instance; customer 0 is the depot.
+type Customer struct {
+    ID         int
+    X, Y       float64
+    Demand     float64
+    Ready, Due float64 // service must start within [Ready, Due]
+    Service    float64
+}
+
+// Instance is a Solomon or Homberger VRPTW instance. Distances are Euclidean
+// and travel one distance unit per time unit.
+type Instance struct {
+    Name      string
+    Vehicles  int
+    Capacity  float64
+    Depot     Customer
+    Customers []Customer
+}
+
+// Load reads an instance file.
+func Load(path string) (Instance, error) {
+    f, err := os.Open(path)
+    if err != nil { return Instance{}, err }
+    defer f.Close()
+    in, err := Parse(f)
+    if err != nil { return Instance{}, fmt.Errorf("%s: %w", path, err) }
+    if in.Name == "" { in.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)) }
+    return in, nil
+}
+
+// Parse reads the Solomon text format shared by the Homberger instances: the
+// instance name, a VEHICLE section with the fleet size and capacity, and a
+// CUSTOMER section of (no, x, y, demand, ready, due, service) rows starting
+// with the depot.
+func Parse(r io.Reader) (Instance, error) {
+    var in Instance
+    section := ""
+    sc := bufio.NewScanner(r)
+    for line := 1; sc.Scan(); line++ {
+        text := strings.TrimSpace(sc.Text())
+        if text == "" { continue }
+        switch up := strings.ToUpper(text); {
+        case up == "VEHICLE" || up == "CUSTOMER":
+            section = up
+            continue
+        case strings.HasPrefix(up, "NUMBER") || strings.HasPrefix(up, "CUST"):
+            continue // column headings
+        case section == "" && in.Name == "":
+            in.Name = text
+            continue
+        }
+        f, err := numbers(strings.Fields(text))
+        if err != nil { return in, fmt.Errorf("line %d: %w", line, err) }
+        switch {
+        case section == "VEHICLE" && len(f) == 2:
+            in.Vehicles, in.Capacity = int(f[0]), f[1]
+        case section == "CUSTOMER" && len(f) == 7:
+            c := Customer{ID: int(f[0]), X: f[1], Y: f[2], Demand: f[3], Ready: f[4], Due: f[5], Service: f[6]}
+            if c.ID == 0 { in.Depot = c } else { in.Customers = append(in.Customers, c) }
+        default:
+            return in, fmt.Errorf("line %d: unexpected %q", line, text)
+        }
+    }
+    if err := sc.Err(); err != nil { return in, err }
+    if in.Vehicles <= 0 || in.Capacity <= 0 { return in, fmt.Errorf("missing vehicle number or capacity") }
+    if len(in.Customers) == 0 { return in, fmt.Errorf("no customers") }
+    return in, nil
+}
+
+func numbers(fields []string) ([]float64, error) {
+    out := make([]float64, len(fields))
+    for i, s := range fields {
+        v, err := strconv.ParseFloat(s, 64)
+        if err != nil { return nil, err }
+        out[i] = v
+    }
+    return out, nil
+}
+
+// origin is the route clock origin of benchmark problems; instance times are
+// seconds after it.
+var origin = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func at(sec float64) time.Time { return origin.Add(time.Duration(sec * float64(time.Second))) }
+
+// Problem converts the instance into an opt.Problem: one vehicle per fleet
+// slot based at the depot, hard windows, demand as weight and a planar
+// matrix in which one distance unit is one metre and one second. Node and
+// vehicle coordinates are scaled to small degrees so that the solver's
+// haversine relatedness measures stay proportional to the plane.
+func (in Instance) Problem() opt.Problem {
+    m := planar{strconv.Itoa(in.Depot.ID): {in.Depot.X, in.Depot.Y}}
+    p := opt.Problem{StartAt: origin, Matrix: m}
+    for _, c := range in.Customers {
+        id := strconv.Itoa(c.ID)
+        m[id] = [2]float64{c.X, c.Y}
+        p.Nodes = append(p.Nodes, opt.Node{ID: id, Lat: c.Y * degree, Lng: c.X * degree, ServiceSec: int(math.Ceil(c.Service)),
+            Windows: []opt.TW{{Start: at(c.Ready), End: at(c.Due)}}, Demand: opt.Demand{Weight: c.Demand}})
+    }
+    depot := &[2]float64{in.Depot.Y * degree, in.Depot.X * degree}
+    for v := 0; v < in.Vehicles; v++ {
+        p.Vehicles = append(p.Vehicles, opt.Vehicle{ID: "v" + strconv.Itoa(v+1), CapWeight: in.Capacity,
+            StartLatLng: depot, EndLatLng: depot, StartID: strconv.Itoa(in.Depot.ID), EndID: strconv.Itoa(in.Depot.ID),
+            ShiftStart: at(in.Depot.Ready), ShiftEnd: at(in.Depot.Due)})
+    }
+    return p
+}
+
+// degree scales instance coordinates (0-100 for Solomon, up to 500 for
+// Homberger) to latitude and longitude near the equator.
+const degree = 1e-3
+
+// planar is an exact Euclidean matrix over instance coordinates by location ID.
+type planar map[string][2]float64
+
+func (m planar) Table(_ context.Context, locs []opt.Location) (opt.Table, error) {
+    t := opt.Table{Dist: make([][]float64, len(locs)), Dur: make([][]float64, len(locs))}
+    for i, a := range locs {
+        pa, ok := m[a.ID]
+        if !ok { return t, fmt.Errorf("unknown location %q", a.ID) }
+        t.Dist[i], t.Dur[i] = make([]float64, len(locs)), make([]float64, len(locs))
+        for j, b := range locs {
+            pb, ok := m[b.ID]
+            if !ok { return t, fmt.Errorf("unknown location %q", b.ID) }
+            d := math.Hypot(pa[0]-pb[0], pa[1]-pb[1])
+            t.Dist[i][j], t.Dur[i][j] = d, d
+        }
+    }
+    return t, nil
+}
+
+// distance is the Euclidean distance between two customers.
+func distance(a, b Customer) float64 { return math.Hypot(a.X-b.X, a.Y-b.Y) }
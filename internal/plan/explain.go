@@ -0,0 +1,71 @@
+package plan
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "slices"
+
+    "gpsnav/internal/opt"
+)
+
+// ErrUnknownStop is returned by Explain for a stop not among the request's
+// orders.
+var ErrUnknownStop = errors.New("unknown stop")
+
+// Option is one place a stop could go: a position on one of the explained
+// routes, with the solver's feasibility verdict and cost delta.
+type Option struct {
+    Route           int    // index into the explained routes
+    Position        int    // stops before it on the route, the explained stop left out
+    PartnerPosition int    // same for its pickup/delivery partner, moved with it; -1 when unpaired
+    AfterStopID     string // stop it would follow; empty at the start of the route
+    Feasible    bool
+    Reasons     []string // opt reason codes when infeasible
+    CostDelta   float64
+}
+
+// Explanation is the outcome of Explain.
+type Explanation struct {
+    Route    int // route the stop is on; -1 when unrouted
+    Position int
+    Options  []Option // feasible first, cheapest first
+}
+
+// Explain prices stop stopID at every position of existing routes, one per
+// req.Vehicles, each listing its stop IDs in order. Nothing is solved: the
+// routes are taken as they are, less the explained stop, and timed from
+// their vehicle's start. Stops of req.Orders not on a route are ignored.
+func Explain(ctx context.Context, req Request, routes [][]string, stopID string) (Explanation, error) {
+    if len(routes) != len(req.Vehicles) { return Explanation{}, fmt.Errorf("%d routes for %d vehicles", len(routes), len(req.Vehicles)) }
+    prob, err := Problem(req)
+    if err != nil { return Explanation{}, err }
+    nodeOf := map[string]int{}
+    for i, nd := range prob.Nodes { nodeOf[nd.ID] = i }
+    idx, ok := nodeOf[stopID]
+    if !ok { return Explanation{}, fmt.Errorf("stop %s: %w", stopID, ErrUnknownStop) }
+    ex := Explanation{Route: -1}
+    plans := make([]opt.RoutePlan, len(routes))
+    for vi, stops := range routes {
+        plans[vi].VehicleID = prob.Vehicles[vi].ID
+        for _, sid := range stops {
+            if sid == stopID { ex.Route, ex.Position = vi, len(plans[vi].Order) }
+            if i, ok := nodeOf[sid]; ok && i != idx { plans[vi].Order = append(plans[vi].Order, i) }
+        }
+    }
+    if err := prob.Prepare(ctx); err != nil { return Explanation{}, fmt.Errorf("distance matrix: %w", err) }
+    ins, err := prob.Explain(plans, idx)
+    if err != nil { return Explanation{}, err }
+    for _, in := range ins {
+        o := Option{Route: in.Vehicle, Position: in.Pos, PartnerPosition: in.PartnerPos, Feasible: in.Feasible, Reasons: in.Reasons, CostDelta: in.Delta}
+        if in.After >= 0 { o.AfterStopID = prob.Nodes[in.After].ID }
+        ex.Options = append(ex.Options, o)
+    }
+    slices.SortStableFunc(ex.Options, func(a, b Option) int {
+        if a.Feasible != b.Feasible { if a.Feasible { return -1 }; return 1 }
+        if a.CostDelta < b.CostDelta { return -1 }
+        if a.CostDelta > b.CostDelta { return 1 }
+        return 0
+    })
+    return ex, nil
+}
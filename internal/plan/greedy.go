@@ -0,0 +1,118 @@
+package plan
+
+import (
+    "context"
+    "fmt"
+    "math"
+    "slices"
+
+    "gpsnav/internal/opt"
+)
+
+// greedy clusters the stops around farthest-first seeds, one cluster per
+// vehicle, and orders each cluster by nearest neighbour and 2-opt. A route
+// runs from its vehicle's own depots and shift start; a vehicle without a
+// depot of its own starts and ends at the default depot nearest to its first
+// stop. A stop its route cannot take within the vehicle's limits goes to
+// another vehicle that can, or is left unassigned.
+func greedy(ctx context.Context, req Request) (opt.Problem, opt.Solution, error) {
+    prob, err := Problem(req)
+    if err != nil { return opt.Problem{}, opt.Solution{}, err }
+    depots, err := defaultDepots(req)
+    if err != nil { return opt.Problem{}, opt.Solution{}, err }
+    nd := prob.Nodes
+    n := len(nd)
+    k := len(req.Vehicles)
+    // Select k seeds (farthest-first)
+    seeds := []int{0}
+    for len(seeds) < k && len(seeds) < n {
+        maxd, maxi := -1.0, -1
+        for i := 0; i < n; i++ {
+            if slices.Contains(seeds, i) { continue }
+            // distance to nearest seed
+            mind := math.MaxFloat64
+            for _, sidx := range seeds { mind = math.Min(mind, haversineMeters(nd[i].Lat, nd[i].Lng, nd[sidx].Lat, nd[sidx].Lng)) }
+            if mind > maxd { maxd, maxi = mind, i }
+        }
+        if maxi < 0 { break }
+        seeds = append(seeds, maxi)
+    }
+    // Assign stops to nearest seed
+    clusters := make([][]int, len(seeds))
+    for i := 0; i < n; i++ {
+        best, bestd := 0, math.MaxFloat64
+        for si, sidx := range seeds {
+            if d := haversineMeters(nd[i].Lat, nd[i].Lng, nd[sidx].Lat, nd[sidx].Lng); d < bestd { bestd, best = d, si }
+        }
+        clusters[best] = append(clusters[best], i)
+    }
+    clusters = keepPairsTogether(clusters, prob.Pairs)
+    stopNodes := make([]opt.StopNode, n)
+    for i := range nd { stopNodes[i] = opt.StopNode{Lat: nd[i].Lat, Lng: nd[i].Lng} }
+    var sol opt.Solution
+    prob.Vehicles = prob.Vehicles[:len(clusters)]
+    for ci, idxs := range clusters {
+        veh := prob.Vehicles[ci]
+        var order []int
+        if len(idxs) > 0 {
+            // order by nearest neighbour starting at the seed
+            start := seeds[ci]
+            if !slices.Contains(idxs, start) { start = idxs[0] } // seed moved to its pickup's cluster
+            used := map[int]bool{start: true}
+            order = []int{start}
+            for len(order) < len(idxs) {
+                last := order[len(order)-1]
+                best, bestd := -1, math.MaxFloat64
+                for _, j := range idxs {
+                    if used[j] { continue }
+                    if d := haversineMeters(nd[last].Lat, nd[last].Lng, nd[j].Lat, nd[j].Lng); d < bestd { bestd, best = d, j }
+                }
+                order = append(order, best)
+                used[best] = true
+            }
+            order = deliveriesAfterPickups(opt.ImproveOrder2Opt(stopNodes, order, 2), prob.Pairs)
+            if len(depots) > 0 && !ownDepot(req.Vehicles[ci]) {
+                first := nd[order[0]]
+                best, bestd := 0, math.MaxFloat64
+                for i, d := range depots {
+                    if dd := haversineMeters(first.Lat, first.Lng, d.Lat, d.Lng); dd < bestd { bestd, best = dd, i }
+                }
+                d := depots[best]
+                veh.StartLatLng, veh.StartID = &[2]float64{d.Lat, d.Lng}, d.ID
+                if veh.EndLatLng != nil { veh.EndLatLng, veh.EndID = &[2]float64{d.Lat, d.Lng}, d.ID } // open routes stay open
+            }
+        }
+        prob.Vehicles[ci] = veh
+        sol.Plans = append(sol.Plans, opt.RoutePlan{VehicleID: veh.ID, Order: order})
+    }
+    if err := prob.Prepare(ctx); err != nil { return opt.Problem{}, opt.Solution{}, fmt.Errorf("distance matrix: %w", err) }
+    sol, err = prob.Fit(sol)
+    if err != nil { return opt.Problem{}, opt.Solution{}, err }
+    return prob, sol, nil
+}
+
+// ownDepot reports whether v has depots of its own rather than a default one.
+func ownDepot(v Vehicle) bool {
+    return v.StartLatLng != nil || v.EndLatLng != nil || v.Depot.StartDepotID != "" || v.Depot.EndDepotID != ""
+}
+
+// defaultDepots returns the depots of req handed out by default.
+func defaultDepots(req Request) ([]Depot, error) {
+    if len(req.DefaultDepots) == 0 { return req.Depots, nil }
+    var out []Depot
+    for _, id := range req.DefaultDepots {
+        i := slices.IndexFunc(req.Depots, func(d Depot) bool { return d.ID == id })
+        if i < 0 { return nil, fmt.Errorf("unknown depot %s", id) }
+        out = append(out, req.Depots[i])
+    }
+    return out, nil
+}
+
+func haversineMeters(lat1, lon1, lat2, lon2 float64) float64 {
+    const R = 6371000.0
+    dLat := (lat2 - lat1) * math.Pi / 180
+    dLon := (lon2 - lon1) * math.Pi / 180
+    a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*math.Pi/180)*math.Cos(lat2*math.Pi/180)*math.Sin(dLon/2)*math.Sin(dLon/2)
+    c := 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+    return R * c
+}
@@ -0,0 +1,101 @@
+package plan
+
+import (
+    "strings"
+
+    "gpsnav/internal/opt"
+)
+
+// nodes flattens the orders' stops into solver nodes and pairs pickups with
+// deliveries by order. Paired stops carry their order's full demand; the
+// order's other stops split it evenly.
+func nodes(orders []Order, c Constraints) ([]opt.Node, []opt.Pair) {
+    var out []opt.Node
+    var kinds, orderIDs []string
+    var rides []int
+    first := make([]int, len(orders)) // node of each order's first stop
+    for oi, o := range orders {
+        first[oi] = len(out)
+        for _, s := range o.Stops {
+            out = append(out, opt.Node{ID: s.ID, Lat: s.Lat, Lng: s.Lng, ServiceSec: s.ServiceSec, Windows: s.Windows, SoftTW: s.SoftTW, MaxLateSec: s.MaxLateSec,
+                Demand: o.Demand, Skills: s.Skills, Pickup: strings.EqualFold(s.Kind, "pickup"), Priority: o.Priority, ServiceLevel: o.ServiceLevel, Affinity: c.Affinity[s.Customer]})
+            kinds, orderIDs, rides = append(kinds, s.Kind), append(orderIDs, o.ID), append(rides, o.MaxRideSec)
+        }
+    }
+    pairs := pickupDeliveryPairs(kinds, orderIDs, rides, c.MaxRideSec)
+    paired := map[int]bool{}
+    for _, pr := range pairs { paired[pr.Pickup], paired[pr.Delivery] = true, true }
+    for oi, o := range orders {
+        var free []int
+        for k := range o.Stops { if !paired[first[oi]+k] { free = append(free, first[oi]+k) } }
+        if len(free) < 2 { continue }
+        for _, i := range free { out[i].Demand = o.Demand.Scale(1 / float64(len(free))) }
+    }
+    return out, pairs
+}
+
+// pickupDeliveryPairs pairs the pickup and delivery stop of every order that
+// has exactly one of each; stops of other orders are planned independently.
+// Indices are positions in the given slices. A per-order maxRide overrides
+// defaultMaxRide.
+func pickupDeliveryPairs(kinds, orderIDs []string, maxRide []int, defaultMaxRide int) []opt.Pair {
+    type acc struct{ pickups, deliveries []int }
+    byOrder := map[string]*acc{}
+    seen := []string{}
+    for i, oid := range orderIDs {
+        if oid == "" { continue }
+        a := byOrder[oid]
+        if a == nil { a = &acc{}; byOrder[oid] = a; seen = append(seen, oid) }
+        switch strings.ToLower(kinds[i]) {
+        case "pickup": a.pickups = append(a.pickups, i)
+        case "delivery": a.deliveries = append(a.deliveries, i)
+        }
+    }
+    pairs := []opt.Pair{}
+    for _, oid := range seen {
+        a := byOrder[oid]
+        if len(a.pickups) != 1 || len(a.deliveries) != 1 { continue }
+        mr := defaultMaxRide
+        if maxRide[a.pickups[0]] > 0 { mr = maxRide[a.pickups[0]] }
+        pairs = append(pairs, opt.Pair{Pickup: a.pickups[0], Delivery: a.deliveries[0], MaxRideSec: mr})
+    }
+    return pairs
+}
+
+// keepPairsTogether moves each delivery into its pickup's cluster.
+func keepPairsTogether(clusters [][]int, pairs []opt.Pair) [][]int {
+    if len(pairs) == 0 { return clusters }
+    at := map[int]int{}
+    for ci, idxs := range clusters { for _, i := range idxs { at[i] = ci } }
+    for _, pr := range pairs {
+        from, to := at[pr.Delivery], at[pr.Pickup]
+        if from == to { continue }
+        for k, i := range clusters[from] {
+            if i == pr.Delivery { clusters[from] = append(clusters[from][:k], clusters[from][k+1:]...); break }
+        }
+        clusters[to] = append(clusters[to], pr.Delivery)
+        at[pr.Delivery] = to
+    }
+    return clusters
+}
+
+// deliveriesAfterPickups moves any delivery sequenced before its pickup to
+// directly after it.
+func deliveriesAfterPickups(order []int, pairs []opt.Pair) []int {
+    for _, pr := range pairs {
+        pi, di := -1, -1
+        for k, i := range order {
+            if i == pr.Pickup { pi = k }
+            if i == pr.Delivery { di = k }
+        }
+        if pi < 0 || di < 0 || di > pi { continue }
+        out := make([]int, 0, len(order))
+        for _, i := range order {
+            if i == pr.Delivery { continue }
+            out = append(out, i)
+            if i == pr.Pickup { out = append(out, pr.Delivery) }
+        }
+        order = out
+    }
+    return order
+}
@@ -0,0 +1,264 @@
+// Package plan turns domain orders, vehicles and depots into route plans
+// with the opt solver. It knows nothing about storage: stores load the
+// inputs, call Solve and persist the routes it returns.
+package plan
+
+import (
+    "context"
+    "fmt"
+    "maps"
+    "math"
+    "slices"
+    "time"
+
+    "gpsnav/internal/model"
+    "gpsnav/internal/opt"
+)
+
+// Algorithms accepted by Constraints.Algorithm.
+const (
+    Greedy = "greedy" // farthest-first clusters ordered by nearest neighbour and 2-opt
+    ALNS   = "alns"
+)
+
+// Stop is one stop of an order.
+type Stop struct {
+    ID         string
+    Kind       string // pickup or delivery pairs an order's stops; anything else is planned on its own
+    Lat, Lng   float64
+    ServiceSec int
+    Windows    []opt.TW
+    SoftTW     bool
+    MaxLateSec int
+    Skills     []string
+    Customer   string // key into Constraints.Affinity
+}
+
+// Order is a customer order and its stops. An order with exactly one pickup
+// and one delivery is served by one vehicle, pickup first, each stop
+// carrying the full demand; otherwise the demand is split evenly across the
+// stops.
+type Order struct {
+    ID           string
+    Priority     int
+    ServiceLevel string
+    Demand       opt.Demand
+    MaxRideSec   int // pickup-to-delivery cap; 0 uses Constraints.MaxRideSec
+    Stops        []Stop
+}
+
+// Depot is a hub a vehicle can start or end at.
+type Depot struct {
+    ID       string
+    Lat, Lng float64
+}
+
+// Vehicle is a fleet vehicle and where its route starts and ends. Depot
+// names Request.Depots; without a start depot the vehicle takes the next
+// default depot in turn. A vehicle whose start or end location is already
+// set keeps it as is.
+type Vehicle struct {
+    opt.Vehicle
+    Depot model.VehicleDepot
+}
+
+// Constraints are the planner settings shared by every vehicle.
+type Constraints struct {
+    Algorithm         string        // Greedy (default) or ALNS
+    StartAt           time.Time     // route clock origin; now when zero
+    TimeBudget        time.Duration // ALNS search time; 300ms when zero
+    MaxIterations     int
+    Seed              int64 // ALNS seed; 0 picks one (see Plan.Metrics.Seed)
+    Parallel          int
+    InitialTemp       float64
+    Cooling           float64
+    RemovalWeights    map[string]float64 // initial ALNS operator weights; nil enables every operator
+    InsertionWeights  map[string]float64
+    Objectives        map[string]float64 // ALNS weights (see opt.Problem.Objectives)
+    Breaks            opt.BreakRules
+    MaxRideSec        int     // default pickup-to-delivery cap
+    MaxRouteSec       int     // cap for vehicles without their own
+    MaxDistM          float64 // cap for vehicles without their own
+    MustServePriority int
+    Matrix            opt.DistanceMatrix // haversine when nil
+    SpeedProfile      *opt.SpeedProfile
+    Zones             []opt.Zone                    // candidate zones; only those constraining a vehicle are solved with
+    Affinity          map[string]map[string]float64 // by Stop.Customer, then driver ID: share of past visits
+}
+
+// Request is everything a plan is made from.
+type Request struct {
+    Orders        []Order
+    Vehicles      []Vehicle // none: a small unconstrained fleet sized to the stops
+    Depots        []Depot
+    DefaultDepots []string // depots handed out to vehicles without one; empty = all Depots
+    Constraints   Constraints
+}
+
+// Leg is one leg of a planned route: a drive to a stop or depot, or a break.
+type Leg struct {
+    Kind       string // drive or break
+    FromStopID string // empty when leaving a depot (or the caller's start location)
+    ToStopID   string // empty when returning to a depot
+    DistM      int
+    DriveSec   int
+    BreakSec   int
+    Arrival    time.Time // service start at the stop, after any wait for its window
+    Departure  time.Time
+}
+
+// Route is the plan of one vehicle.
+type Route struct {
+    Vehicle      int // index into Plan.Problem.Vehicles and Plan.Solution.Plans
+    VehicleID    string
+    StartDepotID string
+    EndDepotID   string
+    StopIDs      []string
+    Legs         []Leg
+    Cost         opt.CostBreakdown
+}
+
+// Plan is the outcome of Solve. Problem and Solution are the solver's view,
+// for callers that report more than the routes.
+type Plan struct {
+    Routes     []Route // vehicles with at least one stop
+    Unassigned []model.UnassignedStop
+    Metrics    opt.Metrics // zero for Greedy
+    Problem    opt.Problem
+    Solution   opt.Solution
+}
+
+// Solve plans req with its algorithm. A request without stops yields an
+// empty plan. When ctx ends an ALNS search early, Solve returns the best plan
+// found so far together with ctx.Err().
+func Solve(ctx context.Context, req Request) (Plan, error) {
+    n := 0
+    for _, o := range req.Orders { n += len(o.Stops) }
+    if n == 0 { return Plan{}, nil }
+    if len(req.Vehicles) == 0 { req.Vehicles = defaultFleet(n) }
+    c := req.Constraints
+    var pl Plan
+    switch c.Algorithm {
+    case ALNS:
+        prob, err := Problem(req)
+        if err != nil { return Plan{}, err }
+        // travel table shared by the solver and the planned legs
+        if err := prob.Prepare(ctx); err != nil { return Plan{}, fmt.Errorf("distance matrix: %w", err) }
+        tb := c.TimeBudget
+        if tb <= 0 { tb = 300 * time.Millisecond }
+        prob.OnSnapshot = opt.ProgressFrom(ctx)
+        sol, m, err := opt.SolveContext(ctx, prob, c.Seed, tb)
+        if err != nil && !m.Cancelled { return Plan{}, err }
+        pl = Plan{Problem: prob, Solution: sol, Metrics: m}
+    case Greedy, "":
+        prob, sol, err := greedy(ctx, req)
+        if err != nil { return Plan{}, err }
+        pl = Plan{Problem: prob, Solution: sol}
+    default:
+        return Plan{}, fmt.Errorf("unknown algorithm %q", c.Algorithm)
+    }
+    for vi, rp := range pl.Solution.Plans {
+        if len(rp.Order) == 0 { continue }
+        v := pl.Problem.Vehicles[vi]
+        legs, err := Timeline(pl.Problem, vi, rp, "")
+        if err != nil { return Plan{}, err }
+        cost, err := pl.Problem.RouteCostBreakdown(vi, rp)
+        if err != nil { return Plan{}, err }
+        r := Route{Vehicle: vi, VehicleID: v.ID, StartDepotID: v.StartID, EndDepotID: v.EndID, Legs: legs, Cost: cost}
+        for _, idx := range rp.Order { r.StopIDs = append(r.StopIDs, pl.Problem.Nodes[idx].ID) }
+        pl.Routes = append(pl.Routes, r)
+    }
+    pl.Unassigned = UnassignedStops(pl.Problem, pl.Solution)
+    if pl.Metrics.Cancelled { return pl, ctx.Err() }
+    return pl, nil
+}
+
+// defaultFleet is the fleet used when a request names no vehicles: one
+// unconstrained vehicle per 20 stops, at most 3.
+func defaultFleet(stops int) []Vehicle {
+    k := int(math.Min(3, math.Ceil(float64(stops)/20.0)))
+    if k <= 0 { k = 1 }
+    out := make([]Vehicle, k)
+    for i := range out { out[i].ID = fmt.Sprintf("vehicle_%d", i+1) }
+    return out
+}
+
+// Problem builds the solver problem of req without solving it: one node per
+// stop in order, pickup/delivery pairs, the vehicles with their depots and
+// caps, and the zones that constrain them. Callers that warm-start the
+// solver set InitialPlans on the result.
+func Problem(req Request) (opt.Problem, error) {
+    c := req.Constraints
+    startAt := c.StartAt
+    if startAt.IsZero() { startAt = time.Now().UTC() }
+    prob := opt.Problem{SpeedKph: 50, Matrix: c.Matrix, SpeedProfile: c.SpeedProfile, StartAt: startAt, Objectives: maps.Clone(c.Objectives),
+        BreakRules: c.Breaks, IterationsLimit: c.MaxIterations, InitialTemp: c.InitialTemp, Cooling: c.Cooling,
+        InitialRemovalWeights: c.RemovalWeights, InitialInsertionWeights: c.InsertionWeights, Parallel: c.Parallel, MustServePriority: c.MustServePriority}
+    prob.Nodes, prob.Pairs = nodes(req.Orders, c)
+    vehicles, err := resolveDepots(req)
+    if err != nil { return opt.Problem{}, err }
+    for i := range vehicles {
+        if vehicles[i].MaxRouteSec == 0 { vehicles[i].MaxRouteSec = c.MaxRouteSec }
+        if vehicles[i].MaxDistM == 0 { vehicles[i].MaxDistM = c.MaxDistM }
+    }
+    prob.Vehicles, prob.Zones = vehicles, usedZones(c.Zones, vehicles)
+    return prob, nil
+}
+
+// resolveDepots places each vehicle at its start and end depots.
+func resolveDepots(req Request) ([]opt.Vehicle, error) {
+    byID := map[string]Depot{}
+    for _, d := range req.Depots { byID[d.ID] = d }
+    defaults, err := defaultDepots(req)
+    if err != nil { return nil, err }
+    out := make([]opt.Vehicle, len(req.Vehicles))
+    for i, v := range req.Vehicles {
+        out[i] = v.Vehicle
+        if v.StartLatLng != nil || v.EndLatLng != nil { continue }
+        def := ""
+        if len(defaults) > 0 { def = defaults[i%len(defaults)].ID }
+        startID, endID := VehicleDepots(v.Depot, def)
+        if startID != "" {
+            d, ok := byID[startID]
+            if !ok { return nil, fmt.Errorf("vehicle %s: unknown start depot %s", v.ID, startID) }
+            out[i].StartLatLng, out[i].StartID = &[2]float64{d.Lat, d.Lng}, d.ID
+        }
+        if endID != "" {
+            d, ok := byID[endID]
+            if !ok { return nil, fmt.Errorf("vehicle %s: unknown end depot %s", v.ID, endID) }
+            out[i].EndLatLng, out[i].EndID = &[2]float64{d.Lat, d.Lng}, d.ID
+        }
+    }
+    return out, nil
+}
+
+// VehicleDepots returns the start and end depot IDs of vd, starting at def
+// when it names none. The end defaults to the start and is empty for open
+// routes.
+func VehicleDepots(vd model.VehicleDepot, def string) (string, string) {
+    start := vd.StartDepotID
+    if start == "" { start = def }
+    if vd.OpenRoute { return start, "" }
+    end := vd.EndDepotID
+    if end == "" { end = start }
+    return start, end
+}
+
+// usedZones returns the zones that constrain vehicles: every territory and
+// each zone some vehicle is forbidden from.
+func usedZones(zones []opt.Zone, vehicles []opt.Vehicle) []opt.Zone {
+    var out []opt.Zone
+    for _, z := range zones {
+        used := z.Territory
+        for _, v := range vehicles { used = used || slices.Contains(v.Forbidden, z.ID) }
+        if used { out = append(out, z) }
+    }
+    return out
+}
+
+// UnassignedStops lists the stops sol leaves unrouted, with the reason.
+func UnassignedStops(prob opt.Problem, sol opt.Solution) []model.UnassignedStop {
+    var out []model.UnassignedStop
+    for _, u := range sol.Unassigned { out = append(out, model.UnassignedStop{StopID: prob.Nodes[u.Node].ID, Reason: u.Reason, MustServe: prob.MustServe(u.Node)}) }
+    return out
+}
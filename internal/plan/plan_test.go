@@ -0,0 +1,166 @@
+package plan
+
+import (
+    "context"
+    "errors"
+    "slices"
+    "testing"
+    "time"
+
+    "gpsnav/internal/model"
+    "gpsnav/internal/opt"
+)
+
+func TestPickupDeliveryPairs(t *testing.T) {
+    kinds := []string{"pickup", "delivery", "delivery", "pickup", "delivery", "delivery"}
+    orders := []string{"o1", "o1", "", "o2", "o2", "o2"}
+    rides := []int{0, 0, 0, 900, 0, 0}
+    pairs := pickupDeliveryPairs(kinds, orders, rides, 3600)
+    // o2 has two deliveries and stays unpaired
+    if len(pairs) != 1 || pairs[0].Pickup != 0 || pairs[0].Delivery != 1 || pairs[0].MaxRideSec != 3600 { t.Fatalf("pairs: %+v", pairs) }
+    clusters := keepPairsTogether([][]int{{0, 2}, {1, 3}}, pairs)
+    if len(clusters[0]) != 3 || clusters[0][2] != 1 || len(clusters[1]) != 1 { t.Fatalf("clusters: %v", clusters) }
+    if got := deliveriesAfterPickups([]int{1, 2, 0}, pairs); got[0] != 2 || got[1] != 0 || got[2] != 1 { t.Fatalf("order: %v", got) }
+}
+
+func TestNodesSplitDemand(t *testing.T) {
+    orders := []Order{
+        {ID: "o1", Demand: opt.Demand{Weight: 10}, Stops: []Stop{{ID: "p", Kind: "pickup"}, {ID: "d", Kind: "delivery"}}},
+        {ID: "o2", Demand: opt.Demand{Weight: 9}, MaxRideSec: 600, Stops: []Stop{{ID: "a", Kind: "delivery"}, {ID: "b", Kind: "delivery"}, {ID: "c", Kind: "delivery", Customer: "acme"}}},
+    }
+    nd, pairs := nodes(orders, Constraints{Affinity: map[string]map[string]float64{"acme": {"d1": 1}}})
+    if len(nd) != 5 || len(pairs) != 1 || !nd[0].Pickup { t.Fatalf("nodes %+v, pairs %+v", nd, pairs) }
+    if nd[0].Demand.Weight != 10 || nd[1].Demand.Weight != 10 || nd[2].Demand.Weight != 3 { t.Fatalf("demand: %v %v %v", nd[0].Demand, nd[1].Demand, nd[2].Demand) }
+    if nd[4].Affinity["d1"] != 1 || nd[3].Affinity != nil { t.Fatalf("affinity: %v %v", nd[3].Affinity, nd[4].Affinity) }
+}
+
+func TestVehicleDepots(t *testing.T) {
+    if s, e := VehicleDepots(model.VehicleDepot{StartDepotID: "hubA", EndDepotID: "home"}, "hubB"); s != "hubA" || e != "home" { t.Fatalf("record: %s %s", s, e) }
+    if s, e := VehicleDepots(model.VehicleDepot{OpenRoute: true}, "hubB"); s != "hubB" || e != "" { t.Fatalf("open: %s %s", s, e) }
+    if s, e := VehicleDepots(model.VehicleDepot{}, "hubB"); s != "hubB" || e != "hubB" { t.Fatalf("default: %s %s", s, e) }
+}
+
+func TestUsedZones(t *testing.T) {
+    zones := []opt.Zone{{ID: "z1", Territory: true}, {ID: "z2"}, {ID: "z3"}}
+    used := usedZones(zones, []opt.Vehicle{{ID: "v1", Forbidden: []string{"z3"}}})
+    if len(used) != 2 || used[0].ID != "z1" || used[1].ID != "z3" { t.Fatalf("solver zones: %+v", used) }
+}
+
+// grid is a request of n deliveries about 1 km apart plus a pickup and
+// delivery order, served from two hubs.
+func grid(n int) Request {
+    req := Request{Depots: []Depot{{ID: "hubA", Lat: 52.50, Lng: 13.40}, {ID: "hubB", Lat: 52.55, Lng: 13.48}}}
+    for i := 0; i < n; i++ {
+        lat, lng := 52.50+float64(i%4)*0.01, 13.40+float64(i/4)*0.015
+        req.Orders = append(req.Orders, Order{ID: "o" + string(rune('a'+i)), Stops: []Stop{{ID: "s" + string(rune('a'+i)), Kind: "delivery", Lat: lat, Lng: lng, ServiceSec: 300}}})
+    }
+    req.Orders = append(req.Orders, Order{ID: "pd", Stops: []Stop{{ID: "pick", Kind: "pickup", Lat: 52.54, Lng: 13.47, ServiceSec: 120}, {ID: "drop", Kind: "delivery", Lat: 52.51, Lng: 13.41, ServiceSec: 120}}})
+    return req
+}
+
+// checkRoutes asserts every stop of req is routed once and each pickup
+// precedes its delivery.
+func checkRoutes(t *testing.T, req Request, pl Plan) {
+    t.Helper()
+    seen := map[string]bool{}
+    for _, r := range pl.Routes {
+        for _, id := range r.StopIDs {
+            if seen[id] { t.Fatalf("stop %s routed twice", id) }
+            seen[id] = true
+        }
+        if p, d := slices.Index(r.StopIDs, "pick"), slices.Index(r.StopIDs, "drop"); (p < 0) != (d < 0) || d < p { t.Fatalf("route %s splits or reverses the pair: %v", r.VehicleID, r.StopIDs) }
+        for k := 1; k < len(r.Legs); k++ {
+            if r.Legs[k].Arrival.Before(r.Legs[k-1].Departure) { t.Fatalf("route %s leg %d arrives before the previous departure", r.VehicleID, k) }
+        }
+    }
+    for _, o := range req.Orders {
+        for _, s := range o.Stops { if !seen[s.ID] { t.Fatalf("stop %s not routed (unassigned %v)", s.ID, pl.Unassigned) } }
+    }
+}
+
+func TestSolveGreedy(t *testing.T) {
+    req := grid(10)
+    req.Vehicles = []Vehicle{{Vehicle: opt.Vehicle{ID: "v1"}}, {Vehicle: opt.Vehicle{ID: "v2"}}}
+    req.Constraints = Constraints{StartAt: time.Date(2025, 3, 3, 8, 0, 0, 0, time.UTC)}
+    pl, err := Solve(context.Background(), req)
+    if err != nil { t.Fatal(err) }
+    if len(pl.Routes) != 2 { t.Fatalf("routes: %d", len(pl.Routes)) }
+    checkRoutes(t, req, pl)
+    for _, r := range pl.Routes {
+        if r.StartDepotID == "" || r.StartDepotID != r.EndDepotID { t.Fatalf("route %s depots %q/%q", r.VehicleID, r.StartDepotID, r.EndDepotID) }
+        if last := r.Legs[len(r.Legs)-1]; last.ToStopID != "" { t.Fatalf("route %s does not return to its depot", r.VehicleID) }
+        if r.Cost.DistanceM <= 0 { t.Fatalf("route %s cost %+v", r.VehicleID, r.Cost) }
+    }
+}
+
+func TestSolveGreedyKeepsVehicleRecord(t *testing.T) {
+    req := grid(10)
+    start := time.Date(2025, 3, 3, 8, 0, 0, 0, time.UTC)
+    req.Vehicles = []Vehicle{{Vehicle: opt.Vehicle{ID: "v1", ShiftStart: start.Add(2 * time.Hour), CapWeight: 5}, Depot: model.VehicleDepot{StartDepotID: "hubB", OpenRoute: true}}}
+    req.Constraints = Constraints{StartAt: start}
+    pl, err := Solve(context.Background(), req)
+    if err != nil { t.Fatal(err) }
+    r := pl.Routes[0]
+    if r.StartDepotID != "hubB" || r.EndDepotID != "" { t.Fatalf("route depots %q/%q, want the vehicle's open route from hubB", r.StartDepotID, r.EndDepotID) }
+    if r.Legs[0].Arrival.Before(start.Add(2 * time.Hour)) { t.Fatalf("route leaves before the shift starts: %v", r.Legs[0].Arrival) }
+    if pl.Problem.Vehicles[0].CapWeight != 5 { t.Fatalf("vehicle limits dropped: %+v", pl.Problem.Vehicles[0]) }
+}
+
+func TestSolveGreedyKeepsVehicleLimits(t *testing.T) {
+    req := grid(10)
+    for i := 0; i < 10; i++ { req.Orders[i].Demand = opt.Demand{Weight: 1} }
+    req.Vehicles = []Vehicle{{Vehicle: opt.Vehicle{ID: "v1", CapWeight: 2}}, {Vehicle: opt.Vehicle{ID: "v2", CapWeight: 3}}}
+    req.Constraints = Constraints{StartAt: time.Date(2025, 3, 3, 8, 0, 0, 0, time.UTC)}
+    pl, err := Solve(context.Background(), req)
+    if err != nil { t.Fatal(err) }
+    routed := 0
+    for _, r := range pl.Routes {
+        load := 0
+        for _, id := range r.StopIDs { if id != "pick" && id != "drop" { load++ } }
+        if cap := int(pl.Problem.Vehicles[r.Vehicle].CapWeight); load > cap { t.Fatalf("route %s carries %d over its capacity %d: %v", r.VehicleID, load, cap, r.StopIDs) }
+        routed += len(r.StopIDs)
+    }
+    if routed != 7 || len(pl.Unassigned) != 5 { t.Fatalf("%d routed, unassigned %+v", routed, pl.Unassigned) }
+    for _, u := range pl.Unassigned { if u.Reason != opt.ReasonCapacity { t.Fatalf("unassigned %+v", u) } }
+}
+
+func TestSolveALNSPlansBreaks(t *testing.T) {
+    req := grid(10)
+    req.Vehicles = []Vehicle{{Vehicle: opt.Vehicle{ID: "v1"}, Depot: model.VehicleDepot{StartDepotID: "hubA", OpenRoute: true}}}
+    req.Constraints = Constraints{Algorithm: ALNS, StartAt: time.Date(2025, 3, 3, 8, 0, 0, 0, time.UTC), MaxIterations: 200, Seed: 7,
+        Objectives: DefaultObjectives, Breaks: opt.BreakRules{MaxDriveSec: 600, MinBreakSec: 900}}
+    pl, err := Solve(context.Background(), req)
+    if err != nil { t.Fatal(err) }
+    if len(pl.Routes) != 1 || pl.Metrics.Iterations == 0 { t.Fatalf("routes %d, metrics %+v", len(pl.Routes), pl.Metrics) }
+    checkRoutes(t, req, pl)
+    r := pl.Routes[0]
+    if r.StartDepotID != "hubA" || r.EndDepotID != "" || r.Legs[len(r.Legs)-1].ToStopID == "" { t.Fatalf("open route from hubA: %q/%q", r.StartDepotID, r.EndDepotID) }
+    breaks, drive := 0, 0
+    for _, lg := range r.Legs {
+        if lg.Kind == "break" {
+            breaks++
+            if lg.BreakSec != 900 || lg.Departure.Sub(lg.Arrival) != 15*time.Minute { t.Fatalf("break leg %+v", lg) }
+            drive = 0
+            continue
+        }
+        if drive += lg.DriveSec; drive > 600 { t.Fatalf("%ds of driving without a break", drive) }
+    }
+    if breaks == 0 || breaks != len(pl.Solution.Plans[0].Breaks) { t.Fatalf("%d break legs, solver planned %+v", breaks, pl.Solution.Plans[0].Breaks) }
+}
+
+func TestSolveCancelledKeepsBestPlan(t *testing.T) {
+    req := grid(10)
+    req.Vehicles = []Vehicle{{Vehicle: opt.Vehicle{ID: "v1"}}}
+    req.Constraints = Constraints{Algorithm: ALNS, StartAt: time.Date(2025, 3, 3, 8, 0, 0, 0, time.UTC), Seed: 7, Objectives: DefaultObjectives}
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+    pl, err := Solve(ctx, req)
+    if !errors.Is(err, context.Canceled) || !pl.Metrics.Cancelled { t.Fatalf("err %v, metrics %+v", err, pl.Metrics) }
+    checkRoutes(t, req, pl)
+}
+
+func TestSolveWithoutStops(t *testing.T) {
+    pl, err := Solve(context.Background(), Request{Constraints: Constraints{Algorithm: "tabu"}})
+    if err != nil || len(pl.Routes) != 0 { t.Fatalf("empty request: %+v, %v", pl, err) }
+    if _, err := Solve(context.Background(), Request{Orders: grid(1).Orders, Constraints: Constraints{Algorithm: "tabu"}}); err == nil { t.Fatal("unknown algorithm accepted") }
+}
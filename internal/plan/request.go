@@ -0,0 +1,56 @@
+package plan
+
+import (
+    "encoding/json"
+    "fmt"
+    "strings"
+    "time"
+
+    "gpsnav/internal/model"
+    "gpsnav/internal/opt"
+)
+
+// DefaultObjectives are the ALNS weights a request's objectives overlay.
+var DefaultObjectives = map[string]float64{"driveTime": 1, "lateness": 4, "earliness": 0, "failed": 50, "priority": 0, "distance": 0.1, "vehicleCost": 1, "vehicles": 0}
+
+// FromRequest reads the planner constraints of an optimize request: the
+// algorithm and its search settings, objectives over DefaultObjectives, and
+// the break rules (see BreakRulesFrom) and the maxRideSec, maxRouteSec,
+// maxDistanceM and mustServePriority constraints. Tenant settings (matrix,
+// speed profile, operator weights, zones, affinity) are left to the caller.
+func FromRequest(req model.OptimizeRequest) Constraints {
+    c := Constraints{Algorithm: strings.ToLower(req.Algorithm), StartAt: time.Now().UTC(), TimeBudget: time.Duration(req.TimeBudgetMs) * time.Millisecond,
+        MaxIterations: req.MaxIterations, Seed: req.Seed, Parallel: req.Parallel, InitialTemp: req.InitTemp, Cooling: req.Cooling,
+        RemovalWeights: req.RemovalWeights, InsertionWeights: req.InsertionWeights, Objectives: map[string]float64{},
+        MaxRideSec: IntConstraint(req.Constraints, "maxRideSec"),
+        MaxRouteSec: IntConstraint(req.Constraints, "maxRouteSec"), MaxDistM: float64(IntConstraint(req.Constraints, "maxDistanceM")),
+        MustServePriority: IntConstraint(req.Constraints, "mustServePriority")}
+    c.Breaks, _ = BreakRulesFrom(req.Constraints) // validated by the API
+    for k, v := range DefaultObjectives { c.Objectives[k] = v }
+    for k, v := range req.Objectives { c.Objectives[k] = v }
+    return c
+}
+
+// BreakRulesFrom reads the driver break rules of request constraints:
+// hosMaxDriveSec (continuous drive), maxDutySec (time on duty), breakSec
+// (break length, default 1800), breakWindows ([{from, to}] "HH:MM" periods a
+// break must start in, e.g. lunch) and breakTimezone (IANA, for the windows).
+func BreakRulesFrom(c map[string]any) (opt.BreakRules, error) {
+    r := opt.BreakRules{MaxDriveSec: IntConstraint(c, "hosMaxDriveSec"), MaxDutySec: IntConstraint(c, "maxDutySec"), MinBreakSec: 1800}
+    if v := IntConstraint(c, "breakSec"); v > 0 { r.MinBreakSec = v }
+    r.Timezone, _ = c["breakTimezone"].(string)
+    if raw, ok := c["breakWindows"]; ok && raw != nil {
+        b, _ := json.Marshal(raw)
+        if err := json.Unmarshal(b, &r.Windows); err != nil { return opt.BreakRules{}, fmt.Errorf("breakWindows: want [{from, to}]") }
+    }
+    return r, r.Validate()
+}
+
+// IntConstraint reads a numeric planner constraint; 0 when absent.
+func IntConstraint(c map[string]any, key string) int {
+    switch x := c[key].(type) {
+    case float64: return int(x)
+    case int: return x
+    }
+    return 0
+}
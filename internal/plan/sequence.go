@@ -0,0 +1,61 @@
+package plan
+
+import (
+    "context"
+    "fmt"
+
+    "gpsnav/internal/opt"
+)
+
+// Violation is a planning rule a route breaks; StopID is empty when it is
+// the route as a whole.
+type Violation struct {
+    StopID string
+    Reason string // opt reason code
+}
+
+// Sequenced is one route timed as given by Sequence.
+type Sequenced struct {
+    Legs       []Leg
+    Cost       opt.CostBreakdown
+    Violations []Violation
+}
+
+// Sequence times routes as given, one per req.Vehicles, each listing its
+// stop IDs in order, without solving: the legs from the vehicle's start
+// (FromStopID from[vi] on the first leg, see Timeline), the route cost and
+// the rules the route breaks. Infeasible routes are timed all the same, so
+// callers can apply manual edits and report what they break.
+func Sequence(ctx context.Context, req Request, routes [][]string, from []string) ([]Sequenced, error) {
+    if len(routes) != len(req.Vehicles) || len(from) != len(routes) { return nil, fmt.Errorf("%d routes for %d vehicles", len(routes), len(req.Vehicles)) }
+    prob, err := Problem(req)
+    if err != nil { return nil, err }
+    nodeOf := map[string]int{}
+    for i, nd := range prob.Nodes { nodeOf[nd.ID] = i }
+    plans := make([]opt.RoutePlan, len(routes))
+    for vi, stops := range routes {
+        plans[vi].VehicleID = prob.Vehicles[vi].ID
+        for _, sid := range stops {
+            idx, ok := nodeOf[sid]
+            if !ok { return nil, fmt.Errorf("stop %s: %w", sid, ErrUnknownStop) }
+            plans[vi].Order = append(plans[vi].Order, idx)
+        }
+    }
+    if err := prob.Prepare(ctx); err != nil { return nil, fmt.Errorf("distance matrix: %w", err) }
+    out := make([]Sequenced, len(plans))
+    for vi, rp := range plans {
+        legs, err := Timeline(prob, vi, rp, from[vi])
+        if err != nil { return nil, err }
+        cost, err := prob.RouteCostBreakdown(vi, rp)
+        if err != nil { return nil, err }
+        vs, err := prob.Violations(vi, rp)
+        if err != nil { return nil, err }
+        out[vi] = Sequenced{Legs: legs, Cost: cost}
+        for _, v := range vs {
+            sv := Violation{Reason: v.Reason}
+            if v.Node >= 0 { sv.StopID = prob.Nodes[v.Node].ID }
+            out[vi].Violations = append(out[vi].Violations, sv)
+        }
+    }
+    return out, nil
+}
@@ -0,0 +1,58 @@
+package plan
+
+import (
+    "math"
+    "slices"
+    "time"
+
+    "gpsnav/internal/opt"
+)
+
+// Timeline returns the legs of plan pl on vehicle vi of a prepared problem,
+// timed from the vehicle's route start (now when unset) with the problem's
+// speed profile. Arrivals wait for time windows to open, and the breaks the
+// solver plans (see opt.Problem.Breaks) become break legs before the drives
+// they precede. A vehicle without a start location reaches its first stop by
+// an empty leg. from is the FromStopID of a first leg that does not leave a
+// depot, e.g. when a route continues from a visited stop. It fails only on
+// an unprepared problem (opt.ErrUnprepared).
+func Timeline(prob opt.Problem, vi int, pl opt.RoutePlan, from string) ([]Leg, error) {
+    curr := prob.RouteStart(vi)
+    if curr.IsZero() { curr = time.Now().UTC() }
+    legs, err := prob.Legs(vi, pl)
+    if err != nil { return nil, err }
+    breaks, err := prob.Breaks(vi, pl)
+    if err != nil { return nil, err }
+    if len(pl.Order) > 0 && (len(legs) == 0 || legs[0].To != pl.Order[0]) {
+        // no start location: the route begins at its first stop
+        legs = append([]opt.PlannedLeg{{From: -1, To: pl.Order[0]}}, legs...)
+    }
+    var out []Leg
+    for _, lg := range legs {
+        // position in pl.Order of the stop the leg leads to
+        pos := 0
+        if lg.From >= 0 { pos = slices.Index(pl.Order, lg.From) + 1 }
+        for len(breaks) > 0 && breaks[0].Pos <= pos {
+            b := breaks[0]
+            if b.Start.After(curr) { curr = b.Start } // idle until a break window opens
+            end := curr.Add(time.Duration(b.Sec) * time.Second)
+            out = append(out, Leg{Kind: "break", BreakSec: b.Sec, Arrival: curr, Departure: end})
+            curr, breaks = end, breaks[1:]
+        }
+        drive, err := prob.DriveTimeAt(lg, curr)
+        if err != nil { return nil, err }
+        leg := Leg{Kind: "drive", FromStopID: from, DistM: int(math.Round(lg.DistM)), DriveSec: int(math.Round(drive))}
+        if lg.From >= 0 { leg.FromStopID = prob.Nodes[lg.From].ID }
+        leg.Arrival = curr.Add(time.Duration(leg.DriveSec) * time.Second)
+        leg.Departure = leg.Arrival // no service time at depots
+        if lg.To >= 0 {
+            nd := prob.Nodes[lg.To]
+            leg.ToStopID = nd.ID
+            leg.Arrival = nd.ServiceStart(leg.Arrival)
+            leg.Departure = leg.Arrival.Add(time.Duration(nd.ServiceSec) * time.Second)
+        }
+        out = append(out, leg)
+        curr = leg.Departure
+    }
+    return out, nil
+}
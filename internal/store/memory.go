@@ -2,21 +2,27 @@ package store
 
 import (
     "context"
+    "errors"
     "fmt"
+    "slices"
     "sync"
     "time"
 
     "github.com/google/uuid"
     "gpsnav/internal/model"
+    "gpsnav/internal/opt"
+    "gpsnav/internal/plan"
 )
 
 // Memory is a simple in-memory store used when no DATABASE_URL is set.
 type Memory struct {
     mu     sync.Mutex
     orders map[string]model.OrderOut            // id -> order
+    imported map[string]memOrder                // id -> order as imported
     byTen  map[string][]string                  // tenant -> order ids
     routes map[string]model.Route               // id -> route
     routesTen map[string][]string               // tenant -> route ids
+    planned map[string]opt.Vehicle              // route id -> vehicle as planned, depots placed
     hos    map[string]map[string]any            // driverId -> HOS state
     gfs    map[string]model.Geofence            // geofenceId -> geofence
     gfsTen map[string][]string                  // tenant -> geofence ids
@@ -32,9 +38,11 @@ type Memory struct {
 func NewMemory() *Memory {
     return &Memory{
         orders: map[string]model.OrderOut{},
+        imported: map[string]memOrder{},
         byTen: map[string][]string{},
         routes: map[string]model.Route{},
         routesTen: map[string][]string{},
+        planned: map[string]opt.Vehicle{},
         hos: map[string]map[string]any{},
         gfs: map[string]model.Geofence{},
         gfsTen: map[string][]string{},
@@ -47,6 +55,12 @@ func NewMemory() *Memory {
     }
 }
 
+// memOrder is an imported order and the IDs given to its stops.
+type memOrder struct {
+    in      model.OrderIn
+    stopIDs []string
+}
+
 // memDelivery augments WebhookDelivery with scheduling/metrics
 type memDelivery struct {
     WebhookDelivery
@@ -63,6 +77,9 @@ func (m *Memory) CreateOrders(ctx context.Context, tenantID string, orders []mod
     for _, o := range orders {
         id := uuid.New().String()
         m.orders[id] = model.OrderOut{ID: id, TenantID: tenantID, ExternalRef: o.ExternalRef, Priority: o.Priority, Status: "pending"}
+        mo := memOrder{in: o}
+        for range o.Stops { mo.stopIDs = append(mo.stopIDs, uuid.New().String()) }
+        m.imported[id] = mo
         m.byTen[tenantID] = append(m.byTen[tenantID], id)
         created++
     }
@@ -166,30 +183,57 @@ func (m *Memory) DeleteSubscription(ctx context.Context, tenantID, id string) er
     return nil
 }
 
-func (m *Memory) PlanRoutes(ctx context.Context, req model.OptimizeRequest) ([]model.Route, string, error) {
+// PlanRoutes plans the tenant's pending orders with the request's algorithm,
+// starting routes at hub geofences. Reoptimizing keeps the plan date's routes
+// and bumps the version of those not frozen.
+func (m *Memory) PlanRoutes(ctx context.Context, req model.OptimizeRequest) (model.PlanResult, error) {
+    if req.Reoptimize {
+        if res := m.replan(req); res.Routes != nil { return res, nil }
+    }
+    m.mu.Lock()
+    orders, depots := m.planOrders(req.TenantID), m.depots(req.TenantID)
+    m.mu.Unlock()
+    var vehicles []plan.Vehicle
+    for _, vid := range req.VehiclePool { vehicles = append(vehicles, plan.Vehicle{Vehicle: opt.Vehicle{ID: vid}, Depot: req.VehicleDepots[vid]}) }
+    c := plan.FromRequest(req)
+    cfg, _ := m.GetOptimizerConfig(ctx, req.TenantID)
+    applyBalance(cfg, req, &c)
+    pl, err := plan.Solve(ctx, plan.Request{Orders: orders, Vehicles: vehicles, Depots: depots, DefaultDepots: req.Depots, Constraints: c})
+    if err != nil { return model.PlanResult{}, err } // a cancelled job persists nothing
+
     m.mu.Lock(); defer m.mu.Unlock()
-    id := uuid.New().String()
-    // Create a tiny fake route with two legs for demo
-    r := model.Route{ID: id, Version: 1, PlanDate: req.PlanDate, Status: "planned", Legs: []model.Leg{
-        {ID: uuid.New().String(), Seq: 1, FromStopID: "s1", ToStopID: "s2", Status: "in_progress"},
-        {ID: uuid.New().String(), Seq: 2, FromStopID: "s2", ToStopID: "s3", Status: "pending"},
-    }}
-    m.routes[id] = r
-    m.routesTen[req.TenantID] = append(m.routesTen[req.TenantID], id)
-    // Record simple planner metrics for admin views
-    algo := req.Algorithm
-    if algo == "" { algo = "greedy" }
+    results := []model.Route{}
+    for _, pr := range pl.Routes {
+        r := model.Route{ID: uuid.New().String(), Version: 1, PlanDate: req.PlanDate, BatchID: "opt_mem", Status: "planned", CostBreakdown: costBreakdownMap(pr.Cost)}
+        appendMemLegs(&r, pr.Legs, true)
+        m.planned[r.ID] = pl.Problem.Vehicles[pr.Vehicle]
+        results = append(results, r)
+    }
+    if len(orders) == 0 {
+        // Create empty route
+        results = append(results, model.Route{ID: uuid.New().String(), Version: 1, PlanDate: req.PlanDate, BatchID: "opt_mem", Status: "planned"})
+    }
+    for _, r := range results {
+        m.routes[r.ID] = r
+        m.routesTen[req.TenantID] = append(m.routesTen[req.TenantID], r.ID)
+    }
+    // Record planner metrics for admin views
+    algo := c.Algorithm
+    if algo == "" { algo = plan.Greedy }
+    if algo == plan.ALNS { opt.RecordMetrics(req.TenantID, req.PlanDate, algo, pl.Metrics) }
     if m.planMx[req.TenantID] == nil { m.planMx[req.TenantID] = map[string][]map[string]any{} }
     items := m.planMx[req.TenantID][req.PlanDate]
+    pm := pl.Metrics
     met := map[string]any{
         "algo": algo,
-        "iterations": 1,
-        "improvements": 0,
-        "acceptedWorse": 0,
-        "bestCost": 0.0,
-        "finalCost": 0.0,
-        "removalSelects": []int{1, 0},
-        "insertSelects": []int{1, 0},
+        "iterations": pm.Iterations,
+        "improvements": pm.Improvements,
+        "acceptedWorse": pm.AcceptedWorse,
+        "bestCost": pm.BestCost,
+        "finalCost": pm.FinalCost,
+        "removalSelects": pm.RemovalSelects,
+        "insertSelects": pm.InsertSelects,
+        "seed": pm.Seed,
     }
     // upsert per algo
     replaced := false
@@ -198,7 +242,189 @@ func (m *Memory) PlanRoutes(ctx context.Context, req model.OptimizeRequest) ([]m
     }
     if !replaced { items = append(items, met) }
     m.planMx[req.TenantID][req.PlanDate] = items
-    return []model.Route{r}, "opt_mem", nil
+    return model.PlanResult{BatchID: "opt_mem", Routes: results, Unassigned: pl.Unassigned}, nil
+}
+
+// ExplainInsertion prices req.StopID at every position of the requested
+// routes (default: the plan date's routes not completed), each run by the
+// vehicle and depots it was planned with. Nothing is written.
+func (m *Memory) ExplainInsertion(ctx context.Context, req model.ExplainRequest) (model.ExplainResult, error) {
+    m.mu.Lock()
+    orders := m.planOrders(req.TenantID)
+    var vehicles []plan.Vehicle
+    var routes [][]string
+    var routeIDs []string
+    for _, rid := range m.routesTen[req.TenantID] {
+        r := m.routes[rid]
+        if len(req.RouteIDs) > 0 && !slices.Contains(req.RouteIDs, rid) { continue }
+        if len(req.RouteIDs) == 0 && (r.PlanDate != req.PlanDate || r.Status == "completed") { continue }
+        v, ok := m.planned[rid]
+        if !ok { v = opt.Vehicle{ID: rid} }
+        vehicles, routes, routeIDs = append(vehicles, plan.Vehicle{Vehicle: v}), append(routes, routeStops(r)), append(routeIDs, rid)
+    }
+    m.mu.Unlock()
+    oreq := explainOptimizeRequest(req)
+    c := plan.FromRequest(oreq)
+    cfg, _ := m.GetOptimizerConfig(ctx, req.TenantID)
+    applyBalance(cfg, oreq, &c)
+    ex, err := plan.Explain(ctx, plan.Request{Orders: orders, Vehicles: vehicles, Constraints: c}, routes, req.StopID)
+    if errors.Is(err, plan.ErrUnknownStop) { return model.ExplainResult{}, ErrNotFound }
+    if err != nil { return model.ExplainResult{}, err }
+    return explainResult(req.StopID, routeIDs, ex), nil
+}
+
+// EditRoute applies patch.Ops across the plan date's routes not completed.
+// Visited and in-progress legs stay; edited tails are retimed from the stop
+// each vehicle is at and checked against the planning rules, broken rules
+// coming back as warnings. Each changed route gets its version bumped.
+func (m *Memory) EditRoute(ctx context.Context, tenantID, routeID string, version int, patch model.RoutePatch) (model.RouteEditResult, error) {
+    m.mu.Lock()
+    r, ok := m.routes[routeID]
+    if !ok { m.mu.Unlock(); return model.RouteEditResult{}, ErrNotFound }
+    if r.Version != version { m.mu.Unlock(); return model.RouteEditResult{}, ErrVersionConflict }
+    orders := m.planOrders(tenantID)
+    var ids []string
+    tails := map[string]routeTail{}
+    stops := map[string][]string{}
+    fixed := map[string]bool{}
+    for _, rid := range m.routesTen[tenantID] {
+        ar := m.routes[rid]
+        if ar.PlanDate != r.PlanDate || ar.Status == "completed" || ar.Status == "cancelled" { continue }
+        t := splitRoute(activeRoute{id: rid}, ar, "")
+        for _, lg := range ar.Legs[:t.fixed] { fixed[lg.ToStopID] = true }
+        if t.ended { continue }
+        ids, tails[rid], stops[rid] = append(ids, rid), t, t.stops
+    }
+    changed, err := applyRouteOps(stops, routeID, patch.Ops)
+    if err != nil { m.mu.Unlock(); return model.RouteEditResult{}, err }
+    changed[routeID] = true // the patched route is always retimed and versioned
+    var edited []routeTail
+    var vehicles []plan.Vehicle
+    var routes [][]string
+    var from []string
+    for _, rid := range ids {
+        if !changed[rid] { continue }
+        t := tails[rid]
+        v, ok := m.planned[rid]
+        if !ok { v = opt.Vehicle{ID: rid} }
+        if ll, ok := m.stopLatLng(tenantID, t.anchor); ok {
+            v.StartLatLng, v.StartID = &ll, t.anchor
+            if t.anchorDep.After(v.ShiftStart) { v.ShiftStart = t.anchorDep }
+        }
+        if !t.returns { v.EndLatLng, v.EndID = nil, "" }
+        edited, vehicles, routes, from = append(edited, t), append(vehicles, plan.Vehicle{Vehicle: v}), append(routes, stops[rid]), append(from, t.anchor)
+    }
+    m.mu.Unlock()
+    oreq := model.OptimizeRequest{TenantID: tenantID, PlanDate: r.PlanDate, Constraints: patch.Constraints}
+    c := plan.FromRequest(oreq)
+    cfg, _ := m.GetOptimizerConfig(ctx, tenantID)
+    applyBalance(cfg, oreq, &c)
+    seqs, err := plan.Sequence(ctx, plan.Request{Orders: withoutStops(orders, fixed), Vehicles: vehicles, Constraints: c}, routes, from)
+    if err != nil { return model.RouteEditResult{}, err }
+
+    m.mu.Lock(); defer m.mu.Unlock()
+    for _, t := range edited {
+        if m.routes[t.route.ID].Version != t.route.Version { return model.RouteEditResult{}, ErrVersionConflict }
+    }
+    var res model.RouteEditResult
+    for i, t := range edited {
+        er := m.routes[t.route.ID]
+        inProgress := false
+        for _, lg := range er.Legs[:t.fixed] { if lg.Status == "in_progress" { inProgress = true } }
+        er.Legs, er.BreaksCount, er.TotalBreakSec = slices.Clone(er.Legs[:t.fixed]), 0, 0
+        for _, lg := range er.Legs { if lg.Kind == "break" { er.BreaksCount++; er.TotalBreakSec += lg.BreakSec } }
+        appendMemLegs(&er, seqs[i].Legs, !inProgress)
+        er.CostBreakdown = costBreakdownMap(seqs[i].Cost)
+        er.Version++
+        m.routes[er.ID] = er
+        res.Warnings = append(res.Warnings, routeWarnings(er.ID, seqs[i])...)
+        if er.ID == routeID { res.Route = er } else { res.Changed = append(res.Changed, er) }
+    }
+    if res.Route.ID == "" { res.Route = m.routes[routeID] }
+    return res, nil
+}
+
+// appendMemLegs appends legs to r after its current legs, the first marked
+// in progress when active.
+func appendMemLegs(r *model.Route, legs []plan.Leg, active bool) {
+    seq := len(r.Legs)
+    if seq > 0 { seq = r.Legs[seq-1].Seq }
+    for i, lg := range legs {
+        status := "pending"
+        if i == 0 && active { status = "in_progress" }
+        r.Legs = append(r.Legs, model.Leg{ID: uuid.New().String(), Seq: seq + i + 1, Kind: lg.Kind, BreakSec: lg.BreakSec, FromStopID: lg.FromStopID, ToStopID: lg.ToStopID, DistM: lg.DistM, DriveSec: lg.DriveSec,
+            ETAArrival: lg.Arrival.Format(time.RFC3339), ETADeparture: lg.Departure.Format(time.RFC3339), Status: status})
+        if lg.Kind == "break" { r.BreaksCount++; r.TotalBreakSec += lg.BreakSec }
+    }
+}
+
+// stopLatLng returns the location of one of the tenant's imported stops.
+func (m *Memory) stopLatLng(tenantID, stopID string) ([2]float64, bool) {
+    if stopID == "" { return [2]float64{}, false }
+    for _, id := range m.byTen[tenantID] {
+        mo := m.imported[id]
+        for i, s := range mo.in.Stops {
+            if mo.stopIDs[i] == stopID && s.Location != nil { return [2]float64{s.Location.Lat, s.Location.Lng}, true }
+        }
+    }
+    return [2]float64{}, false
+}
+
+// replan keeps the plan date's routes and bumps the version of those not
+// frozen; nil Routes when the plan date has none.
+func (m *Memory) replan(req model.OptimizeRequest) model.PlanResult {
+    m.mu.Lock(); defer m.mu.Unlock()
+    frozen := map[string]bool{}
+    if req.Freeze != nil { for _, id := range req.Freeze.Routes { frozen[id] = true } }
+    var out []model.Route
+    for _, rid := range m.routesTen[req.TenantID] {
+        r := m.routes[rid]
+        if r.PlanDate != req.PlanDate || r.Status == "completed" { continue }
+        if !frozen[rid] { r.Version++; m.routes[rid] = r }
+        out = append(out, r)
+    }
+    return model.PlanResult{BatchID: "opt_mem", Routes: out}
+}
+
+// planOrders returns the tenant's pending orders as plan orders; stops
+// without a location are left out.
+func (m *Memory) planOrders(tenantID string) []plan.Order {
+    var out []plan.Order
+    for _, id := range m.byTen[tenantID] {
+        if m.orders[id].Status != "pending" { continue }
+        mo := m.imported[id]
+        o := plan.Order{ID: id, Priority: mo.in.Priority, ServiceLevel: mo.in.ServiceLevel, Demand: orderDemand(mo.in.Attributes), MaxRideSec: int(numeric(mo.in.Attributes["maxRideSec"]))}
+        for i, s := range mo.in.Stops {
+            if s.Location == nil { continue }
+            o.Stops = append(o.Stops, plan.Stop{ID: mo.stopIDs[i], Kind: s.Type, Lat: s.Location.Lat, Lng: s.Location.Lng, ServiceSec: s.ServiceTimeSec, Windows: stopWindows(s),
+                SoftTW: s.TimeWindowMode == "soft", MaxLateSec: s.MaxLatenessSec, Skills: s.RequiredSkills})
+        }
+        if len(o.Stops) > 0 { out = append(out, o) }
+    }
+    return out
+}
+
+// depots returns the tenant's hub geofences with a centre.
+func (m *Memory) depots(tenantID string) []plan.Depot {
+    var out []plan.Depot
+    for _, id := range m.gfsTen[tenantID] {
+        if g := m.gfs[id]; g.Type == "hub" && g.Center != nil { out = append(out, plan.Depot{ID: g.ID, Lat: g.Center.Lat, Lng: g.Center.Lng}) }
+    }
+    return out
+}
+
+// stopWindows parses a stop's RFC 3339 windows; windows missing either end
+// or failing to parse are dropped, as in timeWindowsLiteral.
+func stopWindows(s model.StopIn) []opt.TW {
+    ws := s.TimeWindows
+    if s.TimeWindow != nil { ws = append([]model.TimeWindow{*s.TimeWindow}, ws...) }
+    var out []opt.TW
+    for _, w := range ws {
+        start, err1 := time.Parse(time.RFC3339, w.Start)
+        end, err2 := time.Parse(time.RFC3339, w.End)
+        if err1 == nil && err2 == nil { out = append(out, opt.TW{Start: start, End: end}) }
+    }
+    return out
 }
 
 func (m *Memory) AdvanceRoute(ctx context.Context, tenantID, routeID string, req model.AdvanceRequest) (model.AdvanceResponse, error) {
@@ -271,7 +497,7 @@ func (m *Memory) UpdateHOS(ctx context.Context, tenantID, driverID string, upd m
 func (m *Memory) CreateGeofence(ctx context.Context, tenantID string, in model.GeofenceInput) (model.Geofence, error) {
     m.mu.Lock(); defer m.mu.Unlock()
     id := uuid.New().String()
-    gf := model.Geofence{ID: id, TenantID: tenantID, Name: in.Name, Type: in.Type, RadiusM: in.RadiusM, Center: in.Center, Rules: in.Rules}
+    gf := model.Geofence{ID: id, TenantID: tenantID, Name: in.Name, Type: in.Type, RadiusM: in.RadiusM, Center: in.Center, Polygon: in.Polygon, Rules: in.Rules}
     m.gfs[id] = gf
     m.gfsTen[tenantID] = append(m.gfsTen[tenantID], id)
     return gf, nil
@@ -312,6 +538,7 @@ func (m *Memory) PatchGeofence(ctx context.Context, tenantID, id string, in mode
     if in.Type != "" { gf.Type = in.Type }
     if in.RadiusM != 0 { gf.RadiusM = in.RadiusM }
     if in.Center != nil { gf.Center = in.Center }
+    if in.Polygon != nil { gf.Polygon = in.Polygon }
     if in.Rules != nil { gf.Rules = in.Rules }
     m.gfs[id] = gf
     return gf, nil
@@ -0,0 +1,29 @@
+package store
+
+import (
+    "context"
+    "testing"
+
+    "gpsnav/internal/model"
+)
+
+func TestMemoryPlanRoutes(t *testing.T) {
+    ctx := context.Background()
+    m := NewMemory()
+    if _, err := m.CreateGeofence(ctx, "t1", model.GeofenceInput{Name: "hub", Type: "hub", Center: &model.GeoPoint{Lat: 52.5, Lng: 13.4}}); err != nil { t.Fatal(err) }
+    win := &model.TimeWindow{Start: "2030-01-01T09:00:00Z", End: "2030-01-01T17:00:00Z"}
+    _, _, _, err := m.CreateOrders(ctx, "t1", []model.OrderIn{
+        {Stops: []model.StopIn{{Type: "pickup", Location: &model.GeoPoint{Lat: 52.51, Lng: 13.41}}, {Type: "delivery", Location: &model.GeoPoint{Lat: 52.53, Lng: 13.45}, TimeWindow: win}}},
+        {Stops: []model.StopIn{{Type: "delivery", Location: &model.GeoPoint{Lat: 52.49, Lng: 13.38}, ServiceTimeSec: 300}, {Type: "delivery"}}},
+    })
+    if err != nil { t.Fatal(err) }
+    res, err := m.PlanRoutes(ctx, model.OptimizeRequest{TenantID: "t1", PlanDate: "2030-01-01", Algorithm: "alns", MaxIterations: 50, Seed: 1, VehiclePool: []string{"v1"}})
+    if err != nil { t.Fatal(err) }
+    if len(res.Routes) != 1 || len(res.Unassigned) != 0 { t.Fatalf("routes %d, unassigned %v", len(res.Routes), res.Unassigned) }
+    r := res.Routes[0]
+    // hub -> 3 located stops -> hub
+    if len(r.Legs) != 4 || r.Legs[0].Status != "in_progress" || r.Legs[0].FromStopID != "" || r.Legs[3].ToStopID != "" || r.CostBreakdown["distanceM"] <= 0 { t.Fatalf("route: %+v", r) }
+    if r.Legs[1].ETAArrival == "" || r.Legs[1].Seq != 2 { t.Fatalf("leg: %+v", r.Legs[1]) }
+    if got, _ := m.GetRoute(ctx, "t1", r.ID); len(got.Legs) != 4 { t.Fatalf("stored route: %+v", got) }
+    if mx, _ := m.ListPlanMetrics(ctx, "t1", "2030-01-01", "alns"); len(mx) != 1 || mx[0]["iterations"] == 0 { t.Fatalf("metrics: %v", mx) }
+}
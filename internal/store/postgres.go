@@ -10,21 +10,24 @@ import (
     _ "github.com/jackc/pgx/v5/stdlib"
     "github.com/google/uuid"
     "encoding/json"
-    "math"
     "strings"
+    "strconv"
     "crypto/sha256"
     "encoding/hex"
     "os"
+    "slices"
     "sort"
     "io/fs"
     "path/filepath"
 
     "gpsnav/internal/model"
     "gpsnav/internal/opt"
+    "gpsnav/internal/plan"
 )
 
 type Postgres struct {
-    db *sql.DB
+    db     *sql.DB
+    matrix opt.DistanceMatrix // nil means haversine
 }
 
 func NewPostgres(dsn string) (*Postgres, error) {
@@ -35,7 +38,11 @@ func NewPostgres(dsn string) (*Postgres, error) {
     if err := db.Ping(); err != nil {
         return nil, err
     }
-    return &Postgres{db: db}, nil
+    m, err := opt.MatrixFromEnv()
+    if err != nil {
+        return nil, err
+    }
+    return &Postgres{db: db, matrix: m}, nil
 }
 
 // Ping tests connectivity
@@ -65,26 +72,24 @@ func (p *Postgres) CreateOrders(ctx context.Context, tenantID string, orders []m
                 return "", 0, 0, err
             }
         }
-        _, err = tx.ExecContext(ctx, `INSERT INTO orders (id, tenant_id, external_ref, priority, status, attrs) VALUES ($1,$2,$3,$4,$5,$6)`,
-            oid, tenantID, nullIfEmpty(o.ExternalRef), o.Priority, "pending", toJSON(o.Attributes))
+        _, err = tx.ExecContext(ctx, `INSERT INTO orders (id, tenant_id, external_ref, priority, service_level, status, attrs) VALUES ($1,$2,$3,$4,$5,$6,$7)`,
+            oid, tenantID, nullIfEmpty(o.ExternalRef), o.Priority, nullIfEmpty(o.ServiceLevel), "pending", toJSON(o.Attributes))
         if err != nil { return "", 0, 0, err }
         // stops
         seq := 0
         for _, s := range o.Stops {
             sid := uuid.New()
-            var tw any
-            if s.TimeWindow != nil && s.TimeWindow.Start != "" && s.TimeWindow.End != "" {
-                tw = fmt.Sprintf("[%s,%s]", s.TimeWindow.Start, s.TimeWindow.End)
-            } else {
-                tw = nil
-            }
+            mode := "hard"
+            if strings.EqualFold(s.TimeWindowMode, "soft") { mode = "soft" }
+            var maxLate any
+            if s.MaxLatenessSec > 0 { maxLate = s.MaxLatenessSec }
             var lat, lng any
             if s.Location != nil {
                 lat = s.Location.Lat
                 lng = s.Location.Lng
             }
-            _, err = tx.ExecContext(ctx, `INSERT INTO stops (id, tenant_id, order_id, type, address, lat, lng, time_window, service_time_sec, required_skills, status) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)`,
-                sid, tenantID, oid, s.Type, nullIfEmpty(s.Address), lat, lng, tw, s.ServiceTimeSec, pqStringArray(s.RequiredSkills), "pending")
+            _, err = tx.ExecContext(ctx, `INSERT INTO stops (id, tenant_id, order_id, type, address, lat, lng, time_window, time_window_mode, max_lateness_sec, service_time_sec, required_skills, status) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13)`,
+                sid, tenantID, oid, s.Type, nullIfEmpty(s.Address), lat, lng, timeWindowsLiteral(s), mode, maxLate, s.ServiceTimeSec, pqStringArray(s.RequiredSkills), "pending")
             if err != nil { return "", 0, 0, err }
             seq++
         }
@@ -94,6 +99,17 @@ func (p *Postgres) CreateOrders(ctx context.Context, tenantID string, orders []m
     return importID, created, skipped, nil
 }
 
+// timeWindowsLiteral renders a stop's windows as a tstzmultirange literal, or
+// nil when it has none. Windows missing either end are dropped.
+func timeWindowsLiteral(s model.StopIn) any {
+    ws := s.TimeWindows
+    if s.TimeWindow != nil { ws = append([]model.TimeWindow{*s.TimeWindow}, ws...) }
+    var ranges []string
+    for _, w := range ws { if w.Start != "" && w.End != "" { ranges = append(ranges, fmt.Sprintf("[%s,%s]", w.Start, w.End)) } }
+    if len(ranges) == 0 { return nil }
+    return "{" + strings.Join(ranges, ",") + "}"
+}
+
 func (p *Postgres) ListOrders(ctx context.Context, tenantID, status, cursor string, limit int) ([]model.OrderOut, string, error) {
     if limit <= 0 || limit > 500 { limit = 100 }
     // Simple offset cursor (opaque in real impl). For now cursor is last id text.
@@ -131,10 +147,11 @@ func (p *Postgres) ListOrders(ctx context.Context, tenantID, status, cursor stri
 
 func (p *Postgres) GetRoute(ctx context.Context, tenantID, routeID string) (model.Route, error) {
     var r model.Route
-    row := p.db.QueryRowContext(ctx, `SELECT id::text, version, plan_date, status, driver_id::text, vehicle_id::text, auto_advance FROM routes WHERE tenant_id=$1 AND id=$2`, tenantID, routeID)
+    row := p.db.QueryRowContext(ctx, `SELECT id::text, version, plan_date, COALESCE(batch_id,''), status, driver_id::text, vehicle_id::text, auto_advance, cost_breakdown FROM routes WHERE tenant_id=$1 AND id=$2`, tenantID, routeID)
     var driverID, vehicleID sql.NullString
     var aa any
-    if err := row.Scan(&r.ID, &r.Version, &r.PlanDate, &r.Status, &driverID, &vehicleID, &aa); err != nil {
+    var costs []byte
+    if err := row.Scan(&r.ID, &r.Version, &r.PlanDate, &r.BatchID, &r.Status, &driverID, &vehicleID, &aa, &costs); err != nil {
         if errors.Is(err, sql.ErrNoRows) { return r, ErrNotFound }
         return r, err
     }
@@ -147,6 +164,7 @@ func (p *Postgres) GetRoute(ctx context.Context, tenantID, routeID string) (mode
         if v != "" { var pol model.AutoAdvancePolicy; _ = json.Unmarshal([]byte(v), &pol); r.AutoAdvance = &pol }
     }
     r.VehicleID = vehicleID.String
+    if len(costs) > 0 { _ = json.Unmarshal(costs, &r.CostBreakdown) }
     legsRows, err := p.db.QueryContext(ctx, `SELECT id::text, seq, kind, break_sec, from_stop_id::text, to_stop_id::text, dist_m, drive_sec, eta_arrival, eta_departure, status FROM route_legs WHERE tenant_id=$1 AND route_id=$2 ORDER BY seq`, tenantID, routeID)
     if err != nil { return r, err }
     defer legsRows.Close()
@@ -494,18 +512,18 @@ func (p *Postgres) FindRoutesByStop(ctx context.Context, tenantID, stopID string
 
 func (p *Postgres) SavePlanMetrics(ctx context.Context, tenantID, planDate, algo string, metrics map[string]any) error {
     id := uuid.New().String()
-    _, err := p.db.ExecContext(ctx, `INSERT INTO plan_metrics (id, tenant_id, plan_date, algo, iterations, improvements, accepted_worse, best_cost, final_cost, removal_selects, insert_selects, init_temp, cooling, init_removal_weights, init_insertion_weights, objectives, final_removal_weights, final_insertion_weights)
-        VALUES ($1,$2,$3,$4,COALESCE($5,0),COALESCE($6,0),COALESCE($7,0),$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18)
+    _, err := p.db.ExecContext(ctx, `INSERT INTO plan_metrics (id, tenant_id, plan_date, algo, iterations, improvements, accepted_worse, best_cost, final_cost, removal_selects, insert_selects, init_temp, cooling, init_removal_weights, init_insertion_weights, objectives, final_removal_weights, final_insertion_weights, seed, removal_successes, insert_successes)
+        VALUES ($1,$2,$3,$4,COALESCE($5,0),COALESCE($6,0),COALESCE($7,0),$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19,$20,$21)
         ON CONFLICT (tenant_id, plan_date, algo) DO UPDATE SET
-          iterations=COALESCE($5,0), improvements=COALESCE($6,0), accepted_worse=COALESCE($7,0), best_cost=$8, final_cost=$9, removal_selects=$10, insert_selects=$11, init_temp=$12, cooling=$13, init_removal_weights=$14, init_insertion_weights=$15, objectives=$16, final_removal_weights=$17, final_insertion_weights=$18, created_at=now()`,
+          iterations=COALESCE($5,0), improvements=COALESCE($6,0), accepted_worse=COALESCE($7,0), best_cost=$8, final_cost=$9, removal_selects=$10, insert_selects=$11, init_temp=$12, cooling=$13, init_removal_weights=$14, init_insertion_weights=$15, objectives=$16, final_removal_weights=$17, final_insertion_weights=$18, seed=$19, removal_successes=$20, insert_successes=$21, created_at=now()`,
         id, tenantID, planDate, algo,
-        metrics["iterations"], metrics["improvements"], metrics["acceptedWorse"], metrics["bestCost"], metrics["finalCost"], metrics["removalSelects"], metrics["insertSelects"], metrics["initTemp"], metrics["cooling"], metrics["initRemovalWeights"], metrics["initInsertionWeights"], metrics["objectives"], metrics["finalRemovalWeights"], metrics["finalInsertionWeights"],
+        metrics["iterations"], metrics["improvements"], metrics["acceptedWorse"], metrics["bestCost"], metrics["finalCost"], metrics["removalSelects"], metrics["insertSelects"], metrics["initTemp"], metrics["cooling"], metrics["initRemovalWeights"], metrics["initInsertionWeights"], metrics["objectives"], metrics["finalRemovalWeights"], metrics["finalInsertionWeights"], metrics["seed"], metrics["removalSuccesses"], metrics["insertSuccesses"],
     )
     return err
 }
 
 func (p *Postgres) ListPlanMetrics(ctx context.Context, tenantID, planDate, algo string) ([]map[string]any, error) {
-    base := `SELECT algo, iterations, improvements, accepted_worse, best_cost, final_cost, removal_selects, insert_selects, init_temp, cooling, init_removal_weights, init_insertion_weights, objectives, final_removal_weights, final_insertion_weights FROM plan_metrics WHERE tenant_id=$1 AND plan_date=$2`
+    base := `SELECT algo, iterations, improvements, accepted_worse, best_cost, final_cost, removal_selects, insert_selects, init_temp, cooling, init_removal_weights, init_insertion_weights, objectives, final_removal_weights, final_insertion_weights, seed, removal_successes, insert_successes FROM plan_metrics WHERE tenant_id=$1 AND plan_date=$2`
     args := []any{tenantID, planDate}
     if algo != "" { base += ` AND algo=$3`; args = append(args, algo) }
     rows, err := p.db.QueryContext(ctx, base, args...)
@@ -516,12 +534,13 @@ func (p *Postgres) ListPlanMetrics(ctx context.Context, tenantID, planDate, algo
         var algo string
         var iter, imp, aw int
         var best, final sql.NullFloat64
-        var rem, ins any
+        var rem, ins, remOK, insOK any
         var initTemp, cooling sql.NullFloat64
         var initRem, initIns any
         var objectives any
         var finRem, finIns any
-        if err := rows.Scan(&algo, &iter, &imp, &aw, &best, &final, &rem, &ins, &initTemp, &cooling, &initRem, &initIns, &objectives, &finRem, &finIns); err != nil { return nil, err }
+        var seed sql.NullInt64
+        if err := rows.Scan(&algo, &iter, &imp, &aw, &best, &final, &rem, &ins, &initTemp, &cooling, &initRem, &initIns, &objectives, &finRem, &finIns, &seed, &remOK, &insOK); err != nil { return nil, err }
         item := map[string]any{
             "algo": algo,
             "iterations": iter,
@@ -531,6 +550,8 @@ func (p *Postgres) ListPlanMetrics(ctx context.Context, tenantID, planDate, algo
             "finalCost": final.Float64,
             "removalSelects": rem,
             "insertSelects": ins,
+            "removalSuccesses": remOK,
+            "insertSuccesses": insOK,
             "initTemp": initTemp.Float64,
             "cooling": cooling.Float64,
             "initRemovalWeights": initRem,
@@ -538,6 +559,7 @@ func (p *Postgres) ListPlanMetrics(ctx context.Context, tenantID, planDate, algo
             "objectives": objectives,
             "finalRemovalWeights": finRem,
             "finalInsertionWeights": finIns,
+            "seed": seed.Int64,
         }
         out = append(out, item)
     }
@@ -857,354 +879,605 @@ func (p *Postgres) emitEvent(ctx context.Context, tenantID, eventType string, da
     return nil
 }
 
-// PlanRoutes creates a simple single route with naive ETAs by chaining pending stops.
-func (p *Postgres) PlanRoutes(ctx context.Context, req model.OptimizeRequest) ([]model.Route, string, error) {
-    // Fetch candidate stops (pending with coordinates)
-    rows, err := p.db.QueryContext(ctx, `SELECT id::text, lat, lng, service_time_sec, lower(time_window) AS tw_start, upper(time_window) AS tw_end FROM stops WHERE tenant_id=$1 AND status='pending' AND lat IS NOT NULL AND lng IS NOT NULL ORDER BY id LIMIT 500`, req.TenantID)
-    if err != nil { return nil, "", err }
-    type st struct{ id string; lat, lng float64; svc int; twStart, twEnd *time.Time }
-    var stops []st
+// customerKeySQL identifies the customer of stop s (order o) across plan
+// dates: the order's customerId attribute, else the address, else the
+// location to about 10 m.
+const customerKeySQL = `COALESCE(NULLIF(o.attrs->>'customerId', ''), NULLIF(lower(trim(s.address)), ''), round(s.lat::numeric, 4)::text || ',' || round(s.lng::numeric, 4)::text)`
+
+// loadPlanOrders fetches the tenant's pending stops with coordinates, and
+// the stops assigned to routeIDs, grouped by order, together with the
+// order's demand attributes, priority and service level and each stop's
+// customer. A stop without an order is planned as an order of its own.
+func (p *Postgres) loadPlanOrders(ctx context.Context, tenantID string, routeIDs []string) ([]plan.Order, error) {
+    rows, err := p.db.QueryContext(ctx, `SELECT s.id::text, s.lat, s.lng, s.service_time_sec,
+        COALESCE((SELECT jsonb_agg(jsonb_build_object('start', lower(r), 'end', upper(r)) ORDER BY lower(r)) FROM unnest(s.time_window) r), '[]'::jsonb),
+        s.time_window_mode='soft', COALESCE(s.max_lateness_sec, 0),
+        COALESCE(s.type,''), COALESCE(s.order_id::text,''), CASE WHEN o.attrs->>'maxRideSec' ~ '^[0-9]+$' THEN (o.attrs->>'maxRideSec')::int ELSE 0 END, COALESCE(o.attrs, '{}'::jsonb),
+        COALESCE(array_to_string(s.required_skills, ','), ''), COALESCE(o.priority, 0), COALESCE(o.service_level, ''), `+customerKeySQL+`
+        FROM stops s LEFT JOIN orders o ON o.id=s.order_id
+        WHERE s.tenant_id=$1 AND s.lat IS NOT NULL AND s.lng IS NOT NULL
+          AND (s.status='pending' OR (s.status='assigned' AND EXISTS (SELECT 1 FROM route_legs l WHERE l.tenant_id=s.tenant_id AND l.to_stop_id=s.id AND l.route_id::text = ANY($2))))
+        ORDER BY s.id`, tenantID, routeIDs)
+    if err != nil { return nil, err }
+    defer rows.Close()
+    var orders []plan.Order
+    at := map[string]int{} // order ID -> index in orders
     for rows.Next() {
-        var s st
-        var tws, twe sql.NullTime
-        if err := rows.Scan(&s.id, &s.lat, &s.lng, &s.svc, &tws, &twe); err != nil { rows.Close(); return nil, "", err }
-        if tws.Valid { t := tws.Time; s.twStart = &t }
-        if twe.Valid { t := twe.Time; s.twEnd = &t }
-        stops = append(stops, s)
-    }
-    rows.Close()
-    n := len(stops)
-    if n < 2 {
-        // Create empty route
-        id := uuid.New().String()
-        _, err := p.db.ExecContext(ctx, `INSERT INTO routes (id, tenant_id, version, plan_date, status) VALUES ($1,$2,$3,$4,$5)`, id, req.TenantID, 1, req.PlanDate, "planned")
-        if err != nil { return nil, "", err }
-        r, err := p.GetRoute(ctx, req.TenantID, id)
-        if err != nil { return nil, "", err }
-        return []model.Route{r}, fmt.Sprintf("opt_%d", time.Now().UnixNano()), nil
-    }
-    // Determine number of routes
-    k := len(req.VehiclePool)
-    if k <= 0 {
-        k = int(math.Min(3, math.Ceil(float64(n)/20.0)))
-        if k <= 0 { k = 1 }
-    }
-    // Select k seeds (farthest-first)
-    seeds := []int{0}
-    for len(seeds) < k && len(seeds) < n {
-        maxd := -1.0
-        maxi := -1
-        for i := 0; i < n; i++ {
-            skip := false
-            for _, sidx := range seeds { if sidx == i { skip = true; break } }
-            if skip { continue }
-            // distance to nearest seed
-            mind := math.MaxFloat64
-            for _, sidx := range seeds {
-                d := haversineMeters(stops[i].lat, stops[i].lng, stops[sidx].lat, stops[sidx].lng)
-                if d < mind { mind = d }
-            }
-            if mind > maxd { maxd = mind; maxi = i }
-        }
-        if maxi >= 0 { seeds = append(seeds, maxi) } else { break }
-    }
-    // Assign stops to nearest seed
-    clusters := make([][]int, len(seeds))
-    for i := 0; i < n; i++ {
-        // find nearest seed
-        best := 0
-        bestd := math.MaxFloat64
-        for si, sidx := range seeds {
-            d := haversineMeters(stops[i].lat, stops[i].lng, stops[sidx].lat, stops[sidx].lng)
-            if d < bestd { bestd = d; best = si }
-        }
-        clusters[best] = append(clusters[best], i)
+        var s plan.Stop
+        var o plan.Order
+        var tws, attrs []byte
+        var skills string
+        if err := rows.Scan(&s.ID, &s.Lat, &s.Lng, &s.ServiceSec, &tws, &s.SoftTW, &s.MaxLateSec, &s.Kind, &o.ID, &o.MaxRideSec, &attrs, &skills, &o.Priority, &o.ServiceLevel, &s.Customer); err != nil { return nil, err }
+        s.Windows = parseTimeWindows(tws)
+        if skills != "" { s.Skills = strings.Split(skills, ",") }
+        if i, ok := at[o.ID]; ok && o.ID != "" { orders[i].Stops = append(orders[i].Stops, s); continue }
+        var am map[string]any
+        _ = json.Unmarshal(attrs, &am)
+        o.Demand, o.Stops = orderDemand(am), []plan.Stop{s}
+        if o.ID != "" { at[o.ID] = len(orders) }
+        orders = append(orders, o)
     }
-    // Load depots (geofences of type 'hub')
-    depRows, err := p.db.QueryContext(ctx, `SELECT id::text, lat, lng FROM geofences WHERE tenant_id=$1 AND type='hub' AND lat IS NOT NULL AND lng IS NOT NULL`, req.TenantID)
-    if err != nil { return nil, "", err }
-    type depot struct{ id string; lat, lng float64 }
-    var depots []depot
-    for depRows.Next() {
-        var d depot
-        if err := depRows.Scan(&d.id, &d.lat, &d.lng); err != nil { depRows.Close(); return nil, "", err }
+    return orders, rows.Err()
+}
+
+// parseTimeWindows decodes the [{start,end}] windows loadPlanStops selects;
+// a null bound leaves that side open.
+func parseTimeWindows(b []byte) []opt.TW {
+    var raw []struct{ Start, End *time.Time }
+    _ = json.Unmarshal(b, &raw)
+    var out []opt.TW
+    for _, r := range raw {
+        var tw opt.TW
+        if r.Start != nil { tw.Start = *r.Start }
+        if r.End != nil { tw.End = *r.End }
+        out = append(out, tw)
+    }
+    return out
+}
+
+// loadDepots returns the tenant's hub geofences with coordinates.
+func (p *Postgres) loadDepots(ctx context.Context, tenantID string) ([]plan.Depot, error) {
+    rows, err := p.db.QueryContext(ctx, `SELECT id::text, lat, lng FROM geofences WHERE tenant_id=$1 AND type='hub' AND lat IS NOT NULL AND lng IS NOT NULL`, tenantID)
+    if err != nil { return nil, err }
+    defer rows.Close()
+    var depots []plan.Depot
+    for rows.Next() {
+        var d plan.Depot
+        if err := rows.Scan(&d.ID, &d.Lat, &d.Lng); err != nil { return nil, err }
         depots = append(depots, d)
     }
-    depRows.Close()
+    return depots, rows.Err()
+}
 
-    // ALNS strategy branch
-    if strings.ToLower(req.Algorithm) == "alns" {
-        // HoS planning parameters
-        hosMax := 0
-        breakSec := 1800
-        if req.Constraints != nil {
-            if v, ok := req.Constraints["hosMaxDriveSec"]; ok {
-                switch x := v.(type) { case float64: hosMax = int(x); case int: hosMax = x }
-            }
-            if v, ok := req.Constraints["breakSec"]; ok {
-                switch x := v.(type) { case float64: breakSec = int(x); case int: breakSec = x }
-            }
-        }
-        // Objectives: overlay request over defaults
-        obj := map[string]float64{"driveTime": 1, "lateness": 4, "failed": 50, "distance": 0.1}
-        if req.Objectives != nil { for k, v := range req.Objectives { obj[k] = v } }
-        prob := opt.Problem{Nodes: make([]opt.Node, len(stops)), SpeedKph: 50, Objectives: obj, HosMaxDriveSec: hosMax, BreakSec: breakSec,
-            InitialTemp: req.InitTemp, Cooling: req.Cooling, InitialRemovalWeights: req.RemovalWeights, InitialInsertionWeights: req.InsertionWeights}
-        // Vehicles: from pool if provided else derived count
-        var vehicles []opt.Vehicle
-        if len(req.VehiclePool) > 0 {
-            for _, vid := range req.VehiclePool {
-                var cw, cv sql.NullFloat64
-                var skillsStr sql.NullString
-                _ = p.db.QueryRowContext(ctx, `SELECT (capacity->>'weight')::double precision, (capacity->>'volume')::double precision, array_to_string(skills, ',') FROM vehicles WHERE tenant_id=$1 AND id=$2`, req.TenantID, vid).Scan(&cw, &cv, &skillsStr)
-                veh := opt.Vehicle{ID: vid, CapWeight: cw.Float64, CapVolume: cv.Float64}
-                if skillsStr.Valid && skillsStr.String != "" { veh.Skills = strings.Split(skillsStr.String, ",") }
-                vehicles = append(vehicles, veh)
-            }
-        } else {
-            k := int(math.Min(3, math.Ceil(float64(n)/20.0)))
-            if k <= 0 { k = 1 }
-            for i := 0; i < k; i++ { vehicles = append(vehicles, opt.Vehicle{ID: uuid.New().String(), CapWeight: 0, CapVolume: 0}) }
-        }
-        if len(depots) > 0 {
-            d := depots[0]
-            for i := range vehicles { vehicles[i].StartLatLng = &[2]float64{d.lat, d.lng}; vehicles[i].EndLatLng = &[2]float64{d.lat, d.lng} }
-        }
-        prob.Vehicles = vehicles
-        base := time.Unix(0,0)
-        for i := range stops {
-            var tw *opt.TW
-            if stops[i].twStart != nil || stops[i].twEnd != nil {
-                tw = &opt.TW{}
-                if stops[i].twStart != nil { tw.Start = base.Add(stops[i].twStart.Sub(base)) }
-                if stops[i].twEnd != nil { tw.End = base.Add(stops[i].twEnd.Sub(base)) }
-            }
-            // required skills
-            var skillsStr sql.NullString
-            _ = p.db.QueryRowContext(ctx, `SELECT array_to_string(required_skills, ',') FROM stops WHERE tenant_id=$1 AND id=$2`, req.TenantID, stops[i].id).Scan(&skillsStr)
-            var skills []string
-            if skillsStr.Valid && skillsStr.String != "" { skills = strings.Split(skillsStr.String, ",") }
-            // Demand weights/volumes not yet modeled in stops query; set to zero for now
-            prob.Nodes[i] = opt.Node{ID: stops[i].id, Lat: stops[i].lat, Lng: stops[i].lng, ServiceSec: stops[i].svc, TW: tw, Demand: opt.Demand{Weight: 0, Volume: 0}, Skills: skills}
+// planZone is a geofence with an area, usable as a solver zone, and the
+// vehicles and drivers assigned to it when it is a territory.
+type planZone struct {
+    zone              opt.Zone
+    name, kind        string
+    vehicles, drivers []string
+}
+
+// loadZones returns the tenant's geofences with a polygon or a centre and
+// radius. Hubs are depots, not zones.
+func (p *Postgres) loadZones(ctx context.Context, tenantID string) ([]planZone, error) {
+    rows, err := p.db.QueryContext(ctx, `SELECT id::text, COALESCE(name,''), COALESCE(type,''), COALESCE(radius_m, 0), lat, lng, polygon, COALESCE(rules, '{}'::jsonb)
+        FROM geofences WHERE tenant_id=$1 AND COALESCE(type,'') <> 'hub' AND (polygon IS NOT NULL OR (radius_m > 0 AND lat IS NOT NULL AND lng IS NOT NULL))`, tenantID)
+    if err != nil { return nil, err }
+    defer rows.Close()
+    var zones []planZone
+    for rows.Next() {
+        var z planZone
+        var radius int
+        var lat, lng sql.NullFloat64
+        var polyJS, rulesJS []byte
+        if err := rows.Scan(&z.zone.ID, &z.name, &z.kind, &radius, &lat, &lng, &polyJS, &rulesJS); err != nil { return nil, err }
+        z.zone.RadiusM, z.zone.Territory = float64(radius), strings.EqualFold(z.kind, "territory")
+        if lat.Valid && lng.Valid { z.zone.Center = &[2]float64{lat.Float64, lng.Float64} }
+        var pts []model.GeoPoint
+        _ = json.Unmarshal(polyJS, &pts)
+        for _, pt := range pts { z.zone.Polygon = append(z.zone.Polygon, [2]float64{pt.Lat, pt.Lng}) }
+        if len(z.zone.Polygon) < 3 && (z.zone.Center == nil || z.zone.RadiusM <= 0) { continue }
+        var rules struct {
+            VehicleIDs []string `json:"vehicleIds"`
+            DriverIDs  []string `json:"driverIds"`
         }
-        // time budget and iterations config
-        tb := 300 * time.Millisecond
-        if req.TimeBudgetMs > 0 { tb = time.Duration(req.TimeBudgetMs) * time.Millisecond }
-        if req.MaxIterations > 0 { prob.IterationsLimit = req.MaxIterations }
-        sol, pm := opt.Solve(prob, 0, tb)
-        // record planner metrics (DB + in-memory)
-        _ = p.SavePlanMetrics(ctx, req.TenantID, req.PlanDate, "alns", map[string]any{
-            "iterations": pm.Iterations,
-            "improvements": pm.Improvements,
-            "acceptedWorse": pm.AcceptedWorse,
-            "bestCost": pm.BestCost,
-            "finalCost": pm.FinalCost,
-            "removalSelects": []int{pm.RemovalSelects[0], pm.RemovalSelects[1]},
-            "insertSelects": []int{pm.InsertSelects[0], pm.InsertSelects[1]},
-            "initTemp": prob.InitialTemp,
-            "cooling": prob.Cooling,
-            "initRemovalWeights": prob.InitialRemovalWeights,
-            "initInsertionWeights": prob.InitialInsertionWeights,
-            "finalRemovalWeights": []float64{pm.FinalRemovalWeights[0], pm.FinalRemovalWeights[1]},
-            "finalInsertionWeights": []float64{pm.FinalInsertionWeights[0], pm.FinalInsertionWeights[1]},
-            "objectives": obj,
-        })
-        opt.RecordMetrics(req.TenantID, req.PlanDate, "alns", pm)
-        // persist weight snapshots
-        if len(pm.Snapshots) > 0 {
-            snaps := make([]map[string]any, 0, len(pm.Snapshots))
-            for _, s0 := range pm.Snapshots {
-                snaps = append(snaps, map[string]any{
-                    "iteration": s0.Iteration,
-                    "removal": []float64{s0.Removal[0], s0.Removal[1]},
-                    "insertion": []float64{s0.Insertion[0], s0.Insertion[1]},
-                })
-            }
-            _ = p.SavePlanMetricsWeights(ctx, req.TenantID, req.PlanDate, "alns", snaps)
+        _ = json.Unmarshal(rulesJS, &rules)
+        z.vehicles, z.drivers = rules.VehicleIDs, rules.DriverIDs
+        zones = append(zones, z)
+    }
+    return zones, rows.Err()
+}
+
+// vehicleZones resolves the zone rules of a vehicle: the territories listing
+// it or its driver, and the zones its restrictions forbid, matched by
+// geofence id, name or type.
+func vehicleZones(zones []planZone, vid, driverID string, forbidden []string) (territories, closed []string) {
+    for _, z := range zones {
+        if z.zone.Territory && (slices.Contains(z.vehicles, vid) || (driverID != "" && slices.Contains(z.drivers, driverID))) {
+            territories = append(territories, z.zone.ID)
         }
-        results := []model.Route{}
-        for _, plan := range sol.Plans {
-            if len(plan.Order) == 0 { continue }
-            rid := uuid.New().String()
-            if _, err := p.db.ExecContext(ctx, `INSERT INTO routes (id, tenant_id, version, plan_date, status) VALUES ($1,$2,$3,$4,$5)`, rid, req.TenantID, 1, req.PlanDate, "planned"); err != nil { return nil, "", err }
-            curr := time.Now().UTC()
-            seq := 1
-            driveCum := 0
-            if len(depots) > 0 {
-                first := stops[plan.Order[0]]
-                d := depots[0]
-                dist := int(math.Round(haversineMeters(d.lat, d.lng, first.lat, first.lng)))
-                drive := int(math.Round(float64(dist) / (50_000.0/3600.0)))
-                etaA := curr.Add(time.Duration(drive) * time.Second)
-                if first.twStart != nil && etaA.Before(*first.twStart) { etaA = *first.twStart }
-                etaD := etaA.Add(time.Duration(first.svc) * time.Second)
-            if _, err := p.db.ExecContext(ctx, `INSERT INTO route_legs (id, tenant_id, route_id, seq, kind, break_sec, from_stop_id, to_stop_id, dist_m, drive_sec, eta_arrival, eta_departure, status) VALUES ($1,$2,$3,$4,'drive',NULL,$5,$6,$7,$8,$9,$10,'in_progress')`, uuid.New().String(), req.TenantID, rid, seq, nil, first.id, dist, drive, etaA, etaD); err != nil { return nil, "", err }
-                curr = etaD
-                driveCum += drive
-                seq++
-            }
-            for i := 0; i < len(plan.Order)-1; i++ {
-                a := stops[plan.Order[i]]
-                b := stops[plan.Order[i+1]]
-                dist := int(math.Round(haversineMeters(a.lat, a.lng, b.lat, b.lng)))
-                drive := int(math.Round(float64(dist) / (50_000.0/3600.0)))
-            if hosMax > 0 && driveCum+drive > hosMax && seq > 1 {
-                // plan a break leg
-                etaA0 := curr
-                etaD0 := curr.Add(time.Duration(breakSec) * time.Second)
-                if _, err := p.db.ExecContext(ctx, `INSERT INTO route_legs (id, tenant_id, route_id, seq, kind, break_sec, from_stop_id, to_stop_id, dist_m, drive_sec, eta_arrival, eta_departure, status) VALUES ($1,$2,$3,$4,'break',$5,NULL,NULL,0,0,$6,$7,'pending')`, uuid.New().String(), req.TenantID, rid, seq, breakSec, etaA0, etaD0); err != nil { return nil, "", err }
-                _ = p.emitEvent(ctx, req.TenantID, "hos.break.planned", map[string]any{"routeId": rid, "breakSec": breakSec, "start": etaA0.Format(time.RFC3339), "end": etaD0.Format(time.RFC3339)})
-                curr = etaD0
-                driveCum = 0
-                seq++
-            }
-                etaA := curr.Add(time.Duration(drive) * time.Second)
-                if b.twStart != nil && etaA.Before(*b.twStart) { etaA = *b.twStart }
-                etaD := etaA.Add(time.Duration(b.svc) * time.Second)
-                status := "pending"
-                if seq == 1 && len(depots) == 0 { status = "in_progress" }
-            if _, err := p.db.ExecContext(ctx, `INSERT INTO route_legs (id, tenant_id, route_id, seq, kind, break_sec, from_stop_id, to_stop_id, dist_m, drive_sec, eta_arrival, eta_departure, status) VALUES ($1,$2,$3,$4,'drive',NULL,$5,$6,$7,$8,$9,$10,$11)`, uuid.New().String(), req.TenantID, rid, seq, a.id, b.id, dist, drive, etaA, etaD, status); err != nil { return nil, "", err }
-                curr = etaD
-                driveCum += drive
-                seq++
-            }
-            if len(depots) > 0 {
-                last := stops[plan.Order[len(plan.Order)-1]]
-                d := depots[0]
-                dist := int(math.Round(haversineMeters(last.lat, last.lng, d.lat, d.lng)))
-                drive := int(math.Round(float64(dist) / (50_000.0/3600.0)))
-                etaA := curr.Add(time.Duration(drive) * time.Second)
-                etaD := etaA
-            if _, err := p.db.ExecContext(ctx, `INSERT INTO route_legs (id, tenant_id, route_id, seq, kind, break_sec, from_stop_id, to_stop_id, dist_m, drive_sec, eta_arrival, eta_departure, status) VALUES ($1,$2,$3,$4,'drive',NULL,$5,$6,$7,$8,$9,$10,'pending')`, uuid.New().String(), req.TenantID, rid, seq, last.id, nil, dist, drive, etaA, etaD); err != nil { return nil, "", err }
-            }
-            r, _ := p.GetRoute(ctx, req.TenantID, rid)
-            results = append(results, r)
+        for _, f := range forbidden {
+            if f != "" && (f == z.zone.ID || f == z.name || strings.EqualFold(f, z.kind)) { closed = append(closed, z.zone.ID); break }
         }
-        return results, fmt.Sprintf("opt_%d", time.Now().UnixNano()), nil
     }
-    // HoS planning parameters for greedy planner
-    hosMax := 0
-    breakSec := 1800
-    if req.Constraints != nil {
-        if v, ok := req.Constraints["hosMaxDriveSec"]; ok { switch x := v.(type) { case float64: hosMax = int(x); case int: hosMax = x } }
-        if v, ok := req.Constraints["breakSec"]; ok { switch x := v.(type) { case float64: breakSec = int(x); case int: breakSec = x } }
+    return territories, closed
+}
+
+// zoneViolations lists the routed stops of sol that break their vehicle's
+// zone rules; routeIDs holds the route of each vehicle.
+func zoneViolations(prob opt.Problem, sol opt.Solution, routeIDs []string) ([]model.ZoneViolation, error) {
+    vs, err := prob.ZoneViolations(sol)
+    if err != nil { return nil, err }
+    var out []model.ZoneViolation
+    for _, v := range vs {
+        out = append(out, model.ZoneViolation{RouteID: routeIDs[v.Vehicle], StopID: prob.Nodes[v.Node].ID, ZoneID: v.Zone, Rule: v.Rule})
+    }
+    return out, nil
+}
+
+// loadPlanVehicle reads a vehicle's capacity, skills, shift, caps, type
+// costs and zone rules, plus its depot settings. Unknown vehicles come back
+// unconstrained; other read errors are returned.
+func (p *Postgres) loadPlanVehicle(ctx context.Context, tenantID, vid string, zones []planZone) (opt.Vehicle, model.VehicleDepot, error) {
+    var maxDist sql.NullFloat64
+    var capJS, restrictJS []byte
+    var skillsStr, startDep, endDep, driverID sql.NullString
+    var open sql.NullBool
+    var shiftStart, shiftEnd sql.NullTime
+    var maxRoute sql.NullInt64
+    var vc opt.VehicleCost
+    // shift from the vehicle, else its assigned driver; costs from its type
+    err := p.db.QueryRowContext(ctx, `SELECT COALESCE(v.capacity, '{}'::jsonb), array_to_string(v.skills, ','), v.start_depot_id::text, v.end_depot_id::text, v.open_route,
+        lower(COALESCE(v.shift_window, d.shift_window)), upper(COALESCE(v.shift_window, d.shift_window)), v.max_route_sec, v.max_distance_m,
+        COALESCE(t.fixed_cost, 0), COALESCE(t.cost_per_km, 0), COALESCE(t.cost_per_hour, 0), COALESCE(t.overtime_after_sec, 0), COALESCE(t.overtime_multiplier, 1),
+        v.driver_id::text, COALESCE(v.restrictions, '{}'::jsonb)
+        FROM vehicles v LEFT JOIN drivers d ON d.id=v.driver_id LEFT JOIN vehicle_types t ON t.tenant_id=v.tenant_id AND t.name=v.type
+        WHERE v.tenant_id=$1 AND v.id=$2`, tenantID, vid).Scan(&capJS, &skillsStr, &startDep, &endDep, &open, &shiftStart, &shiftEnd, &maxRoute, &maxDist,
+        &vc.Fixed, &vc.PerKm, &vc.PerHour, &vc.OvertimeAfterSec, &vc.OvertimeFactor, &driverID, &restrictJS)
+    if err != nil && !errors.Is(err, sql.ErrNoRows) { return opt.Vehicle{}, model.VehicleDepot{}, fmt.Errorf("vehicle %s: %w", vid, err) }
+    veh := opt.Vehicle{ID: vid, ShiftStart: shiftStart.Time, ShiftEnd: shiftEnd.Time, MaxRouteSec: int(maxRoute.Int64), MaxDistM: maxDist.Float64, Cost: vc, DriverID: driverID.String}
+    if skillsStr.Valid && skillsStr.String != "" { veh.Skills = strings.Split(skillsStr.String, ",") }
+    var capm map[string]any
+    _ = json.Unmarshal(capJS, &capm)
+    applyVehicleCapacity(&veh, capm)
+    var restrict struct{ ForbiddenZones []string `json:"forbiddenZones"` }
+    _ = json.Unmarshal(restrictJS, &restrict)
+    veh.Territories, veh.Forbidden = vehicleZones(zones, vid, driverID.String, restrict.ForbiddenZones)
+    return veh, model.VehicleDepot{StartDepotID: startDep.String, EndDepotID: endDep.String, OpenRoute: open.Bool}, nil
+}
+
+// PlanRoutes plans the tenant's pending stops with the request's algorithm
+// and persists a route per vehicle used, in one transaction: the routes,
+// tagged with the batch ID, their legs and the planned stops and orders
+// marked assigned. Without pending stops it creates a single empty route.
+// route.planned (and hos.break.planned) events follow the commit.
+func (p *Postgres) PlanRoutes(ctx context.Context, req model.OptimizeRequest) (model.PlanResult, error) {
+    if req.Reoptimize {
+        res, err := p.reoptimize(ctx, req)
+        if err != nil || res.Routes != nil { return res, err }
+        // no active routes for the plan date: plan from scratch
     }
-    // Build routes per cluster
+    batchID := fmt.Sprintf("opt_%d", time.Now().UnixNano())
+    orders, err := p.loadPlanOrders(ctx, req.TenantID, nil)
+    if err != nil { return model.PlanResult{}, err }
+    if len(orders) == 0 {
+        // Create empty route
+        id := uuid.New().String()
+        _, err := p.db.ExecContext(ctx, `INSERT INTO routes (id, tenant_id, version, plan_date, status, batch_id) VALUES ($1,$2,$3,$4,$5,$6)`, id, req.TenantID, 1, req.PlanDate, "planned", batchID)
+        if err != nil { return model.PlanResult{}, err }
+        r, err := p.GetRoute(ctx, req.TenantID, id)
+        if err != nil { return model.PlanResult{}, err }
+        return model.PlanResult{BatchID: batchID, Routes: []model.Route{r}}, nil
+    }
+    c, zones, err := p.planConstraints(ctx, req)
+    if err != nil { return model.PlanResult{}, err }
+    depots, err := p.loadDepots(ctx, req.TenantID)
+    if err != nil { return model.PlanResult{}, err }
+    // Depots per vehicle: request override, then vehicle record, then round-robin over req.Depots
+    var vehicles []plan.Vehicle
+    for _, vid := range req.VehiclePool {
+        veh, vd, err := p.loadPlanVehicle(ctx, req.TenantID, vid, zones)
+        if err != nil { return model.PlanResult{}, err }
+        if o, ok := req.VehicleDepots[vid]; ok { vd = o }
+        vehicles = append(vehicles, plan.Vehicle{Vehicle: veh, Depot: vd})
+    }
+    pl, err := plan.Solve(ctx, plan.Request{Orders: orders, Vehicles: vehicles, Depots: depots, DefaultDepots: req.Depots, Constraints: c})
+    if err != nil { return model.PlanResult{}, err } // a cancelled job persists nothing
+
+    known, err := p.knownVehicles(ctx, req.TenantID, req.VehiclePool)
+    if err != nil { return model.PlanResult{}, err }
+    routeIDs := make([]string, len(pl.Problem.Vehicles))
+    var routeRows, legs [][]any
+    var stopIDs []string
+    for _, r := range pl.Routes {
+        rid := uuid.New().String()
+        routeIDs[r.Vehicle] = rid
+        routeRows = append(routeRows, routeRow(rid, req.TenantID, req.PlanDate, batchID, r, known))
+        legs = append(legs, legRows(req.TenantID, rid, r.Legs, legRun{seq: 1, active: true})...)
+        stopIDs = append(stopIDs, r.StopIDs...)
+    }
+    tx, err := p.db.BeginTx(ctx, nil)
+    if err != nil { return model.PlanResult{}, err }
+    defer func(){ _ = tx.Rollback() }()
+    if err := insertRows(ctx, tx, "routes", routeColumns, routeRows); err != nil { return model.PlanResult{}, err }
+    if err := insertRows(ctx, tx, "route_legs", legColumns, legs); err != nil { return model.PlanResult{}, err }
+    // stops planned by a concurrent batch meanwhile are no longer pending
+    n, err := setStopStatus(ctx, tx, req.TenantID, stopIDs, "pending", "assigned")
+    if err != nil { return model.PlanResult{}, err }
+    if n != len(stopIDs) { return model.PlanResult{}, fmt.Errorf("%w: %d of %d stops were planned by another batch", ErrPlanConflict, len(stopIDs)-n, len(stopIDs)) }
+    if err := tx.Commit(); err != nil { return model.PlanResult{}, err }
+
+    if c.Algorithm == plan.ALNS { p.savePlanMetrics(ctx, req, pl) }
     results := []model.Route{}
-    for ci, idxs := range clusters {
-        if len(idxs) < 2 {
-            // single-stop cluster, create empty route record
-            rid := uuid.New().String()
-            if _, err := p.db.ExecContext(ctx, `INSERT INTO routes (id, tenant_id, version, plan_date, status) VALUES ($1,$2,$3,$4,$5)`, rid, req.TenantID, 1, req.PlanDate, "planned"); err != nil { return nil, "", err }
-            r, _ := p.GetRoute(ctx, req.TenantID, rid)
-            results = append(results, r)
-            continue
-        }
-        // order by nearest neighbor starting at seed
-        startIdx := seeds[ci]
-        used := make(map[int]bool)
-        order := []int{startIdx}
-        used[startIdx] = true
-        for len(order) < len(idxs) {
-            last := order[len(order)-1]
-            best := -1
-            bestd := math.MaxFloat64
-            for _, j := range idxs {
-                if used[j] { continue }
-                d := haversineMeters(stops[last].lat, stops[last].lng, stops[j].lat, stops[j].lng)
-                if d < bestd { bestd = d; best = j }
-            }
-            if best >= 0 { order = append(order, best); used[best] = true } else { break }
+    for _, r := range pl.Routes {
+        rid := routeIDs[r.Vehicle]
+        _ = p.emitEvent(ctx, req.TenantID, "route.planned", map[string]any{"routeId": rid, "batchId": batchID, "planDate": req.PlanDate, "vehicleId": r.VehicleID, "stops": len(r.StopIDs)})
+        p.emitBreaks(ctx, req.TenantID, rid, r.Legs)
+        route, err := p.GetRoute(ctx, req.TenantID, rid)
+        if err != nil { return model.PlanResult{}, err }
+        results = append(results, route)
+    }
+    zv, err := zoneViolations(pl.Problem, pl.Solution, routeIDs)
+    if err != nil { return model.PlanResult{}, err }
+    return model.PlanResult{BatchID: batchID, Routes: results, Unassigned: pl.Unassigned, ZoneViolations: zv}, nil
+}
+
+var routeColumns = []string{"id", "tenant_id", "version", "plan_date", "status", "depot_id", "end_depot_id", "vehicle_id", "cost_breakdown", "batch_id"}
+
+// routeRow renders planned route r as a routes row in routeColumns order.
+// vehicle_id is set when r's vehicle is one of the tenant's vehicles
+// (known), not a pool ID without a record.
+func routeRow(rid, tenantID, planDate, batchID string, r plan.Route, known map[string]bool) []any {
+    var vid any
+    if known[r.VehicleID] { vid = r.VehicleID }
+    return []any{rid, tenantID, 1, planDate, "planned", nullIfEmpty(r.StartDepotID), nullIfEmpty(r.EndDepotID), vid, costBreakdown(r.Cost), batchID}
+}
+
+// knownVehicles returns which of ids are vehicles of the tenant.
+func (p *Postgres) knownVehicles(ctx context.Context, tenantID string, ids []string) (map[string]bool, error) {
+    known := map[string]bool{}
+    if len(ids) == 0 { return known, nil }
+    rows, err := p.db.QueryContext(ctx, `SELECT id::text FROM vehicles WHERE tenant_id=$1 AND id::text = ANY($2)`, tenantID, ids)
+    if err != nil { return nil, err }
+    defer rows.Close()
+    for rows.Next() {
+        var id string
+        if err := rows.Scan(&id); err != nil { return nil, err }
+        known[id] = true
+    }
+    return known, rows.Err()
+}
+
+// planConstraints resolves the planner constraints of req with the tenant's
+// matrix, speed profile, operator weights, driver consistency and zones; the
+// zones are also returned with their rules for loadPlanVehicle.
+func (p *Postgres) planConstraints(ctx context.Context, req model.OptimizeRequest) (plan.Constraints, []planZone, error) {
+    c := plan.FromRequest(req)
+    c.Matrix = p.matrix
+    // Time-of-day speed profile: request constraints override the tenant config
+    profile, err := p.speedProfile(ctx, req)
+    if err != nil { return c, nil, err }
+    c.SpeedProfile = profile
+    c.RemovalWeights, c.InsertionWeights = p.operatorWeights(ctx, req)
+    if err := p.applyConsistency(ctx, req, &c); err != nil { return c, nil, err }
+    cfg, _ := p.GetOptimizerConfig(ctx, req.TenantID)
+    applyBalance(cfg, req, &c)
+    zones, err := p.loadZones(ctx, req.TenantID)
+    if err != nil { return c, nil, err }
+    for _, z := range zones { c.Zones = append(c.Zones, z.zone) }
+    return c, zones, nil
+}
+
+// savePlanMetrics records the ALNS metrics of pl (DB + in-memory) and its
+// operator weight snapshots.
+func (p *Postgres) savePlanMetrics(ctx context.Context, req model.OptimizeRequest, pl plan.Plan) {
+    pm, prob := pl.Metrics, pl.Problem
+    _ = p.SavePlanMetrics(ctx, req.TenantID, req.PlanDate, "alns", map[string]any{
+        "iterations": pm.Iterations,
+        "improvements": pm.Improvements,
+        "acceptedWorse": pm.AcceptedWorse,
+        "bestCost": pm.BestCost,
+        "finalCost": pm.FinalCost,
+        "removalSelects": pm.RemovalSelects,
+        "insertSelects": pm.InsertSelects,
+        "removalSuccesses": pm.RemovalSuccesses,
+        "insertSuccesses": pm.InsertSuccesses,
+        "initTemp": prob.InitialTemp,
+        "cooling": prob.Cooling,
+        "initRemovalWeights": prob.InitialRemovalWeights,
+        "initInsertionWeights": prob.InitialInsertionWeights,
+        "finalRemovalWeights": pm.FinalRemovalWeights,
+        "finalInsertionWeights": pm.FinalInsertionWeights,
+        "objectives": prob.Objectives,
+        "seed": pm.Seed,
+    })
+    opt.RecordMetrics(req.TenantID, req.PlanDate, "alns", pm)
+    // persist weight snapshots
+    if len(pm.Snapshots) > 0 {
+        snaps := make([]map[string]any, 0, len(pm.Snapshots))
+        for _, s0 := range pm.Snapshots {
+            snaps = append(snaps, map[string]any{
+                "iteration": s0.Iteration,
+                "removal": s0.Removal,
+                "insertion": s0.Insertion,
+            })
         }
-        // Improve ordering via 2-opt
-        nodes := make([]opt.StopNode, len(stops))
-        for i := range stops { nodes[i] = opt.StopNode{Lat: stops[i].lat, Lng: stops[i].lng} }
-        order = opt.ImproveOrder2Opt(nodes, order, 2)
+        _ = p.SavePlanMetricsWeights(ctx, req.TenantID, req.PlanDate, "alns", snaps)
+    }
+}
 
-        rid := uuid.New().String()
-        if _, err := p.db.ExecContext(ctx, `INSERT INTO routes (id, tenant_id, version, plan_date, status) VALUES ($1,$2,$3,$4,$5)`, rid, req.TenantID, 1, req.PlanDate, "planned"); err != nil { return nil, "", err }
-        curr := time.Now().UTC()
-        seq := 1
-        driveCum := 0
-        // optional depot start/end
-        var startLat, startLng float64
-        var startStopID string
-        if len(depots) > 0 {
-            // choose nearest depot to first stop
-            first := stops[order[0]]
-            best := 0
-            bestd := math.MaxFloat64
-            for i, d := range depots {
-                dd := haversineMeters(first.lat, first.lng, d.lat, d.lng)
-                if dd < bestd { bestd = dd; best = i }
-            }
-            startLat, startLng = depots[best].lat, depots[best].lng
-            startStopID = depots[best].id // not a stop id, but we keep relation via geofence id in from_stop_id as empty
-            // Create a depot->first leg with empty from_stop_id
-            aLat, aLng := startLat, startLng
-            b := stops[order[0]]
-            dist := int(math.Round(haversineMeters(aLat, aLng, b.lat, b.lng)))
-            drive := int(math.Round(float64(dist) / (50_000.0/3600.0)))
-            etaA := curr.Add(time.Duration(drive) * time.Second)
-            if b.twStart != nil && etaA.Before(*b.twStart) { etaA = *b.twStart }
-            etaD := etaA.Add(time.Duration(b.svc) * time.Second)
-            if _, err := p.db.ExecContext(ctx, `INSERT INTO route_legs (id, tenant_id, route_id, seq, kind, break_sec, from_stop_id, to_stop_id, dist_m, drive_sec, eta_arrival, eta_departure, status) VALUES ($1,$2,$3,$4,'drive',NULL,$5,$6,$7,$8,$9,$10,'in_progress')`,
-                uuid.New().String(), req.TenantID, rid, seq, nil, b.id, dist, drive, etaA, etaD); err != nil { return nil, "", err }
-            curr = etaD
-            driveCum += drive
-            seq++
+// insertLegs persists planned legs of route rid from run.seq on. Callers
+// emit hos.break.planned once the legs are committed (see emitBreaks).
+func insertLegs(ctx context.Context, q execer, tenantID, rid string, legs []plan.Leg, run legRun) error {
+    return insertRows(ctx, q, "route_legs", legColumns, legRows(tenantID, rid, legs, run))
+}
+
+var legColumns = []string{"id", "tenant_id", "route_id", "seq", "kind", "break_sec", "from_stop_id", "to_stop_id", "dist_m", "drive_sec", "eta_arrival", "eta_departure", "status"}
+
+// legRows renders legs as route_legs rows in legColumns order; the first
+// drive leg of an active run is in progress.
+func legRows(tenantID, rid string, legs []plan.Leg, run legRun) [][]any {
+    rows := make([][]any, 0, len(legs))
+    for i, lg := range legs {
+        seq := run.seq + i
+        if lg.Kind == "break" {
+            rows = append(rows, []any{uuid.New().String(), tenantID, rid, seq, "break", lg.BreakSec, nil, nil, 0, 0, lg.Arrival, lg.Departure, "pending"})
+            continue
         }
-        // intra-stop legs
-        for i := 0; i < len(order)-1; i++ {
-            a := stops[order[i]]
-            b := stops[order[i+1]]
-            dist := int(math.Round(haversineMeters(a.lat, a.lng, b.lat, b.lng)))
-            drive := int(math.Round(float64(dist) / (50_000.0/3600.0))) // 50 kph
-            if hosMax > 0 && driveCum+drive > hosMax && seq > 1 {
-                // plan a break leg
-                etaA0 := curr
-                etaD0 := curr.Add(time.Duration(breakSec) * time.Second)
-                if _, err := p.db.ExecContext(ctx, `INSERT INTO route_legs (id, tenant_id, route_id, seq, kind, break_sec, from_stop_id, to_stop_id, dist_m, drive_sec, eta_arrival, eta_departure, status) VALUES ($1,$2,$3,$4,'break',$5,NULL,NULL,0,0,$6,$7,'pending')`, uuid.New().String(), req.TenantID, rid, seq, breakSec, etaA0, etaD0); err != nil { return nil, "", err }
-                _ = p.emitEvent(ctx, req.TenantID, "hos.break.planned", map[string]any{"routeId": rid, "breakSec": breakSec, "start": etaA0.Format(time.RFC3339), "end": etaD0.Format(time.RFC3339)})
-                curr = etaD0
-                driveCum = 0
-                seq++
+        status := "pending"
+        if i == 0 && run.active { status = "in_progress" }
+        rows = append(rows, []any{uuid.New().String(), tenantID, rid, seq, "drive", nil, nullIfEmpty(lg.FromStopID), nullIfEmpty(lg.ToStopID), lg.DistM, lg.DriveSec, lg.Arrival, lg.Departure, status})
+    }
+    return rows
+}
+
+// emitBreaks emits hos.break.planned for each break leg of route rid.
+func (p *Postgres) emitBreaks(ctx context.Context, tenantID, rid string, legs []plan.Leg) {
+    for _, lg := range legs {
+        if lg.Kind != "break" { continue }
+        _ = p.emitEvent(ctx, tenantID, "hos.break.planned", map[string]any{"routeId": rid, "breakSec": lg.BreakSec, "start": lg.Arrival.Format(time.RFC3339), "end": lg.Departure.Format(time.RFC3339)})
+    }
+}
+
+// execer runs statements on a *sql.DB or inside a *sql.Tx.
+type execer interface {
+    ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// maxBindParams is the most bind parameters Postgres takes per statement.
+const maxBindParams = 65535
+
+// insertRows inserts rows into table with multi-row VALUES statements, as
+// many rows per statement as the bind parameter limit allows.
+func insertRows(ctx context.Context, q execer, table string, cols []string, rows [][]any) error {
+    per := maxBindParams / len(cols)
+    for len(rows) > 0 {
+        n := min(per, len(rows))
+        var b strings.Builder
+        args := make([]any, 0, n*len(cols))
+        fmt.Fprintf(&b, "INSERT INTO %s (%s) VALUES ", table, strings.Join(cols, ", "))
+        for i, row := range rows[:n] {
+            if i > 0 { b.WriteString(", ") }
+            b.WriteString("(")
+            for j, v := range row {
+                if j > 0 { b.WriteString(",") }
+                args = append(args, v)
+                fmt.Fprintf(&b, "$%d", len(args))
             }
-            etaA := curr.Add(time.Duration(drive) * time.Second)
-            // wait if before time window start
-            if b.twStart != nil && etaA.Before(*b.twStart) { etaA = *b.twStart }
-            etaD := etaA.Add(time.Duration(b.svc) * time.Second)
-            status := "pending"
-            if seq == 1 && startStopID == "" { status = "in_progress" }
-            if _, err := p.db.ExecContext(ctx, `INSERT INTO route_legs (id, tenant_id, route_id, seq, kind, break_sec, from_stop_id, to_stop_id, dist_m, drive_sec, eta_arrival, eta_departure, status) VALUES ($1,$2,$3,$4,'drive',NULL,$5,$6,$7,$8,$9,$10,$11)`,
-                uuid.New().String(), req.TenantID, rid, seq, a.id, b.id, dist, drive, etaA, etaD, status); err != nil { return nil, "", err }
-            curr = etaD
-            driveCum += drive
-            seq++
+            b.WriteString(")")
         }
-        // last leg back to depot if present
-        if len(depots) > 0 {
-            last := stops[order[len(order)-1]]
-            dist := int(math.Round(haversineMeters(last.lat, last.lng, startLat, startLng)))
-            drive := int(math.Round(float64(dist) / (50_000.0/3600.0)))
-            etaA := curr.Add(time.Duration(drive) * time.Second)
-            etaD := etaA // no service time at depot
-            if _, err := p.db.ExecContext(ctx, `INSERT INTO route_legs (id, tenant_id, route_id, seq, kind, break_sec, from_stop_id, to_stop_id, dist_m, drive_sec, eta_arrival, eta_departure, status) VALUES ($1,$2,$3,$4,'drive',NULL,$5,$6,$7,$8,$9,$10,'pending')`,
-                uuid.New().String(), req.TenantID, rid, seq, last.id, nil, dist, drive, etaA, etaD); err != nil { return nil, "", err }
+        if _, err := q.ExecContext(ctx, b.String(), args...); err != nil { return err }
+        rows = rows[n:]
+    }
+    return nil
+}
+
+// setStopStatus moves stopIDs from status from to status to (pending and
+// assigned) and their orders along: assigned while any of their stops is.
+// Returns how many stops moved; stops not in from are left alone.
+func setStopStatus(ctx context.Context, q execer, tenantID string, stopIDs []string, from, to string) (int, error) {
+    if len(stopIDs) == 0 { return 0, nil }
+    res, err := q.ExecContext(ctx, `UPDATE stops SET status=$4 WHERE tenant_id=$1 AND id::text = ANY($2) AND status=$3`, tenantID, stopIDs, from, to)
+    if err != nil { return 0, err }
+    n, _ := res.RowsAffected()
+    _, err = q.ExecContext(ctx, `UPDATE orders o SET status = CASE WHEN EXISTS (SELECT 1 FROM stops s WHERE s.order_id=o.id AND s.status='assigned') THEN 'assigned' ELSE 'pending' END
+        WHERE o.tenant_id=$1 AND o.status IN ('pending','assigned') AND o.id IN (SELECT order_id FROM stops WHERE tenant_id=$1 AND id::text = ANY($2))`, tenantID, stopIDs)
+    return int(n), err
+}
+
+// legRun says where a persisted leg sequence starts.
+type legRun struct {
+    seq    int  // first seq to write
+    active bool // the first leg is in progress
+}
+
+// numeric reads a JSON number (or numeric string); 0 otherwise.
+func numeric(v any) float64 {
+    switch x := v.(type) {
+    case float64: return x
+    case int: return float64(x)
+    case string:
+        f, _ := strconv.ParseFloat(strings.TrimSpace(x), 64)
+        return f
+    }
+    return 0
+}
+
+// orderDemand reads an order's load from its attributes: weight, volume,
+// pallets and a "dims" object of named dimensions.
+func orderDemand(attrs map[string]any) opt.Demand {
+    d := opt.Demand{Weight: numeric(attrs["weight"]), Volume: numeric(attrs["volume"]), Pallets: numeric(attrs["pallets"])}
+    if dims, ok := attrs["dims"].(map[string]any); ok {
+        d.Dims = map[string]float64{}
+        for k, v := range dims { d.Dims[k] = numeric(v) }
+    }
+    return d
+}
+
+// applyVehicleCapacity sets v's limits from the vehicles.capacity jsonb:
+// weight, volume and pallets, with any other numeric key a named dimension.
+func applyVehicleCapacity(v *opt.Vehicle, capacity map[string]any) {
+    for k, raw := range capacity {
+        val := numeric(raw)
+        switch k {
+        case "weight": v.CapWeight = val
+        case "volume": v.CapVolume = val
+        case "pallets": v.CapPallets = val
+        default:
+            if val <= 0 { continue }
+            if v.CapDims == nil { v.CapDims = map[string]float64{} }
+            v.CapDims[k] = val
         }
-        r, _ := p.GetRoute(ctx, req.TenantID, rid)
-        results = append(results, r)
     }
-    return results, fmt.Sprintf("opt_%d", time.Now().UnixNano()), nil
 }
 
-func haversineMeters(lat1, lon1, lat2, lon2 float64) float64 {
-    const R = 6371000.0
-    dLat := (lat2 - lat1) * math.Pi / 180
-    dLon := (lon2 - lon1) * math.Pi / 180
-    a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*math.Pi/180)*math.Cos(lat2*math.Pi/180)*math.Sin(dLon/2)*math.Sin(dLon/2)
-    c := 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
-    return R * c
+// speedProfile resolves the time-of-day speed profile for a plan from
+// req.Constraints["speedProfile"] or the tenant optimizer config; nil if unset.
+func (p *Postgres) speedProfile(ctx context.Context, req model.OptimizeRequest) (*opt.SpeedProfile, error) {
+    var raw any
+    if cfg, err := p.GetOptimizerConfig(ctx, req.TenantID); err == nil && cfg != nil { raw = cfg["speedProfile"] }
+    if req.Constraints != nil {
+        if v, ok := req.Constraints["speedProfile"]; ok { raw = v }
+    }
+    if raw == nil { return nil, nil }
+    b, err := json.Marshal(raw)
+    if err != nil { return nil, err }
+    var sp opt.SpeedProfile
+    if err := json.Unmarshal(b, &sp); err != nil { return nil, fmt.Errorf("speedProfile: %w", err) }
+    return &sp, nil
+}
+
+// consistencyParams resolves the driver consistency weight and history
+// window: the tenant config consistency.weight and consistency.lookbackDays
+// (default 28), the weight overridden by the request's consistency objective.
+func (p *Postgres) consistencyParams(ctx context.Context, req model.OptimizeRequest) (float64, int) {
+    var c struct {
+        Weight       float64 `json:"weight"`
+        LookbackDays int     `json:"lookbackDays"`
+    }
+    if cfg, err := p.GetOptimizerConfig(ctx, req.TenantID); err == nil && cfg != nil {
+        if b, err := json.Marshal(cfg["consistency"]); err == nil { _ = json.Unmarshal(b, &c) }
+    }
+    if w, ok := req.Objectives["consistency"]; ok { c.Weight = w }
+    if c.LookbackDays <= 0 { c.LookbackDays = 28 }
+    return c.Weight, c.LookbackDays
+}
+
+// loadAffinity returns, by customer key, each driver's share of the visits
+// served in the lookback plan dates before planDate. A visit is a visited leg
+// or a leg of a completed route; its driver is the route's, else the
+// route vehicle's.
+func (p *Postgres) loadAffinity(ctx context.Context, tenantID, planDate string, days int) (map[string]map[string]float64, error) {
+    rows, err := p.db.QueryContext(ctx, `SELECT `+customerKeySQL+`, COALESCE(r.driver_id, v.driver_id)::text, count(*)
+        FROM route_legs l JOIN routes r ON r.id=l.route_id JOIN stops s ON s.id=l.to_stop_id
+        LEFT JOIN orders o ON o.id=s.order_id LEFT JOIN vehicles v ON v.id=r.vehicle_id
+        WHERE l.tenant_id=$1 AND r.plan_date < $2::date AND r.plan_date >= $2::date - $3::int
+        AND COALESCE(r.driver_id, v.driver_id) IS NOT NULL AND (l.status='visited' OR r.status='completed')
+        GROUP BY 1, 2`, tenantID, planDate, days)
+    if err != nil { return nil, err }
+    defer rows.Close()
+    visits := map[string]map[string]float64{}
+    for rows.Next() {
+        var key, driver string
+        var n float64
+        if err := rows.Scan(&key, &driver, &n); err != nil { return nil, err }
+        if visits[key] == nil { visits[key] = map[string]float64{} }
+        visits[key][driver] = n
+    }
+    if err := rows.Err(); err != nil { return nil, err }
+    return visitShares(visits), nil
+}
+
+// visitShares turns per-customer visit counts by driver into shares.
+func visitShares(visits map[string]map[string]float64) map[string]map[string]float64 {
+    for _, byDriver := range visits {
+        total := 0.0
+        for _, n := range byDriver { total += n }
+        for d, n := range byDriver { byDriver[d] = n / total }
+    }
+    return visits
+}
+
+// applyConsistency sets the consistency objective of c and the affinity of
+// each customer to its past drivers. A zero weight or no plan date leaves c
+// unchanged.
+func (p *Postgres) applyConsistency(ctx context.Context, req model.OptimizeRequest, c *plan.Constraints) error {
+    w, days := p.consistencyParams(ctx, req)
+    if w <= 0 || req.PlanDate == "" { return nil }
+    aff, err := p.loadAffinity(ctx, req.TenantID, req.PlanDate, days)
+    if err != nil { return err }
+    c.Objectives["consistency"], c.Affinity = w, aff
+    return nil
+}
+
+// costBreakdown renders a route's cost components as the JSON stored in
+// routes.cost_breakdown.
+func costBreakdown(b opt.CostBreakdown) []byte {
+    js, _ := json.Marshal(costBreakdownMap(b))
+    return js
+}
+
+// costBreakdownMap is the model.Route.CostBreakdown form of b.
+func costBreakdownMap(b opt.CostBreakdown) map[string]float64 {
+    return map[string]float64{"fixed": b.Fixed, "distance": b.Distance, "time": b.Time, "overtime": b.Overtime, "total": b.Total(), "distanceM": b.DistanceM, "durationSec": b.DurationSec}
+}
+
+// operatorWeights resolves the ALNS operator weights for a plan: each of
+// req.RemovalWeights and req.InsertionWeights when set, otherwise the tenant
+// optimizer config; nil leaves every registered operator enabled.
+func (p *Postgres) operatorWeights(ctx context.Context, req model.OptimizeRequest) (rem, ins map[string]float64) {
+    rem, ins = req.RemovalWeights, req.InsertionWeights
+    cfg, err := p.GetOptimizerConfig(ctx, req.TenantID)
+    if err != nil || cfg == nil { return rem, ins }
+    fromConfig := func(key string) map[string]float64 {
+        var w map[string]float64
+        if b, err := json.Marshal(cfg[key]); err == nil { _ = json.Unmarshal(b, &w) }
+        return w
+    }
+    if len(rem) == 0 { rem = fromConfig("removalWeights") }
+    if len(ins) == 0 { ins = fromConfig("insertionWeights") }
+    return rem, ins
+}
+
+// balanceKeys are the route balancing objectives (see opt/balance.go) a
+// tenant can default in the balance block of its optimizer config.
+var balanceKeys = []string{"makespan", "stopBalance", "workBalance", "minStops", "maxStops"}
+
+// applyBalance sets the balancing objectives of c that the request leaves
+// unset from the tenant optimizer config.
+func applyBalance(cfg map[string]any, req model.OptimizeRequest, c *plan.Constraints) {
+    var b map[string]float64
+    if raw, err := json.Marshal(cfg["balance"]); err == nil { _ = json.Unmarshal(raw, &b) }
+    for _, k := range balanceKeys {
+        if _, set := req.Objectives[k]; !set && b[k] > 0 { c.Objectives[k] = b[k] }
+    }
+}
+
+// explainOptimizeRequest is the optimize request whose planner settings an
+// explanation is priced with.
+func explainOptimizeRequest(req model.ExplainRequest) model.OptimizeRequest {
+    return model.OptimizeRequest{TenantID: req.TenantID, PlanDate: req.PlanDate, Algorithm: plan.ALNS, Constraints: req.Constraints, Objectives: req.Objectives}
+}
+
+// routeStops returns the stops a route drives to, in order.
+func routeStops(r model.Route) []string {
+    var out []string
+    for _, lg := range r.Legs {
+        if lg.Kind != "break" && lg.ToStopID != "" { out = append(out, lg.ToStopID) }
+    }
+    return out
+}
+
+// explainResult is the model form of ex over the routes routeIDs.
+func explainResult(stopID string, routeIDs []string, ex plan.Explanation) model.ExplainResult {
+    res := model.ExplainResult{StopID: stopID, Options: []model.InsertionOption{}}
+    if ex.Route >= 0 { res.CurrentRouteID, res.CurrentPosition = routeIDs[ex.Route], ex.Position }
+    for _, o := range ex.Options {
+        io := model.InsertionOption{RouteID: routeIDs[o.Route], Position: o.Position, AfterStopID: o.AfterStopID, Feasible: o.Feasible, Reasons: o.Reasons, CostDelta: o.CostDelta}
+        if o.PartnerPosition >= 0 { pp := o.PartnerPosition; io.PartnerPosition = &pp }
+        res.Options = append(res.Options, io)
+    }
+    return res
 }
 
 func ternary[T any](cond bool, a, b T) T { if cond { return a }; return b }
@@ -1238,7 +1511,7 @@ func (p *Postgres) CreateGeofence(ctx context.Context, tenantID string, in model
     id := uuid.New().String()
     var lat, lng any
     if in.Center != nil { lat, lng = in.Center.Lat, in.Center.Lng }
-    _, err := p.db.ExecContext(ctx, `INSERT INTO geofences (id, tenant_id, name, radius_m, type, rules, lat, lng) VALUES ($1,$2,$3,$4,$5,$6,$7,$8)`, id, tenantID, in.Name, in.RadiusM, in.Type, in.Rules, lat, lng)
+    _, err := p.db.ExecContext(ctx, `INSERT INTO geofences (id, tenant_id, name, radius_m, type, rules, lat, lng, polygon) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)`, id, tenantID, in.Name, in.RadiusM, in.Type, in.Rules, lat, lng, polygonJSON(in.Polygon))
     if err != nil { return model.Geofence{}, err }
     return p.GetGeofence(ctx, tenantID, id)
 }
@@ -1248,9 +1521,9 @@ func (p *Postgres) ListGeofences(ctx context.Context, tenantID, cursor string, l
     var rows *sql.Rows
     var err error
     if cursor != "" {
-        rows, err = p.db.QueryContext(ctx, `SELECT id::text, name, radius_m, type, rules, lat, lng FROM geofences WHERE tenant_id=$1 AND id::text > $2 ORDER BY id LIMIT $3`, tenantID, cursor, limit)
+        rows, err = p.db.QueryContext(ctx, `SELECT id::text, name, radius_m, type, rules, lat, lng, polygon FROM geofences WHERE tenant_id=$1 AND id::text > $2 ORDER BY id LIMIT $3`, tenantID, cursor, limit)
     } else {
-        rows, err = p.db.QueryContext(ctx, `SELECT id::text, name, radius_m, type, rules, lat, lng FROM geofences WHERE tenant_id=$1 ORDER BY id LIMIT $2`, tenantID, limit)
+        rows, err = p.db.QueryContext(ctx, `SELECT id::text, name, radius_m, type, rules, lat, lng, polygon FROM geofences WHERE tenant_id=$1 ORDER BY id LIMIT $2`, tenantID, limit)
     }
     if err != nil { return nil, "", err }
     defer rows.Close()
@@ -1259,9 +1532,11 @@ func (p *Postgres) ListGeofences(ctx context.Context, tenantID, cursor string, l
     for rows.Next() {
         var gf model.Geofence
         var lat, lng sql.NullFloat64
-        if err := rows.Scan(&gf.ID, &gf.Name, &gf.RadiusM, &gf.Type, &gf.Rules, &lat, &lng); err != nil { return nil, "", err }
+        var poly []byte
+        if err := rows.Scan(&gf.ID, &gf.Name, &gf.RadiusM, &gf.Type, &gf.Rules, &lat, &lng, &poly); err != nil { return nil, "", err }
         gf.TenantID = tenantID
         if lat.Valid && lng.Valid { gf.Center = &model.GeoPoint{Lat: lat.Float64, Lng: lng.Float64} }
+        if len(poly) > 0 { _ = json.Unmarshal(poly, &gf.Polygon) }
         out = append(out, gf)
         last = gf.ID
     }
@@ -1273,13 +1548,15 @@ func (p *Postgres) ListGeofences(ctx context.Context, tenantID, cursor string, l
 func (p *Postgres) GetGeofence(ctx context.Context, tenantID, id string) (model.Geofence, error) {
     var gf model.Geofence
     var lat, lng sql.NullFloat64
-    row := p.db.QueryRowContext(ctx, `SELECT id::text, name, radius_m, type, rules, lat, lng FROM geofences WHERE tenant_id=$1 AND id=$2`, tenantID, id)
-    if err := row.Scan(&gf.ID, &gf.Name, &gf.RadiusM, &gf.Type, &gf.Rules, &lat, &lng); err != nil {
+    var poly []byte
+    row := p.db.QueryRowContext(ctx, `SELECT id::text, name, radius_m, type, rules, lat, lng, polygon FROM geofences WHERE tenant_id=$1 AND id=$2`, tenantID, id)
+    if err := row.Scan(&gf.ID, &gf.Name, &gf.RadiusM, &gf.Type, &gf.Rules, &lat, &lng, &poly); err != nil {
         if errors.Is(err, sql.ErrNoRows) { return gf, ErrNotFound }
         return gf, err
     }
     gf.TenantID = tenantID
     if lat.Valid && lng.Valid { gf.Center = &model.GeoPoint{Lat: lat.Float64, Lng: lng.Float64} }
+    if len(poly) > 0 { _ = json.Unmarshal(poly, &gf.Polygon) }
     return gf, nil
 }
 
@@ -1291,9 +1568,10 @@ func (p *Postgres) PatchGeofence(ctx context.Context, tenantID, id string, in mo
     if in.Type != "" { gf.Type = in.Type }
     if in.RadiusM != 0 { gf.RadiusM = in.RadiusM }
     if in.Rules != nil { gf.Rules = in.Rules }
+    if in.Polygon != nil { gf.Polygon = in.Polygon }
     var lat, lng any
     if in.Center != nil { lat, lng = in.Center.Lat, in.Center.Lng } else if gf.Center != nil { lat, lng = gf.Center.Lat, gf.Center.Lng }
-    _, err = p.db.ExecContext(ctx, `UPDATE geofences SET name=$1, radius_m=$2, type=$3, rules=$4, lat=$5, lng=$6 WHERE tenant_id=$7 AND id=$8`, gf.Name, gf.RadiusM, gf.Type, gf.Rules, lat, lng, tenantID, id)
+    _, err = p.db.ExecContext(ctx, `UPDATE geofences SET name=$1, radius_m=$2, type=$3, rules=$4, lat=$5, lng=$6, polygon=$7 WHERE tenant_id=$8 AND id=$9`, gf.Name, gf.RadiusM, gf.Type, gf.Rules, lat, lng, polygonJSON(gf.Polygon), tenantID, id)
     if err != nil { return gf, err }
     return p.GetGeofence(ctx, tenantID, id)
 }
@@ -1306,6 +1584,13 @@ func (p *Postgres) DeleteGeofence(ctx context.Context, tenantID, id string) erro
 
 // Helpers
 func nullIfEmpty(s string) any { if s == "" { return nil }; return s }
+
+// polygonJSON encodes geofence vertices for geofences.polygon; none is NULL.
+func polygonJSON(pts []model.GeoPoint) any {
+    if len(pts) == 0 { return nil }
+    b, _ := json.Marshal(pts)
+    return b
+}
 func toJSON(m map[string]any) any { if m == nil { return nil }; return m }
 func mediaURL(m *model.PoDMedia) any { if m == nil { return nil }; return m.UploadURL }
 func mediaHash(m *model.PoDMedia) any { if m == nil { return nil }; return m.SHA256 }
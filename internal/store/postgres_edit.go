@@ -0,0 +1,151 @@
+package store
+
+import (
+    "context"
+    "fmt"
+    "slices"
+
+    "gpsnav/internal/model"
+    "gpsnav/internal/plan"
+)
+
+// applyRouteOps applies ops, edits of route routeID, to stops, the editable
+// stops of each route by route ID, and returns the routes they change.
+func applyRouteOps(stops map[string][]string, routeID string, ops []model.RouteOp) (map[string]bool, error) {
+    if _, ok := stops[routeID]; !ok { return nil, fmt.Errorf("%w: route %s is not active or already back at its depot", ErrInvalidEdit, routeID) }
+    find := func(sid string) (string, int, error) {
+        for rid, ss := range stops {
+            if i := slices.Index(ss, sid); i >= 0 { return rid, i, nil }
+        }
+        return "", 0, fmt.Errorf("%w: stop %s is not a pending stop of an active route", ErrInvalidEdit, sid)
+    }
+    changed := map[string]bool{}
+    for n, op := range ops {
+        switch op.Op {
+        case "move":
+            from, i, err := find(op.StopID)
+            if err != nil { return nil, err }
+            to := op.ToRouteID
+            if to == "" { to = routeID }
+            if _, ok := stops[to]; !ok { return nil, fmt.Errorf("%w: ops[%d]: route %s is not active", ErrInvalidEdit, n, to) }
+            if from != routeID && to != routeID { return nil, fmt.Errorf("%w: ops[%d]: move must start or end on route %s", ErrInvalidEdit, n, routeID) }
+            stops[from] = slices.Delete(slices.Clone(stops[from]), i, i+1)
+            pos := len(stops[to])
+            if op.Position != nil { pos = *op.Position }
+            if pos < 0 || pos > len(stops[to]) { return nil, fmt.Errorf("%w: ops[%d]: position %d out of range [0,%d]", ErrInvalidEdit, n, pos, len(stops[to])) }
+            stops[to] = slices.Insert(slices.Clone(stops[to]), pos, op.StopID)
+            changed[from], changed[to] = true, true
+        case "swap":
+            ra, ia, err := find(op.StopID)
+            if err != nil { return nil, err }
+            rb, ib, err := find(op.WithStopID)
+            if err != nil { return nil, err }
+            if ra != routeID && rb != routeID { return nil, fmt.Errorf("%w: ops[%d]: swap must involve route %s", ErrInvalidEdit, n, routeID) }
+            stops[ra], stops[rb] = slices.Clone(stops[ra]), slices.Clone(stops[rb])
+            if ra == rb { stops[rb] = stops[ra] }
+            stops[ra][ia], stops[rb][ib] = op.WithStopID, op.StopID
+            changed[ra], changed[rb] = true, true
+        case "resequence":
+            cur := slices.Clone(stops[routeID])
+            next := slices.Clone(op.StopIDs)
+            slices.Sort(cur)
+            slices.Sort(next)
+            if !slices.Equal(cur, next) { return nil, fmt.Errorf("%w: ops[%d]: stopIds must list the route's %d pending stops once each", ErrInvalidEdit, n, len(cur)) }
+            stops[routeID] = slices.Clone(op.StopIDs)
+            changed[routeID] = true
+        case "unassign":
+            from, i, err := find(op.StopID)
+            if err != nil { return nil, err }
+            if from != routeID { return nil, fmt.Errorf("%w: ops[%d]: stop %s is on route %s", ErrInvalidEdit, n, op.StopID, from) }
+            stops[from] = slices.Delete(slices.Clone(stops[from]), i, i+1)
+            changed[from] = true
+        default:
+            return nil, fmt.Errorf("%w: ops[%d]: unknown op %q", ErrInvalidEdit, n, op.Op)
+        }
+    }
+    return changed, nil
+}
+
+// routeWarnings turns the rules a sequenced route breaks into warnings.
+func routeWarnings(routeID string, sq plan.Sequenced) []model.RouteWarning {
+    var out []model.RouteWarning
+    for _, v := range sq.Violations { out = append(out, model.RouteWarning{RouteID: routeID, StopID: v.StopID, Reason: v.Reason}) }
+    return out
+}
+
+// EditRoute applies patch.Ops across the active routes of the route's plan
+// date. Edited tails are retimed from where each vehicle is and checked
+// against the planning rules; broken rules come back as warnings rather
+// than errors. The changed routes are locked, rewritten in place and get
+// their versions bumped in one transaction, failing with ErrVersionConflict
+// when any of them changed since it was read; each then gets a route.edited
+// event.
+func (p *Postgres) EditRoute(ctx context.Context, tenantID, routeID string, version int, patch model.RoutePatch) (model.RouteEditResult, error) {
+    r, err := p.GetRoute(ctx, tenantID, routeID)
+    if err != nil { return model.RouteEditResult{}, err }
+    if r.Version != version { return model.RouteEditResult{}, ErrVersionConflict }
+    acts, err := p.activeRoutes(ctx, tenantID, r.PlanDate)
+    if err != nil { return model.RouteEditResult{}, err }
+    tails := map[string]routeTail{}
+    stops := map[string][]string{}
+    fixed := map[string]bool{} // stops on visited or in-progress legs
+    for _, a := range acts {
+        ar, err := p.GetRoute(ctx, tenantID, a.id)
+        if err != nil { return model.RouteEditResult{}, err }
+        if a.id == routeID && ar.Version != version { return model.RouteEditResult{}, ErrVersionConflict }
+        t := splitRoute(a, ar, "")
+        for _, lg := range ar.Legs[:t.fixed] { fixed[lg.ToStopID] = true }
+        if t.ended { continue }
+        tails[a.id], stops[a.id] = t, t.stops
+    }
+    changed, err := applyRouteOps(stops, routeID, patch.Ops)
+    if err != nil { return model.RouteEditResult{}, err }
+    changed[routeID] = true // the patched route is always retimed and versioned
+    var edited []routeTail
+    var routes [][]string
+    var from []string
+    for _, a := range acts {
+        if !changed[a.id] { continue }
+        t := tails[a.id]
+        edited, routes, from = append(edited, t), append(routes, stops[a.id]), append(from, t.anchor)
+    }
+    oreq := model.OptimizeRequest{TenantID: tenantID, PlanDate: r.PlanDate, Constraints: patch.Constraints}
+    c, zones, err := p.planConstraints(ctx, oreq)
+    if err != nil { return model.RouteEditResult{}, err }
+    orders, err := p.loadPlanOrders(ctx, tenantID, activeIDs(acts))
+    if err != nil { return model.RouteEditResult{}, err }
+    vehicles, err := p.tailVehicles(ctx, tenantID, edited, zones)
+    if err != nil { return model.RouteEditResult{}, err }
+    seqs, err := plan.Sequence(ctx, plan.Request{Orders: withoutStops(orders, fixed), Vehicles: vehicles, Constraints: c}, routes, from)
+    if err != nil { return model.RouteEditResult{}, err }
+    // unassigned stops go back to pending
+    kept := map[string]bool{}
+    for _, ss := range routes { for _, sid := range ss { kept[sid] = true } }
+    var released []string
+    for _, t := range edited {
+        for _, sid := range t.stops { if !kept[sid] { released = append(released, sid) } }
+    }
+
+    tx, err := p.db.BeginTx(ctx, nil)
+    if err != nil { return model.RouteEditResult{}, err }
+    defer func(){ _ = tx.Rollback() }()
+    if err := lockTails(ctx, tx, tenantID, edited); err != nil { return model.RouteEditResult{}, err }
+    versions := make([]int, len(edited))
+    for i, t := range edited {
+        if err := rewriteTail(ctx, tx, tenantID, t, seqs[i].Legs, seqs[i].Cost, ""); err != nil { return model.RouteEditResult{}, err }
+        if versions[i], err = bumpVersion(ctx, tx, tenantID, t.route.ID); err != nil { return model.RouteEditResult{}, err }
+    }
+    if _, err := setStopStatus(ctx, tx, tenantID, released, "assigned", "pending"); err != nil { return model.RouteEditResult{}, err }
+    if err := tx.Commit(); err != nil { return model.RouteEditResult{}, err }
+
+    var res model.RouteEditResult
+    for i, t := range edited {
+        _ = p.emitEvent(ctx, tenantID, "route.edited", map[string]any{"routeId": t.route.ID, "version": versions[i], "planDate": r.PlanDate, "stops": len(routes[i]), "warnings": len(seqs[i].Violations)})
+        p.emitBreaks(ctx, tenantID, t.route.ID, seqs[i].Legs)
+        res.Warnings = append(res.Warnings, routeWarnings(t.route.ID, seqs[i])...)
+        rt, err := p.GetRoute(ctx, tenantID, t.route.ID)
+        if err != nil { return model.RouteEditResult{}, err }
+        if rt.ID == routeID { res.Route = rt } else { res.Changed = append(res.Changed, rt) }
+    }
+    return res, nil
+}
@@ -0,0 +1,62 @@
+package store
+
+import (
+    "context"
+    "errors"
+    "slices"
+
+    "gpsnav/internal/model"
+    "gpsnav/internal/plan"
+)
+
+// ExplainInsertion prices req.StopID at every position of the requested
+// routes (default: the plan date's active routes). Each route is taken from
+// where its vehicle is, over its stops after the visited and in-progress
+// legs; nothing is written.
+func (p *Postgres) ExplainInsertion(ctx context.Context, req model.ExplainRequest) (model.ExplainResult, error) {
+    rows, err := p.db.QueryContext(ctx, `SELECT id::text, COALESCE(depot_id,''), COALESCE(end_depot_id,''), COALESCE(vehicle_id::text,'') FROM routes
+        WHERE tenant_id=$1 AND CASE WHEN $3::text[] IS NULL THEN plan_date=NULLIF($2,'')::date AND COALESCE(status,'') NOT IN ('completed','cancelled') ELSE id::text = ANY($3) END
+        ORDER BY id`, req.TenantID, req.PlanDate, pqStringArray(req.RouteIDs))
+    if err != nil { return model.ExplainResult{}, err }
+    var acts []activeRoute
+    for rows.Next() {
+        var a activeRoute
+        if err := rows.Scan(&a.id, &a.depotID, &a.endDepotID, &a.vehicleID); err != nil { rows.Close(); return model.ExplainResult{}, err }
+        acts = append(acts, a)
+    }
+    rows.Close()
+    var tails []routeTail
+    fixed := map[string]bool{} // stops on visited or in-progress legs
+    for _, a := range acts {
+        r, err := p.GetRoute(ctx, req.TenantID, a.id)
+        if err != nil { return model.ExplainResult{}, err }
+        t := splitRoute(a, r, "")
+        for _, lg := range r.Legs[:t.fixed] { fixed[lg.ToStopID] = true }
+        if !t.ended { tails = append(tails, t) }
+    }
+    c, zones, err := p.planConstraints(ctx, explainOptimizeRequest(req))
+    if err != nil { return model.ExplainResult{}, err }
+    orders, err := p.loadPlanOrders(ctx, req.TenantID, activeIDs(acts))
+    if err != nil { return model.ExplainResult{}, err }
+    orders = withoutStops(orders, fixed)
+    vehicles, err := p.tailVehicles(ctx, req.TenantID, tails, zones)
+    if err != nil { return model.ExplainResult{}, err }
+    routes := make([][]string, len(tails))
+    routeIDs := make([]string, len(tails))
+    for i, t := range tails { routes[i], routeIDs[i] = t.stops, t.route.ID }
+    ex, err := plan.Explain(ctx, plan.Request{Orders: orders, Vehicles: vehicles, Constraints: c}, routes, req.StopID)
+    if errors.Is(err, plan.ErrUnknownStop) { return model.ExplainResult{}, ErrNotFound }
+    if err != nil { return model.ExplainResult{}, err }
+    return explainResult(req.StopID, routeIDs, ex), nil
+}
+
+// withoutStops drops the stops in drop from orders, and orders left without
+// stops.
+func withoutStops(orders []plan.Order, drop map[string]bool) []plan.Order {
+    var out []plan.Order
+    for _, o := range orders {
+        o.Stops = slices.DeleteFunc(slices.Clone(o.Stops), func(s plan.Stop) bool { return drop[s.ID] })
+        if len(o.Stops) > 0 { out = append(out, o) }
+    }
+    return out
+}
@@ -0,0 +1,308 @@
+package store
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "fmt"
+    "time"
+
+    "gpsnav/internal/model"
+    "gpsnav/internal/opt"
+    "gpsnav/internal/plan"
+)
+
+// fixedPrefix returns how many leading legs must stay as planned: through the
+// last visited or in-progress leg, or through upToLegID when it is on this route.
+func fixedPrefix(legs []model.Leg, upToLegID string) int {
+    n := 0
+    for i, lg := range legs {
+        if lg.Status == "visited" || lg.Status == "in_progress" || (upToLegID != "" && lg.ID == upToLegID) { n = i + 1 }
+    }
+    return n
+}
+
+// lastDriveLeg returns the index of the last drive leg in legs, or -1.
+func lastDriveLeg(legs []model.Leg) int {
+    for i := len(legs) - 1; i >= 0; i-- {
+        if legs[i].Kind != "break" { return i }
+    }
+    return -1
+}
+
+// activeRoute is a route of a plan date that is neither completed nor
+// cancelled.
+type activeRoute struct{ id, depotID, endDepotID, vehicleID string }
+
+// activeRoutes lists the plan date's active routes in ID order.
+func (p *Postgres) activeRoutes(ctx context.Context, tenantID, planDate string) ([]activeRoute, error) {
+    rows, err := p.db.QueryContext(ctx, `SELECT id::text, COALESCE(depot_id,''), COALESCE(end_depot_id,''), COALESCE(vehicle_id::text,'') FROM routes
+        WHERE tenant_id=$1 AND plan_date=$2 AND COALESCE(status,'') NOT IN ('completed','cancelled') ORDER BY id`, tenantID, planDate)
+    if err != nil { return nil, err }
+    defer rows.Close()
+    var acts []activeRoute
+    for rows.Next() {
+        var a activeRoute
+        if err := rows.Scan(&a.id, &a.depotID, &a.endDepotID, &a.vehicleID); err != nil { return nil, err }
+        acts = append(acts, a)
+    }
+    return acts, rows.Err()
+}
+
+// activeIDs returns the IDs of acts.
+func activeIDs(acts []activeRoute) []string {
+    ids := make([]string, len(acts))
+    for i, a := range acts { ids[i] = a.id }
+    return ids
+}
+
+// routeTail is an active route split into its fixed prefix and the tail
+// that can still change.
+type routeTail struct {
+    act       activeRoute
+    route     model.Route
+    fixed     int       // legs kept as planned
+    anchor    string    // stop the vehicle continues from; empty at the depot
+    anchorDep time.Time
+    returns   bool      // route ends with a depot leg
+    ended     bool      // already back at a depot
+    stops     []string  // tail stop IDs in current order
+}
+
+// splitRoute splits route r of a after its visited and in-progress legs, or
+// after upToLegID when it is on r.
+func splitRoute(a activeRoute, r model.Route, upToLegID string) routeTail {
+    t := routeTail{act: a, route: r, fixed: fixedPrefix(r.Legs, upToLegID)}
+    last := lastDriveLeg(r.Legs[:t.fixed])
+    t.ended = last >= 0 && r.Legs[last].ToStopID == ""
+    if ld := lastDriveLeg(r.Legs); ld >= 0 && r.Legs[ld].ToStopID == "" { t.returns = true }
+    if last >= 0 {
+        t.anchor = r.Legs[last].ToStopID
+        t.anchorDep, _ = time.Parse(time.RFC3339, r.Legs[last].ETADeparture)
+    }
+    t.stops = routeStops(model.Route{Legs: r.Legs[t.fixed:]})
+    return t
+}
+
+// tailVehicles returns the vehicle running each tail, placed at its anchor
+// or start depot and busy until it leaves the anchor, so plan.Problem leaves
+// it in place.
+func (p *Postgres) tailVehicles(ctx context.Context, tenantID string, tails []routeTail, zones []planZone) ([]plan.Vehicle, error) {
+    depots, err := p.loadDepots(ctx, tenantID)
+    if err != nil { return nil, err }
+    load := func(vid string) (opt.Vehicle, error) { veh, _, err := p.loadPlanVehicle(ctx, tenantID, vid, zones); return veh, err }
+    locate := func(stopID string) ([2]float64, error) {
+        var ll [2]float64
+        err := p.db.QueryRowContext(ctx, `SELECT lat, lng FROM stops WHERE tenant_id=$1 AND id=$2`, tenantID, stopID).Scan(&ll[0], &ll[1])
+        return ll, err
+    }
+    return placeTails(tails, depots, load, locate)
+}
+
+// placeTails returns the vehicle running each tail: the route's vehicle as
+// load reads it, else an unconstrained one named after the route, placed
+// at the tail's anchor (located by locate) or start depot.
+func placeTails(tails []routeTail, depots []plan.Depot, load func(vid string) (opt.Vehicle, error), locate func(stopID string) ([2]float64, error)) ([]plan.Vehicle, error) {
+    depotByID := map[string]plan.Depot{}
+    for _, d := range depots { depotByID[d.ID] = d }
+    var vehicles []plan.Vehicle
+    for _, t := range tails {
+        veh := opt.Vehicle{ID: t.route.ID}
+        if t.act.vehicleID != "" {
+            var err error
+            if veh, err = load(t.act.vehicleID); err != nil { return nil, fmt.Errorf("route %s: %w", t.route.ID, err) }
+        }
+        if t.route.DriverID != "" { veh.DriverID = t.route.DriverID }
+        if t.anchor != "" {
+            ll, err := locate(t.anchor)
+            if err != nil { return nil, fmt.Errorf("route %s anchor stop: %w", t.route.ID, err) }
+            veh.StartLatLng, veh.StartID = &ll, t.anchor
+            // the vehicle is busy until it leaves the anchor stop
+            if t.anchorDep.After(veh.ShiftStart) { veh.ShiftStart = t.anchorDep }
+        } else if d, ok := depotByID[t.act.depotID]; ok {
+            veh.StartLatLng, veh.StartID = &[2]float64{d.Lat, d.Lng}, d.ID
+        }
+        endID := t.act.endDepotID
+        if endID == "" && t.returns { endID = t.act.depotID }
+        if d, ok := depotByID[endID]; ok { veh.EndLatLng, veh.EndID = &[2]float64{d.Lat, d.Lng}, d.ID }
+        vehicles = append(vehicles, plan.Vehicle{Vehicle: veh})
+    }
+    return vehicles, nil
+}
+
+// rewriteTail replaces the legs after t's fixed prefix with legs and prices
+// the route by cost, what is left of it from its anchor. A non-empty batchID
+// tags the route with the batch that rewrote it. Callers bump the version
+// and emit the break events once committed.
+func rewriteTail(ctx context.Context, q execer, tenantID string, t routeTail, legs []plan.Leg, cost opt.CostBreakdown, batchID string) error {
+    lastSeq := 0
+    if t.fixed > 0 { lastSeq = t.route.Legs[t.fixed-1].Seq }
+    inProgress := false
+    for _, lg := range t.route.Legs[:t.fixed] { if lg.Status == "in_progress" { inProgress = true } }
+    if _, err := q.ExecContext(ctx, `DELETE FROM route_legs WHERE tenant_id=$1 AND route_id=$2 AND seq > $3`, tenantID, t.route.ID, lastSeq); err != nil { return err }
+    if err := insertLegs(ctx, q, tenantID, t.route.ID, legs, legRun{seq: lastSeq + 1, active: !inProgress}); err != nil { return err }
+    _, err := q.ExecContext(ctx, `UPDATE routes SET cost_breakdown=$3, batch_id=COALESCE($4, batch_id) WHERE tenant_id=$1 AND id=$2`, tenantID, t.route.ID, costBreakdown(cost), nullIfEmpty(batchID))
+    return err
+}
+
+// lockTails locks the routes of tails for the rest of tx, failing with
+// ErrVersionConflict when one changed since it was read.
+func lockTails(ctx context.Context, tx *sql.Tx, tenantID string, tails []routeTail) error {
+    for _, t := range tails {
+        var version int
+        if err := tx.QueryRowContext(ctx, `SELECT version FROM routes WHERE tenant_id=$1 AND id=$2 FOR UPDATE`, tenantID, t.route.ID).Scan(&version); err != nil {
+            if errors.Is(err, sql.ErrNoRows) { return fmt.Errorf("%w: route %s was deleted", ErrVersionConflict, t.route.ID) }
+            return err
+        }
+        if version != t.route.Version { return fmt.Errorf("%w: route %s is at version %d, not %d", ErrVersionConflict, t.route.ID, version, t.route.Version) }
+    }
+    return nil
+}
+
+// bumpVersion increments a route's version and returns the new one.
+func bumpVersion(ctx context.Context, tx *sql.Tx, tenantID, routeID string) (int, error) {
+    var version int
+    err := tx.QueryRowContext(ctx, `UPDATE routes SET version=version+1 WHERE tenant_id=$1 AND id=$2 RETURNING version`, tenantID, routeID).Scan(&version)
+    return version, err
+}
+
+// reoptimize replans the tails of the plan date's active routes. Visited and
+// in-progress legs (and legs up to req.Freeze.UpToLegID) stay fixed, routes in
+// req.Freeze.Routes are left untouched, and pending stops not yet on a route
+// are inserted into the remaining tails. Routes keep their IDs; the changed
+// tails, their version bumps and stop statuses are written in one
+// transaction, failing with ErrPlanConflict when a route or stop changed
+// meanwhile, and each changed route is tagged with the returned batch ID and
+// then gets a route.reoptimized event. Returns nil Routes when the plan date
+// has no active routes. When ctx ends the search early nothing is written and
+// ctx.Err() is returned, leaving the live routes as they were.
+func (p *Postgres) reoptimize(ctx context.Context, req model.OptimizeRequest) (model.PlanResult, error) {
+    acts, err := p.activeRoutes(ctx, req.TenantID, req.PlanDate)
+    if err != nil { return model.PlanResult{}, err }
+    if len(acts) == 0 { return model.PlanResult{}, nil }
+    batchID := fmt.Sprintf("opt_%d", time.Now().UnixNano())
+    frozen := map[string]bool{}
+    upTo := ""
+    if req.Freeze != nil {
+        for _, id := range req.Freeze.Routes { frozen[id] = true }
+        upTo = req.Freeze.UpToLegID
+    }
+
+    // Split each route into its fixed prefix and replannable tail
+    var tails []routeTail
+    routed := map[string]bool{} // stops on fixed legs or frozen routes
+    for _, a := range acts {
+        r, err := p.GetRoute(ctx, req.TenantID, a.id)
+        if err != nil { return model.PlanResult{}, err }
+        t := splitRoute(a, r, upTo)
+        if frozen[r.ID] || t.ended {
+            for _, lg := range r.Legs { routed[lg.FromStopID], routed[lg.ToStopID] = true, true }
+            continue
+        }
+        for _, lg := range r.Legs[:t.fixed] { routed[lg.FromStopID], routed[lg.ToStopID] = true, true }
+        tails = append(tails, t)
+    }
+    delete(routed, "")
+
+    // Nodes: current tail stops plus pending stops not on any route
+    all, err := p.loadPlanOrders(ctx, req.TenantID, activeIDs(acts))
+    if err != nil { return model.PlanResult{}, err }
+    orders := withoutStops(all, routed)
+    c, zones, err := p.planConstraints(ctx, req)
+    if err != nil { return model.PlanResult{}, err }
+    vehicles, err := p.tailVehicles(ctx, req.TenantID, tails, zones)
+    if err != nil { return model.PlanResult{}, err }
+    prob, err := plan.Problem(plan.Request{Orders: orders, Vehicles: vehicles, Constraints: c})
+    if err != nil { return model.PlanResult{}, err }
+    nodeOf := map[string]int{}
+    for i, nd := range prob.Nodes { nodeOf[nd.ID] = i }
+    for vi, t := range tails {
+        init := opt.RoutePlan{VehicleID: prob.Vehicles[vi].ID}
+        for _, sid := range t.stops {
+            if idx, ok := nodeOf[sid]; ok { init.Order = append(init.Order, idx) }
+        }
+        prob.InitialPlans = append(prob.InitialPlans, init)
+    }
+
+    var sol opt.Solution
+    var pm opt.Metrics
+    if len(prob.Vehicles) > 0 {
+        if err := prob.Prepare(ctx); err != nil { return model.PlanResult{}, fmt.Errorf("distance matrix: %w", err) }
+        tb := c.TimeBudget
+        if tb <= 0 { tb = 300 * time.Millisecond }
+        prob.OnSnapshot = opt.ProgressFrom(ctx)
+        var err error
+        sol, pm, err = opt.SolveContext(ctx, prob, c.Seed, tb)
+        if err != nil { return model.PlanResult{}, err } // a cancelled search persists nothing
+    }
+
+    // Rewrite changed tails in place, all in one transaction
+    type rewrite struct {
+        vi      int
+        t       routeTail
+        legs    []plan.Leg
+        cost    opt.CostBreakdown
+        stops   int
+        version int
+    }
+    var rewrites []rewrite
+    var changed []routeTail
+    for vi, t := range tails {
+        rp := sol.Plans[vi]
+        same := len(rp.Order) == len(t.stops)
+        for k := 0; same && k < len(rp.Order); k++ { same = prob.Nodes[rp.Order[k]].ID == t.stops[k] }
+        if same { continue }
+        legs, err := plan.Timeline(prob, vi, rp, t.anchor)
+        if err != nil { return model.PlanResult{}, err }
+        cost, err := prob.RouteCostBreakdown(vi, rp)
+        if err != nil { return model.PlanResult{}, err }
+        rewrites = append(rewrites, rewrite{vi: vi, t: t, legs: legs, cost: cost, stops: len(rp.Order)})
+        changed = append(changed, t)
+    }
+    // Stops inserted into a tail are assigned now, those dropped from one pending again
+    onTail := map[string]bool{}
+    for _, t := range tails { for _, sid := range t.stops { onTail[sid] = true } }
+    var inserted, dropped []string
+    inPlan := map[string]bool{}
+    for _, rp := range sol.Plans {
+        for _, idx := range rp.Order {
+            sid := prob.Nodes[idx].ID
+            inPlan[sid] = true
+            if !onTail[sid] { inserted = append(inserted, sid) }
+        }
+    }
+    for _, t := range tails {
+        for _, sid := range t.stops { if !inPlan[sid] { dropped = append(dropped, sid) } }
+    }
+    tx, err := p.db.BeginTx(ctx, nil)
+    if err != nil { return model.PlanResult{}, err }
+    defer func(){ _ = tx.Rollback() }()
+    if err := lockTails(ctx, tx, req.TenantID, changed); err != nil { return model.PlanResult{}, fmt.Errorf("%w: %w", ErrPlanConflict, err) }
+    for i, rw := range rewrites {
+        if err := rewriteTail(ctx, tx, req.TenantID, rw.t, rw.legs, rw.cost, batchID); err != nil { return model.PlanResult{}, err }
+        if rewrites[i].version, err = bumpVersion(ctx, tx, req.TenantID, rw.t.route.ID); err != nil { return model.PlanResult{}, err }
+    }
+    // stops planned by a concurrent batch meanwhile are no longer pending
+    n, err := setStopStatus(ctx, tx, req.TenantID, inserted, "pending", "assigned")
+    if err != nil { return model.PlanResult{}, err }
+    if n != len(inserted) { return model.PlanResult{}, fmt.Errorf("%w: %d of %d stops were planned by another batch", ErrPlanConflict, len(inserted)-n, len(inserted)) }
+    if _, err := setStopStatus(ctx, tx, req.TenantID, dropped, "assigned", "pending"); err != nil { return model.PlanResult{}, err }
+    if err := tx.Commit(); err != nil { return model.PlanResult{}, err }
+
+    if len(prob.Vehicles) > 0 { opt.RecordMetrics(req.TenantID, req.PlanDate, "alns", pm) }
+    for _, rw := range rewrites {
+        _ = p.emitEvent(ctx, req.TenantID, "route.reoptimized", map[string]any{"routeId": rw.t.route.ID, "batchId": batchID, "version": rw.version, "planDate": req.PlanDate, "fixedLegs": rw.t.fixed, "stops": rw.stops})
+        p.emitBreaks(ctx, req.TenantID, rw.t.route.ID, rw.legs)
+    }
+    routeIDs := make([]string, len(tails))
+    for vi, t := range tails { routeIDs[vi] = t.route.ID }
+    results := []model.Route{}
+    for _, a := range acts {
+        r, err := p.GetRoute(ctx, req.TenantID, a.id)
+        if err != nil { return model.PlanResult{}, err }
+        results = append(results, r)
+    }
+    zv, err := zoneViolations(prob, sol, routeIDs)
+    if err != nil { return model.PlanResult{}, err }
+    return model.PlanResult{BatchID: batchID, Routes: results, Unassigned: plan.UnassignedStops(prob, sol), ZoneViolations: zv}, nil
+}
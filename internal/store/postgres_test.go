@@ -1,8 +1,19 @@
 package store
 
 import (
+    "context"
+    "database/sql"
     "encoding/hex"
+    "encoding/json"
+    "errors"
+    "slices"
+    "strings"
     "testing"
+    "time"
+
+    "gpsnav/internal/model"
+    "gpsnav/internal/opt"
+    "gpsnav/internal/plan"
 )
 
 func TestComputeDedupKeyFromID(t *testing.T) {
@@ -26,3 +37,154 @@ func TestPQStringArray(t *testing.T) {
     if v := pqStringArray([]string{"a","b"}); v == nil { t.Fatalf("non-empty -> non-nil expected") }
 }
 
+
+func TestOrderDemandAndVehicleCapacity(t *testing.T) {
+    d := orderDemand(map[string]any{"weight": 12.5, "pallets": "2", "dims": map[string]any{"chilled": 1.0}, "note": "fragile"})
+    if d.Weight != 12.5 || d.Pallets != 2 || d.Dims["chilled"] != 1 { t.Fatalf("demand: %+v", d) }
+    if h := d.Scale(0.5); h.Weight != 6.25 || h.Dims["chilled"] != 0.5 { t.Fatalf("scaled: %+v", h) }
+    var v opt.Vehicle
+    applyVehicleCapacity(&v, map[string]any{"weight": 1000.0, "volume": 8.0, "pallets": 6.0, "chilled": 2.0})
+    if v.CapWeight != 1000 || v.CapVolume != 8 || v.CapPallets != 6 || v.CapDims["chilled"] != 2 { t.Fatalf("vehicle: %+v", v) }
+}
+
+func TestFixedPrefix(t *testing.T) {
+    legs := []model.Leg{{ID: "l1", Status: "visited"}, {ID: "l2", Status: "in_progress"}, {ID: "l3", Status: "pending"}, {ID: "l4", Kind: "break", Status: "pending"}, {ID: "l5", Status: "pending"}}
+    if n := fixedPrefix(legs, ""); n != 2 { t.Fatalf("visited/in-progress prefix = %d, want 2", n) }
+    if n := fixedPrefix(legs, "l4"); n != 4 { t.Fatalf("freeze up to l4 = %d, want 4", n) }
+    if n := fixedPrefix(legs, "other-route-leg"); n != 2 { t.Fatalf("foreign leg id = %d, want 2", n) }
+    if i := lastDriveLeg(legs[:4]); i != 2 { t.Fatalf("last drive leg = %d, want 2", i) }
+}
+
+func TestTimeWindowsLiteral(t *testing.T) {
+    if v := timeWindowsLiteral(model.StopIn{}); v != nil { t.Fatalf("no windows -> nil, got %v", v) }
+    s := model.StopIn{TimeWindow: &model.TimeWindow{Start: "2025-03-03T09:00:00Z", End: "2025-03-03T12:00:00Z"}, TimeWindows: []model.TimeWindow{{Start: "2025-03-03T14:00:00Z", End: "2025-03-03T17:00:00Z"}}}
+    if v := timeWindowsLiteral(s); v != "{[2025-03-03T09:00:00Z,2025-03-03T12:00:00Z],[2025-03-03T14:00:00Z,2025-03-03T17:00:00Z]}" { t.Fatalf("literal: %v", v) }
+    ws := parseTimeWindows([]byte(`[{"start":"2025-03-03T09:00:00+00:00","end":null}]`))
+    if len(ws) != 1 || ws[0].Start.Hour() != 9 || !ws[0].End.IsZero() { t.Fatalf("parsed: %+v", ws) }
+}
+
+func TestCostBreakdownMap(t *testing.T) {
+    var m map[string]float64
+    if err := json.Unmarshal(costBreakdown(opt.CostBreakdown{Fixed: 100, Distance: 20, Time: 60, Overtime: 15, DistanceM: 40000, DurationSec: 7200}), &m); err != nil { t.Fatal(err) }
+    if m["total"] != 195 || m["overtime"] != 15 || m["distanceM"] != 40000 || m["durationSec"] != 7200 { t.Fatalf("breakdown: %v", m) }
+}
+
+func TestVehicleZones(t *testing.T) {
+    zones := []planZone{
+        {zone: opt.Zone{ID: "z1", Territory: true}, name: "north", kind: "territory", vehicles: []string{"v1"}},
+        {zone: opt.Zone{ID: "z2", Territory: true}, name: "south", kind: "territory", drivers: []string{"d2"}},
+        {zone: opt.Zone{ID: "z3"}, name: "city", kind: "low_emission"},
+        {zone: opt.Zone{ID: "z4"}, name: "bridge", kind: "restricted"},
+    }
+    terr, closed := vehicleZones(zones, "v1", "d2", []string{"low_emission", "z9"})
+    if len(terr) != 2 || len(closed) != 1 || closed[0] != "z3" { t.Fatalf("territories %v, forbidden %v", terr, closed) }
+    if _, closed = vehicleZones(zones, "v2", "", []string{"bridge"}); len(closed) != 1 || closed[0] != "z4" { t.Fatalf("by name: %v", closed) }
+}
+
+func TestVisitShares(t *testing.T) {
+    got := visitShares(map[string]map[string]float64{"acme": {"d1": 3, "d2": 1}, "1.2345,2.3456": {"d2": 2}})
+    if got["acme"]["d1"] != 0.75 || got["acme"]["d2"] != 0.25 || got["1.2345,2.3456"]["d2"] != 1 { t.Fatalf("shares: %v", got) }
+}
+
+func TestApplyBalance(t *testing.T) {
+    c := plan.Constraints{Objectives: map[string]float64{"stopBalance": 100}}
+    cfg := map[string]any{"balance": map[string]any{"stopBalance": 600.0, "maxStops": 30.0, "makespan": 0.0}}
+    applyBalance(cfg, model.OptimizeRequest{Objectives: map[string]float64{"stopBalance": 100}}, &c)
+    // the request's own weight wins; unset keys come from the tenant
+    if c.Objectives["stopBalance"] != 100 || c.Objectives["maxStops"] != 30 || len(c.Objectives) != 2 { t.Fatalf("objectives: %v", c.Objectives) }
+    applyBalance(nil, model.OptimizeRequest{}, &c) // no tenant config
+}
+
+func TestApplyRouteOps(t *testing.T) {
+    pos := 0
+    stops := map[string][]string{"r1": {"a", "b", "c"}, "r2": {"d"}, "r3": {"e"}}
+    changed, err := applyRouteOps(stops, "r1", []model.RouteOp{
+        {Op: "move", StopID: "c", ToRouteID: "r2", Position: &pos},
+        {Op: "swap", StopID: "a", WithStopID: "e"},
+        {Op: "resequence", StopIDs: []string{"b", "e"}},
+        {Op: "move", StopID: "d"},
+    })
+    if err != nil { t.Fatal(err) }
+    if !slices.Equal(stops["r1"], []string{"b", "e", "d"}) || !slices.Equal(stops["r2"], []string{"c"}) || !slices.Equal(stops["r3"], []string{"a"}) { t.Fatalf("stops: %v", stops) }
+    if len(changed) != 3 { t.Fatalf("changed: %v", changed) }
+    for _, ops := range [][]model.RouteOp{
+        {{Op: "move", StopID: "a", ToRouteID: "r3"}},        // neither end on r1
+        {{Op: "unassign", StopID: "zz"}},                    // not a pending stop
+        {{Op: "resequence", StopIDs: []string{"b"}}},        // drops a stop
+        {{Op: "move", StopID: "d", Position: &[]int{5}[0]}}, // past the end
+    } {
+        if _, err := applyRouteOps(map[string][]string{"r1": {"b", "d"}, "r2": {"a"}, "r3": {}}, "r1", ops); !errors.Is(err, ErrInvalidEdit) { t.Fatalf("%+v: %v", ops, err) }
+    }
+}
+
+// recordExec records the statements run through it.
+type recordExec struct {
+    queries []string
+    args    [][]any
+}
+
+func (r *recordExec) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+    r.queries, r.args = append(r.queries, query), append(r.args, args)
+    return nil, nil
+}
+
+func TestInsertRows(t *testing.T) {
+    var rec recordExec
+    if err := insertRows(context.Background(), &rec, "t", []string{"a", "b"}, nil); err != nil || len(rec.queries) != 0 { t.Fatalf("no rows: %v %v", err, rec.queries) }
+    if err := insertRows(context.Background(), &rec, "t", []string{"a", "b"}, [][]any{{1, "x"}, {2, nil}}); err != nil { t.Fatal(err) }
+    if rec.queries[0] != "INSERT INTO t (a, b) VALUES ($1,$2), ($3,$4)" || !slices.Equal(rec.args[0], []any{1, "x", 2, nil}) { t.Fatalf("insert: %q %v", rec.queries[0], rec.args[0]) }
+    // 13 columns take at most 5041 rows a statement
+    rec = recordExec{}
+    rows := make([][]any, 6000)
+    for i := range rows { rows[i] = make([]any, len(legColumns)) }
+    if err := insertRows(context.Background(), &rec, "route_legs", legColumns, rows); err != nil { t.Fatal(err) }
+    if len(rec.queries) != 2 || len(rec.args[0]) != 5041*13 || len(rec.args[1]) != 959*13 || !strings.HasSuffix(rec.queries[1], "($12455,$12456,$12457,$12458,$12459,$12460,$12461,$12462,$12463,$12464,$12465,$12466,$12467)") { t.Fatalf("chunks: %d", len(rec.queries)) }
+}
+
+func TestRewriteTailTagsBatch(t *testing.T) {
+    tail := routeTail{route: model.Route{ID: "r1"}}
+    for _, batch := range []string{"opt_7", ""} {
+        var rec recordExec
+        if err := rewriteTail(context.Background(), &rec, "t1", tail, nil, opt.CostBreakdown{}, batch); err != nil { t.Fatal(err) }
+        last := rec.args[len(rec.args)-1]
+        if !strings.Contains(rec.queries[len(rec.queries)-1], "batch_id=COALESCE($4, batch_id)") || last[3] != nullIfEmpty(batch) { t.Fatalf("batch %q: %q %v", batch, rec.queries[len(rec.queries)-1], last) }
+    }
+}
+
+func TestLegRows(t *testing.T) {
+    at := time.Date(2025, 3, 3, 8, 0, 0, 0, time.UTC)
+    legs := []plan.Leg{{Kind: "drive", ToStopID: "s1", DistM: 900, DriveSec: 60, Arrival: at, Departure: at}, {Kind: "break", BreakSec: 1800, Arrival: at, Departure: at.Add(30 * time.Minute)}, {Kind: "drive", FromStopID: "s1"}}
+    rows := legRows("t1", "r1", legs, legRun{seq: 4, active: true})
+    if len(rows) != 3 || len(rows[0]) != len(legColumns) { t.Fatalf("rows: %v", rows) }
+    if rows[0][3] != 4 || rows[0][6] != nil || rows[0][7] != "s1" || rows[0][12] != "in_progress" { t.Fatalf("first: %v", rows[0]) }
+    if rows[1][4] != "break" || rows[1][5] != 1800 || rows[1][12] != "pending" { t.Fatalf("break: %v", rows[1]) }
+    if rows[2][3] != 6 || rows[2][5] != nil || rows[2][7] != nil || rows[2][12] != "pending" { t.Fatalf("return: %v", rows[2]) }
+    if r := legRows("t1", "r1", legs[:1], legRun{seq: 1}); r[0][12] != "pending" { t.Fatalf("inactive run: %v", r[0]) }
+}
+
+func TestPlannedVehicleReachesTail(t *testing.T) {
+    r := plan.Route{VehicleID: "veh-1", StartDepotID: "hub"}
+    load := func(vid string) (opt.Vehicle, error) { return opt.Vehicle{ID: vid, CapWeight: 500, Skills: []string{"cold"}}, nil }
+    for _, tc := range []struct {
+        known map[string]bool
+        want  opt.Vehicle
+    }{
+        {map[string]bool{"veh-1": true}, opt.Vehicle{ID: "veh-1", CapWeight: 500}},
+        {nil, opt.Vehicle{ID: "r1"}}, // pool ID without a vehicle record
+    } {
+        row := routeRow("r1", "t1", "2025-03-03", "opt_1", r, tc.known)
+        vid, _ := row[slices.Index(routeColumns, "vehicle_id")].(string)
+        a := activeRoute{id: "r1", depotID: "hub", vehicleID: vid}
+        got, err := placeTails([]routeTail{{act: a, route: model.Route{ID: "r1"}}}, []plan.Depot{{ID: "hub", Lat: 1, Lng: 2}}, load, nil)
+        if err != nil { t.Fatal(err) }
+        v := got[0].Vehicle
+        if v.ID != tc.want.ID || v.CapWeight != tc.want.CapWeight || v.StartID != "hub" { t.Fatalf("known %v: %+v", tc.known, v) }
+    }
+}
+
+func TestPlaceTailsReturnsVehicleReadError(t *testing.T) {
+    boom := errors.New("connection reset")
+    load := func(vid string) (opt.Vehicle, error) { return opt.Vehicle{}, boom }
+    tails := []routeTail{{act: activeRoute{id: "r1", vehicleID: "veh-1"}, route: model.Route{ID: "r1"}}}
+    if _, err := placeTails(tails, nil, load, nil); !errors.Is(err, boom) { t.Fatalf("want the read error, got %v", err) }
+}
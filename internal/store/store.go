@@ -19,7 +19,11 @@ type Store interface {
     ListRoutes(ctx context.Context, tenantID, cursor string, limit int) ([]model.Route, string, error)
     AssignRoute(ctx context.Context, tenantID, routeID, driverID, vehicleID string, startAt time.Time) (model.Route, error)
     PatchRoute(ctx context.Context, tenantID, routeID string, patch model.RoutePatch) (model.Route, error)
-    PlanRoutes(ctx context.Context, req model.OptimizeRequest) (routes []model.Route, batchID string, err error)
+    // EditRoute applies patch.Ops when the route is at version (ErrVersionConflict
+    // otherwise), retimes every route they change and bumps its version.
+    EditRoute(ctx context.Context, tenantID, routeID string, version int, patch model.RoutePatch) (model.RouteEditResult, error)
+    PlanRoutes(ctx context.Context, req model.OptimizeRequest) (model.PlanResult, error)
+    ExplainInsertion(ctx context.Context, req model.ExplainRequest) (model.ExplainResult, error) // read-only
 
     // Events & PoD
     InsertDriverEvents(ctx context.Context, tenantID string, events []model.DriverEvent) (accepted int, err error)
@@ -78,3 +82,14 @@ type Store interface {
 }
 
 var ErrNotFound = errors.New("not found")
+
+// ErrVersionConflict is returned when a route changed since the version an
+// edit was based on.
+var ErrVersionConflict = errors.New("route version conflict")
+
+// ErrInvalidEdit wraps route edit operations that cannot be applied.
+var ErrInvalidEdit = errors.New("invalid route edit")
+
+// ErrPlanConflict is returned when stops being planned were assigned by
+// another batch meanwhile; nothing of the plan is kept.
+var ErrPlanConflict = errors.New("stops already planned")